@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"reviewmakerback/config"
+	db "reviewmakerback/db"
+	"reviewmakerback/storage"
+)
+
+// サーバーを起動せずに実行する管理用のコマンド
+// 終了コードを返す
+func runCommand(conf config.Config, args []string) int {
+	switch args[0] {
+	case "check-storage":
+		return checkStorageCommand(conf, args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "不明なコマンドです: %s\n使用できるコマンド: check-storage\n", args[0])
+		return 2
+	}
+}
+
+// 保存先のファイルとデータベースの参照を突き合わせ、結果をJSONで標準出力に出力する
+// 不整合が見つかった場合は終了コード1を返す
+func checkStorageCommand(conf config.Config, args []string) int {
+	flags := flag.NewFlagSet("check-storage", flag.ContinueOnError)
+	del := flags.Bool("delete", false, "参照されていないファイルを削除する(省略時は報告のみ)")
+	minAge := flags.Duration("min-age", time.Duration(conf.FileGc.MinAgeHours)*time.Hour, "更新からこの時間が経っていないファイルは対象外にする")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	db.InitDb(conf)
+	defer db.CloseDb()
+
+	report, err := storage.Scan(context.Background(), conf.Server.FilePath, storage.Options{Delete: *del, MinAge: *minAge})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "保存済みのファイルを確認できませんでした: %s\n", err.Error())
+		return 1
+	}
+	if *del {
+		db.WriteOperationLog("none", "none", "chst", fmt.Sprintf("cli files=%d references=%d orphans=%d dangling=%d deleted=%d",
+			report.Files, report.References, len(report.Orphans), len(report.Dangling), len(report.Deleted)))
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+
+	if len(report.Dangling) > 0 || len(report.Failed) > 0 || (!*del && len(report.Orphans) > 0) {
+		return 1
+	}
+	return 0
+}
@@ -1,11 +1,10 @@
 package common
 
 import (
-	"crypto/rand"
+	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
-	"math/big"
 	"regexp"
 	"strings"
 	"time"
@@ -34,30 +33,28 @@ func GetSHA256(s string) string {
 	return hex.EncodeToString(GetBinSHA256(s))
 }
 
-// 指定数のランダムな文字列(hex)を返す (最大128文字)
+// HMAC-SHA256の署名の文字列(hex)を返す
+func GetHmacSHA256(secret string, s string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(s))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// 指定数のランダムな文字列(hex)を返す
+// seedは互換性のために残しているもので、生成には使用しない(暗号論的乱数のみから生成する)
 func MakeRandomChars(codeCount int, seed string) (string, error) {
-	// SHA256で対応できるかチェック
-	if codeCount < 0 || codeCount > 128 {
+	if codeCount < 0 {
 		return "", errors.New("指定文字数で出力できません")
 	}
-
-	// 1万通りのランダムな数字を生成する
-	max, _ := new(big.Int).SetString("10000", 10)
-	n, err := rand.Int(rand.Reader, max)
-	if err != nil {
-		return "", errors.New("乱数を生成できません")
+	if codeCount == 0 {
+		return "", nil
 	}
-
-	// seedと1兆通りのランダムな数字と生成時間を文字列結合して、SHA256でハッシュ文字列を指定数切り取り、それを出力する
-	chars := GetSHA256(seed + time.Now().Format("2006-01-02-15-04-05") + ":" + n.Text(10))
-	return chars[0:codeCount], nil
+	return IdGenerator{Alphabet: AlphabetHex, Length: codeCount}.Generate()
 }
 
 func MakeSession(seed string) (string, error) {
-	// 512文字のセッションIDを生成する
-	sessionId, err := MakeRandomChars(64, seed)
-
-	return sessionId, err
+	// 64文字(256bit)のセッションIDを生成する
+	return SessionIdGenerator.Generate()
 }
 
 func DateToString(t time.Time) string {
@@ -135,3 +132,13 @@ func ConvertHtmlSafeString(s string) string {
 	s = strings.ReplaceAll(s, "\"", "”")
 	return s
 }
+
+// トークンとみなす英数字の連続(16文字のIDは対象外)
+var secretPattern = regexp.MustCompile(`[A-Za-z0-9_\-]{32,}`)
+
+// ログに記録する文字列から、トークンやシークレットと思われる長い英数字を伏せ字にする
+func MaskSecrets(s string) string {
+	return secretPattern.ReplaceAllStringFunc(s, func(m string) string {
+		return m[:4] + "****"
+	})
+}
@@ -39,6 +39,9 @@ type SessionData struct {
 
 	GoogleEmail    string `json:"googleEmail"`
 	GoogleImageUrl string `json:"googleImageUrl"`
+
+	OidcEmail string `json:"oidcEmail"` // OIDC Email
+	OidcName  string `json:"oidcName"`  // OIDC 表示名
 }
 
 type GoogleInfoData struct {
@@ -0,0 +1,128 @@
+package common
+
+import (
+	"crypto/rand"
+	"errors"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// IDに使用する文字種
+const (
+	// 16進数(従来の16文字のIDと同じ形式)
+	AlphabetHex = "0123456789abcdef"
+	// Crockford Base32(小文字, 紛らわしいi l o uを除く)
+	AlphabetBase32 = "0123456789abcdefghjkmnpqrstvwxyz"
+	// 英数字
+	AlphabetAlnum = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+// 時刻順に並ぶIDの先頭に埋め込むミリ秒時刻のビット数(ULIDと同じ)
+const sortableTimeBits = 48
+
+// 暗号論的乱数によるIDの生成器
+type IdGenerator struct {
+	Alphabet string // 使用する文字(時刻順にする場合は昇順に並べる)
+	Length   int    // 生成する文字数
+	Sortable bool   // 先頭に生成時刻を埋め込み、文字列として比較した際に生成順に並ぶようにする
+}
+
+// 従来と同じ16文字(64bit)のID
+var DefaultIdGenerator = IdGenerator{Alphabet: AlphabetHex, Length: 16}
+
+// 64文字(256bit)のセッションID
+var SessionIdGenerator = IdGenerator{Alphabet: AlphabetHex, Length: 64}
+
+// 生成器の設定が使用可能かチェックする
+func (g IdGenerator) Validate() error {
+	n := len(g.Alphabet)
+	if n < 2 || n > 256 {
+		return errors.New("文字種は2から256文字で指定してください")
+	}
+	seen := map[byte]bool{}
+	for i := 0; i < n; i++ {
+		c := g.Alphabet[i]
+		if seen[c] {
+			return errors.New("文字種に重複があります")
+		}
+		seen[c] = true
+		if g.Sortable && i > 0 && g.Alphabet[i-1] > c {
+			return errors.New("時刻順にする場合は文字種を昇順に並べてください")
+		}
+	}
+	if g.Length < 1 {
+		return errors.New("文字数は1以上で指定してください")
+	}
+	if g.Sortable && g.Length <= g.timeLength() {
+		return errors.New("時刻を埋め込むには文字数が足りません")
+	}
+	return nil
+}
+
+// 時刻を埋め込む文字数
+func (g IdGenerator) timeLength() int {
+	if !g.Sortable {
+		return 0
+	}
+	return int(math.Ceil(sortableTimeBits / math.Log2(float64(len(g.Alphabet)))))
+}
+
+// 乱数部分の情報量(bit)
+func (g IdGenerator) EntropyBits() float64 {
+	return float64(g.Length-g.timeLength()) * math.Log2(float64(len(g.Alphabet)))
+}
+
+// IDを生成する
+func (g IdGenerator) Generate() (string, error) {
+	return g.GenerateAt(time.Now())
+}
+
+// 時刻を指定してIDを生成する(Sortableでない場合、時刻は使用しない)
+func (g IdGenerator) GenerateAt(t time.Time) (string, error) {
+	if err := g.Validate(); err != nil {
+		return "", err
+	}
+
+	id := make([]byte, g.Length)
+	timeLen := g.timeLength()
+
+	// 先頭にミリ秒時刻を固定長で埋め込む
+	n := uint64(len(g.Alphabet))
+	ms := uint64(t.UnixMilli()) & (1<<sortableTimeBits - 1)
+	for i := timeLen - 1; i >= 0; i-- {
+		id[i] = g.Alphabet[ms%n]
+		ms /= n
+	}
+
+	if err := fillRandom(id[timeLen:], g.Alphabet); err != nil {
+		return "", err
+	}
+	return string(id), nil
+}
+
+// 文字種から偏りなくランダムに選んだ文字で埋める
+// 文字種の数を超える乱数は捨てることで、剰余による偏りを無くす
+func fillRandom(dst []byte, alphabet string) error {
+	n := len(alphabet)
+	mask := byte(1<<bits.Len8(uint8(n-1)) - 1)
+	buf := make([]byte, len(dst)*2)
+	filled := 0
+	for filled < len(dst) {
+		if _, err := rand.Read(buf); err != nil {
+			return errors.New("乱数を生成できません")
+		}
+		for _, b := range buf {
+			b &= mask
+			if int(b) >= n {
+				continue
+			}
+			dst[filled] = alphabet[b]
+			filled++
+			if filled == len(dst) {
+				break
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package common
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// 外部への送信で接続先として許可しないアドレスへの接続を試みた
+var ErrNonPublicAddress = errors.New("内部向けのアドレスには送信できません")
+
+// 公開されたアドレスとして扱わない範囲(net.IPの判定で対象外のもの)
+var nonPublicNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",     // このネットワーク
+		"100.64.0.0/10", // キャリアグレードNAT
+		"192.0.0.0/24",  // IETFプロトコル割り当て
+		"198.18.0.0/15", // ベンチマーク
+		"240.0.0.0/4",   // 予約済み
+		"64:ff9b::/96",  // NAT64(内部のIPv4アドレスを指定できるため)
+	} {
+		_, ipNet, _ := net.ParseCIDR(cidr)
+		nets = append(nets, ipNet)
+	}
+	return nets
+}()
+
+// 外部への送信先として許可するIPアドレスかどうか
+// ループバック・プライベート・リンクローカル・未指定・マルチキャスト等のアドレスはfalse
+func IsPublicIP(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, ipNet := range nonPublicNets {
+		if ipNet.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// URLのホストが明らかに内部向けのもの(localhostや公開されていないIPアドレス)かどうか
+// 名前解決が必要なホストは接続時にPublicOnlyControlで確認する
+func IsInternalHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+		return !IsPublicIP(ip)
+	}
+	return false
+}
+
+// net.DialerのControlに指定し、名前解決後の接続先が公開されたアドレスでなければ接続しない
+func PublicOnlyControl(network string, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if !IsPublicIP(net.ParseIP(host)) {
+		return ErrNonPublicAddress
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package common
+
+import (
+	"context"
+	"net/http"
+
+	"golang.org/x/oauth2"
+)
+
+// 連携サービスとのOAuthの通信に使用するHTTPクライアント(nilなら既定のクライアント)
+var oauthClient *http.Client
+
+// 連携サービスとのOAuthの通信に使用するHTTPクライアントを設定する
+func SetOAuthClient(client *http.Client) {
+	oauthClient = client
+}
+
+// OAuthの通信(トークンの取得・ユーザー情報の取得等)に設定したHTTPクライアントを使用するコンテキストを返す
+// oauth2.Config.Exchange, oauth2.Config.Clientにはこのコンテキストを渡すこと
+func OAuthContext(ctx context.Context) context.Context {
+	if oauthClient == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, oauth2.HTTPClient, oauthClient)
+}
@@ -0,0 +1,622 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"reviewmakerback/common"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// アプリケーション全体の設定
+// 設定ファイル(YAML/TOML)の値を環境変数で上書きして使用する
+// 環境変数名は各項目のenvタグで指定する
+type Config struct {
+	Database   DatabaseConfig   `yaml:"database" toml:"database"`
+	Server     ServerConfig     `yaml:"server" toml:"server"`
+	Twitter    TwitterConfig    `yaml:"twitter" toml:"twitter"`
+	Google     GoogleConfig     `yaml:"google" toml:"google"`
+	Encryption EncryptionConfig `yaml:"encryption" toml:"encryption"`
+	Smtp       SmtpConfig       `yaml:"smtp" toml:"smtp"`
+	RateLimit  RateLimitConfig  `yaml:"rateLimit" toml:"rateLimit"`
+	Oidc       OidcConfig       `yaml:"oidc" toml:"oidc"`
+	Admin      AdminConfig      `yaml:"admin" toml:"admin"`
+	Metrics    MetricsConfig    `yaml:"metrics" toml:"metrics"`
+	Log        LogConfig        `yaml:"log" toml:"log"`
+	Tracing    TracingConfig    `yaml:"tracing" toml:"tracing"`
+	Retention  RetentionConfig  `yaml:"retention" toml:"retention"`
+	Jobs       JobsConfig       `yaml:"jobs" toml:"jobs"`
+	Queue      QueueConfig      `yaml:"queue" toml:"queue"`
+	FileGc     FileGcConfig     `yaml:"fileGc" toml:"fileGc"`
+	Ids        IdsConfig        `yaml:"ids" toml:"ids"`
+	Limits     Limits           `yaml:"limits" toml:"limits"`
+}
+
+type DatabaseConfig struct {
+	Host     string `yaml:"host" toml:"host" env:"BACK_DB_HOST" required:"true"`
+	Port     int    `yaml:"port" toml:"port" env:"BACK_DB_PORT" required:"true"`
+	Name     string `yaml:"name" toml:"name" env:"BACK_DB_NAME" required:"true"`
+	User     string `yaml:"user" toml:"user" env:"BACK_DB_USER" required:"true"`
+	Password string `yaml:"password" toml:"password" env:"BACK_DB_PASSWORD" required:"true"`
+	TimeZone string `yaml:"timeZone" toml:"timeZone" env:"BACK_DB_TIMEZONE" required:"true"`
+}
+
+type ServerConfig struct {
+	Port            int    `yaml:"port" toml:"port" env:"BACK_AP_PORT" required:"true"`                   // リスナーポート番号
+	FilePath        string `yaml:"filePath" toml:"filePath" env:"BACK_AP_FILE_PATH" required:"true"`      // ユーザーが投稿した画像の保存先
+	Url             string `yaml:"url" toml:"url" env:"BACK_AP_URL"`                                      // 外部から見たバックエンドのURL(メールのリンクに使用する)
+	ShutdownTimeout int    `yaml:"shutdownTimeout" toml:"shutdownTimeout" env:"BACK_AP_SHUTDOWN_TIMEOUT"` // 終了時に処理中のリクエストを待つ時間(秒)
+	MinFreeMb       int    `yaml:"minFreeMb" toml:"minFreeMb" env:"BACK_AP_MIN_FREE_MB"`                  // 準備完了とみなす画像の保存先の空き容量(MB)
+}
+
+// Twitter OAuth2.0, OAuth1.0aの設定
+type TwitterConfig struct {
+	ClientId     string `yaml:"clientId" toml:"clientId" env:"BACK_TW_CLIENT_ID" required:"true"`
+	ClientSecret string `yaml:"clientSecret" toml:"clientSecret" env:"BACK_TW_CLIENT_SEC" required:"true"`
+	RedirectUri  string `yaml:"redirectUri" toml:"redirectUri" env:"BACK_TW_REDIRECT_URI" required:"true"`
+	ApiKey       string `yaml:"apiKey" toml:"apiKey" env:"BACK_TW1_APIKEY" required:"true"`
+	ApiSecret    string `yaml:"apiSecret" toml:"apiSecret" env:"BACK_TW1_APISECRET" required:"true"`
+	AccessToken  string `yaml:"accessToken" toml:"accessToken" env:"BACK_TW1_ACCESSTOKEN" required:"true"`
+	AccessSecret string `yaml:"accessSecret" toml:"accessSecret" env:"BACK_TW1_ACCESSSEC" required:"true"`
+}
+
+type GoogleConfig struct {
+	ConfJson string `yaml:"confJson" toml:"confJson" env:"BACK_GG_CONFJSON" required:"true"` // OAuth2.0クライアントの設定(JSON)
+}
+
+// 連携サービスのトークンの暗号化
+type EncryptionConfig struct {
+	Keys       string `yaml:"keys" toml:"keys" env:"BACK_ENC_KEYS" required:"true"`    // 'ID:base64の鍵'のカンマ区切り
+	CurrentKey string `yaml:"currentKey" toml:"currentKey" env:"BACK_ENC_CURRENT_KEY"` // 暗号化に使用する鍵ID(省略時は最後に指定した鍵)
+	LegacyKey  string `yaml:"legacyKey" toml:"legacyKey" env:"BACK_ENC_LEGACY_KEY"`    // 旧形式の暗号文を復号するためのパスワード
+}
+
+// 通知ダイジェストの送信に使用するSMTPサーバー(Hostを省略すると送信しない)
+type SmtpConfig struct {
+	Host     string `yaml:"host" toml:"host" env:"BACK_SMTP_HOST"`
+	Port     int    `yaml:"port" toml:"port" env:"BACK_SMTP_PORT"`
+	User     string `yaml:"user" toml:"user" env:"BACK_SMTP_USER"`
+	Password string `yaml:"password" toml:"password" env:"BACK_SMTP_PASSWORD"`
+	From     string `yaml:"from" toml:"from" env:"BACK_SMTP_FROM"`
+}
+
+type RateLimitConfig struct {
+	Conf string `yaml:"conf" toml:"conf" env:"BACK_RATELIMIT_CONF"` // レート制限の設定ファイル(JSON)のパス(省略時は既定の設定)
+}
+
+type OidcConfig struct {
+	Conf string `yaml:"conf" toml:"conf" env:"BACK_OIDC_CONF"` // OIDCのプロバイダーの設定ファイル(JSON)のパス(省略時はOIDCを使用しない)
+}
+
+type AdminConfig struct {
+	Users []string `yaml:"users" toml:"users" env:"BACK_ADMIN_USERS"` // 起動時に管理者にするユーザーID
+}
+
+type MetricsConfig struct {
+	Token string `yaml:"token" toml:"token" env:"BACK_METRICS_TOKEN"` // /metricsの取得に必要なBearerトークン(省略時は制限なし)
+}
+
+// ログ出力の設定
+type LogConfig struct {
+	Level      string `yaml:"level" toml:"level" env:"BACK_LOG_LEVEL"`                  // 出力する最低の重要度(debug, info, warn, error)
+	File       string `yaml:"file" toml:"file" env:"BACK_LOG_FILE"`                     // ログファイルのパス(空なら標準出力のみ)
+	MaxSizeMb  int    `yaml:"maxSizeMb" toml:"maxSizeMb" env:"BACK_LOG_MAX_SIZE_MB"`    // ローテーションするサイズ(MB)
+	MaxBackups int    `yaml:"maxBackups" toml:"maxBackups" env:"BACK_LOG_MAX_BACKUPS"`  // 残しておく古いログファイルの数(0なら無制限)
+	MaxAgeDays int    `yaml:"maxAgeDays" toml:"maxAgeDays" env:"BACK_LOG_MAX_AGE_DAYS"` // 古いログファイルを残しておく日数(0なら無制限)
+	Compress   bool   `yaml:"compress" toml:"compress" env:"BACK_LOG_COMPRESS"`         // 古いログファイルをgzipで圧縮するかどうか
+}
+
+// OpenTelemetryによるトレースの設定
+type TracingConfig struct {
+	Exporter    string   `yaml:"exporter" toml:"exporter" env:"BACK_TRACE_EXPORTER"`           // スパンの出力先(none, otlp, stdout)
+	Endpoint    string   `yaml:"endpoint" toml:"endpoint" env:"BACK_TRACE_ENDPOINT"`           // OTLP/HTTPの送信先URL
+	Headers     []string `yaml:"headers" toml:"headers" env:"BACK_TRACE_HEADERS"`              // OTLPの送信時に追加するヘッダー('名前=値'をカンマ区切り)
+	SampleRatio float64  `yaml:"sampleRatio" toml:"sampleRatio" env:"BACK_TRACE_SAMPLE_RATIO"` // 記録するトレースの割合(0から1)
+	ServiceName string   `yaml:"serviceName" toml:"serviceName" env:"BACK_TRACE_SERVICE_NAME"` // サービス名
+}
+
+// '名前=値'の形式のヘッダーを解析する
+func (t TracingConfig) HeaderMap() map[string]string {
+	headers := map[string]string{}
+	for _, header := range t.Headers {
+		if index := strings.Index(header, "="); index > 0 {
+			headers[strings.TrimSpace(header[:index])] = strings.TrimSpace(header[index+1:])
+		}
+	}
+	return headers
+}
+
+// 操作ログ・エラーログの保存期間
+type RetentionConfig struct {
+	OperationDays int    `yaml:"operationDays" toml:"operationDays" env:"BACK_RETENTION_OPERATION_DAYS"` // 操作ログを残す日数(0なら無期限)
+	ErrorDays     int    `yaml:"errorDays" toml:"errorDays" env:"BACK_RETENTION_ERROR_DAYS"`             // エラーログを残す日数(0なら無期限)
+	ArchiveDir    string `yaml:"archiveDir" toml:"archiveDir" env:"BACK_RETENTION_ARCHIVE_DIR"`          // 削除するログの保存先(空なら保存せずに削除する)
+	Span          int    `yaml:"span" toml:"span" env:"BACK_RETENTION_SPAN"`                             // 保存期間を過ぎたログを整理する間隔(秒)
+}
+
+// 定期処理の設定
+type JobsConfig struct {
+	LeaderElection bool     `yaml:"leaderElection" toml:"leaderElection" env:"BACK_JOBS_LEADER_ELECTION"` // 複数のインスタンスのうち一つだけが定期処理を実行するようにするかどうか
+	LockKey        int      `yaml:"lockKey" toml:"lockKey" env:"BACK_JOBS_LOCK_KEY"`                      // 実行の担当を決めるアドバイザリーロックのキー
+	Schedules      []string `yaml:"schedules" toml:"schedules" env:"BACK_JOBS_SCHEDULES" sep:";"`         // スケジュールの上書き('名前=スケジュール'、環境変数ではセミコロン区切り)
+}
+
+// ジョブキューの設定
+type QueueConfig struct {
+	Workers       int `yaml:"workers" toml:"workers" env:"BACK_QUEUE_WORKERS"`                    // 並行してジョブを実行するワーカーの数
+	PollInterval  int `yaml:"pollInterval" toml:"pollInterval" env:"BACK_QUEUE_POLL_INTERVAL"`    // 他のインスタンスが登録したジョブを確認する間隔(秒)
+	RetentionDays int `yaml:"retentionDays" toml:"retentionDays" env:"BACK_QUEUE_RETENTION_DAYS"` // 完了したジョブとエクスポートしたファイルを残す日数
+}
+
+// 保存済みのファイルの確認の設定
+type FileGcConfig struct {
+	Delete      bool `yaml:"delete" toml:"delete" env:"BACK_FILEGC_DELETE"`                  // 参照されていないファイルを削除するかどうか(falseなら報告のみ)
+	MinAgeHours int  `yaml:"minAgeHours" toml:"minAgeHours" env:"BACK_FILEGC_MIN_AGE_HOURS"` // 更新からこの時間が経っていないファイルは対象外にする(時間)
+}
+
+// 生成するIDの設定
+type IdsConfig struct {
+	Alphabet      string `yaml:"alphabet" toml:"alphabet" env:"BACK_ID_ALPHABET"`                 // IDに使用する文字(時刻順にする場合は昇順に並べる)
+	Length        int    `yaml:"length" toml:"length" env:"BACK_ID_LENGTH"`                       // ユーザー・Tier・レビューなどのIDの文字数
+	Sortable      bool   `yaml:"sortable" toml:"sortable" env:"BACK_ID_SORTABLE"`                 // IDの先頭に生成時刻を埋め込み、生成順に並ぶようにするかどうか
+	SessionLength int    `yaml:"sessionLength" toml:"sessionLength" env:"BACK_ID_SESSION_LENGTH"` // セッションIDの文字数
+}
+
+// ユーザー・Tier・レビューなどのIDの生成器
+func (i IdsConfig) Generator() common.IdGenerator {
+	return common.IdGenerator{Alphabet: i.Alphabet, Length: i.Length, Sortable: i.Sortable}
+}
+
+// セッションIDの生成器(推測されないよう時刻は埋め込まない)
+func (i IdsConfig) SessionGenerator() common.IdGenerator {
+	return common.IdGenerator{Alphabet: i.Alphabet, Length: i.SessionLength}
+}
+
+// '名前=スケジュール'の形式のスケジュールの上書きを解析する
+func (j JobsConfig) ScheduleMap() map[string]string {
+	schedules := map[string]string{}
+	for _, schedule := range j.Schedules {
+		if index := strings.Index(schedule, "="); index > 0 {
+			schedules[strings.TrimSpace(schedule[:index])] = strings.TrimSpace(schedule[index+1:])
+		}
+	}
+	return schedules
+}
+
+// 入力値の制限と一度に取得する件数
+type Limits struct {
+	PostSpan        int `yaml:"postSpan" toml:"postSpan" env:"BACK_AP_POST_SPAN" required:"true"` // 投稿可能な最小間隔(秒)
+	PostPageSize    int `yaml:"postPageSize" toml:"postPageSize"`                                 // 一度に取得可能なTier/レビュー数
+	ReviewMaxInTier int `yaml:"reviewMaxInTier" toml:"reviewMaxInTier"`                           // Tier一つあたりのレビューの最大登録数
+	LatestPostMax   int `yaml:"latestPostMax" toml:"latestPostMax"`                               // 最新の投稿一覧の最大取得数
+
+	User    UserLimits    `yaml:"user" toml:"user"`
+	Tier    TierLimits    `yaml:"tier" toml:"tier"`
+	Review  ReviewLimits  `yaml:"review" toml:"review"`
+	Section SectionLimits `yaml:"section" toml:"section"`
+}
+
+type UserLimits struct {
+	NameLenMax     int `yaml:"nameLenMax" toml:"nameLenMax"`         // ユーザー表示名の最大文字数
+	ProfileLenMax  int `yaml:"profileLenMax" toml:"profileLenMax"`   // プロフィールの最大文字数
+	IconMaxBytes   int `yaml:"iconMaxBytes" toml:"iconMaxBytes"`     // プロフィールアイコンの最大サイズ(KB)
+	StorageQuotaMb int `yaml:"storageQuotaMb" toml:"storageQuotaMb"` // ユーザーごとに保存できる画像の合計サイズ(MB)
+}
+
+type TierLimits struct {
+	NameLenMax      int     `yaml:"nameLenMax" toml:"nameLenMax"`           // tier名の最大文字数
+	ParamsLenMax    int     `yaml:"paramsLenMax" toml:"paramsLenMax"`       // 評価項目の合計数の上限
+	ParamNameLenMax int     `yaml:"paramNameLenMax" toml:"paramNameLenMax"` // 評価項目名の文字数の上限
+	ImgMaxBytes     float64 `yaml:"imgMaxBytes" toml:"imgMaxBytes"`         // tierの画像サイズの最大(KB)
+	ImgMaxEdge      int     `yaml:"imgMaxEdge" toml:"imgMaxEdge"`           // tierの画像サイズの一辺最大
+	ImgAspectRate   float32 `yaml:"imgAspectRate" toml:"imgAspectRate"`     // 画像のアスペクト比
+}
+
+type ReviewLimits struct {
+	NameLenMax       int     `yaml:"nameLenMax" toml:"nameLenMax"`             // レビュー名の最大文字数
+	TitleLenMax      int     `yaml:"titleLenMax" toml:"titleLenMax"`           // レビュータイトルの最大文字数
+	SectionLenMax    int     `yaml:"sectionLenMax" toml:"sectionLenMax"`       // セクションの最大数
+	FactorInfoLenMax int     `yaml:"factorInfoLenMax" toml:"factorInfoLenMax"` // 評価情報の文字数の上限
+	IconMaxBytes     float64 `yaml:"iconMaxBytes" toml:"iconMaxBytes"`         // レビューアイコンサイズの最大(KB)
+	IconMaxEdge      int     `yaml:"iconMaxEdge" toml:"iconMaxEdge"`           // レビューアイコンサイズの一辺最大
+	IconAspectRate   float32 `yaml:"iconAspectRate" toml:"iconAspectRate"`     // 画像のアスペクト比
+}
+
+type SectionLimits struct {
+	SectionTitleLen   int     `yaml:"sectionTitleLen" toml:"sectionTitleLen"`     // セクションタイトルの最大文字数
+	ParagTextLenMax   int     `yaml:"paragTextLenMax" toml:"paragTextLenMax"`     // 説明文の文字数の上限
+	ParagsLenMax      int     `yaml:"paragsLenMax" toml:"paragsLenMax"`           // セクション中に存在できるパラグラフ最大数
+	ParagLinkLenMax   int     `yaml:"paragLinkLenMax" toml:"paragLinkLenMax"`     // リンクの文字数の長さの上限
+	ParagImgMaxBytes  int     `yaml:"paragImgMaxBytes" toml:"paragImgMaxBytes"`   // Parag内の画像として受理する画像の最大サイズ(KB)
+	ParagImgAspect    float32 `yaml:"paragImgAspect" toml:"paragImgAspect"`       // 画像のアスペクト比(負数なら制限しない)
+	ParagImgMax       int     `yaml:"paragImgMax" toml:"paragImgMax"`             // 画像サイズの一辺最大
+	ParagImageQuality int     `yaml:"paragImageQuality" toml:"paragImageQuality"` // 画像品質
+}
+
+// 設定ファイルに記載がない項目の既定値
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			ShutdownTimeout: 30,
+			MinFreeMb:       100,
+		},
+		Log: LogConfig{
+			Level:      "info",
+			File:       "echo.log",
+			MaxSizeMb:  100,
+			MaxBackups: 10,
+			MaxAgeDays: 30,
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			Endpoint:    "http://localhost:4318/v1/traces",
+			SampleRatio: 1,
+			ServiceName: "reviewmakerback",
+		},
+		Retention: RetentionConfig{
+			OperationDays: 365,
+			ErrorDays:     90,
+			ArchiveDir:    "log-archive",
+			Span:          86400,
+		},
+		Jobs: JobsConfig{
+			LeaderElection: true,
+			LockKey:        1801745522,
+		},
+		Queue: QueueConfig{
+			Workers:       4,
+			PollInterval:  2,
+			RetentionDays: 7,
+		},
+		FileGc: FileGcConfig{
+			MinAgeHours: 24,
+		},
+		Smtp: SmtpConfig{
+			Port: 587,
+		},
+		Ids: IdsConfig{
+			Alphabet:      common.DefaultIdGenerator.Alphabet,
+			Length:        common.DefaultIdGenerator.Length,
+			SessionLength: common.SessionIdGenerator.Length,
+		},
+		Limits: Limits{
+			PostPageSize:    5,
+			ReviewMaxInTier: 255,
+			LatestPostMax:   100,
+			User: UserLimits{
+				NameLenMax:     50,
+				ProfileLenMax:  400,
+				IconMaxBytes:   10000,
+				StorageQuotaMb: 500,
+			},
+			Tier: TierLimits{
+				NameLenMax:      100,
+				ParamsLenMax:    16,
+				ParamNameLenMax: 16,
+				ImgMaxBytes:     10000,
+				ImgMaxEdge:      1080,
+				ImgAspectRate:   10.0 / 3.0,
+			},
+			Review: ReviewLimits{
+				NameLenMax:       50,
+				TitleLenMax:      100,
+				SectionLenMax:    8,
+				FactorInfoLenMax: 16,
+				IconMaxBytes:     10000,
+				IconMaxEdge:      256,
+				IconAspectRate:   1.0,
+			},
+			Section: SectionLimits{
+				SectionTitleLen:   100,
+				ParagTextLenMax:   5000,
+				ParagsLenMax:      64,
+				ParagLinkLenMax:   400,
+				ParagImgMaxBytes:  10000,
+				ParagImgAspect:    -1,
+				ParagImgMax:       1080,
+				ParagImageQuality: 60,
+			},
+		},
+	}
+}
+
+// 設定の問題点をまとめて返すエラー
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "設定に問題があります:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+// 設定を読み込む
+// path 設定ファイルのパス(.yaml, .yml, .toml)、空文字列なら環境変数のみを使用する
+// lookupEnv 環境変数の取得に使用する関数(通常はos.LookupEnv)
+// 読み込みと検証で見つかった問題は、最初の一つで中断せずにまとめて返す
+func Load(path string, lookupEnv func(string) (string, bool)) (Config, error) {
+	config := Default()
+	var problems []string
+
+	if path != "" {
+		problems = append(problems, decodeFile(path, &config)...)
+	}
+	problems = append(problems, applyEnv(reflect.ValueOf(&config).Elem(), lookupEnv)...)
+	problems = append(problems, config.Problems()...)
+
+	if len(problems) > 0 {
+		return config, &ValidationError{Problems: problems}
+	}
+	return config, nil
+}
+
+// 設定ファイルを読み込む
+// 未知の項目は記述ミスの可能性が高いため問題とする
+func decodeFile(path string, config *Config) []string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return []string{fmt.Sprintf("設定ファイル'%s'が読み込めません: %s", path, err.Error())}
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(b))
+		decoder.KnownFields(true)
+		if err = decoder.Decode(config); err != nil && err != io.EOF {
+			return []string{fmt.Sprintf("設定ファイル'%s'の形式が異常です: %s", path, err.Error())}
+		}
+	case ".toml":
+		md, err := toml.Decode(string(b), config)
+		if err != nil {
+			return []string{fmt.Sprintf("設定ファイル'%s'の形式が異常です: %s", path, err.Error())}
+		}
+		var problems []string
+		for _, key := range md.Undecoded() {
+			problems = append(problems, fmt.Sprintf("設定ファイル'%s'の項目'%s'は存在しません", path, key.String()))
+		}
+		return problems
+	default:
+		return []string{fmt.Sprintf("設定ファイル'%s'の拡張子は.yaml, .yml, .tomlのいずれかにしてください", path)}
+	}
+	return nil
+}
+
+// envタグを持つ項目を環境変数で上書きする
+func applyEnv(v reflect.Value, lookupEnv func(string) (string, bool)) []string {
+	var problems []string
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			problems = append(problems, applyEnv(field, lookupEnv)...)
+			continue
+		}
+		name := t.Field(i).Tag.Get("env")
+		if name == "" {
+			continue
+		}
+		value, ok := lookupEnv(name)
+		if !ok || value == "" {
+			continue
+		}
+
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Int:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("環境変数'%s'は整数で指定してください", name))
+				continue
+			}
+			field.SetInt(int64(n))
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("環境変数'%s'はtrueかfalseで指定してください", name))
+				continue
+			}
+			field.SetBool(b)
+		case reflect.Float32, reflect.Float64:
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("環境変数'%s'は数値で指定してください", name))
+				continue
+			}
+			field.SetFloat(f)
+		case reflect.Slice:
+			values := []string{}
+			for _, s := range strings.Split(value, separator(t.Field(i))) {
+				if s = strings.TrimSpace(s); s != "" {
+					values = append(values, s)
+				}
+			}
+			field.Set(reflect.ValueOf(values))
+		}
+	}
+	return problems
+}
+
+// 環境変数でリストを指定する際の区切り文字(sepタグ、省略時はカンマ)
+func separator(field reflect.StructField) string {
+	if sep := field.Tag.Get("sep"); sep != "" {
+		return sep
+	}
+	return ","
+}
+
+// requiredタグを持つ項目が設定されているかチェックする
+func checkRequired(v reflect.Value, prefix string) []string {
+	var problems []string
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		key := prefix + t.Field(i).Tag.Get("yaml")
+		if field.Kind() == reflect.Struct {
+			problems = append(problems, checkRequired(field, key+".")...)
+			continue
+		}
+		if t.Field(i).Tag.Get("required") == "true" && field.IsZero() {
+			problems = append(problems, fmt.Sprintf("'%s'(環境変数'%s')がありません", key, t.Field(i).Tag.Get("env")))
+		}
+	}
+	return problems
+}
+
+// 設定の問題点を全て返す
+func (c Config) Problems() []string {
+	problems := checkRequired(reflect.ValueOf(c), "")
+
+	checkPort := func(key string, port int) {
+		if port < 0 || port > 65535 {
+			problems = append(problems, fmt.Sprintf("'%s'はポート番号(0から65535)で指定してください", key))
+		}
+	}
+	checkPort("database.port", c.Database.Port)
+	checkPort("server.port", c.Server.Port)
+	checkPort("smtp.port", c.Smtp.Port)
+
+	if c.Smtp.Host != "" && c.Smtp.From == "" {
+		problems = append(problems, "SMTPを使用する場合は'smtp.from'(環境変数'BACK_SMTP_FROM')を指定してください")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		problems = append(problems, "'server.shutdownTimeout'は正の値で指定してください")
+	}
+	if c.Server.MinFreeMb < 0 {
+		problems = append(problems, "'server.minFreeMb'は0以上で指定してください")
+	}
+	switch strings.ToLower(c.Log.Level) {
+	case "debug", "info", "warn", "error":
+	default:
+		problems = append(problems, "'log.level'はdebug, info, warn, errorのいずれかで指定してください")
+	}
+	if c.Log.MaxSizeMb <= 0 {
+		problems = append(problems, "'log.maxSizeMb'は正の値で指定してください")
+	}
+	if c.Log.MaxBackups < 0 || c.Log.MaxAgeDays < 0 {
+		problems = append(problems, "'log.maxBackups'と'log.maxAgeDays'は0以上で指定してください")
+	}
+	switch c.Tracing.Exporter {
+	case "none", "otlp", "stdout":
+	default:
+		problems = append(problems, "'tracing.exporter'はnone, otlp, stdoutのいずれかで指定してください")
+	}
+	if c.Tracing.Exporter == "otlp" && c.Tracing.Endpoint == "" {
+		problems = append(problems, "OTLPを使用する場合は'tracing.endpoint'(環境変数'BACK_TRACE_ENDPOINT')を指定してください")
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		problems = append(problems, "'tracing.sampleRatio'は0から1の範囲で指定してください")
+	}
+	for _, header := range c.Tracing.Headers {
+		if strings.Index(header, "=") <= 0 {
+			problems = append(problems, "'tracing.headers'は'名前=値'の形式で指定してください")
+			break
+		}
+	}
+	if c.Retention.OperationDays < 0 || c.Retention.ErrorDays < 0 {
+		problems = append(problems, "'retention.operationDays'と'retention.errorDays'は0以上で指定してください")
+	}
+	if c.Retention.Span <= 0 {
+		problems = append(problems, "'retention.span'は正の値で指定してください")
+	}
+	for _, schedule := range c.Jobs.Schedules {
+		if strings.Index(schedule, "=") <= 0 {
+			problems = append(problems, "'jobs.schedules'は'名前=スケジュール'の形式で指定してください")
+			break
+		}
+	}
+	if c.Queue.Workers <= 0 || c.Queue.PollInterval <= 0 || c.Queue.RetentionDays <= 0 {
+		problems = append(problems, "'queue.workers'、'queue.pollInterval'、'queue.retentionDays'は正の値で指定してください")
+	}
+	if c.FileGc.MinAgeHours <= 0 {
+		problems = append(problems, "'fileGc.minAgeHours'は正の値で指定してください")
+	}
+	if err := c.Ids.Generator().Validate(); err != nil {
+		problems = append(problems, fmt.Sprintf("'ids'の設定に問題があります: %s", err.Error()))
+	}
+	if err := c.Ids.SessionGenerator().Validate(); err != nil {
+		problems = append(problems, fmt.Sprintf("'ids.sessionLength'の設定に問題があります: %s", err.Error()))
+	}
+	if c.Limits.PostSpan < 0 {
+		problems = append(problems, "'limits.postSpan'は0以上で指定してください")
+	}
+
+	// 制限値は全て正の値でなければならない(パラグラフ画像のアスペクト比は負数で無制限)
+	checkPositive(reflect.ValueOf(c.Limits), "limits.", &problems)
+	return problems
+}
+
+func checkPositive(v reflect.Value, prefix string, problems *[]string) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		key := prefix + t.Field(i).Tag.Get("yaml")
+		if t.Field(i).Tag.Get("env") != "" {
+			// 環境変数で指定する項目は個別にチェックする
+			continue
+		}
+		switch field.Kind() {
+		case reflect.Struct:
+			checkPositive(field, key+".", problems)
+		case reflect.Int:
+			if field.Int() <= 0 {
+				*problems = append(*problems, fmt.Sprintf("'%s'は正の値で指定してください", key))
+			}
+		case reflect.Float32, reflect.Float64:
+			if key == "limits.section.paragImgAspect" {
+				if field.Float() == 0 {
+					*problems = append(*problems, fmt.Sprintf("'%s'は0以外で指定してください", key))
+				}
+			} else if field.Float() <= 0 {
+				*problems = append(*problems, fmt.Sprintf("'%s'は正の値で指定してください", key))
+			}
+		}
+	}
+}
+
+// 環境変数を直接参照するコード(セッション管理等)のために、設定ファイルで指定された値を環境変数に反映する
+// 既に設定されている環境変数は変更しない
+func (c Config) ExportEnv() error {
+	return exportEnv(reflect.ValueOf(c))
+}
+
+func exportEnv(v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := exportEnv(field); err != nil {
+				return err
+			}
+			continue
+		}
+		name := t.Field(i).Tag.Get("env")
+		if name == "" || os.Getenv(name) != "" || field.IsZero() {
+			continue
+		}
+		var value string
+		switch field.Kind() {
+		case reflect.String:
+			value = field.String()
+		case reflect.Int:
+			value = strconv.FormatInt(field.Int(), 10)
+		case reflect.Bool:
+			value = strconv.FormatBool(field.Bool())
+		case reflect.Float32, reflect.Float64:
+			value = strconv.FormatFloat(field.Float(), 'g', -1, 64)
+		case reflect.Slice:
+			value = strings.Join(field.Interface().([]string), separator(t.Field(i)))
+		}
+		if err := os.Setenv(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,67 +1,127 @@
 package db
 
 import (
+	"context"
 	"errors"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-func GetNotifications(userId string, limit int) ([]NotificationJoinRead, *gorm.DB) {
+// 通知を取得する
+// cursorに0より大きい通知IDを指定すると、その通知より古いものを取得する
+func GetNotifications(ctx context.Context, userId string, cursor uint, limit int) ([]NotificationJoinRead, *gorm.DB) {
 	var notifications []NotificationJoinRead
 
-	db1 := Db.Order("created_at DESC, id DESC").Limit(limit).Model(&Notification{})
-
 	// 未読状態が管理されてない場合、is_deleteなら最初から既読状態にする
-	isRead := "COALESCE(r.is_read, is_deleted)"
+	isRead := "COALESCE(r.is_read, t.is_deleted)"
+
+	tx := Db.WithContext(ctx).Select("t.id, t.content, t.is_important, t.url, " + isRead + " as is_read, t.created_at").Table("notifications as t")
+	tx = tx.Joins("left join notification_reads as r on r.notification_id = t.id and r.user_id = ?", userId)
+
+	// 全ユーザー宛と自分宛の通知のみ
+	tx = tx.Where("t.user_id in ?", []string{"", userId})
+
+	// 非表示にした通知は除外する
+	tx = tx.Where("COALESCE(r.is_hidden, ?) = ?", false, false)
+
+	if cursor > 0 {
+		// Postgresのみ可能な文(行値の比較)
+		tx = tx.Where("(t.created_at, t.id) < (select n.created_at, n.id from notifications as n where n.id = ?)", cursor)
+	}
 
-	tx := Db.Select("id, content, is_important, url, "+isRead+" as is_read, created_at").Table("(?) as t", db1)
-	tx = tx.Joins("left join notification_reads as r on r.notification_id = t.id and r.user_id = ?", userId).Order("created_at DESC, id DESC")
-	tx = tx.Scan(&notifications)
+	tx = tx.Order("t.created_at DESC, t.id DESC").Limit(limit).Scan(&notifications)
 
 	// Gormではbool型の項目でのnullはfalseになる
 	return notifications, tx
 }
 
-func GetNotificationsCount(userId string, limit int) (int64, *gorm.DB) {
+// 一覧に表示される直近limit件の通知のうち、未読の通知の数を取得する
+// 非表示にした通知は一覧と同じく件数の枠に含めない
+func GetNotificationsCount(ctx context.Context, userId string, limit int) (int64, *gorm.DB) {
 	var cnt int64
 
-	db1 := Db.Order("created_at DESC, id DESC").Limit(limit).Model(&Notification{})
-
 	// 未読状態が管理されてない場合、is_deleteなら最初から既読状態にする
-	isRead := "COALESCE(r.is_read, is_deleted)"
+	isRead := "COALESCE(r.is_read, t.is_deleted)"
 
-	db2 := Db.Select("content, is_important, url, "+isRead+" as is_read, created_at").Table("(?) as t", db1)
-	db2 = db2.Joins("left join notification_reads as r on r.notification_id = t.id and r.user_id = ?", userId)
+	db1 := Db.WithContext(ctx).Select(isRead+" as is_read").Table("notifications as t")
+	db1 = db1.Joins("left join notification_reads as r on r.notification_id = t.id and r.user_id = ?", userId)
+	db1 = db1.Where("t.user_id in ?", []string{"", userId})
+	db1 = db1.Where("COALESCE(r.is_hidden, ?) = ?", false, false)
+	db1 = db1.Order("t.created_at DESC, t.id DESC").Limit(limit)
 
 	// Postgresのみ可能な文
-	db3 := Db.Table("(?) as t2", db2).Where("COALESCE(t2.is_read, ?) = ?", false, false).Count(&cnt)
+	db2 := Db.WithContext(ctx).Table("(?) as t2", db1).Where("COALESCE(t2.is_read, ?) = ?", false, false).Count(&cnt)
 
-	return cnt, db3
+	return cnt, db2
 }
 
-// 通知の既読情報を更新する
-func UpdateNotificationRead(userId string, notificationId uint, isRead bool) error {
+// 通知の存在チェック(他のユーザー宛の通知は存在しないものとする)
+func existsNotification(userId string, notificationId uint) error {
 	var cnt int64
-	tx := Db.Where("id = ?", notificationId).Model(&Notification{}).Count(&cnt)
+	tx := Db.Where("id = ? and user_id in ?", notificationId, []string{"", userId}).Model(&Notification{}).Count(&cnt)
 	if tx.Error != nil {
 		return tx.Error
 	} else if cnt != 1 {
 		return errors.New("指定された通知は存在しません")
 	}
+	return nil
+}
 
-	var nr NotificationRead
-	tx = Db.Where("notification_id = ? and user_id = ?", notificationId, userId).Find(&nr).Count(&cnt)
+// 通知の既読情報を更新する
+func UpdateNotificationRead(userId string, notificationId uint, isRead bool) error {
+	if err := existsNotification(userId, notificationId); err != nil {
+		return err
+	}
 
-	if tx.Error != nil {
-		return tx.Error
-	} else if cnt == 0 {
-		return Db.Create(NotificationRead{
-			NotificationId: notificationId,
-			UserId:         userId,
-			IsRead:         isRead,
-		}).Error
-	} else {
-		return tx.Update("is_read", isRead).Error
+	// 既読情報の作成と更新を一文で行う
+	return Db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "notification_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"is_read"}),
+	}).Create(&NotificationRead{
+		NotificationId: notificationId,
+		UserId:         userId,
+		IsRead:         isRead,
+	}).Error
+}
+
+// 指定した通知ID以下の通知をすべて既読にする
+func UpdateNotificationReadAll(userId string, lastNotificationId uint) (int64, error) {
+	tx := Db.Exec(
+		`INSERT INTO notification_reads (notification_id, user_id, is_read, is_hidden)
+		SELECT id, ?, true, false FROM notifications WHERE id <= ? AND user_id IN ('', ?)
+		ON CONFLICT (notification_id, user_id) DO UPDATE SET is_read = true`,
+		userId,
+		lastNotificationId,
+		userId,
+	)
+	return tx.RowsAffected, tx.Error
+}
+
+// 通知をユーザーの一覧から非表示にする
+// 非表示にした通知は既読としても扱う
+func HideNotification(userId string, notificationId uint) error {
+	if err := existsNotification(userId, notificationId); err != nil {
+		return err
 	}
 
+	return Db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "notification_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"is_read", "is_hidden"}),
+	}).Create(&NotificationRead{
+		NotificationId: notificationId,
+		UserId:         userId,
+		IsRead:         true,
+		IsHidden:       true,
+	}).Error
+}
+
+// 特定のユーザー宛の通知を作成する
+func CreateUserNotification(userId string, content string, url string, isImportant bool) error {
+	return Db.Create(&Notification{
+		UserId:      userId,
+		Content:     content,
+		IsImportant: isImportant,
+		Url:         url,
+	}).Error
 }
@@ -0,0 +1,261 @@
+package db
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	b64 "encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	common "reviewmakerback/common"
+	"reviewmakerback/config"
+)
+
+// 暗号文の形式を表す接頭辞
+// 'v2:鍵ID:base64(nonce+暗号文)' の形式で保存する
+const encryptedPrefix = "v2:"
+
+// 保存済みの値の再暗号化を行う間隔(秒)
+const EncryptionRotateSpan = 3600
+
+// 再暗号化を一度に処理する行数
+const encryptionRotateBatch = 100
+
+// 暗号化サービス
+// AES-GCMで暗号化し、暗号文に鍵IDを埋め込むことで複数の鍵を併用できるようにする
+type Encryptor struct {
+	aeads        map[string]cipher.AEAD // 鍵IDと暗号化アルゴリズムの対応
+	currentKeyId string                 // 暗号化に使用する鍵ID
+	legacyKey    string                 // 旧形式(AES-CFB)の復号に用いるパスワード
+}
+
+// 暗号化サービス(InitDbで読み込む)
+var Encryption *Encryptor
+
+// 鍵IDと鍵(16, 24, 32バイト)から暗号化サービスを作成する
+// legacyKeyを指定すると、旧形式の暗号文も復号できる
+func NewEncryptor(keys map[string][]byte, currentKeyId string, legacyKey string) (*Encryptor, error) {
+	e := &Encryptor{
+		aeads:        map[string]cipher.AEAD{},
+		currentKeyId: currentKeyId,
+		legacyKey:    legacyKey,
+	}
+	for id, key := range keys {
+		if !common.TestRegexp(`^[a-zA-Z0-9_-]+$`, id) {
+			return nil, fmt.Errorf("鍵ID'%s'は使用できません", id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("鍵'%s'が不正です: %s", id, err.Error())
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		e.aeads[id] = aead
+	}
+	if _, ok := e.aeads[currentKeyId]; !ok {
+		return nil, fmt.Errorf("暗号化に使用する鍵'%s'がありません", currentKeyId)
+	}
+	return e, nil
+}
+
+// 設定から暗号化サービスを作成する
+// Keys 'ID:base64の鍵'をカンマ区切りで指定
+// CurrentKey 暗号化に使用する鍵ID(省略時は最後に指定した鍵)
+// LegacyKey 旧形式の暗号文を復号するためのパスワード
+func NewEncryptorFromConfig(conf config.EncryptionConfig) (*Encryptor, error) {
+	keys, currentKeyId, err := ParseEncryptionKeys(conf.Keys)
+	if err != nil {
+		return nil, err
+	}
+	if conf.CurrentKey != "" {
+		currentKeyId = conf.CurrentKey
+	}
+	return NewEncryptor(keys, currentKeyId, conf.LegacyKey)
+}
+
+// 'ID:base64の鍵'のカンマ区切り文字列を解析し、最後に指定された鍵IDとともに返す
+func ParseEncryptionKeys(s string) (map[string][]byte, string, error) {
+	keys := map[string][]byte{}
+	lastId := ""
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		index := strings.Index(pair, ":")
+		if index <= 0 {
+			return nil, "", fmt.Errorf("鍵の形式が不正です: '%s'", common.Substring(pair, 0, 8))
+		}
+		key, err := b64.StdEncoding.DecodeString(pair[index+1:])
+		if err != nil {
+			return nil, "", fmt.Errorf("鍵'%s'がbase64ではありません", pair[:index])
+		}
+		keys[pair[:index]] = key
+		lastId = pair[:index]
+	}
+	if len(keys) == 0 {
+		return nil, "", errors.New("暗号化の鍵がありません")
+	}
+	return keys, lastId, nil
+}
+
+// 平文を現在の鍵で暗号化する
+// 空文字列は暗号化しない
+func (e *Encryptor) Encrypt(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+
+	aead := e.aeads[e.currentKeyId]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	// 鍵IDを追加データとして認証対象に含める
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(e.currentKeyId))
+	return encryptedPrefix + e.currentKeyId + ":" + b64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// 暗号文を復号する
+// 旧形式(固定IVで暗号化したJSON)も復号できる
+func (e *Encryptor) Decrypt(ciphertext string) (string, error) {
+	if ciphertext == "" {
+		return "", nil
+	}
+
+	if !strings.HasPrefix(ciphertext, encryptedPrefix) {
+		if e.legacyKey == "" {
+			return "", errors.New("旧形式の暗号文を復号する鍵がありません")
+		}
+		return DecryptTextJson(ciphertext, e.legacyKey)
+	}
+
+	body := ciphertext[len(encryptedPrefix):]
+	index := strings.Index(body, ":")
+	if index <= 0 {
+		return "", errors.New("暗号文の形式が不正です")
+	}
+	keyId := body[:index]
+	aead, ok := e.aeads[keyId]
+	if !ok {
+		return "", fmt.Errorf("鍵'%s'がありません", keyId)
+	}
+
+	sealed, err := b64.StdEncoding.DecodeString(body[index+1:])
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("暗号文の形式が不正です")
+	}
+
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(keyId))
+	if err != nil {
+		return "", errors.New("暗号文が改ざんされているか、鍵が一致しません")
+	}
+	return string(plaintext), nil
+}
+
+// 暗号文が現在の鍵で暗号化されているかどうか
+func (e *Encryptor) IsCurrent(ciphertext string) bool {
+	return ciphertext == "" || strings.HasPrefix(ciphertext, e.currentPrefix())
+}
+
+func (e *Encryptor) currentPrefix() string {
+	return encryptedPrefix + e.currentKeyId + ":"
+}
+
+// 暗号文を現在の鍵で暗号化し直す
+func (e *Encryptor) Rotate(ciphertext string) (string, error) {
+	if e.IsCurrent(ciphertext) {
+		return ciphertext, nil
+	}
+	plaintext, err := e.Decrypt(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	return e.Encrypt(plaintext)
+}
+
+// 暗号化して保存している項目
+type EncryptedColumn struct {
+	Table     string // テーブル名
+	KeyColumn string // 主キーの列名
+	Column    string // 暗号化している列名
+	Plaintext bool   // 暗号化前の値が平文で保存されているかどうか(falseなら旧形式の暗号文)
+}
+
+var encryptedColumns []EncryptedColumn
+
+// 再暗号化の対象とする項目を登録する
+// plaintextをtrueにすると、接頭辞の無い値を旧形式の暗号文ではなく平文として暗号化する
+func RegisterEncryptedColumn(table string, keyColumn string, column string, plaintext bool) {
+	encryptedColumns = append(encryptedColumns, EncryptedColumn{
+		Table:     table,
+		KeyColumn: keyColumn,
+		Column:    column,
+		Plaintext: plaintext,
+	})
+}
+
+// 暗号化サービスの形式で暗号化された値かどうか
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// 現在の鍵以外で暗号化されている保存済みの値を全て現在の鍵で暗号化し直し、更新した件数を返す
+func RotateEncryptedColumns() (int64, error) {
+	if Encryption == nil {
+		return 0, nil
+	}
+
+	var total int64
+	for _, ec := range encryptedColumns {
+		lastKey := ""
+		for {
+			var rows []map[string]interface{}
+			tx := Db.Table(ec.Table).Select(ec.KeyColumn+", "+ec.Column).
+				Where(ec.KeyColumn+" > ? and "+ec.Column+" <> ? and "+ec.Column+" not like ?", lastKey, "", Encryption.currentPrefix()+"%").
+				Order(ec.KeyColumn + " asc").Limit(encryptionRotateBatch).Find(&rows)
+			if tx.Error != nil {
+				return total, tx.Error
+			}
+			if len(rows) == 0 {
+				break
+			}
+
+			for _, row := range rows {
+				key, _ := row[ec.KeyColumn].(string)
+				value, _ := row[ec.Column].(string)
+				lastKey = key
+
+				var rotated string
+				var err error
+				if ec.Plaintext && !IsEncrypted(value) {
+					// 暗号化前の平文
+					rotated, err = Encryption.Encrypt(value)
+				} else {
+					rotated, err = Encryption.Rotate(value)
+				}
+				if err != nil {
+					// 復号できない値は残して次へ進む(値そのものは記録しない)
+					WriteErrorLog("none", "none", "rotc-001", "保存済みの値を再暗号化できません", fmt.Sprintf("%s.%s %s", ec.Table, ec.Column, err.Error()))
+					continue
+				}
+
+				// 他の処理で更新されていた場合は上書きしない
+				tx = Db.Table(ec.Table).Where(ec.KeyColumn+" = ? and "+ec.Column+" = ?", key, value).Update(ec.Column, rotated)
+				if tx.Error != nil {
+					return total, tx.Error
+				}
+				total += tx.RowsAffected
+			}
+		}
+	}
+	return total, nil
+}
@@ -1,8 +1,13 @@
 package db
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"os"
+
+	"reviewmakerback/common"
+	"reviewmakerback/config"
+	"reviewmakerback/logger"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -12,26 +17,48 @@ import (
 var Db *gorm.DB
 
 // 関数についても大文字で定義しないと外部から参照できない
-func InitDb() *gorm.DB {
-	Db = connectDB()
+func InitDb(conf config.Config) *gorm.DB {
+	PostSpanMin = conf.Limits.PostSpan
+	IdGenerator = conf.Ids.Generator()
+	common.SessionIdGenerator = conf.Ids.SessionGenerator()
+
+	// 暗号化サービスを読み込む
+	var err error
+	Encryption, err = NewEncryptorFromConfig(conf.Encryption)
+	if err != nil {
+		panic(fmt.Sprintf("暗号化の鍵が読み込めません: %s", err.Error()))
+	}
+
+	Db = connectDB(conf.Database)
 	if Db != nil {
 		migrateDB()
-		println("マイグレートを実行しました")
-		ArrangeSession()
-		println("最初のセッション整理を行いました")
+		logger.Info(context.Background(), "マイグレートを実行しました", nil)
+		// 平文や古い鍵で保存されている値を現在の鍵で暗号化する
+		cnt, err := RotateEncryptedColumns()
+		if err != nil {
+			panic(fmt.Sprintf("保存済みの値を暗号化できません: %s", err.Error()))
+		}
+		logger.Info(context.Background(), "保存済みの値を暗号化しました", logger.Fields{"count": cnt})
+		err = PromoteAdmins(conf.Admin.Users)
+		if err != nil {
+			panic(fmt.Sprintf("管理者を設定できません: %s", err.Error()))
+		}
+		ArrangeSession(context.Background())
+		logger.Info(context.Background(), "最初のセッション整理を行いました", nil)
+		registerTotalsMetrics()
 	}
 	return Db
 }
 
 // データベースに接続する関数
-func connectDB() *gorm.DB {
-	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable TimeZone=%s",
-		os.Getenv("BACK_DB_HOST"),
-		os.Getenv("BACK_DB_USER"),
-		os.Getenv("BACK_DB_PASSWORD"),
-		os.Getenv("BACK_DB_NAME"),
-		os.Getenv("BACK_DB_PORT"),
-		os.Getenv("BACK_DB_TIMEZONE"))
+func connectDB(conf config.DatabaseConfig) *gorm.DB {
+	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%d sslmode=disable TimeZone=%s",
+		conf.Host,
+		conf.User,
+		conf.Password,
+		conf.Name,
+		conf.Port,
+		conf.TimeZone)
 
 	var err error
 	Db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{
@@ -39,30 +66,142 @@ func connectDB() *gorm.DB {
 	})
 
 	if err != nil {
-		println("データベース接続エラー")
+		logger.Error(context.Background(), "データベース接続エラー", logger.Fields{"error": err})
 		return nil
 	}
 
 	if Db == nil {
-		println("データベース接続エラー")
+		logger.Error(context.Background(), "データベース接続エラー", nil)
 		return nil
 	}
-	println("データベース接続を確認")
+	logger.Info(context.Background(), "データベース接続を確認", nil)
+
+	// クエリの実行時間を記録する
+	if err = registerQueryMetrics(Db); err != nil {
+		logger.Warn(context.Background(), "メトリクスの登録に失敗しました", logger.Fields{"error": err})
+	}
+	// 親スパンがあるクエリをトレースに記録する
+	if err = registerQueryTracing(Db); err != nil {
+		logger.Warn(context.Background(), "トレースの登録に失敗しました", logger.Fields{"error": err})
+	}
 
 	return Db
 }
 
+// データベースに接続できるかどうかを確認する
+func PingDb(ctx context.Context) error {
+	if Db == nil {
+		return errors.New("データベースに接続していません")
+	}
+	sqlDb, err := Db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDb.PingContext(ctx)
+}
+
+// データベースの接続を閉じる
+func CloseDb() error {
+	if Db == nil {
+		return nil
+	}
+	sqlDb, err := Db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDb.Close()
+}
+
 // データベースのテーブルをマイグレートする関数
 func migrateDB() {
-	Db.AutoMigrate(
-		&Session{},
-		&TempSession{},
-		&User{},
-		&OperationLog{},
-		&ErrorLog{},
-		&Tier{},
-		&Review{},
-		&Notification{},
-		&NotificationRead{},
-	)
+	// 一意にした列の既存のインデックスは、同じ名前で作り直させるため削除する
+	migrationErr = dropNonUniqueIndex("refresh_tokens", "idx_refresh_tokens_session_id")
+	if migrationErr == nil {
+		migrationErr = Db.AutoMigrate(models...)
+	}
+	if migrationErr == nil {
+		migrationErr = addMissingPrimaryKeys("operation_logs", "error_logs")
+	}
+	if migrationErr != nil {
+		logger.Error(context.Background(), "マイグレートに失敗しました", logger.Fields{"error": migrationErr})
+	}
+}
+
+// 一意でないインデックスがあれば削除する(AutoMigrateは同じ名前のインデックスがあると作成しないため)
+func dropNonUniqueIndex(table string, index string) error {
+	var cnt int64
+	tx := Db.Raw("select count(*) from pg_index as i join pg_class as c on c.oid = i.indexrelid where c.relname = ? and i.indrelid = to_regclass(?) and not i.indisunique", index, table).Scan(&cnt)
+	if tx.Error != nil || cnt == 0 {
+		return tx.Error
+	}
+	if err := Db.Exec(fmt.Sprintf("drop index %s", index)).Error; err != nil {
+		return err
+	}
+	logger.Info(context.Background(), "一意でないインデックスを削除しました", logger.Fields{"index": index})
+	return nil
+}
+
+// 一意制約に違反した場合のエラーかどうか
+func isUniqueViolation(err error) bool {
+	var pgErr interface{ SQLState() string }
+	return errors.As(err, &pgErr) && pgErr.SQLState() == "23505"
+}
+
+// 主キーのないテーブルのid列を主キーにする
+// 既存のテーブルに主キーの列を追加した場合、AutoMigrateは列(bigserial)のみ追加して主キーにしないため
+func addMissingPrimaryKeys(tables ...string) error {
+	for _, table := range tables {
+		var cnt int64
+		tx := Db.Raw("select count(*) from pg_index where indrelid = ?::regclass and indisprimary", table).Scan(&cnt)
+		if tx.Error != nil {
+			return tx.Error
+		}
+		if cnt > 0 {
+			continue
+		}
+		if err := Db.Exec(fmt.Sprintf("alter table %s add primary key (id)", table)).Error; err != nil {
+			return err
+		}
+		logger.Info(context.Background(), "主キーを追加しました", logger.Fields{"table": table})
+	}
+	return nil
+}
+
+// マイグレートの結果(準備状態の確認に使用する)
+var migrationErr = errors.New("マイグレートが実行されていません")
+
+// マイグレートが完了しており、全てのテーブルが存在するかどうかを確認する
+func MigrationStatus() error {
+	if migrationErr != nil {
+		return migrationErr
+	}
+	for _, model := range models {
+		if !Db.Migrator().HasTable(model) {
+			return fmt.Errorf("テーブル'%T'が存在しません", model)
+		}
+	}
+	return nil
+}
+
+// マイグレートの対象
+var models = []interface{}{
+	&Session{},
+	&RefreshToken{},
+	&TempSession{},
+	&User{},
+	&OperationLog{},
+	&ErrorLog{},
+	&Tier{},
+	&Review{},
+	&Notification{},
+	&NotificationRead{},
+	&Report{},
+	&SuspensionLog{},
+	&BannedAccount{},
+	&RateLimitBucket{},
+	&Webhook{},
+	&WebhookDelivery{},
+	&ProviderLinkCode{},
+	&QueueJob{},
+	&StorageUsage{},
 }
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	common "reviewmakerback/common"
+)
+
+// 通知ダイジェストの送信対象を確認する間隔(秒)
+const DigestSendSpan = 3600
+
+// 送信先メールアドレスの確認用トークンの有効期限
+const DigestConfirmExpire = 24 * time.Hour
+
+// 確認メールを続けて送信できない時間(第三者のアドレスへの大量送信を防ぐ)
+const DigestConfirmResendSpan = 10 * time.Minute
+
+// 通知ダイジェストの送信頻度
+var DigestFrequencies = []string{
+	"none",
+	"daily",
+	"weekly",
+}
+
+// 送信頻度に対応する送信間隔
+func DigestInterval(frequency string) time.Duration {
+	if frequency == "weekly" {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// 通知ダイジェストの送信時期に達したユーザーを取得する
+func GetDigestTargetUsers(now time.Time) ([]User, error) {
+	var users []User
+	tx := Db.Select("user_id, name, google_email, digest_email, digest_frequency, digest_token, last_digest_at").Where(
+		Db.Where("digest_frequency = ? and (last_digest_at is null or last_digest_at <= ?)", "daily", now.Add(-DigestInterval("daily"))).
+			Or("digest_frequency = ? and (last_digest_at is null or last_digest_at <= ?)", "weekly", now.Add(-DigestInterval("weekly"))),
+	).Find(&users)
+	return users, tx.Error
+}
+
+// 指定日時以降に発信された未読の通知を取得する
+func GetUnreadNotificationsSince(userId string, since time.Time, limit int) ([]NotificationJoinRead, *gorm.DB) {
+	var notifications []NotificationJoinRead
+
+	// 未読状態が管理されてない場合、is_deleteなら最初から既読状態にする
+	isRead := "COALESCE(r.is_read, t.is_deleted)"
+
+	tx := Db.Select("t.id, t.content, t.is_important, t.url, " + isRead + " as is_read, t.created_at").Table("notifications as t")
+	tx = tx.Joins("left join notification_reads as r on r.notification_id = t.id and r.user_id = ?", userId)
+	tx = tx.Where("t.user_id in ?", []string{"", userId})
+	tx = tx.Where(isRead+" = ? and COALESCE(r.is_hidden, ?) = ? and t.created_at > ?", false, false, false, since)
+	tx = tx.Order("t.is_important DESC, t.created_at DESC, t.id DESC").Limit(limit).Scan(&notifications)
+
+	return notifications, tx
+}
+
+// 通知ダイジェストの送信日時を記録する
+func UpdateLastDigestAt(userId string, t time.Time) error {
+	return Db.Model(&User{}).Where("user_id = ?", userId).Update("last_digest_at", t).Error
+}
+
+// 通知ダイジェストの設定を更新する
+func UpdateDigestSetting(userId string, email string, frequency string, token string) error {
+	return Db.Model(&User{}).Where("user_id = ?", userId).Updates(map[string]interface{}{
+		"digest_email":     email,
+		"digest_frequency": frequency,
+		"digest_token":     token,
+	}).Error
+}
+
+// 送信先メールアドレスを確認待ちにする
+// 確認用トークンで確認するまで、通知ダイジェストは確認済みの送信先に送信する
+func SetPendingDigestEmail(userId string, email string, confirmToken string, now time.Time) error {
+	return Db.Model(&User{}).Where("user_id = ?", userId).Updates(map[string]interface{}{
+		"digest_pending_email":   email,
+		"digest_confirm_hash":    common.GetSHA256(confirmToken),
+		"digest_confirm_sent_at": now,
+	}).Error
+}
+
+// 確認待ちの送信先メールアドレスを取り消す
+func ClearPendingDigestEmail(userId string) error {
+	return Db.Model(&User{}).Where("user_id = ?", userId).Updates(map[string]interface{}{
+		"digest_pending_email": "",
+		"digest_confirm_hash":  "",
+	}).Error
+}
+
+// 確認用トークンに対応する確認待ちの送信先メールアドレスを、送信先として確定する
+// 該当するユーザーがいないか、有効期限が切れている場合はfalseを返す
+func ConfirmDigestEmail(confirmToken string, now time.Time) (bool, error) {
+	if confirmToken == "" {
+		return false, nil
+	}
+	tx := Db.Model(&User{}).
+		Where("digest_confirm_hash = ? and digest_pending_email <> '' and digest_confirm_sent_at > ?", common.GetSHA256(confirmToken), now.Add(-DigestConfirmExpire)).
+		Updates(map[string]interface{}{
+			"digest_email":         gorm.Expr("digest_pending_email"),
+			"digest_pending_email": "",
+			"digest_confirm_hash":  "",
+		})
+	return tx.RowsAffected > 0, tx.Error
+}
+
+// 配信停止用トークンに対応するユーザーの通知ダイジェストを停止する
+// 該当するユーザーがいない場合はfalseを返す
+func UnsubscribeDigest(token string) (bool, error) {
+	if token == "" {
+		return false, nil
+	}
+	tx := Db.Model(&User{}).Where("digest_token = ?", token).Update("digest_frequency", "none")
+	return tx.RowsAffected > 0, tx.Error
+}
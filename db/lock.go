@@ -0,0 +1,72 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+)
+
+// Postgresのセッション単位のアドバイザリーロック
+// ロックは取得した接続に結び付くため、保持している間は接続を専有する
+// 接続が切れた場合はPostgres側でロックが解放される
+type AdvisoryLock struct {
+	key   int64
+	mutex sync.Mutex
+	conn  *sql.Conn
+}
+
+// keyを使用するアドバイザリーロックを作成する(この時点では取得しない)
+func NewAdvisoryLock(key int64) *AdvisoryLock {
+	return &AdvisoryLock{key: key}
+}
+
+// ロックを保持しているかどうかを確認し、保持していなければ取得を試みる
+// 他の接続が保持している場合は待たずにfalseを返す
+func (l *AdvisoryLock) Acquire(ctx context.Context) (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.conn != nil {
+		if err := l.conn.PingContext(ctx); err == nil {
+			return true, nil
+		}
+		// 接続が切れていればロックも失われている
+		l.conn.Close()
+		l.conn = nil
+	}
+
+	if Db == nil {
+		return false, errors.New("データベースに接続していません")
+	}
+	sqlDb, err := Db.DB()
+	if err != nil {
+		return false, err
+	}
+	conn, err := sqlDb.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var acquired bool
+	err = conn.QueryRowContext(ctx, "select pg_try_advisory_lock($1)", l.key).Scan(&acquired)
+	if err != nil || !acquired {
+		conn.Close()
+		return false, err
+	}
+	l.conn = conn
+	return true, nil
+}
+
+// ロックを解放して接続をプールに戻す
+func (l *AdvisoryLock) Release() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.conn == nil {
+		return
+	}
+	l.conn.ExecContext(context.Background(), "select pg_advisory_unlock($1)", l.key)
+	l.conn.Close()
+	l.conn = nil
+}
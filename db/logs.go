@@ -0,0 +1,186 @@
+package db
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 操作ログ・エラーログの検索条件
+// 空文字列・ゼロ値の項目は条件に含めない
+type LogFilter struct {
+	UserId    string    // 操作したユーザーまたは操作の対象となったユーザーのID
+	IpAddress string    // IPアドレス
+	Code      string    // 操作ログは操作コード(4文字)、エラーログはエラーID(前方一致)
+	From      time.Time // この日時以降
+	To        time.Time // この日時より前
+}
+
+// アーカイブの際に一度に読み込むログの行数
+const logArchiveBatch = 1000
+
+// 操作ログを新しい順に検索する
+func GetOperationLogs(filter LogFilter, page int, pageSize int) ([]OperationLog, error) {
+	tx := Db.Model(&OperationLog{})
+	if filter.UserId != "" {
+		tx = tx.Where("user_id = ? or target_user_id = ?", filter.UserId, filter.UserId)
+	}
+	if filter.Code != "" {
+		tx = tx.Where("operation = ?", filter.Code)
+	}
+	tx = filterLogs(tx, filter)
+
+	var logs []OperationLog
+	tx = tx.Order("created_at desc, id desc").Offset(pageSize * (page - 1)).Limit(pageSize).Find(&logs)
+	return logs, tx.Error
+}
+
+// エラーログを新しい順に検索する
+func GetErrorLogs(filter LogFilter, page int, pageSize int) ([]ErrorLog, error) {
+	tx := Db.Model(&ErrorLog{})
+	if filter.UserId != "" {
+		tx = tx.Where("user_id = ?", filter.UserId)
+	}
+	if filter.Code != "" {
+		// 'gaus'のように指定した場合は同じ処理のエラーを全て対象にする
+		tx = tx.Where("error_id = ? or error_id like ?", filter.Code, filter.Code+"-%")
+	}
+	tx = filterLogs(tx, filter)
+
+	var logs []ErrorLog
+	tx = tx.Order("created_at desc, id desc").Offset(pageSize * (page - 1)).Limit(pageSize).Find(&logs)
+	return logs, tx.Error
+}
+
+// 操作ログ・エラーログに共通する条件を追加する
+func filterLogs(tx *gorm.DB, filter LogFilter) *gorm.DB {
+	if filter.IpAddress != "" {
+		tx = tx.Where("ip_address = ?", filter.IpAddress)
+	}
+	if !filter.From.IsZero() {
+		tx = tx.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		tx = tx.Where("created_at < ?", filter.To)
+	}
+	return tx
+}
+
+// ユーザー本人の操作と、ユーザーを対象とした権限による操作を新しい順に取得する
+// 続きを取得する場合は、最後に受け取ったログのIDをcursorに指定する
+func GetUserActivities(userId string, cursor uint64, limit int) ([]OperationLog, error) {
+	tx := Db.Select("id, user_id, target_user_id, ip_address, operation, created_at").
+		Where("user_id = ? or target_user_id = ?", userId, userId)
+	if cursor > 0 {
+		tx = tx.Where("id < ?", cursor)
+	}
+
+	var logs []OperationLog
+	tx = tx.Order("id desc").Limit(limit).Find(&logs)
+	return logs, tx.Error
+}
+
+// 保存期間を過ぎた操作ログをアーカイブしてから削除し、削除した件数を返す
+// dirを空文字列にするとアーカイブせずに削除する
+func ArchiveOperationLogs(dir string, before time.Time) (int64, error) {
+	return archiveLogs(dir, "operation_logs", &OperationLog{}, before)
+}
+
+// 保存期間を過ぎたエラーログをアーカイブしてから削除し、削除した件数を返す
+// dirを空文字列にするとアーカイブせずに削除する
+func ArchiveErrorLogs(dir string, before time.Time) (int64, error) {
+	return archiveLogs(dir, "error_logs", &ErrorLog{}, before)
+}
+
+func archiveLogs(dir string, table string, model interface{}, before time.Time) (int64, error) {
+	if dir == "" {
+		tx := Db.Where("created_at < ?", before).Delete(model)
+		return tx.RowsAffected, tx.Error
+	}
+
+	// 対象が無ければファイルを作成しない
+	var cnt int64
+	if tx := Db.Table(table).Where("created_at < ?", before).Count(&cnt); tx.Error != nil || cnt == 0 {
+		return 0, tx.Error
+	}
+
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return 0, err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s.jsonl.gz", table, time.Now().UTC().Format("20060102T150405Z")))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0640)
+	if err != nil {
+		return 0, err
+	}
+	// 書き込みに失敗した場合は不完全なファイルを残さない
+	fail := func(err error) (int64, error) {
+		f.Close()
+		os.Remove(path)
+		return 0, err
+	}
+
+	archive := NewLogArchiveWriter(f)
+	var lastId int64
+	for {
+		var rows []map[string]interface{}
+		tx := Db.Table(table).Where("created_at < ? and id > ?", before, lastId).
+			Order("id asc").Limit(logArchiveBatch).Find(&rows)
+		if tx.Error != nil {
+			return fail(tx.Error)
+		}
+		if len(rows) == 0 {
+			break
+		}
+		for _, row := range rows {
+			if err := archive.Write(row); err != nil {
+				return fail(err)
+			}
+			id, ok := row["id"].(int64)
+			if !ok {
+				return fail(fmt.Errorf("%sのidが読み込めません", table))
+			}
+			lastId = id
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return fail(err)
+	}
+	if err := f.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return 0, err
+	}
+
+	// アーカイブに書き込んだ行のみを削除する
+	tx := Db.Where("created_at < ? and id <= ?", before, lastId).Delete(model)
+	return tx.RowsAffected, tx.Error
+}
+
+// ログをgzip圧縮したJSON Lines形式で書き込む
+type LogArchiveWriter struct {
+	gz  *gzip.Writer
+	enc *json.Encoder
+}
+
+func NewLogArchiveWriter(w io.Writer) *LogArchiveWriter {
+	gz := gzip.NewWriter(w)
+	return &LogArchiveWriter{gz: gz, enc: json.NewEncoder(gz)}
+}
+
+// ログを一行書き込む
+func (a *LogArchiveWriter) Write(row interface{}) error {
+	return a.enc.Encode(row)
+}
+
+// 圧縮を終了する(元のWriterは閉じない)
+func (a *LogArchiveWriter) Close() error {
+	return a.gz.Close()
+}
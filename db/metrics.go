@@ -0,0 +1,99 @@
+package db
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+
+	"reviewmakerback/metrics"
+)
+
+// クエリの開始時刻を保存するキー
+const metricsStartKey = "metrics:start"
+
+// GORMの各処理の前後にクエリの実行時間を記録するコールバックを登録する
+func registerQueryMetrics(db *gorm.DB) error {
+	callback := db.Callback()
+	processors := []struct {
+		operation string
+		before    func(name string, fn func(*gorm.DB)) error
+		after     func(name string, fn func(*gorm.DB)) error
+	}{
+		{"create", callback.Create().Before("gorm:create").Register, callback.Create().After("gorm:create").Register},
+		{"query", callback.Query().Before("gorm:query").Register, callback.Query().After("gorm:query").Register},
+		{"update", callback.Update().Before("gorm:update").Register, callback.Update().After("gorm:update").Register},
+		{"delete", callback.Delete().Before("gorm:delete").Register, callback.Delete().After("gorm:delete").Register},
+		{"row", callback.Row().Before("gorm:row").Register, callback.Row().After("gorm:row").Register},
+		{"raw", callback.Raw().Before("gorm:raw").Register, callback.Raw().After("gorm:raw").Register},
+	}
+
+	for _, p := range processors {
+		operation := p.operation
+		if err := p.before("metrics:before_"+operation, func(tx *gorm.DB) {
+			tx.InstanceSet(metricsStartKey, time.Now())
+		}); err != nil {
+			return err
+		}
+		if err := p.after("metrics:after_"+operation, func(tx *gorm.DB) {
+			start, ok := tx.InstanceGet(metricsStartKey)
+			if !ok {
+				return
+			}
+			result := "ok"
+			if tx.Error != nil && tx.Error != gorm.ErrRecordNotFound {
+				result = "error"
+			}
+			metrics.DbQueryDuration.WithLabelValues(operation, result).Observe(time.Since(start.(time.Time)).Seconds())
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 有効なセッション数とTier・レビューの総数をメトリクスの取得時に集計する
+type totalsCollector struct {
+	activeSessions *prometheus.Desc
+	tiers          *prometheus.Desc
+	reviews        *prometheus.Desc
+}
+
+func newTotalsCollector() *totalsCollector {
+	return &totalsCollector{
+		activeSessions: prometheus.NewDesc("reviewmaker_active_sessions", "Number of sessions that have not expired.", nil, nil),
+		tiers:          prometheus.NewDesc("reviewmaker_tiers", "Number of tiers.", nil, nil),
+		reviews:        prometheus.NewDesc("reviewmaker_reviews", "Number of reviews.", nil, nil),
+	}
+}
+
+func (t *totalsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- t.activeSessions
+	ch <- t.tiers
+	ch <- t.reviews
+}
+
+func (t *totalsCollector) Collect(ch chan<- prometheus.Metric) {
+	count := func(desc *prometheus.Desc, tx *gorm.DB) {
+		var cnt int64
+		if err := tx.Count(&cnt).Error; err != nil {
+			ch <- prometheus.NewInvalidMetric(desc, err)
+			return
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(cnt))
+	}
+	count(t.activeSessions, Db.Model(&Session{}).Where("expired_time >= ?", time.Now()))
+	count(t.tiers, Db.Model(&Tier{}))
+	count(t.reviews, Db.Model(&Review{}))
+}
+
+var totalsRegistered = false
+
+// 集計値のメトリクスを登録する
+func registerTotalsMetrics() {
+	if totalsRegistered {
+		return
+	}
+	metrics.Registry.MustRegister(newTotalsCollector())
+	totalsRegistered = true
+}
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"fmt"
 	"time"
 )
 
@@ -17,6 +18,8 @@ type TempSession struct {
 	State         string `gorm:""`         // OA2 OAuth2.0認証でコード検証に用いるstate
 	RequestToken  string `gorm:""`         // OA1 OAuth1.0a認証で認証サーバーから受け取るトークン
 	RequestSecret string `gorm:""`         // OA1 OAuth1.0a認証で認証サーバーから受け取るハッシュ
+	Nonce         string `gorm:""`         // OIDC IDトークンの再利用を防ぐためのnonce
+	OidcProvider  string `gorm:""`         // OIDC 認証に使用するプロバイダー名
 }
 
 // セッション
@@ -30,36 +33,81 @@ type Session struct {
 
 	ServiceId string `gorm:"not null"` // 連携サービスンの固有ID(OA1 OA2)
 
-	TwitterIconUrl  string `gorm:""` // Twitter アイコンURL
-	TwitterUserName string `gorm:""` // Twitter @名
-	TwitterToken    string `gorm:""` // Twitterから与えられたアクセストークン(OA2)
-	TwitterToken1   string `gorm:""` // Twitterから与えられたアクセストークン(OA1)
-	TwitterSecret1  string `gorm:""` // Twitterから与えられたアクセスシークレット(OA1)
+	// 連携サービスのトークンは暗号化して保存する
+	TwitterIconUrl  string `gorm:""`                     // Twitter アイコンURL
+	TwitterUserName string `gorm:""`                     // Twitter @名
+	TwitterToken    string `gorm:"serializer:encrypted"` // Twitterから与えられたアクセストークン(OA2)
+	TwitterToken1   string `gorm:"serializer:encrypted"` // Twitterから与えられたアクセストークン(OA1)
+	TwitterSecret1  string `gorm:"serializer:encrypted"` // Twitterから与えられたアクセスシークレット(OA1)
 
-	GoogleEmail        string    `gorm:""` // Google Email
-	GoogleImageUrl     string    `gorm:""` // Google 画像
-	GoogleAccessToken  string    `gorm:""` // Googleから与えられたアクセストークン
-	GoogleExpiry       time.Time `gorm:""` // Googleから与えられたアクセストークンの期限
-	GoogleRefreshToken string    `gorm:""` // Googleから与えられたアクセストークンのリフレッシュ用
+	GoogleEmail        string    `gorm:""`                     // Google Email
+	GoogleImageUrl     string    `gorm:""`                     // Google 画像
+	GoogleAccessToken  string    `gorm:"serializer:encrypted"` // Googleから与えられたアクセストークン
+	GoogleExpiry       time.Time `gorm:""`                     // Googleから与えられたアクセストークンの期限
+	GoogleRefreshToken string    `gorm:"serializer:encrypted"` // Googleから与えられたアクセストークンのリフレッシュ用
+
+	OidcEmail string `gorm:""` // OIDC Email
 
 	IsNew          bool      `gorm:"not null"`  // ユーザー未登録状態フラグ
 	LastPostAt     time.Time `gorm:"not null;"` // 直近の投稿時間
 	DeleteCodeTime time.Time `gorm:""`          // ユーザーを削除する際の確認コード生成時間
+
+	IpAddress  string    `gorm:"not null;default:0.0.0.0"` // 直近のアクセス元IPアドレス
+	UserAgent  string    `gorm:"not null;default:''"`      // 直近のアクセス元ユーザーエージェント
+	CreatedAt  time.Time `gorm:""`                         // セッションの作成日時
+	LastSeenAt time.Time `gorm:""`                         // 直近のアクセス日時
+	LimitTime  time.Time `gorm:""`                         // アクセスがあっても延長できない絶対的な有効期限
+}
+
+// ログ出力時に連携サービスのトークンが含まれないようにする
+func (s Session) String() string {
+	return fmt.Sprintf("Session{UserId:%s LoginService:%s ExpiredTime:%s}", s.UserId, s.LoginService, s.ExpiredTime)
+}
+
+func (s Session) GoString() string {
+	return s.String()
+}
+
+// リフレッシュトークン
+// セッションの期限が切れた後、新しいセッションを発行するために用いる
+// 使用するたびに新しいトークンに置き換え、使用済みのトークンが再度使われた場合は系列ごと無効にする
+type RefreshToken struct {
+	TokenHash   string    `gorm:"primaryKey;not null"`    // トークンのハッシュ(SHA256)
+	FamilyId    string    `gorm:"not null;index"`         // 同じログインから派生したトークンの系列ID
+	SessionId   string    `gorm:"not null;uniqueIndex"`   // 発行時に対応していたセッションID(一つのセッションにつき一つ)
+	UserId      string    `gorm:"not null;index"`         // ユーザーデータのID
+	IsUsed      bool      `gorm:"not null;default:false"` // 使用済みフラグ
+	ExpiredTime time.Time `gorm:"not null;index"`         // 系列の絶対的な有効期限
+	CreatedAt   time.Time `gorm:""`                       // 作成日時
 }
 
 // ユーザーデータ
 type User struct {
-	UserId           string `gorm:"primaryKey;not null"`    // ランダムで決定するユーザー固有のID
-	IconUrl          string `gorm:"not null"`               // TwitterのアイコンURL
-	Name             string `gorm:"not null"`               // 登録名
-	Profile          string `gorm:"not null"`               // 自己紹介文
-	AllowTwitterLink bool   `gorm:"not null;default:false"` // Twitterへのリンク許可
-	KeepSession      int    `gorm:"not null;default:7200"`  // セッション保持時間(秒)
-
-	TwitterId       string `gorm:""` // TwitterID(自分自身でのログイン時およびTwitter連携を許可した時のみ開示)
-	TwitterUserName string `gorm:""` // @名
-	GoogleId        string `gorm:""` // Google 固有ID
-	GoogleEmail     string `gorm:""` // Google Gmailアドレス
+	UserId           string `gorm:"primaryKey;not null"`     // ランダムで決定するユーザー固有のID
+	IconUrl          string `gorm:"not null"`                // TwitterのアイコンURL
+	Name             string `gorm:"not null"`                // 登録名
+	Profile          string `gorm:"not null"`                // 自己紹介文
+	AllowTwitterLink bool   `gorm:"not null;default:false"`  // Twitterへのリンク許可
+	KeepSession      int    `gorm:"not null;default:7200"`   // セッション保持時間(秒)
+	Role             string `gorm:"not null;default:'user'"` // 権限(user, moderator, admin)
+
+	SuspensionState string    `gorm:"not null;default:'none';index"` // 利用停止状態(none, temporary, permanent)
+	SuspendedUntil  time.Time `gorm:""`                              // 一時的な利用停止の終了日時
+
+	TwitterId       string `gorm:""`      // TwitterID(自分自身でのログイン時およびTwitter連携を許可した時のみ開示)
+	TwitterUserName string `gorm:""`      // @名
+	GoogleId        string `gorm:""`      // Google 固有ID
+	GoogleEmail     string `gorm:""`      // Google Gmailアドレス
+	OidcId          string `gorm:"index"` // OIDC プロバイダー名と固有IDの組("プロバイダー名:sub")
+	OidcEmail       string `gorm:""`      // OIDC Emailアドレス
+
+	DigestEmail         string    `gorm:"not null;default:''"`       // 通知ダイジェストの送信先メールアドレス(確認済みのもの)
+	DigestFrequency     string    `gorm:"not null;default:'none'"`   // 通知ダイジェストの送信頻度(none, daily, weekly)
+	DigestToken         string    `gorm:"not null;default:'';index"` // 通知ダイジェストの配信停止用トークン
+	LastDigestAt        time.Time `gorm:""`                          // 直近で通知ダイジェストを送信した日時
+	DigestPendingEmail  string    `gorm:"not null;default:''"`       // 確認待ちの送信先メールアドレス
+	DigestConfirmHash   string    `gorm:"not null;default:'';index"` // 送信先メールアドレスの確認用トークンのハッシュ
+	DigestConfirmSentAt time.Time `gorm:""`                          // 確認メールを送信した日時
 
 	CreatedAt time.Time `gorm:""` // 作成日
 	UpdatedAt time.Time `gorm:""` // 更新日
@@ -68,66 +116,75 @@ type User struct {
 // アクセスログ
 // 条件: ログイン、ログアウト、ユーザー登録・変更・削除、Tier作成・編集・削除、レビュー作成・編集・削除
 type OperationLog struct {
-	UserId    string    `gorm:"not null"`                 // ユーザーデータの固有ID
-	IpAddress string    `gorm:"not null;default:0.0.0.0"` // セッション確立時のIPアドレス
-	Operation string    `gorm:"not null"`                 // 操作対象(エラーコードに準じる)
-	Content   string    `gorm:"not null"`                 // 操作内容
-	CreatedAt time.Time `gorm:"not null;index"`           // 作成日
+	Id           uint64    `gorm:"primaryKey"`                // ログの固有ID(検索結果のページングとアーカイブに使用する)
+	UserId       string    `gorm:"not null;index"`            // ユーザーデータの固有ID
+	TargetUserId string    `gorm:"not null;default:'';index"` // 権限による操作の対象となったユーザーの固有ID
+	IpAddress    string    `gorm:"not null;default:0.0.0.0"`  // セッション確立時のIPアドレス
+	RequestId    string    `gorm:"not null;default:'';index"` // 操作を行ったリクエストのID(X-Request-ID)
+	Operation    string    `gorm:"not null;index"`            // 操作対象(エラーコードに準じる)
+	Content      string    `gorm:"not null"`                  // 操作内容
+	CreatedAt    time.Time `gorm:"not null;index"`            // 作成日
 }
 
 // エラーログ
 // 条件: 致命的なエラーの場合
 type ErrorLog struct {
-	UserId       string    `gorm:"not null"`                 // ユーザーデータの固有ID
-	IpAddress    string    `gorm:"not null;default:0.0.0.0"` // セッション確立時のIPアドレス
-	ErrorId      string    `gorm:"not null"`                 // エラーID
-	Operation    string    `gorm:"not null"`                 // 操作内容
-	Descriptions string    `gorm:"not null"`                 // 操作内容(詳細)
-	CreatedAt    time.Time `gorm:"not null;index"`           // 作成日
+	Id           uint64    `gorm:"primaryKey"`                // ログの固有ID(検索結果のページングとアーカイブに使用する)
+	UserId       string    `gorm:"not null;index"`            // ユーザーデータの固有ID
+	IpAddress    string    `gorm:"not null;default:0.0.0.0"`  // セッション確立時のIPアドレス
+	RequestId    string    `gorm:"not null;default:'';index"` // エラーが発生したリクエストのID(X-Request-ID)
+	ErrorId      string    `gorm:"not null;index"`            // エラーID
+	Operation    string    `gorm:"not null"`                  // 操作内容
+	Descriptions string    `gorm:"not null"`                  // 操作内容(詳細)
+	CreatedAt    time.Time `gorm:"not null;index"`            // 作成日
 }
 
 // Tier
 type Tier struct {
-	TierId       string    `gorm:"primaryKey;not null"` // Tier固有のID
-	UserId       string    `gorm:"not null;index"`      // 作成ユーザーの固有ID
-	Name         string    `gorm:"not null"`            // Tierの名称
-	ImageUrl     string    `gorm:"not null"`            // Tierカバー画像のURL
-	Parags       string    `gorm:"not null"`            // 説明文
-	PointType    string    `gorm:"not null"`            // デフォルトのポイント表示形式
-	FactorParams string    `gorm:"not null"`            // 評価のパラメータ
-	PullingUp    int       `gorm:"not null"`            // Tier表を上に引き上げる
-	PullingDown  int       `gorm:"not null"`            // Tier表を下に引き下げる
-	CreatedAt    time.Time `gorm:""`                    // 作成日
-	UpdatedAt    time.Time `gorm:"index"`               // 更新日
+	TierId       string    `gorm:"primaryKey;not null"`          // Tier固有のID
+	UserId       string    `gorm:"not null;index"`               // 作成ユーザーの固有ID
+	Name         string    `gorm:"not null"`                     // Tierの名称
+	ImageUrl     string    `gorm:"not null"`                     // Tierカバー画像のURL
+	Parags       string    `gorm:"not null"`                     // 説明文
+	PointType    string    `gorm:"not null"`                     // デフォルトのポイント表示形式
+	FactorParams string    `gorm:"not null"`                     // 評価のパラメータ
+	PullingUp    int       `gorm:"not null"`                     // Tier表を上に引き上げる
+	PullingDown  int       `gorm:"not null"`                     // Tier表を下に引き下げる
+	IsHidden     bool      `gorm:"not null;default:false;index"` // モデレーターによる非表示フラグ
+	CreatedAt    time.Time `gorm:""`                             // 作成日
+	UpdatedAt    time.Time `gorm:"index"`                        // 更新日
 }
 
 // Review
 type Review struct {
-	ReviewId      string    `gorm:"primaryKey;not null"` // レビュー固有のID
-	UserId        string    `gorm:"not null;index"`      // 作成ユーザーの固有ID
-	TierId        string    `gorm:"not null"`            // 作成元Tierの固有ID
-	Title         string    `gorm:"not null"`            // レビューのタイトル
-	Name          string    `gorm:"not null"`            // レビューの名前
-	IconUrl       string    `gorm:"not null"`            // レビューアイコンのURL
-	ReviewFactors string    `gorm:"not null"`            // レビューの評価要素
-	Sections      string    `gorm:"not null"`            // レビュー説明セクション
-	CreatedAt     time.Time `gorm:""`                    // 作成日
-	UpdatedAt     time.Time `gorm:"index"`               // 更新日
+	ReviewId      string    `gorm:"primaryKey;not null"`          // レビュー固有のID
+	UserId        string    `gorm:"not null;index"`               // 作成ユーザーの固有ID
+	TierId        string    `gorm:"not null"`                     // 作成元Tierの固有ID
+	Title         string    `gorm:"not null"`                     // レビューのタイトル
+	Name          string    `gorm:"not null"`                     // レビューの名前
+	IconUrl       string    `gorm:"not null"`                     // レビューアイコンのURL
+	ReviewFactors string    `gorm:"not null"`                     // レビューの評価要素
+	Sections      string    `gorm:"not null"`                     // レビュー説明セクション
+	IsHidden      bool      `gorm:"not null;default:false;index"` // モデレーターによる非表示フラグ
+	CreatedAt     time.Time `gorm:""`                             // 作成日
+	UpdatedAt     time.Time `gorm:"index"`                        // 更新日
 }
 
 type Notification struct {
 	Id          uint      `gorm:"primaryKey"`
-	Content     string    `gorm:""`                       // 表示する文章
-	IsImportant bool      `gorm:"default:false;not null"` // 重要情報フラグ
-	Url         string    `gorm:""`                       // クリックした際に飛ぶURL
-	CreatedAt   time.Time `gorm:"index"`                  // 発信日時
-	IsDeleted   bool      `gorm:"default:false;index"`    // 終了済みフラグ（旧削除フラグ）
+	UserId      string    `gorm:"not null;default:'';index"` // 宛先ユーザーID(空文字列なら全ユーザー宛)
+	Content     string    `gorm:""`                          // 表示する文章
+	IsImportant bool      `gorm:"default:false;not null"`    // 重要情報フラグ
+	Url         string    `gorm:""`                          // クリックした際に飛ぶURL
+	CreatedAt   time.Time `gorm:"index"`                     // 発信日時
+	IsDeleted   bool      `gorm:"default:false;index"`       // 終了済みフラグ（旧削除フラグ）
 }
 
 type NotificationRead struct {
-	NotificationId uint   `gorm:"primaryKey"` // NotificationのID
-	UserId         string `gorm:"primaryKey"` // ユーザーID
-	IsRead         bool   `gorm:"not null"`   // 既読フラグ
+	NotificationId uint   `gorm:"primaryKey"`             // NotificationのID
+	UserId         string `gorm:"primaryKey"`             // ユーザーID
+	IsRead         bool   `gorm:"not null"`               // 既読フラグ
+	IsHidden       bool   `gorm:"not null;default:false"` // 非表示フラグ
 }
 
 type NotificationJoinRead struct {
@@ -138,3 +195,115 @@ type NotificationJoinRead struct {
 	Url         string
 	CreatedAt   time.Time
 }
+
+// 通報
+// Tier、レビュー、ユーザーのプロフィールに対する閲覧者からの通報
+type Report struct {
+	Id           uint      `gorm:"primaryKey"`
+	ReporterId   string    `gorm:"not null;index"`                   // 通報したユーザーの固有ID
+	TargetType   string    `gorm:"not null;index:idx_report_target"` // 通報対象の種類(tier, review, user)
+	TargetId     string    `gorm:"not null;index:idx_report_target"` // 通報対象の固有ID
+	TargetUserId string    `gorm:"not null;index"`                   // 通報対象の作成ユーザーの固有ID
+	Reason       string    `gorm:"not null"`                         // 通報理由の分類
+	Comment      string    `gorm:"not null;default:''"`              // 通報理由の詳細
+	Status       string    `gorm:"not null;default:'open';index"`    // 対応状態(open, dismissed, hidden, suspended)
+	ResolvedBy   string    `gorm:"not null;default:''"`              // 対応したモデレーターの固有ID
+	ResolvedAt   time.Time `gorm:""`                                 // 対応日時
+	CreatedAt    time.Time `gorm:"index"`                            // 通報日時
+}
+
+// 利用停止状態の変更履歴
+type SuspensionLog struct {
+	Id         uint      `gorm:"primaryKey"`
+	UserId     string    `gorm:"not null;index"` // 対象ユーザーの固有ID
+	State      string    `gorm:"not null"`       // 変更後の利用停止状態(none, temporary, permanent)
+	Until      time.Time `gorm:""`               // 一時的な利用停止の終了日時
+	Reason     string    `gorm:"not null"`       // 変更理由
+	OperatorId string    `gorm:"not null"`       // 変更したユーザーの固有ID
+	CreatedAt  time.Time `gorm:""`               // 変更日時
+}
+
+// 無期限の利用停止にした連携サービスのアカウント
+// ユーザーが削除された後も同じアカウントで再登録できないようにする
+type BannedAccount struct {
+	Service   string    `gorm:"primaryKey;not null"` // 連携サービス(twitter, google, oidc)
+	ServiceId string    `gorm:"primaryKey;not null"` // 連携サービスの固有ID
+	UserId    string    `gorm:"not null;index"`      // 利用停止にしたユーザーの固有ID
+	CreatedAt time.Time `gorm:""`                    // 登録日時
+}
+
+// レート制限のトークンバケット(保存先にpostgresを指定した場合のみ使用)
+type RateLimitBucket struct {
+	BucketKey string    `gorm:"primaryKey;not null"` // ルール名と制限対象(ユーザーID, IPアドレス)
+	Tokens    float64   `gorm:"not null"`            // 残りのトークン数
+	Allowed   bool      `gorm:"not null"`            // 直近の取り出しが許可されたかどうか
+	UpdatedAt time.Time `gorm:"not null;index"`      // 直近の取り出し日時
+}
+
+// Webhook
+// Tierやレビューの作成・更新・削除を外部に通知するための送信先
+type Webhook struct {
+	WebhookId string    `gorm:"primaryKey;not null"`           // Webhook固有のID
+	UserId    string    `gorm:"not null;index"`                // 登録ユーザーの固有ID
+	Url       string    `gorm:"not null"`                      // 送信先のURL
+	Secret    string    `gorm:"not null;serializer:encrypted"` // 署名に用いるシークレット
+	Events    string    `gorm:"not null"`                      // 送信対象のイベント(カンマ区切り)
+	IsActive  bool      `gorm:"not null;default:true"`         // 有効フラグ
+	CreatedAt time.Time `gorm:""`                              // 作成日
+	UpdatedAt time.Time `gorm:""`                              // 更新日
+}
+
+// Webhookの配信キュー兼配信ログ
+type WebhookDelivery struct {
+	Id            uint      `gorm:"primaryKey"`
+	WebhookId     string    `gorm:"not null;index"`      // 送信先WebhookのID
+	Event         string    `gorm:"not null"`            // イベント名
+	Payload       string    `gorm:"not null"`            // 送信する本文(JSON)
+	Status        string    `gorm:"not null;index"`      // 配信状態(pending, succeeded, failed)
+	Attempts      int       `gorm:"not null;default:0"`  // 送信を試行した回数
+	NextAttemptAt time.Time `gorm:"not null;index"`      // 次に送信を試行する時間
+	QueueJobId    uint64    `gorm:"not null;default:0"`  // 送信を行うジョブキューのID(0ならジョブキュー導入前に登録したもの)
+	StatusCode    int       `gorm:"not null;default:0"`  // 直近の送信で受け取ったHTTPステータス
+	LastError     string    `gorm:"not null;default:''"` // 直近の送信で発生したエラー
+	CreatedAt     time.Time `gorm:"index"`               // 作成日
+	UpdatedAt     time.Time `gorm:""`                    // 更新日
+}
+
+// ジョブキュー
+// 時間のかかる処理をリクエストから切り離してワーカーで実行する
+type QueueJob struct {
+	Id          uint64    `gorm:"primaryKey"`
+	Kind        string    `gorm:"not null;index"`            // ジョブの種類
+	UserId      string    `gorm:"not null;default:'';index"` // ジョブを登録したユーザーの固有ID(状態を参照できるユーザー)
+	Payload     string    `gorm:"not null"`                  // ジョブの入力(JSON)
+	Status      string    `gorm:"not null;index"`            // 状態(queued, running, succeeded, dead)
+	Attempts    int       `gorm:"not null;default:0"`        // 実行を試行した回数
+	MaxAttempts int       `gorm:"not null"`                  // 試行回数の上限(超えるとdeadにする)
+	RunAt       time.Time `gorm:"not null;index"`            // 次に実行する時間
+	LockedBy    string    `gorm:"not null;default:''"`       // 実行中のワーカーのID
+	LockedUntil time.Time `gorm:""`                          // 実行中のワーカーが応答しなくなったとみなす時間
+	LastError   string    `gorm:"not null;default:''"`       // 直近の実行で発生したエラー
+	Result      string    `gorm:"not null;default:''"`       // 実行結果(JSON)
+	ResultFile  string    `gorm:"not null;default:''"`       // 実行結果として作成したファイル(ジョブの削除時に削除する)
+	FinishedAt  time.Time `gorm:""`                          // 成功またはdeadになった日時
+	CreatedAt   time.Time `gorm:"index"`                     // 作成日
+	UpdatedAt   time.Time `gorm:""`                          // 更新日
+}
+
+// 連携サービス追加用のコード
+// ログイン中のユーザーが発行し、追加するサービスでログインした別のセッションから使用する
+type ProviderLinkCode struct {
+	CodeHash    string    `gorm:"primaryKey;not null"` // コードのハッシュ(SHA256)
+	UserId      string    `gorm:"not null;index"`      // 発行したユーザーの固有ID
+	ExpiredTime time.Time `gorm:"not null;index"`      // コードの有効期限
+	CreatedAt   time.Time `gorm:""`                    // 発行日時
+}
+
+// ユーザーごとの保存済みファイルの容量
+// 画像の保存・削除時に増減し、保存先の確認時に実際のファイルから再計算する
+type StorageUsage struct {
+	UserId    string    `gorm:"primaryKey;not null"` // ユーザーの固有ID
+	Bytes     int64     `gorm:"not null;default:0"`  // 保存済みのファイル(変換待ちの画像を含む)の合計バイト数
+	ScanDelta int64     `gorm:"not null;default:0"`  // 再計算の開始後に増減したバイト数(再計算の結果に加える)
+	UpdatedAt time.Time `gorm:""`                    // 更新日
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	common "reviewmakerback/common"
+
+	"github.com/labstack/echo"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OIDCの認証開始時に一時セッションを作成する
+func CreateOidcTempSession(provider string, state string, nonce string, codeVerifier string, ipAddress string) (TempSession, error) {
+	sessionId, err := common.MakeSession(provider)
+	if err != nil {
+		return TempSession{}, err
+	}
+	tempSession := TempSession{
+		SessionID:    sessionId,
+		AccessTime:   time.Now(),
+		IpAddress:    ipAddress,
+		LoginService: ServiceOidc,
+		LoginVersion: 2,
+		CodeVerifier: codeVerifier,
+		State:        state,
+		Nonce:        nonce,
+		OidcProvider: provider,
+	}
+	tx := Db.Create(&tempSession)
+	return tempSession, tx.Error
+}
+
+// OIDCの一時セッションを取り出す
+// 認証コードの再利用を防ぐため、取り出した一時セッションは削除する
+func PopOidcTempSession(sessionId string, provider string) (TempSession, error) {
+	var tempSession TempSession
+	err := Db.Transaction(func(tx *gorm.DB) error {
+		tdb := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("session_id = ? and login_service = ? and oidc_provider = ?", sessionId, ServiceOidc, provider).
+			Find(&tempSession)
+		if tdb.Error != nil {
+			return tdb.Error
+		} else if tdb.RowsAffected != 1 {
+			return errors.New("一時セッションがありません")
+		}
+		if tempSession.AccessTime.Add(TempSessionAlive * time.Second).Before(time.Now()) {
+			return errors.New("一時セッションの有効期限が切れています")
+		}
+		return tx.Where("session_id = ?", sessionId).Delete(&TempSession{}).Error
+	})
+	return tempSession, err
+}
+
+// 連携サービスでの認証後にセッションを作成する
+// サービスのIDが登録済みであればそのユーザーのセッション、未登録であればユーザー未登録状態のセッションになる
+func CreateLoginSession(c echo.Context, service string, version int, serviceId string, email string) (Session, User, error) {
+	if IsBannedAccount(service, serviceId) {
+		return Session{}, User{}, ErrUserSuspended
+	}
+	_, user := ExistsUserService(service, serviceId)
+
+	sessionId, err := common.MakeSession(serviceId)
+	if err != nil {
+		return Session{}, User{}, err
+	}
+	keepSession := user.KeepSession
+	if keepSession <= 0 {
+		keepSession = defaultKeepSession
+	}
+	now := time.Now()
+	session := Session{
+		SessionId:    sessionId,
+		UserId:       user.UserId,
+		ExpiredTime:  now.Add(time.Duration(keepSession) * time.Second),
+		LoginService: service,
+		LoginVersion: version,
+		ServiceId:    serviceId,
+		IsNew:        user.UserId == "",
+		IpAddress:    net.ParseIP(c.RealIP()).String(),
+		UserAgent:    common.SubstringMult(c.Request().UserAgent(), 0, userAgentLenMax),
+		CreatedAt:    now,
+		LastSeenAt:   now,
+		LimitTime:    now.Add(SessionLifetimeMax * time.Second),
+	}
+	if service == ServiceOidc {
+		session.OidcEmail = email
+	}
+	tx := Db.Create(&session)
+	return session, user, tx.Error
+}
@@ -1,17 +1,19 @@
 package db
 
 import (
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	b64 "encoding/base64"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"reflect"
 	"strings"
 	"time"
 
 	common "reviewmakerback/common"
+	"reviewmakerback/logger"
+	"reviewmakerback/metrics"
 
 	"github.com/labstack/echo"
 	"gorm.io/gorm"
@@ -26,39 +28,88 @@ const SessionDelSpan = 60
 // 各ID作成に失敗した際の最大試行回数
 const RetryCreateCnt = 3
 
-// 各IDの桁数
-const idSize = 16
+// 各IDの生成器(従来と同じ16文字の16進数)
+// 64bitの暗号論的乱数から生成するため、重複の確認は主キー制約に任せる
+var IdGenerator = common.DefaultIdGenerator
+
+// 新しいIDを生成する
+func makeId() (string, error) {
+	return IdGenerator.Generate()
+}
 
 // 最小投稿間隔にの初期値(mainから上書きする)
 var PostSpanMin = 10
 
+// 操作を記録する(リクエストIDは記録しない)
 func WriteOperationLog(id string, ipAddress string, operation string, content string) {
+	WriteOperationLogContext(context.Background(), id, ipAddress, operation, content)
+}
+
+// 操作を記録する
+// ctxにリクエストIDが含まれていれば一緒に記録する
+func WriteOperationLogContext(ctx context.Context, id string, ipAddress string, operation string, content string) {
+	WritePrivilegedOperationLogContext(ctx, id, "", ipAddress, operation, content)
+}
+
+// 権限による他のユーザーに対する操作を記録する(リクエストIDは記録しない)
+// idは操作したユーザー、targetIdは操作の対象となったユーザー
+func WritePrivilegedOperationLog(id string, targetId string, ipAddress string, operation string, content string) {
+	WritePrivilegedOperationLogContext(context.Background(), id, targetId, ipAddress, operation, content)
+}
+
+// 権限による他のユーザーに対する操作を記録する
+func WritePrivilegedOperationLogContext(ctx context.Context, id string, targetId string, ipAddress string, operation string, content string) {
 	// ログを記録
 	log := OperationLog{
-		UserId:    id,
-		IpAddress: ipAddress,
-		Operation: operation,
-		Content:   content,
-		CreatedAt: time.Now(),
+		UserId:       id,
+		TargetUserId: targetId,
+		IpAddress:    ipAddress,
+		RequestId:    logger.RequestId(ctx),
+		Operation:    operation,
+		Content:      common.MaskSecrets(content),
+		CreatedAt:    time.Now(),
 	}
+	logger.Info(ctx, "operation", logger.Fields{
+		"userId":       id,
+		"targetUserId": targetId,
+		"ip":           ipAddress,
+		"operation":    operation,
+	})
 
 	// データベースに登録
-	Db.Create(log)
+	Db.Create(&log)
 }
 
+// エラーを記録する(リクエストIDは記録しない)
 func WriteErrorLog(id string, ipAddress string, errorId string, operation string, descriptions string) {
+	WriteErrorLogContext(context.Background(), id, ipAddress, errorId, operation, descriptions)
+}
+
+// エラーを記録する
+// ctxにリクエストIDが含まれていれば一緒に記録する
+func WriteErrorLogContext(ctx context.Context, id string, ipAddress string, errorId string, operation string, descriptions string) {
+	// トークンを含む値は呼び出し側で渡さないこと
+	// 取り除き漏れがあった場合に備えて、トークンと思われる長い英数字も伏せ字にする
+	descriptions = common.MaskSecrets(descriptions)
+
 	// ログを記録
 	log := ErrorLog{
 		UserId:       id,
 		IpAddress:    ipAddress,
+		RequestId:    logger.RequestId(ctx),
 		ErrorId:      errorId,
 		Operation:    operation,
 		Descriptions: descriptions,
 		CreatedAt:    time.Now(),
 	}
-	fmt.Printf("id=%s\nip=%s\nerrId=%s\nope=%s\ndes=%s\n", id, ipAddress, errorId, operation, descriptions)
+	logger.Error(ctx, operation, logger.Fields{
+		"userId":       id,
+		"ip":           ipAddress,
+		"errorId":      errorId,
+		"descriptions": descriptions,
+	})
 	// データベースに登録
-	Db.Create(log)
+	Db.Create(&log)
 }
 
 func CheckSession(c echo.Context, requireUser bool, updateExpiredTime bool) (Session, error) {
@@ -72,25 +123,45 @@ func CheckSession(c echo.Context, requireUser bool, updateExpiredTime bool) (Ses
 
 	var session Session
 	var cnt int64
-	tx := Db.Where("session_id = ?", sessionId)
+	// リクエストのトレースにセッションの確認を含める
+	db := Db.WithContext(c.Request().Context())
+	tx := db.Where("session_id = ?", sessionId)
 
 	tx.Find(&session).Count(&cnt)
 	if cnt != 1 {
 		return Session{}, errors.New("セッションがありません")
 	}
 
+	// 削除前の期限切れのセッションを弾く
+	now := time.Now()
+	if session.ExpiredTime.Before(now) {
+		return Session{}, errors.New("セッションの有効期限が切れています")
+	}
+
+	// 直近のアクセス情報を記録する
+	touchSession(&session, c)
+
 	var user User
-	if requireUser {
-		Db.Where("user_id = ?", session.UserId).Find(&user).Count(&cnt)
-		if cnt != 1 {
+	if requireUser || updateExpiredTime {
+		db.Where("user_id = ?", session.UserId).Find(&user).Count(&cnt)
+		if requireUser && cnt != 1 {
 			return Session{}, errors.New("ユーザーが存在しません")
 		}
 	}
 
+	// 利用停止中のユーザーは書き込みの操作を行えない
+	if session.UserId != "" && isWriteRequest(c) {
+		if user.UserId == "" {
+			db.Where("user_id = ?", session.UserId).Find(&user)
+		}
+		if user.IsSuspendedAt(now) {
+			return Session{}, ErrUserSuspended
+		}
+	}
+
 	if updateExpiredTime {
-		exTime := time.Now().Add(time.Duration(user.KeepSession) * time.Second)
-		tx.Update("expired_time", exTime)
-		session.ExpiredTime = exTime
+		// ユーザーのセッション保持時間だけ有効期限を延長する
+		slideSession(&session, user.KeepSession, now)
 	}
 
 	return session, nil
@@ -158,11 +229,31 @@ func SearchWord(columns []string, word string) *gorm.DB {
 	return txAnd
 }
 
-func ArrangeSession() {
+func ArrangeSession(ctx context.Context) error {
+	tdb := Db.WithContext(ctx)
+	// 削除した行数をテーブルごとに記録し、失敗した時点で止める
+	var err error
+	record := func(table string, tx *gorm.DB) {
+		if err != nil {
+			return
+		}
+		err = tx.Error
+		metrics.ArrangedRows.WithLabelValues(table).Add(float64(tx.RowsAffected))
+	}
+
 	// 一時セッションの生存期間が終了したデータを削除
-	Db.Where("access_time < ?", time.Now().Add(-TempSessionAlive*time.Second)).Delete(&TempSession{})
+	record("temp_sessions", tdb.Where("access_time < ?", time.Now().Add(-TempSessionAlive*time.Second)).Delete(&TempSession{}))
 	// セッションの生存期間が終了したデータを削除
-	Db.Where("expired_time < ?", time.Now()).Delete(&Session{})
+	// ただし、リフレッシュトークンで更新できるセッションは、更新に必要な情報を引き継ぐため残しておく
+	record("sessions", tdb.Where("expired_time < ?", time.Now()).Where(
+		"session_id not in (?)",
+		tdb.Model(&RefreshToken{}).Select("session_id").Where("is_used = ? and expired_time >= ?", false, time.Now()),
+	).Delete(&Session{}))
+	// リフレッシュトークンの生存期間が終了したデータを削除
+	record("refresh_tokens", tdb.Where("expired_time < ?", time.Now()).Delete(&RefreshToken{}))
+	// 期限切れの連携サービス追加用コードを削除
+	record("provider_link_codes", tdb.Where("expired_time < ?", time.Now()).Delete(&ProviderLinkCode{}))
+	return err
 }
 
 // 指定した項目を除外したSelect句を作成する
@@ -184,6 +275,10 @@ func ExcludeSelect(baseStruct interface{}, columns ...string) string {
 }
 
 // ==========================================================================================
+// 旧形式の暗号化(固定IVのAES-CFB)
+// 改ざんを検知できないため、新しく暗号化する値にはEncryption(crypto.go)を使用すること
+// 旧形式で保存済みの値を読み込むためだけに残している
+//
 // 9.6 データを暗号化/復号する
 // https://astaxie.gitbooks.io/build-web-application-with-golang/content/ja/09.6.html
 
@@ -230,27 +325,6 @@ type EncryptedTextData struct {
 	Length     int    `json:"l"`
 }
 
-func EncryptText(text string, password string) (EncryptedTextData, error) {
-	plaintext := []byte(text)
-
-	// 暗号化アルゴリズムaesを作成
-	c, err := aes.NewCipher([]byte(password))
-	if err != nil {
-		return EncryptedTextData{}, err
-	}
-
-	// 暗号化文字列
-	cfb := cipher.NewCFBEncrypter(c, commonIV)
-	ciphertext := make([]byte, len(plaintext))
-	cfb.XORKeyStream(ciphertext, plaintext)
-	b64Text := b64.StdEncoding.EncodeToString(ciphertext)
-
-	return EncryptedTextData{
-		Base64Text: b64Text,
-		Length:     len(plaintext),
-	}, nil
-}
-
 func DecryptText(etd EncryptedTextData, password string) (string, error) {
 	// 暗号化アルゴリズムaesを作成
 	c, err := aes.NewCipher([]byte(password))
@@ -271,29 +345,6 @@ func DecryptText(etd EncryptedTextData, password string) (string, error) {
 	return string(plaintextCopy), nil
 }
 
-func EncryptTextJson(text string, password string) (string, error) {
-	plaintext := []byte(text)
-
-	// 暗号化アルゴリズムaesを作成
-	c, err := aes.NewCipher([]byte(password))
-	if err != nil {
-		return "{}", err
-	}
-
-	// 暗号化文字列
-	cfb := cipher.NewCFBEncrypter(c, commonIV)
-	ciphertext := make([]byte, len(plaintext))
-	cfb.XORKeyStream(ciphertext, plaintext)
-	b64Text := b64.StdEncoding.EncodeToString(ciphertext)
-
-	bytes, err := json.Marshal(EncryptedTextData{
-		Base64Text: b64Text,
-		Length:     len(plaintext),
-	})
-
-	return string(bytes), err
-}
-
 func DecryptTextJson(jsonText string, password string) (string, error) {
 	var etd EncryptedTextData
 	err := json.Unmarshal([]byte(jsonText), &etd)
@@ -0,0 +1,447 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	common "reviewmakerback/common"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 連携できるサービス
+const (
+	ServiceTwitter = "twitter"
+	ServiceGoogle  = "google"
+	ServiceOidc    = "oidc"
+)
+
+var Services = []string{
+	ServiceTwitter,
+	ServiceGoogle,
+	ServiceOidc,
+}
+
+// 連携サービス追加用コードの有効期間(秒)
+const ProviderLinkCodeSpan = 600
+
+// 連携サービスの追加・統合の失敗理由
+var (
+	ErrProviderLinkCodeInvalid = errors.New("連携コードが無効です")
+	ErrProviderLinked          = errors.New("指定されたサービスは既に連携されています")
+	ErrProviderConflict        = errors.New("同じサービスの別のアカウントが連携されています")
+	ErrProviderOtherUser       = errors.New("指定されたサービスは別のユーザーに登録されています")
+	ErrProviderLast            = errors.New("最後の連携サービスは解除できません")
+)
+
+// ユーザーに連携されているサービスのIDを返す
+func (u User) ServiceId(service string) string {
+	if service == ServiceTwitter {
+		return u.TwitterId
+	} else if service == ServiceGoogle {
+		return u.GoogleId
+	} else if service == ServiceOidc {
+		return u.OidcId
+	}
+	return ""
+}
+
+// ユーザーに連携されているサービスのアカウント名を返す
+func (u User) ServiceName(service string) string {
+	if service == ServiceTwitter {
+		return u.TwitterUserName
+	} else if service == ServiceGoogle {
+		return u.GoogleEmail
+	} else if service == ServiceOidc {
+		return u.OidcEmail
+	}
+	return ""
+}
+
+// ユーザーに連携されているサービスの一覧を返す
+func (u User) LinkedServices() []string {
+	services := []string{}
+	for _, service := range Services {
+		if u.ServiceId(service) != "" {
+			services = append(services, service)
+		}
+	}
+	return services
+}
+
+// ユーザーにサービスのIDを設定する
+// serviceId, name を空文字列にすると連携を解除する
+// name はTwitterの@名、Google, OIDCのEmail
+func (u *User) SetService(service string, serviceId string, name string) {
+	if service == ServiceTwitter {
+		u.TwitterId = serviceId
+		u.TwitterUserName = name
+	} else if service == ServiceGoogle {
+		u.GoogleId = serviceId
+		u.GoogleEmail = name
+	} else if service == ServiceOidc {
+		u.OidcId = serviceId
+		u.OidcEmail = name
+	}
+}
+
+// サービスの連携を解除できるかチェックする
+func CheckUnlinkService(user User, service string) error {
+	if user.ServiceId(service) == "" {
+		return errors.New("指定されたサービスは連携されていません")
+	}
+	if len(user.LinkedServices()) <= 1 {
+		return ErrProviderLast
+	}
+	return nil
+}
+
+// 統合元のユーザーの連携サービスを統合先のユーザーに移す
+// 同じサービスの別のアカウントが両方に連携されている場合は統合できない
+func MergeServices(dst User, src User) (User, error) {
+	for _, service := range Services {
+		srcId := src.ServiceId(service)
+		if srcId == "" {
+			continue
+		}
+		dstId := dst.ServiceId(service)
+		if dstId != "" && dstId != srcId {
+			return dst, ErrProviderConflict
+		}
+		dst.SetService(service, srcId, src.ServiceName(service))
+	}
+	return dst, nil
+}
+
+// ユーザーのファイルパスの所有者部分を置き換える
+// パスは "ユーザーID/機能種別/ID/ファイル名" の形式
+func ReplacePathOwner(path string, from string, to string) string {
+	if strings.HasPrefix(path, from+"/") {
+		return to + path[len(from):]
+	}
+	return path
+}
+
+// 説明文の段落(画像のパスの置き換え用)
+type pathParag struct {
+	Type string `json:"type"`
+	Body string `json:"body"`
+}
+
+// レビューの説明セクション(画像のパスの置き換え用)
+type pathSection struct {
+	Title  string      `json:"title"`
+	Parags []pathParag `json:"parags"`
+}
+
+// 段落中の画像のパスの所有者部分を置き換え、置き換えたかどうかを返す
+func replaceParagsOwner(parags []pathParag, from string, to string) bool {
+	replaced := false
+	for i, parag := range parags {
+		if parag.Type != "imageLink" {
+			continue
+		}
+		if path := ReplacePathOwner(parag.Body, from, to); path != parag.Body {
+			parags[i].Body = path
+			replaced = true
+		}
+	}
+	return replaced
+}
+
+// Tierの説明文(JSON)中の画像のパスの所有者部分を置き換える
+// 読み込めない場合はそのまま返す
+func ReplaceParagsOwner(paragsJson string, from string, to string) string {
+	var parags []pathParag
+	if json.Unmarshal([]byte(paragsJson), &parags) != nil || !replaceParagsOwner(parags, from, to) {
+		return paragsJson
+	}
+	b, err := json.Marshal(parags)
+	if err != nil {
+		return paragsJson
+	}
+	return string(b)
+}
+
+// レビューの説明セクション(JSON)中の画像のパスの所有者部分を置き換える
+// 読み込めない場合はそのまま返す
+func ReplaceSectionsOwner(sectionsJson string, from string, to string) string {
+	var sections []pathSection
+	if json.Unmarshal([]byte(sectionsJson), &sections) != nil {
+		return sectionsJson
+	}
+	replaced := false
+	for _, section := range sections {
+		if replaceParagsOwner(section.Parags, from, to) {
+			replaced = true
+		}
+	}
+	if !replaced {
+		return sectionsJson
+	}
+	b, err := json.Marshal(sections)
+	if err != nil {
+		return sectionsJson
+	}
+	return string(b)
+}
+
+// 変換待ちの画像の縮小・変換ジョブの出力先を統合先のフォルダに置き換える
+// 変換待ちの画像はジョブの実行前に統合先のフォルダに移す
+func mergeImageJobsTx(tx *gorm.DB, srcId string, dstId string) error {
+	var jobs []QueueJob
+	tdb := tx.Select("id, payload").Where("kind = ? and user_id = ? and status = ?", QueueKindImage, srcId, QueueQueued).Find(&jobs)
+	if tdb.Error != nil {
+		return tdb.Error
+	}
+	for _, job := range jobs {
+		var payload map[string]interface{}
+		if json.Unmarshal([]byte(job.Payload), &payload) != nil {
+			continue
+		}
+		if path, ok := payload["path"].(string); ok {
+			payload["path"] = ReplacePathOwner(path, srcId, dstId)
+		}
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		tdb = tx.Model(&QueueJob{}).Where("id = ?", job.Id).UpdateColumns(map[string]interface{}{
+			"user_id": dstId,
+			"payload": string(b),
+		})
+		if tdb.Error != nil {
+			return tdb.Error
+		}
+	}
+	return nil
+}
+
+// セッションでログインしているサービスのアカウント名を返す
+func sessionServiceName(session Session) string {
+	if session.LoginService == ServiceTwitter {
+		return session.TwitterUserName
+	} else if session.LoginService == ServiceOidc {
+		return session.OidcEmail
+	}
+	return session.GoogleEmail
+}
+
+// 連携サービス追加用のコードを発行する
+func IssueProviderLinkCode(userId string, now time.Time) (string, time.Time, error) {
+	code, err := common.MakeSession(userId)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiredTime := now.Add(time.Duration(ProviderLinkCodeSpan) * time.Second)
+
+	// 発行済みのコードは無効にする
+	err = Db.Transaction(func(tx *gorm.DB) error {
+		tdb := tx.Where("user_id = ?", userId).Delete(&ProviderLinkCode{})
+		if tdb.Error != nil {
+			return tdb.Error
+		}
+		return tx.Create(&ProviderLinkCode{
+			CodeHash:    common.GetSHA256(code),
+			UserId:      userId,
+			ExpiredTime: expiredTime,
+		}).Error
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return code, expiredTime, nil
+}
+
+// 連携サービス追加用のコードを消費する
+func consumeProviderLinkCode(tx *gorm.DB, userId string, code string, now time.Time) error {
+	var linkCode ProviderLinkCode
+	tdb := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("code_hash = ?", common.GetSHA256(code)).Find(&linkCode)
+	if tdb.Error != nil {
+		return tdb.Error
+	} else if tdb.RowsAffected != 1 || linkCode.UserId != userId || linkCode.ExpiredTime.Before(now) {
+		return ErrProviderLinkCodeInvalid
+	}
+	return tx.Where("code_hash = ?", linkCode.CodeHash).Delete(&ProviderLinkCode{}).Error
+}
+
+// 未登録のサービスでログインしたセッションを、コードを発行したユーザーに連携する
+func LinkProvider(userId string, code string, session Session) (User, error) {
+	var user User
+	err := Db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := consumeProviderLinkCode(tx, userId, code, now); err != nil {
+			return err
+		}
+
+		tdb := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userId).Find(&user)
+		if tdb.Error != nil {
+			return tdb.Error
+		} else if tdb.RowsAffected != 1 {
+			return errors.New("ユーザーが存在しません")
+		}
+		if user.IsSuspendedAt(now) {
+			return ErrUserSuspended
+		}
+
+		if !common.Contains(session.LoginService, Services) || session.ServiceId == "" {
+			return errors.New("連携できないサービスです")
+		}
+		if IsBannedAccount(session.LoginService, session.ServiceId) {
+			return ErrUserSuspended
+		}
+		if linkedId := user.ServiceId(session.LoginService); linkedId == session.ServiceId {
+			return ErrProviderLinked
+		} else if linkedId != "" {
+			return ErrProviderConflict
+		}
+
+		// 他のユーザーに登録されたサービスは統合でのみ連携できる
+		var cnt int64
+		tdb = tx.Model(&User{}).Where(session.LoginService+"_id = ?", session.ServiceId).Count(&cnt)
+		if tdb.Error != nil {
+			return tdb.Error
+		} else if cnt > 0 {
+			return ErrProviderOtherUser
+		}
+
+		user.SetService(session.LoginService, session.ServiceId, sessionServiceName(session))
+		if tdb = tx.Save(&user); tdb.Error != nil {
+			return tdb.Error
+		}
+
+		// 連携したサービスのセッションをユーザーに紐づける
+		return tx.Model(&Session{}).Where("session_id = ?", session.SessionId).
+			Updates(map[string]interface{}{"user_id": userId, "is_new": false}).Error
+	})
+	return user, err
+}
+
+// サービスの連携を解除する
+// 解除したサービスでログインしているセッションは削除する
+func UnlinkProvider(userId string, service string) (User, error) {
+	var user User
+	err := Db.Transaction(func(tx *gorm.DB) error {
+		tdb := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userId).Find(&user)
+		if tdb.Error != nil {
+			return tdb.Error
+		} else if tdb.RowsAffected != 1 {
+			return errors.New("ユーザーが存在しません")
+		}
+		if err := CheckUnlinkService(user, service); err != nil {
+			return err
+		}
+
+		user.SetService(service, "", "")
+		if tdb = tx.Save(&user); tdb.Error != nil {
+			return tdb.Error
+		}
+
+		tdb = tx.Where("user_id = ? and login_service = ?", userId, service).Delete(&Session{})
+		return tdb.Error
+	})
+	return user, err
+}
+
+// 別のユーザー(統合元)のTier、レビュー、連携サービスをコードを発行したユーザー(統合先)に移し、統合元を削除する
+// 保存したファイルは移動しないので、呼び出し元で統合元のフォルダを統合先に移す
+func MergeUsers(userId string, code string, session Session) (User, error) {
+	var user User
+	srcId := session.UserId
+	err := Db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := consumeProviderLinkCode(tx, userId, code, now); err != nil {
+			return err
+		}
+		if srcId == "" || srcId == userId {
+			return errors.New("統合するユーザーが不正です")
+		}
+
+		var src User
+		tdb := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userId).Find(&user)
+		if tdb.Error != nil {
+			return tdb.Error
+		} else if tdb.RowsAffected != 1 {
+			return errors.New("ユーザーが存在しません")
+		}
+		tdb = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", srcId).Find(&src)
+		if tdb.Error != nil {
+			return tdb.Error
+		} else if tdb.RowsAffected != 1 {
+			return errors.New("統合するユーザーが存在しません")
+		}
+
+		// 利用停止の回避に使われないよう、どちらかが利用停止中なら統合しない
+		if user.IsSuspendedAt(now) || src.IsSuspendedAt(now) {
+			return ErrUserSuspended
+		}
+
+		merged, err := MergeServices(user, src)
+		if err != nil {
+			return err
+		}
+
+		// 統合元の連携を外し、統合元のサービスでのログインを統合先に向ける
+		tdb = tx.Model(&User{}).Where("user_id = ?", srcId).
+			Updates(map[string]interface{}{"twitter_id": "", "google_id": ""})
+		if tdb.Error != nil {
+			return tdb.Error
+		}
+		user = merged
+		if tdb = tx.Save(&user); tdb.Error != nil {
+			return tdb.Error
+		}
+
+		// Tier移動(画像のパスも統合先のフォルダに置き換える)
+		var tiers []Tier
+		if tdb = tx.Select("tier_id, image_url, parags").Where("user_id = ?", srcId).Find(&tiers); tdb.Error != nil {
+			return tdb.Error
+		}
+		for _, tier := range tiers {
+			tdb = tx.Model(&Tier{}).Where("tier_id = ?", tier.TierId).UpdateColumns(map[string]interface{}{
+				"user_id":   userId,
+				"image_url": ReplacePathOwner(tier.ImageUrl, srcId, userId),
+				"parags":    ReplaceParagsOwner(tier.Parags, srcId, userId),
+			})
+			if tdb.Error != nil {
+				return tdb.Error
+			}
+		}
+
+		// レビュー移動
+		var reviews []Review
+		if tdb = tx.Select("review_id, icon_url, sections").Where("user_id = ?", srcId).Find(&reviews); tdb.Error != nil {
+			return tdb.Error
+		}
+		for _, review := range reviews {
+			tdb = tx.Model(&Review{}).Where("review_id = ?", review.ReviewId).UpdateColumns(map[string]interface{}{
+				"user_id":  userId,
+				"icon_url": ReplacePathOwner(review.IconUrl, srcId, userId),
+				"sections": ReplaceSectionsOwner(review.Sections, srcId, userId),
+			})
+			if tdb.Error != nil {
+				return tdb.Error
+			}
+		}
+
+		if err := mergeImageJobsTx(tx, srcId, userId); err != nil {
+			return err
+		}
+
+		// 統合元のセッションとリフレッシュトークンは統合先に引き継ぐ
+		tdb = tx.Model(&Session{}).Where("user_id = ?", srcId).Update("user_id", userId)
+		if tdb.Error != nil {
+			return tdb.Error
+		}
+		tdb = tx.Model(&RefreshToken{}).Where("user_id = ?", srcId).Update("user_id", userId)
+		if tdb.Error != nil {
+			return tdb.Error
+		}
+
+		return deleteUserDataTx(tx, srcId)
+	})
+	return user, err
+}
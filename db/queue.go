@@ -0,0 +1,292 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"reviewmakerback/queue"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ジョブの状態
+const (
+	QueueQueued    = queue.StatusQueued    // 実行待ち(再試行待ちを含む)
+	QueueRunning   = queue.StatusRunning   // 実行中
+	QueueSucceeded = queue.StatusSucceeded // 成功
+	QueueDead      = queue.StatusDead      // 試行回数の上限に達したか、再試行しても成功しない失敗
+)
+
+// ジョブの種類
+const (
+	QueueKindImage   = "image.resize"    // 画像の縮小・JPEGへの変換
+	QueueKindExport  = "user.export"     // ユーザーデータのエクスポート
+	QueueKindWebhook = "webhook.deliver" // Webhookの配信
+)
+
+// ジョブの種類ごとの試行回数の上限
+const (
+	QueueImageRetryMax  = 3
+	QueueExportRetryMax = 3
+)
+
+// 完了したジョブを削除する間隔(秒)
+const QueuePurgeSpan = 3600
+
+// 実行中のジョブが他のワーカーに取得されていた場合のエラー
+var ErrQueueJobLost = queue.ErrLost
+
+// 同じ種類の未完了のジョブがある場合のエラー
+var ErrQueueJobActive = errors.New("同じ種類の未完了のジョブがあります")
+
+// Webhookの配信ジョブの入力
+type WebhookJobPayload struct {
+	DeliveryId uint `json:"deliveryId"`
+}
+
+// ジョブを登録する(トランザクション内で使用する)
+func EnqueueQueueJobTx(tx *gorm.DB, kind string, userId string, payload interface{}, maxAttempts int) (QueueJob, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return QueueJob{}, err
+	}
+	job := QueueJob{
+		Kind:        kind,
+		UserId:      userId,
+		Payload:     string(b),
+		Status:      QueueQueued,
+		MaxAttempts: maxAttempts,
+		RunAt:       time.Now(),
+	}
+	err = tx.Create(&job).Error
+	return job, err
+}
+
+// ジョブを登録する
+func EnqueueQueueJob(kind string, userId string, payload interface{}, maxAttempts int) (QueueJob, error) {
+	return EnqueueQueueJobTx(Db, kind, userId, payload, maxAttempts)
+}
+
+func GetQueueJob(id uint64) (QueueJob, error) {
+	var job QueueJob
+	tx := Db.Where("id = ?", id).Limit(1).Find(&job)
+	if tx.Error != nil {
+		return job, tx.Error
+	}
+	if tx.RowsAffected != 1 {
+		return job, errors.New("ジョブが存在しません")
+	}
+	return job, nil
+}
+
+// 管理画面向けにジョブを新しい順に取得する
+// status, kind 空文字列にすると指定なし
+func GetQueueJobs(status string, kind string, page int, pageSize int) ([]QueueJob, error) {
+	tx := Db.Model(&QueueJob{})
+	if status != "" {
+		tx = tx.Where("status = ?", status)
+	}
+	if kind != "" {
+		tx = tx.Where("kind = ?", kind)
+	}
+
+	var jobs []QueueJob
+	tx = tx.Order("id desc").Offset(pageSize * (page - 1)).Limit(pageSize).Find(&jobs)
+	return jobs, tx.Error
+}
+
+// ユーザーごとに同時に一つまでのジョブを登録する
+// 同時に登録されても重複しないよう、ユーザーの行をロックしてから未完了のジョブを確認する
+// 未完了のジョブがある場合はErrQueueJobActiveを返す
+func EnqueueSingleQueueJob(kind string, userId string, payload interface{}, maxAttempts int) (QueueJob, error) {
+	var job QueueJob
+	err := Db.Transaction(func(tx *gorm.DB) error {
+		var user User
+		tdb := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userId).Find(&user)
+		if tdb.Error != nil {
+			return tdb.Error
+		}
+		if tdb.RowsAffected != 1 {
+			return errors.New("ユーザーが存在しません")
+		}
+
+		var cnt int64
+		tdb = tx.Model(&QueueJob{}).Where("user_id = ? and kind = ? and status in ?", userId, kind, []string{QueueQueued, QueueRunning}).Count(&cnt)
+		if tdb.Error != nil {
+			return tdb.Error
+		}
+		if cnt > 0 {
+			return ErrQueueJobActive
+		}
+
+		var err error
+		job, err = EnqueueQueueJobTx(tx, kind, userId, payload, maxAttempts)
+		return err
+	})
+	return job, err
+}
+
+// 実行時間に達したジョブを一つ取得して実行中にする
+// 他のワーカーが取得中の行は飛ばす(SKIP LOCKED)ため、複数のインスタンスで同時に取得しても重複しない
+// 実行中のまま期限を過ぎたジョブ(ワーカーが停止したもの)も再度取得する
+// 取得できるジョブが無ければnilを返す
+func ClaimQueueJob(workerId string, kinds []string, lease time.Duration) (*QueueJob, error) {
+	if len(kinds) == 0 {
+		return nil, nil
+	}
+	now := time.Now()
+
+	var jobs []QueueJob
+	tx := Db.Raw(`update queue_jobs set status = ?, locked_by = ?, locked_until = ?, attempts = attempts + 1, updated_at = ?
+		where id = (
+			select id from queue_jobs
+			where kind in ? and ((status = ? and run_at <= ?) or (status = ? and locked_until < ?))
+			order by run_at asc, id asc
+			limit 1
+			for update skip locked
+		)
+		returning *`,
+		QueueRunning, workerId, now.Add(lease), now,
+		kinds, QueueQueued, now, QueueRunning, now,
+	).Scan(&jobs)
+	if tx.Error != nil || len(jobs) == 0 {
+		return nil, tx.Error
+	}
+	return &jobs[0], nil
+}
+
+// 取得したワーカーが保持しているジョブを更新する
+func updateClaimedQueueJob(id uint64, workerId string, values map[string]interface{}) error {
+	values["locked_by"] = ""
+	values["updated_at"] = time.Now()
+	tx := Db.Model(&QueueJob{}).Where("id = ? and locked_by = ? and status = ?", id, workerId, QueueRunning).Updates(values)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected != 1 {
+		return ErrQueueJobLost
+	}
+	return nil
+}
+
+// ジョブの成功を記録する
+func CompleteQueueJob(id uint64, workerId string, result string, resultFile string) error {
+	return updateClaimedQueueJob(id, workerId, map[string]interface{}{
+		"status":      QueueSucceeded,
+		"result":      result,
+		"result_file": resultFile,
+		"last_error":  "",
+		"finished_at": time.Now(),
+	})
+}
+
+// ジョブの失敗を記録し、runAtに再試行する
+func RetryQueueJob(id uint64, workerId string, lastError string, runAt time.Time) error {
+	return updateClaimedQueueJob(id, workerId, map[string]interface{}{
+		"status":     QueueQueued,
+		"last_error": lastError,
+		"run_at":     runAt,
+	})
+}
+
+// ジョブを再試行しない失敗(dead)にする
+func KillQueueJob(id uint64, workerId string, lastError string) error {
+	return updateClaimedQueueJob(id, workerId, map[string]interface{}{
+		"status":      QueueDead,
+		"last_error":  lastError,
+		"finished_at": time.Now(),
+	})
+}
+
+// 停止する際に実行中のジョブを試行回数に数えずに戻す
+func ReleaseQueueJob(id uint64, workerId string) error {
+	return updateClaimedQueueJob(id, workerId, map[string]interface{}{
+		"status":   QueueQueued,
+		"attempts": gorm.Expr("attempts - 1"),
+	})
+}
+
+// deadになったジョブを試行回数を0に戻して再度実行待ちにする
+func RetryDeadQueueJob(id uint64) error {
+	tx := Db.Model(&QueueJob{}).Where("id = ? and status = ?", id, QueueDead).Updates(map[string]interface{}{
+		"status":      QueueQueued,
+		"attempts":    0,
+		"run_at":      time.Now(),
+		"finished_at": time.Time{},
+	})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected != 1 {
+		return errors.New("再試行できるジョブが存在しません")
+	}
+	return nil
+}
+
+// 完了してからbefore以前のジョブを削除し、削除した数と削除したジョブの結果ファイルを返す
+func PurgeQueueJobs(before time.Time) (int64, []string, error) {
+	var jobs []QueueJob
+	tx := Db.Select("id, result_file").Where("status in ? and finished_at < ?", []string{QueueSucceeded, QueueDead}, before).Find(&jobs)
+	if tx.Error != nil || len(jobs) == 0 {
+		return 0, nil, tx.Error
+	}
+
+	ids := make([]uint64, len(jobs))
+	var files []string
+	for i, job := range jobs {
+		ids[i] = job.Id
+		if job.ResultFile != "" {
+			files = append(files, job.ResultFile)
+		}
+	}
+	tx = Db.Where("id in ?", ids).Delete(&QueueJob{})
+	return tx.RowsAffected, files, tx.Error
+}
+
+// データベースに保存するジョブキューの保存先
+// 複数のインスタンスで同じジョブを重複して実行しない
+type QueueStore struct{}
+
+func NewQueueStore() *QueueStore {
+	return &QueueStore{}
+}
+
+func (s *QueueStore) Claim(workerId string, kinds []string, lease time.Duration) (*queue.Job, error) {
+	job, err := ClaimQueueJob(workerId, kinds, lease)
+	if err != nil || job == nil {
+		return nil, err
+	}
+	return &queue.Job{
+		Id:          job.Id,
+		Kind:        job.Kind,
+		UserId:      job.UserId,
+		Payload:     job.Payload,
+		Attempts:    job.Attempts,
+		MaxAttempts: job.MaxAttempts,
+	}, nil
+}
+
+func (s *QueueStore) Complete(job queue.Job, workerId string, result queue.Result) error {
+	return CompleteQueueJob(job.Id, workerId, result.Data, result.File)
+}
+
+func (s *QueueStore) Retry(job queue.Job, workerId string, lastError string, runAt time.Time) error {
+	return RetryQueueJob(job.Id, workerId, lastError, runAt)
+}
+
+// deadにしたジョブは管理者が確認できるようエラーログにも残す
+func (s *QueueStore) Kill(job queue.Job, workerId string, lastError string) error {
+	userId := job.UserId
+	if userId == "" {
+		userId = "none"
+	}
+	WriteErrorLog(userId, "none", "qjob-001", "ジョブの実行に失敗しました", fmt.Sprintf("job=%d kind=%s attempts=%d %s", job.Id, job.Kind, job.Attempts, lastError))
+	return KillQueueJob(job.Id, workerId, lastError)
+}
+
+func (s *QueueStore) Release(job queue.Job, workerId string) error {
+	return ReleaseQueueJob(job.Id, workerId)
+}
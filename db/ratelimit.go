@@ -0,0 +1,77 @@
+package db
+
+import (
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"reviewmakerback/ratelimit"
+)
+
+// 使われなくなったバケットを削除する間隔
+const rateLimitSweepSpan = time.Hour
+
+// この期間使われなかったバケットを削除する
+const rateLimitBucketKeep = 24 * time.Hour
+
+// データベースに保持するレート制限の保存先
+// 複数台で動かしても全体で制限できる
+type RateLimitStore struct {
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func NewRateLimitStore() *RateLimitStore {
+	return &RateLimitStore{}
+}
+
+// トークンの補充と取り出しを一文で行う
+// 補充後のトークンが1未満の場合は取り出さずに不許可とする
+const rateLimitTakeSql = `
+INSERT INTO rate_limit_buckets (bucket_key, tokens, allowed, updated_at) VALUES (@key, @burst - 1, true, @now)
+ON CONFLICT (bucket_key) DO UPDATE SET
+	tokens = CASE WHEN ` + rateLimitRefillSql + ` >= 1 THEN ` + rateLimitRefillSql + ` - 1 ELSE ` + rateLimitRefillSql + ` END,
+	allowed = ` + rateLimitRefillSql + ` >= 1,
+	updated_at = @now
+RETURNING tokens, allowed`
+
+const rateLimitRefillSql = `LEAST(@burst, rate_limit_buckets.tokens + @rate * GREATEST(EXTRACT(EPOCH FROM (@now - rate_limit_buckets.updated_at)), 0))`
+
+func (s *RateLimitStore) Take(key string, limit ratelimit.Limit, now time.Time) (ratelimit.Result, error) {
+	s.sweep(now)
+
+	var bucket RateLimitBucket
+	tx := Db.Raw(rateLimitTakeSql, map[string]interface{}{
+		"key":   key,
+		"burst": float64(limit.Burst),
+		"rate":  limit.Rate,
+		"now":   now,
+	}).Scan(&bucket)
+	if tx.Error != nil {
+		return ratelimit.Result{}, tx.Error
+	}
+	return ratelimit.MakeResult(bucket.Tokens, bucket.Allowed, limit), nil
+}
+
+// Takeで取り出したトークンを戻す(満杯を超えない)
+func (s *RateLimitStore) Refund(key string, limit ratelimit.Limit, now time.Time) error {
+	return Db.Model(&RateLimitBucket{}).Where("bucket_key = ?", key).
+		Update("tokens", gorm.Expr("LEAST(?, tokens + 1)", float64(limit.Burst))).Error
+}
+
+// 一定時間使われていないバケットを削除する
+func (s *RateLimitStore) sweep(now time.Time) {
+	s.mu.Lock()
+	if now.Sub(s.lastSweep) < rateLimitSweepSpan {
+		s.mu.Unlock()
+		return
+	}
+	s.lastSweep = now
+	s.mu.Unlock()
+
+	tx := Db.Where("updated_at < ?", now.Add(-rateLimitBucketKeep)).Delete(&RateLimitBucket{})
+	if tx.Error != nil {
+		WriteErrorLog("none", "none", "rlsw-001", "レート制限のバケットを整理できません", tx.Error.Error())
+	}
+}
@@ -0,0 +1,162 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	common "reviewmakerback/common"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 使用済みのリフレッシュトークンが再度使用された場合のエラー
+var ErrRefreshTokenReused = errors.New("使用済みのリフレッシュトークンが使用されました")
+
+// セッションのリフレッシュトークンが発行済みの場合のエラー
+var ErrRefreshTokenIssued = errors.New("このセッションのリフレッシュトークンは発行済みです")
+
+// 使用済みのリフレッシュトークンが再度使用された場合の詳細(errors.IsでErrRefreshTokenReusedと一致する)
+type RefreshTokenReusedError struct {
+	UserId    string // トークンを発行したユーザーのID
+	FamilyId  string // 無効にした系列のID
+	RevokeErr error  // 系列の無効化に失敗した場合のエラー
+}
+
+func (e *RefreshTokenReusedError) Error() string { return ErrRefreshTokenReused.Error() }
+
+func (e *RefreshTokenReusedError) Is(target error) bool { return target == ErrRefreshTokenReused }
+
+// セッションに対して最初のリフレッシュトークンを発行する
+// 一つのセッションにつき一度しか発行できない(同時に発行した場合も一意制約で一つに限る)
+func IssueRefreshToken(session Session) (string, time.Time, error) {
+	familyId, err := makeId()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	token, err := common.MakeSession(session.SessionId)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	limit := sessionLimitTime(session, time.Now())
+	tx := Db.Create(&RefreshToken{
+		TokenHash:   common.GetSHA256(token),
+		FamilyId:    familyId,
+		SessionId:   session.SessionId,
+		UserId:      session.UserId,
+		ExpiredTime: limit,
+	})
+	if isUniqueViolation(tx.Error) {
+		return "", time.Time{}, ErrRefreshTokenIssued
+	} else if tx.Error != nil {
+		return "", time.Time{}, tx.Error
+	}
+
+	// リフレッシュトークンの期限までセッションを延長できるようにする
+	if !session.LimitTime.Equal(limit) {
+		Db.Model(&Session{}).Where("session_id = ?", session.SessionId).Update("limit_time", limit)
+	}
+	return token, limit, nil
+}
+
+// リフレッシュトークンを使用して新しいセッションとリフレッシュトークンを発行する
+// 使用済みのトークンが使われた場合は、盗用とみなして同じ系列のセッションとトークンを全て無効にし、*RefreshTokenReusedErrorを返す
+func RotateRefreshToken(token string) (Session, string, time.Time, error) {
+	var newSession Session
+	var newToken string
+	var rt RefreshToken
+
+	err := Db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		tx1 := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("token_hash = ?", common.GetSHA256(token)).Find(&rt)
+		if tx1.Error != nil {
+			return tx1.Error
+		} else if tx1.RowsAffected != 1 {
+			return errors.New("リフレッシュトークンが存在しません")
+		} else if rt.IsUsed {
+			return ErrRefreshTokenReused
+		} else if rt.ExpiredTime.Before(now) {
+			return errors.New("リフレッシュトークンの有効期限が切れています")
+		}
+
+		// 引き継ぎ元のセッション(ログアウト済みであれば更新できない)
+		var oldSession Session
+		tx1 = tx.Where("session_id = ?", rt.SessionId).Find(&oldSession)
+		if tx1.Error != nil {
+			return tx1.Error
+		} else if tx1.RowsAffected != 1 {
+			return errors.New("セッションは終了しています")
+		}
+
+		var user User
+		tx1 = tx.Select("keep_session").Where("user_id = ?", rt.UserId).Find(&user)
+		if tx1.Error != nil {
+			return tx1.Error
+		}
+		keepSession := user.KeepSession
+		if keepSession <= 0 {
+			keepSession = defaultKeepSession
+		}
+
+		sessionId, err := common.MakeSession(oldSession.SessionId)
+		if err != nil {
+			return err
+		}
+		newToken, err = common.MakeSession(sessionId)
+		if err != nil {
+			return err
+		}
+
+		// ログイン情報はそのままに、セッションIDと期限のみ更新する
+		newSession = oldSession
+		newSession.SessionId = sessionId
+		newSession.LimitTime = rt.ExpiredTime
+		newSession.ExpiredTime = now.Add(time.Duration(keepSession) * time.Second)
+		if newSession.ExpiredTime.After(rt.ExpiredTime) {
+			newSession.ExpiredTime = rt.ExpiredTime
+		}
+		newSession.LastSeenAt = now
+
+		if err = tx.Create(&newSession).Error; err != nil {
+			return err
+		}
+		if err = tx.Where("session_id = ?", oldSession.SessionId).Delete(&Session{}).Error; err != nil {
+			return err
+		}
+		if err = tx.Model(&rt).Update("is_used", true).Error; err != nil {
+			return err
+		}
+		return tx.Create(&RefreshToken{
+			TokenHash:   common.GetSHA256(newToken),
+			FamilyId:    rt.FamilyId,
+			SessionId:   sessionId,
+			UserId:      rt.UserId,
+			ExpiredTime: rt.ExpiredTime,
+		}).Error
+	})
+
+	if errors.Is(err, ErrRefreshTokenReused) {
+		return Session{}, "", time.Time{}, &RefreshTokenReusedError{
+			UserId:    rt.UserId,
+			FamilyId:  rt.FamilyId,
+			RevokeErr: revokeRefreshTokenFamily(rt.FamilyId),
+		}
+	}
+	if err != nil {
+		return Session{}, "", time.Time{}, err
+	}
+	return newSession, newToken, rt.ExpiredTime, nil
+}
+
+// 系列に属するセッションとリフレッシュトークンを全て削除する
+func revokeRefreshTokenFamily(familyId string) error {
+	return Db.Transaction(func(tx *gorm.DB) error {
+		tx1 := tx.Where("session_id in (?)", tx.Model(&RefreshToken{}).Select("session_id").Where("family_id = ?", familyId)).Delete(&Session{})
+		if tx1.Error != nil {
+			return tx1.Error
+		}
+		return tx.Where("family_id = ?", familyId).Delete(&RefreshToken{}).Error
+	})
+}
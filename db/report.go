@@ -0,0 +1,126 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 通報対象の種類
+const (
+	ReportTargetTier   = "tier"
+	ReportTargetReview = "review"
+	ReportTargetUser   = "user"
+)
+
+var ReportTargetTypes = []string{ReportTargetTier, ReportTargetReview, ReportTargetUser}
+
+// 通報理由の分類
+var ReportReasons = []string{
+	"spam",          // スパム・宣伝
+	"harassment",    // 誹謗中傷・嫌がらせ
+	"inappropriate", // 不適切な内容
+	"copyright",     // 権利侵害
+	"other",         // その他
+}
+
+// 通報の対応状態
+const (
+	ReportOpen      = "open"      // 未対応
+	ReportDismissed = "dismissed" // 問題なしとして却下
+	ReportHidden    = "hidden"    // 対象を非表示にした
+	ReportSuspended = "suspended" // 作成ユーザーを利用停止にした
+)
+
+// 対象ごとにまとめた未対応の通報
+type ReportGroup struct {
+	TargetType      string
+	TargetId        string
+	TargetUserId    string
+	ReportCount     int64
+	FirstReportedAt time.Time
+	LastReportedAt  time.Time
+}
+
+// 通報対象の作成ユーザーを取得する
+func GetReportTargetUserId(targetType string, targetId string) (string, error) {
+	var cnt int64
+	var userId string
+	var tx *gorm.DB
+	switch targetType {
+	case ReportTargetTier:
+		var tier Tier
+		tier, tx = GetTier(context.Background(), targetId, "tier_id, user_id")
+		userId = tier.UserId
+	case ReportTargetReview:
+		var review Review
+		review, tx = GetReview(context.Background(), targetId, "review_id, user_id")
+		userId = review.UserId
+	case ReportTargetUser:
+		var user User
+		user, tx = GetUser(context.Background(), targetId, "user_id")
+		userId = user.UserId
+	default:
+		return "", errors.New("通報対象の種類が異常です")
+	}
+	if tx.Error != nil {
+		return "", tx.Error
+	}
+	if tx.Count(&cnt); cnt != 1 {
+		return "", errors.New("通報対象が存在しません")
+	}
+	return userId, nil
+}
+
+// 同じユーザーが同じ対象に対して未対応の通報をしているかどうか
+func ExistsOpenReport(reporterId string, targetType string, targetId string) bool {
+	var cnt int64
+	Db.Model(&Report{}).Where("reporter_id = ? and target_type = ? and target_id = ? and status = ?", reporterId, targetType, targetId, ReportOpen).Count(&cnt)
+	return cnt > 0
+}
+
+func CreateReport(reporterId string, targetType string, targetId string, targetUserId string, reason string, comment string) (Report, error) {
+	report := Report{
+		ReporterId:   reporterId,
+		TargetType:   targetType,
+		TargetId:     targetId,
+		TargetUserId: targetUserId,
+		Reason:       reason,
+		Comment:      comment,
+		Status:       ReportOpen,
+	}
+	tx := Db.Create(&report)
+	return report, tx.Error
+}
+
+// 未対応の通報を対象ごとにまとめて、通報数の多い順に取得する
+func GetOpenReportGroups(ctx context.Context, page int, pageSize int) ([]ReportGroup, error) {
+	var groups []ReportGroup
+	tx := Db.WithContext(ctx).Model(&Report{}).
+		Select("target_type, target_id, target_user_id, count(*) as report_count, min(created_at) as first_reported_at, max(created_at) as last_reported_at").
+		Where("status = ?", ReportOpen).
+		Group("target_type, target_id, target_user_id").
+		Order("report_count desc, last_reported_at desc").
+		Offset(pageSize * (page - 1)).Limit(pageSize).
+		Scan(&groups)
+	return groups, tx.Error
+}
+
+// 対象に対する未対応の通報を新しい順に取得する
+func GetOpenReports(ctx context.Context, targetType string, targetId string) ([]Report, error) {
+	var reports []Report
+	tx := Db.WithContext(ctx).Where("target_type = ? and target_id = ? and status = ?", targetType, targetId, ReportOpen).Order("created_at desc").Find(&reports)
+	return reports, tx.Error
+}
+
+// 対象に対する未対応の通報を全て対応済みにし、対応した件数を返す
+func ResolveReports(targetType string, targetId string, status string, moderatorId string) (int64, error) {
+	tx := Db.Model(&Report{}).Where("target_type = ? and target_id = ? and status = ?", targetType, targetId, ReportOpen).Updates(map[string]interface{}{
+		"status":      status,
+		"resolved_by": moderatorId,
+		"resolved_at": time.Now(),
+	})
+	return tx.RowsAffected, tx.Error
+}
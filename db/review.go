@@ -1,15 +1,17 @@
 package db
 
 import (
+	"context"
+
 	common "reviewmakerback/common"
 
 	"gorm.io/gorm"
 )
 
-func GetReview(rid string, selectText string) (Review, *gorm.DB) {
+func GetReview(ctx context.Context, rid string, selectText string) (Review, *gorm.DB) {
 	var review Review
 
-	tx := Db.Select(selectText).Where("review_id = ?", rid).Find(&review)
+	tx := Db.WithContext(ctx).Select(selectText).Where("review_id = ?", rid).Find(&review)
 	return review, tx
 }
 
@@ -18,7 +20,8 @@ func GetReview(rid string, selectText string) (Review, *gorm.DB) {
 // word 空文字列になると検索無し
 // pageSize 省略不可
 // sortType 空文字列にすると順序指定なし
-func GetReviews(userId string, tierId string, word string, sortType string, page int, pageSize int, includeSection bool) ([]Review, error) {
+// includeHidden falseにするとモデレーターにより非表示にされたレビューを含めない
+func GetReviews(ctx context.Context, userId string, tierId string, word string, sortType string, page int, pageSize int, includeSection bool, includeHidden bool) ([]Review, error) {
 	/**
 	"updatedAtDesc",
 	"updatedAtAsc",
@@ -26,7 +29,10 @@ func GetReviews(userId string, tierId string, word string, sortType string, page
 	"createdAtAsc",
 	*/
 
-	tx := Db.Where("user_id = ?", userId)
+	tx := Db.WithContext(ctx).Where("user_id = ?", userId)
+	if !includeHidden {
+		tx = tx.Where("is_hidden = ?", false)
+	}
 
 	if !includeSection {
 		// セクションを含めないでselectする
@@ -62,26 +68,15 @@ func GetReviews(userId string, tierId string, word string, sortType string, page
 func ExistsReview(rid string) bool {
 	var cnt int64
 
-	_, tx := GetReview(rid, "review_id")
+	_, tx := GetReview(context.Background(), rid, "review_id")
 
 	tx.Count(&cnt)
 	return cnt == 1
 }
 
 func CreateReviewId(userId string, tierId string) (string, error) {
-	var id string
-	var err error
-	for i := 0; i < RetryCreateCnt; i++ {
-		// ランダムな文字列を生成して、IDにする
-		id, err = common.MakeRandomChars(idSize, userId+tierId)
-		if err != nil {
-			return "", err
-		}
-		if !ExistsReview(id) {
-			return id, err
-		}
-	}
-	return "", err
+	// ランダムな文字列を生成して、IDにする
+	return makeId()
 }
 
 func CreateReview(
@@ -135,9 +130,9 @@ func DeleteReview(reviewId string) error {
 	return tx.Error
 }
 
-func GetReviewCountInUser(userId string) int64 {
+func GetReviewCountInUser(ctx context.Context, userId string) int64 {
 	var cnt int64
-	Db.Select("review_id").Where("user_id = ?", userId).Find(&Review{}).Count(&cnt)
+	Db.WithContext(ctx).Select("review_id").Where("user_id = ?", userId).Find(&Review{}).Count(&cnt)
 	return cnt
 }
 
@@ -146,3 +141,10 @@ func GetReviewCountInTier(tierId string) int64 {
 	Db.Select("review_id").Where("tier_id = ?", tierId).Find(&Review{}).Count(&cnt)
 	return cnt
 }
+
+// ユーザーの全レビューを作成順に取得する(エクスポート用)
+func GetAllReviewsInUser(userId string) ([]Review, error) {
+	var reviews []Review
+	tx := Db.Where("user_id = ?", userId).Order("created_at asc").Find(&reviews)
+	return reviews, tx.Error
+}
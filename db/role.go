@@ -0,0 +1,128 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ユーザーの権限
+const (
+	RoleUser      = "user"      // 一般ユーザー
+	RoleModerator = "moderator" // 任意のTier・レビューの非表示化が可能
+	RoleAdmin     = "admin"     // モデレーターの権限に加えてユーザーの管理が可能
+)
+
+// 権限の一覧(権限の弱い順)
+var Roles = []string{RoleUser, RoleModerator, RoleAdmin}
+
+// 権限の強さを返す(不明な権限は-1)
+func roleLevel(role string) int {
+	for i, r := range Roles {
+		if r == role {
+			return i
+		}
+	}
+	return -1
+}
+
+// roleがrequiredの権限を満たしているかどうか
+func HasRole(role string, required string) bool {
+	level := roleLevel(role)
+	return level >= 0 && level >= roleLevel(required)
+}
+
+// roleがtargetの権限より強いかどうか(同じ権限のユーザーには操作できない)
+func OutranksRole(role string, target string) bool {
+	level := roleLevel(role)
+	return level >= 0 && level > roleLevel(target)
+}
+
+// ユーザーの権限を取得する
+func GetUserRole(userId string) (string, error) {
+	var cnt int64
+	user, tx := GetUser(context.Background(), userId, "user_id, role")
+	if tx.Error != nil {
+		return "", tx.Error
+	}
+	if tx.Count(&cnt); cnt != 1 {
+		return "", errors.New("ユーザーが存在しません")
+	}
+	return user.Role, nil
+}
+
+// ユーザーの権限を変更する
+func UpdateUserRole(userId string, role string) error {
+	if roleLevel(role) < 0 {
+		return errors.New("権限が異常です")
+	}
+	tx := Db.Model(&User{}).Where("user_id = ?", userId).Update("role", role)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected != 1 {
+		return errors.New("ユーザーが存在しません")
+	}
+	return nil
+}
+
+// 管理画面向けにユーザーの一覧を取得する
+// role 空文字列にすると権限の指定なし
+func GetUsers(word string, role string, page int, pageSize int) ([]User, error) {
+	tx := Db.Select("user_id, name, icon_url, role, suspension_state, suspended_until, created_at, updated_at")
+	if word != "" {
+		tx = tx.Where(SearchWord([]string{"name"}, word))
+	}
+	if role != "" {
+		tx = tx.Where("role = ?", role)
+	}
+
+	var users []User
+	tx = tx.Order("created_at desc").Offset(pageSize * (page - 1)).Limit(pageSize).Find(&users)
+	return users, tx.Error
+}
+
+// Tierの非表示フラグを変更する
+// 更新日時は変更しない
+func UpdateTierHidden(tierId string, isHidden bool) error {
+	return updateHidden(Db.Model(&Tier{}).Where("tier_id = ?", tierId), isHidden)
+}
+
+// レビューの非表示フラグを変更する
+// 更新日時は変更しない
+func UpdateReviewHidden(reviewId string, isHidden bool) error {
+	return updateHidden(Db.Model(&Review{}).Where("review_id = ?", reviewId), isHidden)
+}
+
+func updateHidden(tx *gorm.DB, isHidden bool) error {
+	tx = tx.UpdateColumn("is_hidden", isHidden)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected != 1 {
+		return errors.New("対象が存在しません")
+	}
+	return nil
+}
+
+// 設定(環境変数BACK_ADMIN_USERS)で指定したユーザーを管理者にする
+// 最初の管理者を設定するために使用する
+func PromoteAdmins(userIds []string) error {
+	for _, userId := range userIds {
+		userId = strings.TrimSpace(userId)
+		if userId == "" {
+			continue
+		}
+		tx := Db.Model(&User{}).Where("user_id = ? and role <> ?", userId, RoleAdmin).Update("role", RoleAdmin)
+		if tx.Error != nil {
+			return tx.Error
+		}
+		if tx.RowsAffected > 0 {
+			WritePrivilegedOperationLog("none", userId, "none", "uaur", fmt.Sprintf("role=->%s (BACK_ADMIN_USERS)", RoleAdmin))
+		}
+	}
+	return nil
+}
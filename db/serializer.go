@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm/schema"
+)
+
+// 保存時に暗号化し、読み込み時に復号するシリアライザ
+// `gorm:"serializer:encrypted"` を指定したstring型の項目に使用する
+type EncryptedSerializer struct{}
+
+func init() {
+	schema.RegisterSerializer("encrypted", EncryptedSerializer{})
+
+	// セッションに保存している連携サービスのトークン
+	for _, column := range []string{
+		"twitter_token",
+		"twitter_token1",
+		"twitter_secret1",
+		"google_access_token",
+		"google_refresh_token",
+	} {
+		RegisterEncryptedColumn("sessions", "session_id", column, true)
+	}
+	// Webhookの署名に用いるシークレット
+	RegisterEncryptedColumn("webhooks", "webhook_id", "secret", true)
+}
+
+func (EncryptedSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var value string
+	switch v := dbValue.(type) {
+	case nil:
+		value = ""
+	case []byte:
+		value = string(v)
+	case string:
+		value = v
+	default:
+		return fmt.Errorf("暗号化された項目'%s'の型が異常です", field.Name)
+	}
+
+	// 暗号化前に保存された平文はそのまま読み込む(再暗号化の処理で暗号化される)
+	if IsEncrypted(value) {
+		if Encryption == nil {
+			return errors.New("暗号化の鍵が設定されていません")
+		}
+		plaintext, err := Encryption.Decrypt(value)
+		if err != nil {
+			return fmt.Errorf("項目'%s'を復号できません: %s", field.Name, err.Error())
+		}
+		value = plaintext
+	}
+
+	field.ReflectValueOf(ctx, dst).SetString(value)
+	return nil
+}
+
+func (EncryptedSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	value, ok := fieldValue.(string)
+	if !ok {
+		return nil, fmt.Errorf("暗号化する項目'%s'はstring型である必要があります", field.Name)
+	}
+	if Encryption == nil {
+		return nil, errors.New("暗号化の鍵が設定されていません")
+	}
+	return Encryption.Encrypt(value)
+}
@@ -0,0 +1,139 @@
+package db
+
+import (
+	"net"
+	"time"
+
+	common "reviewmakerback/common"
+
+	"github.com/labstack/echo"
+)
+
+// 直近のアクセス日時を記録する間隔(秒)
+const SessionTouchSpan = 60
+
+// ユーザーのセッション保持時間が取得できない場合の既定値(秒)
+const defaultKeepSession = 7200
+
+// アクセスがあっても延長できないセッションの最大寿命(秒)
+const SessionLifetimeMax = 30 * 24 * 60 * 60
+
+// ユーザーエージェントの最大保存文字数
+const userAgentLenMax = 400
+
+// セッションの直近のアクセス情報を記録する
+// 書き込みを減らすため、アクセス元が変わった場合か一定時間経過した場合のみ更新する
+func touchSession(session *Session, c echo.Context) {
+	now := time.Now()
+	ip := net.ParseIP(c.RealIP()).String()
+	ua := common.SubstringMult(c.Request().UserAgent(), 0, userAgentLenMax)
+
+	if session.IpAddress == ip && session.UserAgent == ua && session.LastSeenAt.Add(SessionTouchSpan*time.Second).After(now) {
+		return
+	}
+
+	session.IpAddress = ip
+	session.UserAgent = ua
+	session.LastSeenAt = now
+	Db.Model(&Session{}).Where("session_id = ?", session.SessionId).Updates(map[string]interface{}{
+		"ip_address":   ip,
+		"user_agent":   ua,
+		"last_seen_at": now,
+	})
+}
+
+// セッションの絶対的な有効期限を取得する
+// 期限が未設定の古いセッションは、作成日時から最大寿命を過ぎるまでとする
+func sessionLimitTime(session Session, now time.Time) time.Time {
+	if !session.LimitTime.IsZero() {
+		return session.LimitTime
+	}
+	base := session.CreatedAt
+	if base.IsZero() {
+		base = now
+	}
+	return base.Add(SessionLifetimeMax * time.Second)
+}
+
+// セッションの有効期限をアクセス時点からセッション保持時間だけ延長する
+// ただし、絶対的な有効期限を超えて延長しない
+func slideSession(session *Session, keepSession int, now time.Time) {
+	if keepSession <= 0 {
+		keepSession = defaultKeepSession
+	}
+
+	limit := sessionLimitTime(*session, now)
+	exTime := now.Add(time.Duration(keepSession) * time.Second)
+	if exTime.After(limit) {
+		exTime = limit
+	}
+
+	// 書き込みを減らすため、一定時間以上延長される場合のみ更新する
+	if session.LimitTime.Equal(limit) && exTime.Sub(session.ExpiredTime) < SessionTouchSpan*time.Second {
+		return
+	}
+
+	session.ExpiredTime = exTime
+	session.LimitTime = limit
+	Db.Model(&Session{}).Where("session_id = ?", session.SessionId).Updates(map[string]interface{}{
+		"expired_time": exTime,
+		"limit_time":   limit,
+	})
+}
+
+// セッションIDを推測できない形で外部に公開するための識別子
+func PublicSessionId(sessionId string) string {
+	return common.Substring(common.GetSHA256(sessionId), 0, 16)
+}
+
+// ユーザーの有効なセッションを直近のアクセス順に取得する
+func GetSessionsInUser(userId string) ([]Session, error) {
+	var sessions []Session
+	tx := Db.Select("session_id, user_id, expired_time, login_service, login_version, ip_address, user_agent, created_at, last_seen_at").
+		Where("user_id = ? and expired_time >= ?", userId, time.Now()).
+		Order("last_seen_at desc nulls last").Find(&sessions)
+	return sessions, tx.Error
+}
+
+// 公開用の識別子に対応するユーザーのセッションを削除する
+// 該当するセッションが無い場合はfalseを返す
+func DeleteSessionInUser(userId string, publicId string) (bool, error) {
+	sessions, err := GetSessionsInUser(userId)
+	if err != nil {
+		return false, err
+	}
+	for _, session := range sessions {
+		if PublicSessionId(session.SessionId) == publicId {
+			tx := Db.Where("session_id = ? and user_id = ?", session.SessionId, userId).Delete(&Session{})
+			if tx.Error != nil {
+				return false, tx.Error
+			}
+			// セッションに対応するリフレッシュトークンも無効にする
+			return tx.RowsAffected > 0, Db.Where("session_id = ?", session.SessionId).Delete(&RefreshToken{}).Error
+		}
+	}
+	return false, nil
+}
+
+// 指定したセッション以外のユーザーのセッションを全て削除する
+func DeleteOtherSessionsInUser(userId string, sessionId string) (int64, error) {
+	tx := Db.Where("user_id = ? and session_id <> ?", userId, sessionId).Delete(&Session{})
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+	// セッションに対応するリフレッシュトークンも無効にする
+	return tx.RowsAffected, Db.Where("user_id = ? and session_id <> ?", userId, sessionId).Delete(&RefreshToken{}).Error
+}
+
+// リクエストのセッションに紐づくユーザーIDを取得する
+// アクセスの記録や有効期限の延長は行わない(セッションが無い場合は空文字列)
+func PeekSessionUserId(c echo.Context) string {
+	token := c.Request().Header.Get("Authorization")
+	if common.Substring(token, 0, 7) != "Bearer " {
+		return ""
+	}
+
+	var session Session
+	Db.Select("user_id").Where("session_id = ? and expired_time > ?", common.Substring(token, 7, len(token)-7), time.Now()).Limit(1).Find(&session)
+	return session.UserId
+}
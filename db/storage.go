@@ -0,0 +1,183 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 保存済みのファイルを確認する間隔(秒)
+const StorageCheckSpan = 86400
+
+// 管理画面で一度に取得可能な容量の多いユーザー数
+const StorageRankingMax = 100
+
+// 容量の多いユーザー(管理画面用)
+type StorageUsageRanking struct {
+	UserId    string
+	Name      string
+	Bytes     int64
+	UpdatedAt time.Time
+}
+
+// 画像のパスを含む列のみを読み込み、batchSize件ずつfnに渡す
+func EachTierFiles(batchSize int, fn func(tiers []Tier) error) error {
+	var tiers []Tier
+	return Db.Select("tier_id, user_id, image_url, parags").FindInBatches(&tiers, batchSize, func(tx *gorm.DB, batch int) error {
+		return fn(tiers)
+	}).Error
+}
+
+// 画像のパスを含む列のみを読み込み、batchSize件ずつfnに渡す
+func EachReviewFiles(batchSize int, fn func(reviews []Review) error) error {
+	var reviews []Review
+	return Db.Select("review_id, user_id, icon_url, sections").FindInBatches(&reviews, batchSize, func(tx *gorm.DB, batch int) error {
+		return fn(reviews)
+	}).Error
+}
+
+// 画像のパスを含む列のみを読み込み、batchSize件ずつfnに渡す
+func EachUserFiles(batchSize int, fn func(users []User) error) error {
+	var users []User
+	return Db.Select("user_id, icon_url").FindInBatches(&users, batchSize, func(tx *gorm.DB, batch int) error {
+		return fn(users)
+	}).Error
+}
+
+// 実行待ち・実行中の画像の変換ジョブの出力先のパスを取得する
+func GetPendingImageJobPaths() ([]string, error) {
+	var payloads []string
+	tdb := Db.Model(&QueueJob{}).Where("kind = ? and status in ?", QueueKindImage, []string{QueueQueued, QueueRunning}).Pluck("payload", &payloads)
+	if tdb.Error != nil {
+		return nil, tdb.Error
+	}
+	paths := make([]string, 0, len(payloads))
+	for _, payload := range payloads {
+		var p struct {
+			Path string `json:"path"`
+		}
+		if json.Unmarshal([]byte(payload), &p) == nil && p.Path != "" {
+			paths = append(paths, p.Path)
+		}
+	}
+	return paths, nil
+}
+
+// ユーザーの保存済みファイルの容量を取得する(記録がなければ0)
+func GetStorageUsage(userId string) (int64, error) {
+	var usage StorageUsage
+	tdb := Db.Where("user_id = ?", userId).Limit(1).Find(&usage)
+	return usage.Bytes, tdb.Error
+}
+
+// ユーザーの保存済みファイルの容量をdeltaバイト増減する(0未満にはしない)
+// 再計算中の増減を再計算の結果に反映できるよう、増減したバイト数も記録する
+func AddStorageUsage(userId string, delta int64) error {
+	if userId == "" || delta == 0 {
+		return nil
+	}
+	bytes := delta
+	if bytes < 0 {
+		bytes = 0
+	}
+	return Db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"bytes":      gorm.Expr("GREATEST(storage_usages.bytes + ?, 0)", delta),
+			"scan_delta": gorm.Expr("storage_usages.scan_delta + ?", delta),
+			"updated_at": time.Now(),
+		}),
+	}).Create(&StorageUsage{UserId: userId, Bytes: bytes, ScanDelta: delta}).Error
+}
+
+// 上限quotaを超えない場合のみ、ユーザーの保存済みファイルの容量をaddingバイト増やす
+// freeingには同時に削除するファイルのバイト数を指定する(削除後にAddStorageUsageで減らすこと)
+// 確認と増加を一つの更新で行うため、同時に保存しても上限を超えない
+func ReserveStorageUsage(userId string, adding int64, freeing int64, quota int64) (bool, error) {
+	tdb := Db.Clauses(clause.OnConflict{DoNothing: true}).Create(&StorageUsage{UserId: userId})
+	if tdb.Error != nil {
+		return false, tdb.Error
+	}
+	tdb = Db.Model(&StorageUsage{}).Where("user_id = ? and bytes - ? + ? <= ?", userId, freeing, adding, quota).
+		Updates(map[string]interface{}{
+			"bytes":      gorm.Expr("bytes + ?", adding),
+			"scan_delta": gorm.Expr("scan_delta + ?", adding),
+			"updated_at": time.Now(),
+		})
+	return tdb.RowsAffected == 1, tdb.Error
+}
+
+// 保存先のファイルからの容量の再計算を始める
+// 以降の増減を記録し、SetStorageUsagesで再計算の結果に加える
+// userIdが空文字列なら全ユーザーを対象にする
+func StartStorageRecount(userId string) error {
+	tdb := Db.Model(&StorageUsage{}).Where("scan_delta <> 0")
+	if userId != "" {
+		tdb = tdb.Where("user_id = ?", userId)
+	}
+	return tdb.Update("scan_delta", 0).Error
+}
+
+// ユーザーの保存済みファイルの容量を再計算の結果で置き換える
+func SetStorageUsage(userId string, bytes int64) error {
+	return SetStorageUsages(map[string]int64{userId: bytes}, false)
+}
+
+// 保存済みファイルの容量を再計算の結果でまとめて置き換える
+// StartStorageRecountの後に記録した増減は、再計算中の保存・削除の分として結果に加える
+// resetOthersがtrueならusagesに含まれないユーザーの容量を再計算中の増減のみにする
+func SetStorageUsages(usages map[string]int64, resetOthers bool) error {
+	rows := make([]StorageUsage, 0, len(usages))
+	userIds := make([]string, 0, len(usages))
+	for userId, bytes := range usages {
+		rows = append(rows, StorageUsage{UserId: userId, Bytes: bytes})
+		userIds = append(userIds, userId)
+	}
+	return Db.Transaction(func(tx *gorm.DB) error {
+		if resetOthers {
+			tdb := tx.Model(&StorageUsage{}).Where("(bytes <> 0 or scan_delta <> 0)")
+			if len(userIds) > 0 {
+				tdb = tdb.Where("user_id not in ?", userIds)
+			}
+			err := tdb.Updates(map[string]interface{}{
+				"bytes":      gorm.Expr("GREATEST(scan_delta, 0)"),
+				"scan_delta": 0,
+				"updated_at": time.Now(),
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		return tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "user_id"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"bytes":      gorm.Expr("GREATEST(excluded.bytes + storage_usages.scan_delta, 0)"),
+				"scan_delta": 0,
+				"updated_at": time.Now(),
+			}),
+		}).CreateInBatches(rows, 500).Error
+	})
+}
+
+// ユーザーの保存済みファイルの容量の記録を削除する
+func DeleteStorageUsage(userId string) error {
+	return Db.Where("user_id = ?", userId).Delete(&StorageUsage{}).Error
+}
+
+// 保存済みファイルの容量が多い順にユーザーを取得する
+func GetStorageUsageRanking(limit int) ([]StorageUsageRanking, error) {
+	var ranking []StorageUsageRanking
+	tdb := Db.Table("storage_usages as s").
+		Select("s.user_id, COALESCE(u.name, '') as name, s.bytes, s.updated_at").
+		Joins("left join users as u on u.user_id = s.user_id").
+		Where("s.bytes > 0").
+		Order("s.bytes desc, s.user_id").
+		Limit(limit).
+		Scan(&ranking)
+	return ranking, tdb.Error
+}
@@ -0,0 +1,139 @@
+package db
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 利用停止状態
+const (
+	SuspensionNone      = "none"      // 通常
+	SuspensionTemporary = "temporary" // 期限付きの利用停止
+	SuspensionPermanent = "permanent" // 無期限の利用停止(BAN)
+)
+
+var SuspensionStates = []string{SuspensionNone, SuspensionTemporary, SuspensionPermanent}
+
+// 利用停止中のユーザーが書き込みの操作を行おうとした
+var ErrUserSuspended = errors.New("利用停止中のユーザーです")
+
+// 指定日時の時点で利用停止中かどうか
+func (u User) IsSuspendedAt(t time.Time) bool {
+	switch u.SuspensionState {
+	case SuspensionPermanent:
+		return true
+	case SuspensionTemporary:
+		return u.SuspendedUntil.After(t)
+	}
+	return false
+}
+
+// 現在利用停止中かどうか
+func (u User) IsSuspended() bool {
+	return u.IsSuspendedAt(time.Now())
+}
+
+// 書き込みの操作かどうか
+// ログイン・ログアウトなどの認証に関する操作は利用停止中でも行えるようにする
+func isWriteRequest(c echo.Context) bool {
+	switch c.Request().Method {
+	case "GET", "HEAD", "OPTIONS":
+		return false
+	}
+	return !strings.HasPrefix(c.Request().URL.Path, "/auth/")
+}
+
+// ユーザーの利用停止状態を変更し、履歴を記録する
+// stateがtemporaryの場合のみuntilを使用する
+// 無期限の利用停止にした場合は、連携サービスのアカウントでの再登録もできないようにする
+func UpdateSuspension(userId string, state string, until time.Time, reason string, operatorId string) error {
+	switch state {
+	case SuspensionTemporary:
+		if !until.After(time.Now()) {
+			return errors.New("利用停止の終了日時が異常です")
+		}
+	case SuspensionNone, SuspensionPermanent:
+		until = time.Time{}
+	default:
+		return errors.New("利用停止状態が異常です")
+	}
+
+	return Db.Transaction(func(tx *gorm.DB) error {
+		var user User
+		var cnt int64
+		tdb := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userId).Find(&user)
+		if tdb.Error != nil {
+			return tdb.Error
+		}
+		if tdb.Count(&cnt); cnt != 1 {
+			return errors.New("ユーザーが存在しません")
+		}
+
+		tdb = tx.Model(&User{}).Where("user_id = ?", userId).Updates(map[string]interface{}{
+			"suspension_state": state,
+			"suspended_until":  until,
+		})
+		if tdb.Error != nil {
+			return tdb.Error
+		}
+
+		// 再登録の制限
+		if state == SuspensionPermanent {
+			for _, service := range user.LinkedServices() {
+				serviceId := user.ServiceId(service)
+				tdb = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&BannedAccount{
+					Service:   service,
+					ServiceId: serviceId,
+					UserId:    userId,
+				})
+				if tdb.Error != nil {
+					return tdb.Error
+				}
+			}
+		} else {
+			tdb = tx.Where("user_id = ?", userId).Delete(&BannedAccount{})
+			if tdb.Error != nil {
+				return tdb.Error
+			}
+		}
+
+		return tx.Create(&SuspensionLog{
+			UserId:     userId,
+			State:      state,
+			Until:      until,
+			Reason:     reason,
+			OperatorId: operatorId,
+		}).Error
+	})
+}
+
+// ユーザーを利用停止にする
+// untilにゼロ値を指定すると無期限の利用停止になる
+func SuspendUser(userId string, until time.Time, reason string, operatorId string) error {
+	if until.IsZero() {
+		return UpdateSuspension(userId, SuspensionPermanent, until, reason, operatorId)
+	}
+	return UpdateSuspension(userId, SuspensionTemporary, until, reason, operatorId)
+}
+
+// 連携サービスのアカウントが無期限の利用停止になっているかどうか
+func IsBannedAccount(service string, serviceId string) bool {
+	if serviceId == "" {
+		return false
+	}
+	var cnt int64
+	Db.Model(&BannedAccount{}).Where("service = ? and service_id = ?", service, serviceId).Count(&cnt)
+	return cnt > 0
+}
+
+// 利用停止状態の変更履歴を新しい順に取得する
+func GetSuspensionLogs(userId string) ([]SuspensionLog, error) {
+	var logs []SuspensionLog
+	tx := Db.Where("user_id = ?", userId).Order("created_at desc, id desc").Find(&logs)
+	return logs, tx.Error
+}
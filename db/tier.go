@@ -1,41 +1,32 @@
 package db
 
 import (
+	"context"
+
 	common "reviewmakerback/common"
 
 	"gorm.io/gorm"
 )
 
-func GetTier(tid string, selectText string) (Tier, *gorm.DB) {
+func GetTier(ctx context.Context, tid string, selectText string) (Tier, *gorm.DB) {
 	var tier Tier
 
-	tx := Db.Select(selectText).Where("tier_id = ?", tid).Find(&tier)
+	tx := Db.WithContext(ctx).Select(selectText).Where("tier_id = ?", tid).Find(&tier)
 	return tier, tx
 }
 
 func ExistsTier(tid string) bool {
 	var cnt int64
 
-	_, tx := GetTier(tid, "tier_id")
+	_, tx := GetTier(context.Background(), tid, "tier_id")
 
 	tx.Count(&cnt)
 	return cnt == 1
 }
 
 func CreateTierId(userId string) (string, error) {
-	var id string
-	var err error
-	for i := 0; i < RetryCreateCnt; i++ {
-		// ランダムな文字列を生成して、IDにする
-		id, err = common.MakeRandomChars(idSize, userId)
-		if err != nil {
-			return "", err
-		}
-		if !ExistsTier(id) {
-			return id, err
-		}
-	}
-	return "", err
+	// ランダムな文字列を生成して、IDにする
+	return makeId()
 }
 
 func CreateTier(
@@ -108,14 +99,18 @@ func UpdateTierTx(
 	return tx1.Error
 }
 
-func GetTiers(userId string, word string, sortType string, page int, pageSize int) ([]Tier, error) {
+// includeHidden falseにするとモデレーターにより非表示にされたTierを含めない
+func GetTiers(ctx context.Context, userId string, word string, sortType string, page int, pageSize int, includeHidden bool) ([]Tier, error) {
 	/**
 	"updatedAtDesc",
 	"updatedAtAsc",
 	"createdAtDesc",
 	"createdAtAsc",
 	*/
-	tx := Db
+	tx := Db.WithContext(ctx)
+	if !includeHidden {
+		tx = tx.Where("is_hidden = ?", false)
+	}
 
 	if word == "" {
 		// 検索文字列指定無
@@ -140,8 +135,15 @@ func GetTiers(userId string, word string, sortType string, page int, pageSize in
 	return tiers, nil
 }
 
-func GetTierCountInUser(userId string) int64 {
+func GetTierCountInUser(ctx context.Context, userId string) int64 {
 	var cnt int64
-	Db.Select("tier_id").Where("user_id = ?", userId).Find(&Tier{}).Count(&cnt)
+	Db.WithContext(ctx).Select("tier_id").Where("user_id = ?", userId).Find(&Tier{}).Count(&cnt)
 	return cnt
 }
+
+// ユーザーの全Tierを作成順に取得する(エクスポート用)
+func GetAllTiersInUser(userId string) ([]Tier, error) {
+	var tiers []Tier
+	tx := Db.Where("user_id = ?", userId).Order("created_at asc").Find(&tiers)
+	return tiers, tx.Error
+}
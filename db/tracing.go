@@ -0,0 +1,67 @@
+package db
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+
+	"reviewmakerback/tracing"
+)
+
+// スパンを保存するキー
+const tracingSpanKey = "tracing:span"
+
+// GORMの各処理をスパンとして記録するコールバックを登録する
+// 親スパンを持つコンテキスト(Db.WithContext)で実行したクエリのみ記録する
+// リクエストの処理ではセッションの確認と、Tier・レビュー・ユーザー・通知・通報の取得にリクエストのコンテキストを渡す
+func registerQueryTracing(db *gorm.DB) error {
+	callback := db.Callback()
+	processors := []struct {
+		operation string
+		before    func(name string, fn func(*gorm.DB)) error
+		after     func(name string, fn func(*gorm.DB)) error
+	}{
+		{"create", callback.Create().Before("gorm:create").Register, callback.Create().After("gorm:create").Register},
+		{"query", callback.Query().Before("gorm:query").Register, callback.Query().After("gorm:query").Register},
+		{"update", callback.Update().Before("gorm:update").Register, callback.Update().After("gorm:update").Register},
+		{"delete", callback.Delete().Before("gorm:delete").Register, callback.Delete().After("gorm:delete").Register},
+		{"row", callback.Row().Before("gorm:row").Register, callback.Row().After("gorm:row").Register},
+		{"raw", callback.Raw().Before("gorm:raw").Register, callback.Raw().After("gorm:raw").Register},
+	}
+
+	for _, p := range processors {
+		operation := p.operation
+		if err := p.before("tracing:before_"+operation, func(tx *gorm.DB) {
+			ctx := tx.Statement.Context
+			if ctx == nil || !trace.SpanContextFromContext(ctx).IsValid() {
+				return
+			}
+			_, span := tracing.Start(ctx, "gorm."+operation,
+				attribute.String("db.system", "postgresql"),
+				attribute.String("db.sql.table", tx.Statement.Table),
+			)
+			tx.InstanceSet(tracingSpanKey, span)
+		}); err != nil {
+			return err
+		}
+		if err := p.after("tracing:after_"+operation, func(tx *gorm.DB) {
+			value, ok := tx.InstanceGet(tracingSpanKey)
+			if !ok {
+				return
+			}
+			span := value.(trace.Span)
+			// 値はプレースホルダーのまま記録する
+			span.SetAttributes(
+				attribute.String("db.statement", tx.Statement.SQL.String()),
+				attribute.Int64("db.rows_affected", tx.RowsAffected),
+			)
+			if tx.Error != gorm.ErrRecordNotFound {
+				tracing.RecordError(span, tx.Error)
+			}
+			span.End()
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"errors"
 	"fmt"
 
@@ -9,16 +10,16 @@ import (
 	"gorm.io/gorm"
 )
 
-func GetUser(id string, selectText string) (User, *gorm.DB) {
+func GetUser(ctx context.Context, id string, selectText string) (User, *gorm.DB) {
 	var user User
 
-	tx := Db.Select(selectText).Where("user_id = ?", id).Find(&user)
+	tx := Db.WithContext(ctx).Select(selectText).Where("user_id = ?", id).Find(&user)
 	return user, tx
 }
 
 func ExistsUser(id string) bool {
 	var cnt int64
-	_, tx := GetUser(id, "user_id")
+	_, tx := GetUser(context.Background(), id, "user_id")
 	tx.Count(&cnt)
 	return cnt == 1
 }
@@ -39,48 +40,83 @@ func ExistsUserGId(gid string) (bool, User) {
 	return cnt > 0, user
 }
 
+// 連携サービスのIDでユーザーを検索する
+func ExistsUserService(service string, serviceId string) (bool, User) {
+	var user User
+	var cnt int64
+
+	if !common.Contains(service, Services) || serviceId == "" {
+		return false, user
+	}
+	Db.Where(service+"_id = ?", serviceId).Find(&user).Count(&cnt)
+	return cnt > 0, user
+}
+
 func CreateUser(service string, name string, profile string, iconUrl string, twitterId string, twitterUserName string, googleId string, googleEmail string, requestIp string) (User, error) {
-	var id string
-	var err error
-	if service == "twitter" {
-		if f, u := ExistsUserTId(twitterId); f {
-			return u, errors.New("指定されたTwitterIDは登録済みです")
-		}
-	} else if service == "google" {
-		if f, u := ExistsUserGId(googleId); f {
-			return u, errors.New("指定されたGoogleIDは登録済みです")
-		}
+	serviceId := ""
+	if service == ServiceTwitter {
+		serviceId = twitterId
+	} else if service == ServiceGoogle {
+		serviceId = googleId
 	}
+	return createUser(service, serviceId, User{
+		Name:            name,
+		Profile:         profile,
+		IconUrl:         iconUrl,
+		TwitterId:       twitterId,
+		TwitterUserName: twitterUserName,
+		GoogleId:        googleId,
+		GoogleEmail:     googleEmail,
+	}, requestIp)
+}
 
-	for i := 0; i < RetryCreateCnt; i++ {
-		// ランダムな文字列を生成して、IDにする
-		id, err = common.MakeRandomChars(idSize, twitterId)
-		if err != nil {
-			return User{}, err
-		}
-		if !ExistsUser(id) {
-			user := User{
-				UserId:           id,
-				Name:             common.ConvertHtmlSafeString(name),
-				Profile:          common.ConvertHtmlSafeString(profile),
-				IconUrl:          iconUrl,
-				AllowTwitterLink: false,
-				KeepSession:      7200,
-				TwitterId:        twitterId,
-				TwitterUserName:  twitterUserName,
-				GoogleId:         googleId,
-				GoogleEmail:      googleEmail,
-			}
-			tx := Db.Create(&user)
+// OIDCでログインしたユーザーを作成する
+func CreateOidcUser(name string, profile string, iconUrl string, oidcId string, oidcEmail string, requestIp string) (User, error) {
+	return createUser(ServiceOidc, oidcId, User{
+		Name:      name,
+		Profile:   profile,
+		IconUrl:   iconUrl,
+		OidcId:    oidcId,
+		OidcEmail: oidcEmail,
+	}, requestIp)
+}
 
-			if tx.Error != nil {
-				WriteErrorLog("", requestIp, "pusr-005", "ユーザーの作成に失敗しました", fmt.Sprintf("使用したID(%s) %s", id, tx.Error.Error()))
-				return User{}, errors.New("ユーザー作成に失敗しました")
+func createUser(service string, serviceId string, user User, requestIp string) (User, error) {
+	if serviceId != "" {
+		if f, u := ExistsUserService(service, serviceId); f {
+			if service == ServiceTwitter {
+				return u, errors.New("指定されたTwitterIDは登録済みです")
+			} else if service == ServiceGoogle {
+				return u, errors.New("指定されたGoogleIDは登録済みです")
 			}
-			return user, nil
+			return u, errors.New("指定されたOIDCのIDは登録済みです")
+		}
+	}
+
+	// 無期限の利用停止になったアカウントでは再登録できない
+	for _, s := range user.LinkedServices() {
+		if IsBannedAccount(s, user.ServiceId(s)) {
+			return User{}, ErrUserSuspended
 		}
 	}
-	return User{}, errors.New("ユーザー作成の試行回数が上限に達しました")
+
+	// ランダムな文字列を生成して、IDにする
+	id, err := makeId()
+	if err != nil {
+		return User{}, err
+	}
+	user.UserId = id
+	user.Name = common.ConvertHtmlSafeString(user.Name)
+	user.Profile = common.ConvertHtmlSafeString(user.Profile)
+	user.AllowTwitterLink = false
+	user.KeepSession = 7200
+	tx := Db.Create(&user)
+
+	if tx.Error != nil {
+		WriteErrorLog("", requestIp, "pusr-005", "ユーザーの作成に失敗しました", fmt.Sprintf("使用したID(%s) %s", id, tx.Error.Error()))
+		return User{}, errors.New("ユーザー作成に失敗しました")
+	}
+	return user, nil
 }
 
 func UpdateUser(user User, name string, profile string, iconUrl string, iconIsChanged bool, allowTwitterLink bool, keepSession int) error {
@@ -95,3 +131,74 @@ func UpdateUser(user User, name string, profile string, iconUrl string, iconIsCh
 	tx = Db.Save(&user)
 	return tx.Error
 }
+
+// ユーザーとそのユーザーが作成したデータを全て削除する
+// 保存したファイルは削除しない
+func DeleteUserData(userId string) error {
+	return Db.Transaction(func(tx *gorm.DB) error {
+		return deleteUserDataTx(tx, userId)
+	})
+}
+
+func deleteUserDataTx(tx *gorm.DB, userId string) error {
+	var user User
+	var cnt int64
+	tdb := tx.Where("user_id = ?", userId).Find(&user)
+	if tdb.Error != nil {
+		return tdb.Error
+	}
+	if tdb.Count(&cnt); cnt != 1 {
+		return errors.New("ユーザーが存在しません")
+	}
+
+	// Tier削除
+	tdb = tx.Where("user_id = ?", userId).Delete(&Tier{})
+	if tdb.Error != nil {
+		return tdb.Error
+	}
+
+	// レビュー削除
+	tdb = tx.Where("user_id = ?", userId).Delete(&Review{})
+	if tdb.Error != nil {
+		return tdb.Error
+	}
+
+	// 通知の既読状態削除
+	tdb = tx.Where("user_id = ?", userId).Delete(&NotificationRead{})
+	if tdb.Error != nil {
+		return tdb.Error
+	}
+
+	// ユーザー宛の通知削除
+	tdb = tx.Where("user_id = ?", userId).Delete(&Notification{})
+	if tdb.Error != nil {
+		return tdb.Error
+	}
+
+	// Webhook削除
+	err := DeleteWebhooksInUserTx(tx, userId)
+	if err != nil {
+		return err
+	}
+
+	// リフレッシュトークン削除
+	tdb = tx.Where("user_id = ?", userId).Delete(&RefreshToken{})
+	if tdb.Error != nil {
+		return tdb.Error
+	}
+
+	// セッション削除
+	tdb = tx.Where("user_id = ?", userId).Delete(&Session{})
+	if tdb.Error != nil {
+		return tdb.Error
+	}
+
+	// 一時セッションはUserIdを持っていないので何もしない
+
+	// ユーザー削除
+	tdb = tx.Where("user_id = ?", userId).Delete(&User{})
+	if tdb.Error != nil {
+		return tdb.Error
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	common "reviewmakerback/common"
+
+	"gorm.io/gorm"
+)
+
+// ジョブキュー導入前に登録した配信をジョブキューに移す間隔(秒)
+const WebhookDeliverSpan = 60
+
+// Webhookの送信を試行する最大回数
+const WebhookRetryMax = 8
+
+// Webhookの配信状態
+const (
+	WebhookPending   = "pending"
+	WebhookSucceeded = "succeeded"
+	WebhookFailed    = "failed"
+)
+
+// Webhookで送信するイベントの種類
+var WebhookEvents = []string{
+	"tier.created",
+	"tier.updated",
+	"tier.deleted",
+	"review.created",
+	"review.updated",
+	"review.deleted",
+}
+
+// Webhookで送信する本文
+type WebhookEventData struct {
+	Event      string `json:"event"`
+	UserId     string `json:"userId"`
+	TierId     string `json:"tierId"`
+	ReviewId   string `json:"reviewId"`
+	OccurredAt string `json:"occurredAt"`
+}
+
+func GetWebhook(wid string, selectText string) (Webhook, *gorm.DB) {
+	var webhook Webhook
+
+	tx := Db.Select(selectText).Where("webhook_id = ?", wid).Find(&webhook)
+	return webhook, tx
+}
+
+func GetWebhooks(userId string) ([]Webhook, error) {
+	var webhooks []Webhook
+	tx := Db.Where("user_id = ?", userId).Order("created_at asc").Find(&webhooks)
+	return webhooks, tx.Error
+}
+
+func ExistsWebhook(wid string) bool {
+	var cnt int64
+
+	_, tx := GetWebhook(wid, "webhook_id")
+
+	tx.Count(&cnt)
+	return cnt == 1
+}
+
+func GetWebhookCountInUser(userId string) int64 {
+	var cnt int64
+	Db.Select("webhook_id").Where("user_id = ?", userId).Find(&Webhook{}).Count(&cnt)
+	return cnt
+}
+
+func CreateWebhook(userId string, url string, secret string, events []string) (Webhook, error) {
+	// ランダムな文字列を生成して、IDにする
+	id, err := makeId()
+	if err != nil {
+		return Webhook{}, err
+	}
+	webhook := Webhook{
+		WebhookId: id,
+		UserId:    userId,
+		Url:       url,
+		Secret:    secret,
+		Events:    strings.Join(events, ","),
+		IsActive:  true,
+	}
+	tx := Db.Create(&webhook)
+	return webhook, tx.Error
+}
+
+// Webhookとその配信ログを削除する
+func DeleteWebhook(wid string) error {
+	return Db.Transaction(func(tx *gorm.DB) error {
+		tx1 := tx.Where("webhook_id = ?", wid).Delete(&WebhookDelivery{})
+		if tx1.Error != nil {
+			return tx1.Error
+		}
+		return tx.Where("webhook_id = ?", wid).Delete(&Webhook{}).Error
+	})
+}
+
+// ユーザーの全Webhookとその配信ログを削除する(トランザクション内で使用する)
+func DeleteWebhooksInUserTx(tx *gorm.DB, userId string) error {
+	tx1 := tx.Where("webhook_id in (?)", tx.Model(&Webhook{}).Select("webhook_id").Where("user_id = ?", userId)).Delete(&WebhookDelivery{})
+	if tx1.Error != nil {
+		return tx1.Error
+	}
+	return tx.Where("user_id = ?", userId).Delete(&Webhook{}).Error
+}
+
+// イベントを購読している有効なWebhookに対して、配信キューを登録する
+func EnqueueWebhookEvent(userId string, event string, tierId string, reviewId string) error {
+	var webhooks []Webhook
+	tx := Db.Select("webhook_id, events").Where("user_id = ? and is_active = ?", userId, true).Find(&webhooks)
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	now := time.Now()
+	payload, err := json.Marshal(WebhookEventData{
+		Event:      event,
+		UserId:     userId,
+		TierId:     tierId,
+		ReviewId:   reviewId,
+		OccurredAt: common.DateToString(now),
+	})
+	if err != nil {
+		return err
+	}
+
+	var deliveries []WebhookDelivery
+	for _, webhook := range webhooks {
+		if common.Contains(event, strings.Split(webhook.Events, ",")) {
+			deliveries = append(deliveries, WebhookDelivery{
+				WebhookId:     webhook.WebhookId,
+				Event:         event,
+				Payload:       string(payload),
+				Status:        WebhookPending,
+				NextAttemptAt: now,
+			})
+		}
+	}
+
+	if len(deliveries) == 0 {
+		return nil
+	}
+	// 配信とその送信を行うジョブを同時に登録する
+	return Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&deliveries).Error; err != nil {
+			return err
+		}
+		for _, delivery := range deliveries {
+			if err := enqueueWebhookDeliveryTx(tx, userId, delivery.Id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// 配信を送信するジョブを登録し、配信に記録する(トランザクション内で使用する)
+func enqueueWebhookDeliveryTx(tx *gorm.DB, userId string, deliveryId uint) error {
+	job, err := EnqueueQueueJobTx(tx, QueueKindWebhook, userId, WebhookJobPayload{DeliveryId: deliveryId}, WebhookRetryMax)
+	if err != nil {
+		return err
+	}
+	return tx.Model(&WebhookDelivery{}).Where("id = ?", deliveryId).Update("queue_job_id", job.Id).Error
+}
+
+// ジョブキュー導入前に登録した未送信の配信に、送信を行うジョブを登録する
+// 登録した数を返す
+func EnqueuePendingWebhookDeliveries(limit int) (int, error) {
+	var rows []struct {
+		Id     uint
+		UserId string
+	}
+	tx := Db.Model(&WebhookDelivery{}).Select("webhook_deliveries.id, webhooks.user_id").
+		Joins("join webhooks on webhooks.webhook_id = webhook_deliveries.webhook_id").
+		Where("webhook_deliveries.status = ? and webhook_deliveries.queue_job_id = 0", WebhookPending).
+		Order("webhook_deliveries.id asc").Limit(limit).Scan(&rows)
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+	for i, row := range rows {
+		err := Db.Transaction(func(tx *gorm.DB) error {
+			return enqueueWebhookDeliveryTx(tx, row.UserId, row.Id)
+		})
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(rows), nil
+}
+
+func GetWebhookDelivery(id uint) (WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	tx := Db.Where("id = ?", id).Limit(1).Find(&delivery)
+	if tx.Error != nil {
+		return delivery, tx.Error
+	}
+	if tx.RowsAffected != 1 {
+		return delivery, errors.New("配信が存在しません")
+	}
+	return delivery, nil
+}
+
+// 送信結果を記録する
+func UpdateWebhookDelivery(delivery WebhookDelivery) error {
+	return Db.Save(&delivery).Error
+}
+
+// 配信ログを新しい順に取得する
+func GetWebhookDeliveries(wid string, page int, pageSize int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	tx := Db.Where("webhook_id = ?", wid).Order("created_at desc, id desc").Offset(pageSize * (page - 1)).Limit(pageSize).Find(&deliveries)
+	return deliveries, tx.Error
+}
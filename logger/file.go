@@ -0,0 +1,28 @@
+package logger
+
+import (
+	"io"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// ログファイルのローテーションの設定
+type FileConfig struct {
+	Path       string // ログファイルのパス
+	MaxSizeMb  int    // ローテーションするサイズ(MB)
+	MaxBackups int    // 残しておく古いログファイルの数
+	MaxAgeDays int    // 古いログファイルを残しておく日数
+	Compress   bool   // 古いログファイルをgzipで圧縮するかどうか
+}
+
+// サイズでローテーションするログファイルを開く
+func NewFileWriter(conf FileConfig) io.WriteCloser {
+	return &lumberjack.Logger{
+		Filename:   conf.Path,
+		MaxSize:    conf.MaxSizeMb,
+		MaxBackups: conf.MaxBackups,
+		MaxAge:     conf.MaxAgeDays,
+		Compress:   conf.Compress,
+		LocalTime:  true,
+	}
+}
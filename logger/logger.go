@@ -0,0 +1,187 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ログの重要度
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// 重要度の名前(debug, info, warn, error)を解析する
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("ログの重要度'%s'は存在しません", s)
+}
+
+// ログに追加する項目
+type Fields map[string]interface{}
+
+// 1行に1つのJSONを出力するロガー
+type Logger struct {
+	mutex sync.Mutex
+	out   io.Writer
+	level Level            // 出力する最低の重要度
+	now   func() time.Time // 現在時刻(テスト用に差し替えられる)
+}
+
+func New(out io.Writer, level Level) *Logger {
+	return &Logger{out: out, level: level, now: time.Now}
+}
+
+// 時刻の取得方法を差し替える
+func (l *Logger) SetNow(now func() time.Time) {
+	l.now = now
+}
+
+// ログを出力する
+// ctxにリクエストIDが含まれていれば一緒に出力する
+func (l *Logger) Log(ctx context.Context, level Level, msg string, fields Fields) {
+	if level < l.level {
+		return
+	}
+
+	entry := make(map[string]interface{}, len(fields)+4)
+	for k, v := range fields {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		entry[k] = v
+	}
+	entry["time"] = l.now().Format(time.RFC3339Nano)
+	entry["level"] = level.String()
+	entry["msg"] = msg
+	if requestId := RequestId(ctx); requestId != "" {
+		entry["requestId"] = requestId
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(entry); err != nil {
+		buf.Reset()
+		fmt.Fprintf(&buf, "{\"level\":\"error\",\"msg\":\"ログを出力できません\",\"error\":%q}\n", err.Error())
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.out.Write(buf.Bytes())
+}
+
+func (l *Logger) Debug(ctx context.Context, msg string, fields Fields) {
+	l.Log(ctx, LevelDebug, msg, fields)
+}
+
+func (l *Logger) Info(ctx context.Context, msg string, fields Fields) {
+	l.Log(ctx, LevelInfo, msg, fields)
+}
+
+func (l *Logger) Warn(ctx context.Context, msg string, fields Fields) {
+	l.Log(ctx, LevelWarn, msg, fields)
+}
+
+func (l *Logger) Error(ctx context.Context, msg string, fields Fields) {
+	l.Log(ctx, LevelError, msg, fields)
+}
+
+// 1行ずつ指定した重要度のログとして出力するWriter
+// 標準のlogパッケージやechoのロガーの出力先に使用する
+func (l *Logger) Writer(level Level) io.Writer {
+	return &lineWriter{logger: l, level: level}
+}
+
+type lineWriter struct {
+	logger *Logger
+	level  Level
+}
+
+func (w *lineWriter) Write(b []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(b), "\n"), "\n") {
+		if line != "" {
+			w.logger.Log(context.Background(), w.level, line, nil)
+		}
+	}
+	return len(b), nil
+}
+
+// 標準のロガー(mainで設定を読み込んだ後に差し替える)
+var std = New(os.Stdout, LevelInfo)
+
+func SetDefault(l *Logger) {
+	std = l
+}
+
+func Default() *Logger {
+	return std
+}
+
+func Debug(ctx context.Context, msg string, fields Fields) {
+	std.Log(ctx, LevelDebug, msg, fields)
+}
+
+func Info(ctx context.Context, msg string, fields Fields) {
+	std.Log(ctx, LevelInfo, msg, fields)
+}
+
+func Warn(ctx context.Context, msg string, fields Fields) {
+	std.Log(ctx, LevelWarn, msg, fields)
+}
+
+func Error(ctx context.Context, msg string, fields Fields) {
+	std.Log(ctx, LevelError, msg, fields)
+}
+
+type requestIdKey struct{}
+
+// リクエストIDを含むコンテキストを返す
+func WithRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, requestId)
+}
+
+// コンテキストに含まれるリクエストID(無ければ空文字列)
+func RequestId(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	requestId, _ := ctx.Value(requestIdKey{}).(string)
+	return requestId
+}
+
+// 新しいリクエストIDを生成する(32文字の16進数)
+func NewRequestId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// 乱数が取得できない場合でも追跡できるように時刻から作成する
+		return fmt.Sprintf("%032x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,88 @@
+package mail
+
+import (
+	"bytes"
+	_ "embed"
+	htmltemplate "html/template"
+	"strconv"
+	texttemplate "text/template"
+)
+
+//go:embed templates/digest.txt
+var digestText string
+
+//go:embed templates/digest.html
+var digestHtml string
+
+var digestTextTemplate = texttemplate.Must(texttemplate.New("digest.txt").Parse(digestText))
+var digestHtmlTemplate = htmltemplate.Must(htmltemplate.New("digest.html").Parse(digestHtml))
+
+// ダイジェストに載せる通知
+type DigestItem struct {
+	Content     string
+	Url         string
+	IsImportant bool
+	CreatedAt   string
+}
+
+// ダイジェストの作成に必要な情報
+type DigestData struct {
+	UserName       string
+	Frequency      string // daily, weekly
+	Items          []DigestItem
+	ImportantCount int
+	UnsubscribeUrl string
+}
+
+// 通知ダイジェストのメールを作成する
+func BuildDigestMessage(from string, to string, data DigestData) ([]byte, error) {
+	var text bytes.Buffer
+	if err := digestTextTemplate.Execute(&text, data); err != nil {
+		return nil, err
+	}
+
+	var html bytes.Buffer
+	if err := digestHtmlTemplate.Execute(&html, data); err != nil {
+		return nil, err
+	}
+
+	subject := "【くどくどTier】未読のお知らせが" + strconv.Itoa(len(data.Items)) + "件あります"
+	if data.ImportantCount > 0 {
+		subject = "【くどくどTier】重要なお知らせを含む未読のお知らせが" + strconv.Itoa(len(data.Items)) + "件あります"
+	}
+
+	return BuildMessage(from, to, subject, map[string]string{
+		"List-Unsubscribe": "<" + data.UnsubscribeUrl + ">",
+	}, text.String(), html.String())
+}
+
+//go:embed templates/digest_confirm.txt
+var digestConfirmText string
+
+//go:embed templates/digest_confirm.html
+var digestConfirmHtml string
+
+var digestConfirmTextTemplate = texttemplate.Must(texttemplate.New("digest_confirm.txt").Parse(digestConfirmText))
+var digestConfirmHtmlTemplate = htmltemplate.Must(htmltemplate.New("digest_confirm.html").Parse(digestConfirmHtml))
+
+// 送信先メールアドレスの確認メールの作成に必要な情報
+type DigestConfirmData struct {
+	UserName    string
+	ConfirmUrl  string
+	ExpireHours int
+}
+
+// 通知ダイジェストの送信先メールアドレスの確認メールを作成する
+func BuildDigestConfirmMessage(from string, to string, data DigestConfirmData) ([]byte, error) {
+	var text bytes.Buffer
+	if err := digestConfirmTextTemplate.Execute(&text, data); err != nil {
+		return nil, err
+	}
+
+	var html bytes.Buffer
+	if err := digestConfirmHtmlTemplate.Execute(&html, data); err != nil {
+		return nil, err
+	}
+
+	return BuildMessage(from, to, "【くどくどTier】お知らせダイジェストの送信先の確認", map[string]string{}, text.String(), html.String())
+}
@@ -0,0 +1,95 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"time"
+
+	"reviewmakerback/config"
+)
+
+// メールの送信手段
+type Transport interface {
+	Send(from string, to []string, msg []byte) error
+}
+
+// SMTPによるメールの送信手段
+type SmtpTransport struct {
+	Host     string
+	Port     string
+	User     string // 空文字列なら認証しない
+	Password string
+}
+
+func (t SmtpTransport) Send(from string, to []string, msg []byte) error {
+	var auth smtp.Auth
+	if t.User != "" {
+		auth = smtp.PlainAuth("", t.User, t.Password, t.Host)
+	}
+	return smtp.SendMail(t.Host+":"+t.Port, auth, from, to, msg)
+}
+
+// 設定からSMTPの送信手段を作成する
+// ホストが設定されていない場合はfalseを返す
+func NewSmtpTransport(conf config.SmtpConfig) (SmtpTransport, bool) {
+	if conf.Host == "" {
+		return SmtpTransport{}, false
+	}
+	return SmtpTransport{
+		Host:     conf.Host,
+		Port:     strconv.Itoa(conf.Port),
+		User:     conf.User,
+		Password: conf.Password,
+	}, true
+}
+
+// テキストとHTMLの両方を含むメールを作成する
+// headersには件名や宛先以外に追加するヘッダを指定する
+func BuildMessage(from string, to string, subject string, headers map[string]string, text string, html string) ([]byte, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	for k, v := range headers {
+		fmt.Fprintf(&buf, "%s: %s\r\n", k, v)
+	}
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+
+	for _, part := range []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=UTF-8", text},
+		{"text/html; charset=UTF-8", html},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qw := quotedprintable.NewWriter(w)
+		if _, err = qw.Write([]byte(part.body)); err != nil {
+			return nil, err
+		}
+		if err = qw.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -1,84 +1,244 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
 
+	"reviewmakerback/common"
+	"reviewmakerback/config"
 	db "reviewmakerback/db"
+	"reviewmakerback/logger"
+	"reviewmakerback/oidc"
 	"reviewmakerback/ontime"
+	"reviewmakerback/queue"
+	"reviewmakerback/ratelimit"
 	rest "reviewmakerback/rest"
+	"reviewmakerback/tracing"
 )
 
 func main() {
-	// 環境変数の読み込み
-	CheckEnvs()
+	// 設定の読み込み
+	conf := loadConfig()
 
 	// ログ出力場所の指定
-	loggingSettings("echo.log")
+	loggingSettings(conf.Log)
+
+	// 引数でコマンドを指定した場合はサーバーを起動せずに実行する
+	// 例: reviewmakerback check-storage -delete
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(conf, os.Args[1:]))
+	}
+
+	// トレースの出力先を設定する
+	shutdownTracing, err := tracing.Setup(tracing.Config{
+		Exporter:    conf.Tracing.Exporter,
+		Endpoint:    conf.Tracing.Endpoint,
+		Headers:     conf.Tracing.HeaderMap(),
+		SampleRatio: conf.Tracing.SampleRatio,
+		ServiceName: conf.Tracing.ServiceName,
+	})
+	if err != nil {
+		panic(fmt.Sprintf("トレースを開始できません: %s", err.Error()))
+	}
+	// 連携サービス・OIDCの認証サーバーとの通信をトレースに含める
+	oauthClient := tracing.NewClient(10 * time.Second)
+	common.SetOAuthClient(oauthClient)
 
 	e := echo.New()
+	e.HideBanner = true
+	e.Logger.SetOutput(logger.Default().Writer(logger.LevelWarn))
 
 	// データベース接続・マイグレート
-	db.InitDb()
+	db.InitDb(conf)
 
 	// 定期処理を登録
-	_, stop := ontime.Start()
+	_, stop, err := ontime.Start(conf)
+	if err != nil {
+		panic(fmt.Sprintf("定期処理を開始できません: %s", err.Error()))
+	}
+
+	// リクエストIDを割り当ててアクセスログを出力する
+	e.Use(rest.RequestId())
+
+	// リクエストごとにトレースのスパンを記録する
+	e.Use(rest.Tracing())
 
 	// ミドルウェアからCORSの使用を設定する
 	// これを設定しないと、同オリジンからのアクセスが拒否される
-	e.Use(middleware.CORS())
+	// 独自のレスポンスヘッダーはフロントエンドから読めるように公開する
+	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+		ExposeHeaders: []string{
+			"X-Pending-Jobs",
+			"X-Request-ID",
+			"X-RateLimit-Limit",
+			"X-RateLimit-Remaining",
+			"X-RateLimit-Reset",
+			"Retry-After",
+		},
+	}))
+
+	// リクエスト数と処理時間を記録する
+	e.Use(rest.Metrics())
+
+	// ユーザーIDとIPアドレスごとにリクエスト数を制限する
+	e.Use(rest.RateLimit(newRateLimiter(conf.RateLimit)))
+
+	// 設定されたOIDCのプロバイダーでのログインを有効にする
+	rest.SetOidcProviders(newOidcProviders(conf.Oidc, oauthClient))
 
+	rest.Configure(conf)
 	rest.Route(e)
 
+	// 画像の変換・エクスポート・Webhookの配信を行うジョブキューを開始する
+	stopQueue := startQueue(conf.Queue)
+
 	// リスナーポート番号
-	e.Logger.Fatal(e.Start(":" + os.Getenv("BACK_AP_PORT")))
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- e.Start(":" + strconv.Itoa(conf.Server.Port))
+	}()
+
+	// 終了シグナルを受け取るか、サーバーが停止するまで待つ
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case sig := <-signals:
+		shutdown(e, stop, stopQueue, shutdownTracing, time.Duration(conf.Server.ShutdownTimeout)*time.Second, sig)
+	case err := <-serverErr:
+		e.Logger.Error(err)
+		stop()
+		stopQueue()
+		shutdownTracing(context.Background())
+		db.WriteErrorLog("none", "none", "none", "stop", "システムが予期せず終了しました "+err.Error())
+		db.CloseDb()
+		os.Exit(1)
+	}
+}
+
+// 処理中のリクエストと定期処理・ジョブの終了を待ってからシステムを終了する
+func shutdown(e *echo.Echo, stop func(), stopQueue func(), shutdownTracing func(context.Context) error, timeout time.Duration, sig os.Signal) {
+	db.WriteOperationLog("none", "none", "stop", fmt.Sprintf("終了を開始します signal=%s", sig))
 
+	// 新しいリクエストの受付を止め、処理中のリクエスト(画像の保存等)が終わるまで待つ
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := e.Shutdown(ctx); err != nil {
+		db.WriteErrorLog("none", "none", "none", "stop", "処理中のリクエストが時間内に終了しませんでした "+err.Error())
+	}
+
+	// 実行中の定期処理が終わるまで待つ
 	stop()
-	db.WriteErrorLog("none", "none", "none", "stop", "システムが予期せず終了しました")
+
+	// 実行中のジョブが終わるまで待つ(中断したジョブは次の起動時に実行し直す)
+	stopQueue()
+
+	// 未送信のスパンを送信する
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error(context.Background(), "トレースの送信に失敗しました", logger.Fields{"error": err})
+	}
+
+	db.WriteOperationLog("none", "none", "stop", "システムを終了しました")
+	if err := db.CloseDb(); err != nil {
+		logger.Error(context.Background(), "データベースの切断に失敗しました", logger.Fields{"error": err})
+	}
 }
 
-// 環境変数の必須チェック
-func CheckEnvs() {
-	checkEnv("BACK_DB_HOST")
-	checkEnv("BACK_DB_PORT")
-	checkEnv("BACK_DB_NAME")
-	checkEnv("BACK_DB_USER")
-	checkEnv("BACK_DB_PASSWORD")
-	checkEnv("BACK_DB_TIMEZONE")
-	checkEnv("BACK_TW_CLIENT_ID")
-	checkEnv("BACK_TW_CLIENT_SEC")
-	checkEnv("BACK_TW_REDIRECT_URI")
-	checkEnv("BACK_TW1_APIKEY")
-	checkEnv("BACK_TW1_APISECRET")
-	checkEnv("BACK_TW1_ACCESSTOKEN")
-	checkEnv("BACK_TW1_ACCESSSEC")
-	checkEnv("BACK_AP_FILE_PATH")
-	checkEnv("BACK_AP_PORT")
-	// 投稿可能な最小間隔
-	checkEnv("BACK_AP_POST_SPAN")
-	var err error
-	if db.PostSpanMin, err = strconv.Atoi(os.Getenv("BACK_AP_POST_SPAN")); err != nil {
-		panic(fmt.Sprintf("最小投稿間隔が読み込めません: %s", err.Error()))
-	}
-	checkEnv("BACK_GG_CONFJSON")
+// 設定を読み込む
+// BACK_CONFIG_FILEに設定ファイル(YAML/TOML)のパスを指定する(省略時は環境変数のみ)
+// 設定ファイルの値は環境変数で上書きできる
+func loadConfig() config.Config {
+	conf, err := config.Load(os.Getenv("BACK_CONFIG_FILE"), os.LookupEnv)
+	if err != nil {
+		panic(err.Error())
+	}
+	// セッション管理は環境変数から連携サービスの設定を読み込むため、設定ファイルの値を反映する
+	if err = conf.ExportEnv(); err != nil {
+		panic(fmt.Sprintf("環境変数を設定できません: %s", err.Error()))
+	}
+	return conf
 }
 
-func checkEnv(name string) {
-	if os.Getenv(name) == "" {
-		panic(fmt.Sprintf("環境変数'%s'がありません", name))
+// ジョブキューのワーカーを開始する
+// 返り値の関数はワーカーを停止し、実行中のジョブが終わるまで待つ
+func startQueue(conf config.QueueConfig) func() {
+	q := queue.New(db.NewQueueStore(), conf.Workers, time.Duration(conf.PollInterval)*time.Second)
+	rest.RegisterQueueJobs(q)
+	ontime.RegisterWebhookJob(q)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wait := q.Start(ctx)
+	queue.SetCurrent(q)
+	return func() {
+		cancel()
+		wait()
+		queue.SetCurrent(nil)
 	}
 }
 
-func loggingSettings(filename string) {
+// レート制限の設定を読み込む
+// 設定ファイル(JSON)のパスを省略した場合は既定の設定
+func newRateLimiter(conf config.RateLimitConfig) *ratelimit.Limiter {
+	limitConfig := ratelimit.DefaultConfig
+	if conf.Conf != "" {
+		var err error
+		limitConfig, err = ratelimit.LoadConfig(conf.Conf)
+		if err != nil {
+			panic(fmt.Sprintf("レート制限の設定が読み込めません: %s", err.Error()))
+		}
+	}
+
+	var store ratelimit.Store = ratelimit.NewMemoryStore()
+	if limitConfig.Store == ratelimit.StorePostgres {
+		store = db.NewRateLimitStore()
+	}
+	return &ratelimit.Limiter{Config: limitConfig, Store: store}
+}
+
+// OIDCのプロバイダーの設定を読み込む
+// 設定ファイル(JSON)のパスを省略した場合はOIDCを使用しない
+func newOidcProviders(conf config.OidcConfig, client *http.Client) map[string]*oidc.Provider {
+	if conf.Conf == "" {
+		return map[string]*oidc.Provider{}
+	}
+	oidcConfig, err := oidc.LoadConfig(conf.Conf)
+	if err != nil {
+		panic(fmt.Sprintf("OIDCの設定が読み込めません: %s", err.Error()))
+	}
+	return oidc.NewProviders(oidcConfig, client)
+}
+
+// 標準出力とログファイル(サイズでローテーションする)にJSON形式でログを出力する
+func loggingSettings(conf config.LogConfig) {
+	level, err := logger.ParseLevel(conf.Level)
+	if err != nil {
+		panic(err.Error())
+	}
+
 	// ログ出力先を指定
-	logfile, _ := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
-	multiLogFile := io.MultiWriter(os.Stdout, logfile)
-	log.SetFlags(log.Ldate | log.Ltime | log.Llongfile)
-	log.SetOutput(multiLogFile)
+	var out io.Writer = os.Stdout
+	if conf.File != "" {
+		out = io.MultiWriter(os.Stdout, logger.NewFileWriter(logger.FileConfig{
+			Path:       conf.File,
+			MaxSizeMb:  conf.MaxSizeMb,
+			MaxBackups: conf.MaxBackups,
+			MaxAgeDays: conf.MaxAgeDays,
+			Compress:   conf.Compress,
+		}))
+	}
+	logger.SetDefault(logger.New(out, level))
+
+	// 標準のlogパッケージの出力もJSON形式にする
+	log.SetFlags(0)
+	log.SetOutput(logger.Default().Writer(logger.LevelInfo))
 }
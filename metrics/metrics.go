@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// メトリクス名の接頭辞
+const namespace = "reviewmaker"
+
+// メトリクスの登録先(/metricsで公開する)
+var Registry = prometheus.NewRegistry()
+
+var (
+	// HTTPリクエスト数(ルートとステータスコードごと)
+	HttpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests by route and status.",
+	}, []string{"method", "route", "status"})
+
+	// HTTPリクエストの処理時間(秒)
+	HttpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	// エラーレスポンスの数(エラーコードの機能部分ごと)
+	ErrorCodes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_error_codes_total",
+		Help:      "Number of error responses by error code prefix.",
+	}, []string{"prefix"})
+
+	// データベースのクエリの実行時間(秒)
+	DbQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "GORM query latency by operation and result.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation", "result"})
+
+	// 保存した画像のサイズ(バイト)
+	ImageBytes = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "image_saved_bytes",
+		Help:      "Size of images written by savePicture.",
+		Buckets:   prometheus.ExponentialBuckets(1024, 2, 12),
+	})
+
+	// 画像の保存にかかった時間(秒)
+	ImageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "image_save_duration_seconds",
+		Help:      "savePicture latency by result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"result"})
+
+	// セッション整理で削除した行数(テーブルごと)
+	ArrangedRows = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "arrange_session_deleted_rows_total",
+		Help:      "Rows deleted by ArrangeSession by table.",
+	}, []string{"table"})
+
+	// 定期処理の実行回数(処理・結果ごと)
+	JobRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "job_runs_total",
+		Help:      "Scheduled job runs by job and result (succeeded, failed, skipped).",
+	}, []string{"job", "result"})
+
+	// 定期処理の実行にかかった時間(秒)
+	JobDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "job_duration_seconds",
+		Help:      "Scheduled job latency by job.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"job"})
+
+	// ジョブキューで実行したジョブの数(種類・結果ごと)
+	QueueJobs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "queue_jobs_total",
+		Help:      "Queue job runs by kind and result (succeeded, retried, dead, released).",
+	}, []string{"kind", "result"})
+
+	// ジョブキューのジョブの実行にかかった時間(秒)
+	QueueDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "queue_job_duration_seconds",
+		Help:      "Queue job latency by kind.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"kind"})
+
+	// 最後の確認で見つかった保存先の不整合の数(参照されていないファイル・存在しないファイルへの参照)
+	StorageProblems = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "storage_problems",
+		Help:      "Orphaned files and dangling references found by the last storage check.",
+	}, []string{"type"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HttpRequests,
+		HttpDuration,
+		ErrorCodes,
+		DbQueryDuration,
+		ImageBytes,
+		ImageDuration,
+		ArrangedRows,
+		JobRuns,
+		JobDuration,
+		QueueJobs,
+		QueueDuration,
+		StorageProblems,
+	)
+}
+
+// エラーコード(xxxx-nnn[-ee])から機能部分を取り出す
+func ErrorCodePrefix(code string) string {
+	if index := strings.Index(code, "-"); index > 0 {
+		return code[:index]
+	}
+	if code == "" {
+		return "none"
+	}
+	return code
+}
@@ -0,0 +1,160 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// JWKS(公開鍵セット)
+type JsonWebKeySet struct {
+	Keys []JsonWebKey `json:"keys"`
+}
+
+type JsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`   // RSA
+	E   string `json:"e"`   // RSA
+	Crv string `json:"crv"` // EC
+	X   string `json:"x"`   // EC
+	Y   string `json:"y"`   // EC
+}
+
+// 署名に使用できる公開鍵を鍵IDごとに返す
+// 暗号化用の鍵や未対応の種類の鍵は無視する
+func (s JsonWebKeySet) PublicKeys() (map[string]interface{}, error) {
+	keys := map[string]interface{}{}
+	for _, k := range s.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var key interface{}
+		var err error
+		switch k.Kty {
+		case "RSA":
+			key, err = k.rsaPublicKey()
+		case "EC":
+			key, err = k.ecdsaPublicKey()
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("鍵'%s'が読み込めません: %s", k.Kid, err.Error())
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("値がありません")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k JsonWebKey) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := decodeBigInt(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := decodeBigInt(k.E)
+	if err != nil {
+		return nil, err
+	}
+	if !e.IsInt64() || e.Int64() > 1<<31-1 {
+		return nil, errors.New("指数が大きすぎます")
+	}
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+func (k JsonWebKey) ecdsaPublicKey() (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch k.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("曲線'%s'には対応していません", k.Crv)
+	}
+	x, err := decodeBigInt(k.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := decodeBigInt(k.Y)
+	if err != nil {
+		return nil, err
+	}
+	if !curve.IsOnCurve(x, y) {
+		return nil, errors.New("公開鍵が曲線上にありません")
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+// 署名アルゴリズムごとのハッシュ関数
+// 共通鍵(HS256等)と署名なし(none)は受け付けない
+var signingHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"PS256": crypto.SHA256,
+	"PS384": crypto.SHA384,
+	"PS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+func verifySignature(alg string, key interface{}, signed []byte, signature []byte) error {
+	hash, ok := signingHashes[alg]
+	if !ok {
+		return fmt.Errorf("署名アルゴリズム'%s'には対応していません", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if alg[0] == 'R' {
+			if rsa.VerifyPKCS1v15(pub, hash, digest, signature) != nil {
+				return errors.New("IDトークンの署名が一致しません")
+			}
+			return nil
+		} else if alg[0] == 'P' {
+			if rsa.VerifyPSS(pub, hash, digest, signature, nil) != nil {
+				return errors.New("IDトークンの署名が一致しません")
+			}
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		if alg[0] == 'E' {
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			if len(signature) != size*2 {
+				return errors.New("IDトークンの署名が一致しません")
+			}
+			r := new(big.Int).SetBytes(signature[:size])
+			s := new(big.Int).SetBytes(signature[size:])
+			if !ecdsa.Verify(pub, digest, r, s) {
+				return errors.New("IDトークンの署名が一致しません")
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("署名アルゴリズム'%s'と鍵の種類が一致しません", alg)
+}
@@ -0,0 +1,377 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// OpenID Connectのプロバイダー(Keycloak等)の設定
+type ProviderConfig struct {
+	Name         string   `json:"name"`         // URLに使用するプロバイダー名(英数字)
+	Issuer       string   `json:"issuer"`       // 発行者のURL(ディスカバリーに使用する)
+	ClientId     string   `json:"clientId"`     // クライアントID
+	ClientSecret string   `json:"clientSecret"` // クライアントシークレット(公開クライアントなら空文字列)
+	RedirectUrl  string   `json:"redirectUrl"`  // 認証後にリダイレクトするフロントエンドのURL
+	Scopes       []string `json:"scopes"`       // 要求するスコープ(省略時はopenid, email, profile)
+}
+
+type Config struct {
+	Providers []ProviderConfig `json:"providers"`
+}
+
+// ディスカバリーで取得するプロバイダーのメタデータ
+type Discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+// IDトークンのクレーム
+type Claims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          Audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp"`
+	Expiry            int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+	Picture           string   `json:"picture"`
+}
+
+// audクレームは文字列と配列のどちらでもよい
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		*a = Audience{s}
+		return nil
+	}
+	var arr []string
+	if err := json.Unmarshal(b, &arr); err != nil {
+		return err
+	}
+	*a = arr
+	return nil
+}
+
+func (a Audience) Contains(v string) bool {
+	for _, s := range a {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// IDトークンの有効期限等を検証する際に許容する時計のずれ
+const ClockSkew = 60 * time.Second
+
+// 未知の鍵IDを受け取った際に、鍵セットを再取得する最小間隔
+var JwksRefreshSpan = 60 * time.Second
+
+// 設定ファイル(JSON)を読み込む
+//
+//	{"providers": [
+//	  {"name": "keycloak", "issuer": "https://sso.example.com/realms/main", "clientId": "reviewmaker",
+//	   "clientSecret": "...", "redirectUrl": "https://example.com/auth/oidc/keycloak"}
+//	]}
+func LoadConfig(path string) (Config, error) {
+	var config Config
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	err = json.Unmarshal(b, &config)
+	if err != nil {
+		return config, err
+	}
+	return config, config.Validate()
+}
+
+// 設定が使用可能かチェックする
+func (c Config) Validate() error {
+	names := map[string]bool{}
+	for _, p := range c.Providers {
+		if p.Name == "" || strings.Trim(p.Name, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789") != "" {
+			return fmt.Errorf("プロバイダー名'%s'は使用できません", p.Name)
+		}
+		if names[p.Name] {
+			return fmt.Errorf("プロバイダー'%s'が重複しています", p.Name)
+		}
+		names[p.Name] = true
+		if p.Issuer == "" || p.ClientId == "" || p.RedirectUrl == "" {
+			return fmt.Errorf("プロバイダー'%s'の設定が不足しています", p.Name)
+		}
+	}
+	return nil
+}
+
+// PKCEのコード検証用文字列と、state, nonceに使用するランダムな文字列を生成する
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// PKCEのコードチャレンジ(S256)を求める
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+type Provider struct {
+	Config ProviderConfig
+	Client *http.Client
+
+	mu            sync.Mutex
+	discovery     *Discovery
+	keys          map[string]interface{}
+	keysFetchedAt time.Time
+}
+
+func NewProvider(config ProviderConfig, client *http.Client) *Provider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Provider{Config: config, Client: client}
+}
+
+// 設定に含まれる全てのプロバイダーを作成する
+func NewProviders(config Config, client *http.Client) map[string]*Provider {
+	providers := map[string]*Provider{}
+	for _, p := range config.Providers {
+		providers[p.Name] = NewProvider(p, client)
+	}
+	return providers
+}
+
+func (p *Provider) getJson(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("'%s'の取得に失敗しました: %d", url, res.StatusCode)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// ディスカバリーでメタデータを取得する(取得済みならそれを返す)
+func (p *Provider) Discover(ctx context.Context) (Discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return *p.discovery, nil
+	}
+
+	var d Discovery
+	err := p.getJson(ctx, strings.TrimSuffix(p.Config.Issuer, "/")+"/.well-known/openid-configuration", &d)
+	if err != nil {
+		return d, err
+	}
+	// なりすましを防ぐため、発行者は設定と完全に一致しなければならない
+	if d.Issuer != p.Config.Issuer {
+		return d, fmt.Errorf("発行者'%s'が設定と一致しません", d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JwksUri == "" {
+		return d, errors.New("メタデータに必要なエンドポイントがありません")
+	}
+	p.discovery = &d
+	return d, nil
+}
+
+func (p *Provider) oauth2Config(d Discovery) oauth2.Config {
+	scopes := p.Config.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+	return oauth2.Config{
+		ClientID:     p.Config.ClientId,
+		ClientSecret: p.Config.ClientSecret,
+		RedirectURL:  p.Config.RedirectUrl,
+		Scopes:       scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  d.AuthorizationEndpoint,
+			TokenURL: d.TokenEndpoint,
+		},
+	}
+}
+
+// ユーザーが認証するためのURLを作成する
+func (p *Provider) AuthCodeURL(ctx context.Context, state string, nonce string, verifier string) (string, error) {
+	d, err := p.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	config := p.oauth2Config(d)
+	return config.AuthCodeURL(state,
+		oauth2.SetAuthURLParam("nonce", nonce),
+		oauth2.SetAuthURLParam("code_challenge", CodeChallenge(verifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	), nil
+}
+
+// 認証コードをトークンに交換し、IDトークンを検証してクレームを返す
+func (p *Provider) Exchange(ctx context.Context, code string, verifier string, nonce string) (Claims, error) {
+	d, err := p.Discover(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+	config := p.oauth2Config(d)
+	token, err := config.Exchange(context.WithValue(ctx, oauth2.HTTPClient, p.Client), code,
+		oauth2.SetAuthURLParam("code_verifier", verifier),
+	)
+	if err != nil {
+		// 応答の本文にはトークンが含まれる場合があるため、エラーにはステータスのみを含める
+		var retrieveErr *oauth2.RetrieveError
+		if errors.As(err, &retrieveErr) {
+			return Claims{}, fmt.Errorf("トークンの取得に失敗しました: %s", retrieveErr.Response.Status)
+		}
+		return Claims{}, err
+	}
+	rawIdToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIdToken == "" {
+		return Claims{}, errors.New("IDトークンがありません")
+	}
+	return p.Verify(ctx, rawIdToken, nonce, time.Now())
+}
+
+// IDトークンの署名とクレームを検証する
+func (p *Provider) Verify(ctx context.Context, rawIdToken string, nonce string, now time.Time) (Claims, error) {
+	var claims Claims
+	d, err := p.Discover(ctx)
+	if err != nil {
+		return claims, err
+	}
+
+	parts := strings.Split(rawIdToken, ".")
+	if len(parts) != 3 {
+		return claims, errors.New("IDトークンの形式が異常です")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err = decodeSegment(parts[0], &header); err != nil {
+		return claims, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, errors.New("IDトークンの署名が異常です")
+	}
+
+	key, err := p.key(ctx, d, header.Kid)
+	if err != nil {
+		return claims, err
+	}
+	if err = verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return claims, err
+	}
+
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return claims, err
+	}
+	return claims, p.validateClaims(claims, d, nonce, now)
+}
+
+func (p *Provider) validateClaims(claims Claims, d Discovery, nonce string, now time.Time) error {
+	if claims.Issuer != d.Issuer {
+		return errors.New("IDトークンの発行者が一致しません")
+	}
+	if claims.Subject == "" {
+		return errors.New("IDトークンにユーザーの識別子がありません")
+	}
+	if !claims.Audience.Contains(p.Config.ClientId) {
+		return errors.New("IDトークンの対象者が一致しません")
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.Config.ClientId {
+		return errors.New("IDトークンの認可された対象者が一致しません")
+	}
+	if claims.Expiry == 0 || now.Add(-ClockSkew).After(time.Unix(claims.Expiry, 0)) {
+		return errors.New("IDトークンの有効期限が切れています")
+	}
+	if claims.IssuedAt != 0 && now.Add(ClockSkew).Before(time.Unix(claims.IssuedAt, 0)) {
+		return errors.New("IDトークンの発行日時が異常です")
+	}
+	if nonce == "" || claims.Nonce != nonce {
+		return errors.New("IDトークンのnonceが一致しません")
+	}
+	return nil
+}
+
+// 鍵IDに対応する公開鍵を取得する
+// 鍵のローテーションに対応するため、未知の鍵IDであれば鍵セットを再取得する
+func (p *Provider) key(ctx context.Context, d Discovery, kid string) (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.findKey(kid); ok {
+		return key, nil
+	}
+	if p.keys != nil && time.Since(p.keysFetchedAt) < JwksRefreshSpan {
+		return nil, fmt.Errorf("鍵'%s'が見つかりません", kid)
+	}
+
+	var set JsonWebKeySet
+	if err := p.getJson(ctx, d.JwksUri, &set); err != nil {
+		return nil, err
+	}
+	keys, err := set.PublicKeys()
+	if err != nil {
+		return nil, err
+	}
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+
+	if key, ok := p.findKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("鍵'%s'が見つかりません", kid)
+}
+
+// 鍵IDが省略されている場合は、鍵が一つだけの時に限りその鍵を使う
+func (p *Provider) findKey(kid string) (interface{}, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return errors.New("IDトークンの形式が異常です")
+	}
+	if err = json.Unmarshal(b, v); err != nil {
+		return errors.New("IDトークンの形式が異常です")
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package ontime
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	common "reviewmakerback/common"
+	"reviewmakerback/config"
+	db "reviewmakerback/db"
+	"reviewmakerback/mail"
+)
+
+// ダイジェスト一通に載せる通知の最大数
+const digestItemsMax = 50
+
+// 送信時期に達したユーザーに通知ダイジェストを送信する
+// 制限時間を過ぎた場合は残りのユーザーを次の周回で処理する
+func SendDigests(ctx context.Context, transport mail.Transport, conf config.Config, now time.Time) error {
+	users, err := db.GetDigestTargetUsers(now)
+	if err != nil {
+		db.WriteErrorLog("none", "none", "sdgs-001", "通知ダイジェストの送信対象が取得できません", err.Error())
+		return err
+	}
+
+	for _, user := range users {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		to := user.DigestEmail
+		if to == "" {
+			to = user.GoogleEmail
+		}
+		if to == "" || user.DigestToken == "" {
+			continue
+		}
+
+		// 前回の送信以降、または初回なら送信間隔分さかのぼった通知を対象とする
+		since := user.LastDigestAt
+		if since.IsZero() {
+			since = now.Add(-db.DigestInterval(user.DigestFrequency))
+		}
+
+		notifications, tx := db.GetUnreadNotificationsSince(user.UserId, since, digestItemsMax)
+		if tx.Error != nil {
+			db.WriteErrorLog(user.UserId, "none", "sdgs-002", "通知ダイジェストの通知が取得できません", tx.Error.Error())
+			continue
+		}
+
+		if len(notifications) > 0 {
+			err = sendDigest(transport, conf, user, to, notifications)
+			if err != nil {
+				// 送信日時を更新せず、次の周回で再送する
+				db.WriteErrorLog(user.UserId, "none", "sdgs-003", "通知ダイジェストの送信に失敗しました", err.Error())
+				continue
+			}
+		}
+
+		err = db.UpdateLastDigestAt(user.UserId, now)
+		if err != nil {
+			db.WriteErrorLog(user.UserId, "none", "sdgs-004", "通知ダイジェストの送信日時を記録できません", err.Error())
+		}
+	}
+	return nil
+}
+
+func sendDigest(transport mail.Transport, conf config.Config, user db.User, to string, notifications []db.NotificationJoinRead) error {
+	data := mail.DigestData{
+		UserName:       user.Name,
+		Frequency:      user.DigestFrequency,
+		Items:          make([]mail.DigestItem, len(notifications)),
+		UnsubscribeUrl: fmt.Sprintf("%s/digest/unsubscribe/%s", conf.Server.Url, user.DigestToken),
+	}
+	for i, n := range notifications {
+		data.Items[i] = mail.DigestItem{
+			Content:     n.Content,
+			Url:         n.Url,
+			IsImportant: n.IsImportant,
+			CreatedAt:   common.DateToString(n.CreatedAt),
+		}
+		if n.IsImportant {
+			data.ImportantCount++
+		}
+	}
+
+	from := conf.Smtp.From
+	msg, err := mail.BuildDigestMessage(from, to, data)
+	if err != nil {
+		return err
+	}
+	return transport.Send(from, []string{to}, msg)
+}
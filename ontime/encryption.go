@@ -0,0 +1,16 @@
+package ontime
+
+import (
+	"context"
+
+	db "reviewmakerback/db"
+)
+
+// 古い鍵や旧形式で暗号化されている値を現在の鍵で暗号化し直す
+func RotateEncryption(ctx context.Context) error {
+	_, err := db.RotateEncryptedColumns()
+	if err != nil {
+		db.WriteErrorLog("none", "none", "rotc-002", "保存済みの値の再暗号化に失敗しました", err.Error())
+	}
+	return err
+}
@@ -0,0 +1,63 @@
+package ontime
+
+import (
+	"sync"
+	"time"
+)
+
+// 定期処理が止まっていると判断するまでの猶予(予定の実行日時に加える時間)
+const jobStaleMargin = time.Minute
+
+// 定期処理の実行状況
+type JobStatus struct {
+	Name      string        // 定期処理の名前
+	Schedule  string        // スケジュールの表記
+	Timeout   time.Duration // 一回の実行の制限時間
+	LastRun   time.Time     // 最後に実行を開始した日時
+	Duration  time.Duration // 最後の実行にかかった時間
+	LastError string        // 最後の実行で発生したエラー(成功した場合は空)
+	NextRun   time.Time     // 次の実行予定日時
+	Running   bool          // 実行中かどうか
+	Skipped   bool          // 他のインスタンスが担当しているため、最後の予定日時に実行しなかったかどうか
+	Stale     bool          // 予定の実行日時を大きく過ぎても実行されていないかどうか
+}
+
+// 予定の実行日時と猶予を過ぎても実行されていないか、実行中の処理が制限時間と猶予を過ぎても終わらないかどうか
+func (status JobStatus) isStale(now time.Time) bool {
+	if status.Running {
+		return status.Timeout > 0 && now.Sub(status.LastRun) > status.Timeout+jobStaleMargin
+	}
+	return !status.NextRun.IsZero() && now.Sub(status.NextRun) > jobStaleMargin
+}
+
+var (
+	currentMutex sync.Mutex
+	current      *Scheduler
+)
+
+func setCurrent(s *Scheduler) {
+	currentMutex.Lock()
+	defer currentMutex.Unlock()
+	current = s
+}
+
+// 実行中の定期処理の状況を名前順に返す(定期処理が開始していなければ空)
+func Statuses(now time.Time) []JobStatus {
+	currentMutex.Lock()
+	s := current
+	currentMutex.Unlock()
+
+	if s == nil {
+		return []JobStatus{}
+	}
+	return s.Statuses(now)
+}
+
+// このインスタンスが定期処理の実行を担当しているかどうか
+func IsLeader() bool {
+	currentMutex.Lock()
+	s := current
+	currentMutex.Unlock()
+
+	return s != nil && s.IsLeader()
+}
@@ -2,34 +2,130 @@ package ontime
 
 import (
 	"context"
+	"fmt"
+	"reviewmakerback/config"
 	db "reviewmakerback/db"
+	"reviewmakerback/mail"
 	"time"
 )
 
-func Start() (context.Context, context.CancelFunc) {
-	ctx, cancel := context.WithCancel(context.Background())
-	go ArrangeSession(ctx)
-	return ctx, cancel
-}
+// 定期処理を開始する
+// 返り値の関数は定期処理を停止し、実行中の処理が終わるまで待つ
+func Start(conf config.Config) (context.Context, func(), error) {
+	var elector Elector
+	if conf.Jobs.LeaderElection {
+		// 同じデータベースを使用するインスタンスのうち、ロックを取得したものだけが実行する
+		elector = db.NewAdvisoryLock(int64(conf.Jobs.LockKey))
+	}
+	scheduler := NewScheduler(elector)
+
+	jobs := []Job{
+		{
+			Name:       "arrangeSession",
+			Schedule:   Every(db.SessionDelSpan * time.Second),
+			Timeout:    time.Minute,
+			RunOnStart: true,
+			Run:        ArrangeSession,
+		},
+		{
+			Name:     "deliverWebhooks",
+			Schedule: Every(db.WebhookDeliverSpan * time.Second),
+			Timeout:  10 * time.Minute,
+			Run:      DeliverWebhooks,
+		},
+		{
+			// 最初の一回は起動時にInitDbで実行済み
+			Name:     "rotateEncryption",
+			Schedule: Every(db.EncryptionRotateSpan * time.Second),
+			Timeout:  30 * time.Minute,
+			Run:      RotateEncryption,
+		},
+		{
+			Name:       "arrangeLogs",
+			Schedule:   Every(time.Duration(conf.Retention.Span) * time.Second),
+			Timeout:    time.Hour,
+			RunOnStart: true,
+			Run:        func(ctx context.Context) error { return ArrangeLogs(ctx, conf.Retention, time.Now()) },
+		},
+		{
+			Name:     "purgeQueue",
+			Schedule: Every(db.QueuePurgeSpan * time.Second),
+			Timeout:  10 * time.Minute,
+			Run:      func(ctx context.Context) error { return PurgeQueue(ctx, conf.Queue.RetentionDays, time.Now()) },
+		},
+		{
+			Name:     "checkStorage",
+			Schedule: Every(db.StorageCheckSpan * time.Second),
+			Timeout:  time.Hour,
+			// ユーザーごとの容量を記録し始める前に保存したファイルを数えるため、起動直後にも実行する
+			RunOnStart: true,
+			Run: func(ctx context.Context) error {
+				return CheckStorage(ctx, conf.Server.FilePath, conf.FileGc, time.Now())
+			},
+		},
+	}
 
-func ArrangeSession(ctx context.Context) {
-	// タイマーを設定する
-	ticker := time.NewTicker(db.SessionDelSpan * time.Second)
+	transport, smtpEnabled := mail.NewSmtpTransport(conf.Smtp)
+	jobs = append(jobs, Job{
+		Name:     "sendDigests",
+		Schedule: Every(db.DigestSendSpan * time.Second),
+		Timeout:  30 * time.Minute,
+		Run:      func(ctx context.Context) error { return SendDigests(ctx, transport, conf, time.Now()) },
+	})
+
+	if err := applySchedules(jobs, conf.Jobs.ScheduleMap()); err != nil {
+		return nil, nil, err
+	}
+
+	for _, job := range jobs {
+		// SMTPが設定されている場合のみ通知ダイジェストを送信する
+		if job.Name == "sendDigests" && !smtpEnabled {
+			continue
+		}
+		if err := scheduler.Add(job); err != nil {
+			return nil, nil, err
+		}
+	}
 
-	// 処理終了時、タイマーを終了する
-	defer ticker.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	wait := scheduler.Start(ctx)
+	setCurrent(scheduler)
 
-	// 最初の一回を実行
-	db.ArrangeSession()
+	stop := func() {
+		cancel()
+		wait()
+		setCurrent(nil)
+	}
+	return ctx, stop, nil
+}
 
-	for {
-		select {
-		case <-ctx.Done():
-			// キャンセルされた場合
-			return
-		case <-ticker.C:
-			// タイマーが周回した際
-			db.ArrangeSession()
+// 設定で指定したスケジュールで上書きする
+func applySchedules(jobs []Job, schedules map[string]string) error {
+	for name, spec := range schedules {
+		found := false
+		for i := range jobs {
+			if jobs[i].Name != name {
+				continue
+			}
+			schedule, err := ParseSchedule(spec)
+			if err != nil {
+				return fmt.Errorf("定期処理'%s'のスケジュールが不正です: %s", name, err.Error())
+			}
+			jobs[i].Schedule = schedule
+			found = true
 		}
+		if !found {
+			return fmt.Errorf("定期処理'%s'は存在しません", name)
+		}
+	}
+	return nil
+}
+
+// 生存期間が終了したセッション等を削除する
+func ArrangeSession(ctx context.Context) error {
+	err := db.ArrangeSession(ctx)
+	if err != nil {
+		db.WriteErrorLog("none", "none", "arss-001", "期限切れのセッションの整理に失敗しました", err.Error())
 	}
+	return err
 }
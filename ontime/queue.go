@@ -0,0 +1,31 @@
+package ontime
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	db "reviewmakerback/db"
+	"reviewmakerback/metrics"
+)
+
+// 保存期間を過ぎた完了済み(成功・dead)のジョブと、その結果ファイル(エクスポートしたファイル等)を削除する
+func PurgeQueue(ctx context.Context, retentionDays int, now time.Time) error {
+	deleted, files, err := db.PurgeQueueJobs(now.AddDate(0, 0, -retentionDays))
+	if err != nil {
+		db.WriteErrorLog("none", "none", "pgqu-001", "完了したジョブを削除できませんでした", err.Error())
+		return err
+	}
+
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			db.WriteErrorLog("none", "none", "pgqu-002", "ジョブの結果ファイルを削除できませんでした", file+" "+err.Error())
+		}
+	}
+	metrics.ArrangedRows.WithLabelValues("queue_jobs").Add(float64(deleted))
+	if deleted > 0 {
+		db.WriteOperationLog("none", "none", "pgqu", fmt.Sprintf("queue_jobs=%d files=%d", deleted, len(files)))
+	}
+	return nil
+}
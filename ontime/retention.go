@@ -0,0 +1,45 @@
+package ontime
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"reviewmakerback/config"
+	db "reviewmakerback/db"
+	"reviewmakerback/metrics"
+)
+
+// 保存期間を過ぎた操作ログ・エラーログをアーカイブしてから削除する
+// 失敗したものがあっても残りの処理を続け、最後のエラーを返す
+func ArrangeLogs(ctx context.Context, conf config.RetentionConfig, now time.Time) error {
+	targets := []struct {
+		table   string
+		days    int
+		archive func(dir string, before time.Time) (int64, error)
+	}{
+		{"operation_logs", conf.OperationDays, db.ArchiveOperationLogs},
+		{"error_logs", conf.ErrorDays, db.ArchiveErrorLogs},
+	}
+
+	var lastErr error
+	for _, target := range targets {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if target.days <= 0 {
+			continue
+		}
+		deleted, err := target.archive(conf.ArchiveDir, now.AddDate(0, 0, -target.days))
+		if err != nil {
+			db.WriteErrorLog("none", "none", "arlg-001", "保存期間を過ぎたログを整理できませんでした", target.table+" "+err.Error())
+			lastErr = err
+			continue
+		}
+		metrics.ArrangedRows.WithLabelValues(target.table).Add(float64(deleted))
+		if deleted > 0 {
+			db.WriteOperationLog("none", "none", "arlg", fmt.Sprintf("%s=%d", target.table, deleted))
+		}
+	}
+	return lastErr
+}
@@ -0,0 +1,202 @@
+package ontime
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 定期処理の実行日時の決め方
+type Schedule interface {
+	Next(t time.Time) time.Time // tより後の次の実行日時
+	String() string             // 設定での表記
+}
+
+// 一定間隔で実行する
+type interval time.Duration
+
+// dごとに実行するスケジュールを作成する
+func Every(d time.Duration) Schedule {
+	return interval(d)
+}
+
+func (i interval) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(i))
+}
+
+func (i interval) String() string {
+	return "@every " + time.Duration(i).String()
+}
+
+// 短縮表記とcron形式の対応
+var cronAliases = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// スケジュールの表記を解析する
+// '@every 30s'のような間隔指定、'@daily'などの短縮表記、5項目のcron形式(分 時 日 月 曜日)に対応する
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("間隔'%s'が不正です", spec)
+		}
+		return Every(d), nil
+	}
+	if alias, ok := cronAliases[spec]; ok {
+		schedule, err := parseCron(alias)
+		if err != nil {
+			return nil, err
+		}
+		schedule.spec = spec
+		return schedule, nil
+	}
+	return parseCron(spec)
+}
+
+// cron形式のスケジュール
+// 各項目は実行する値のビット集合で持つ
+type cronSchedule struct {
+	spec                          string
+	minute, hour, dom, month, dow uint64
+	domRestricted, dowRestricted  bool
+}
+
+// cronの各項目の範囲
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"分", 0, 59},
+	{"時", 0, 23},
+	{"日", 1, 31},
+	{"月", 1, 12},
+	{"曜日", 0, 7},
+}
+
+func parseCron(spec string) (*cronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("cron形式'%s'は5項目で指定してください", spec)
+	}
+
+	var bits [5]uint64
+	for i, field := range fields {
+		b, err := parseCronField(field, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("cron形式'%s'の%sが不正です: %s", spec, cronFields[i].name, err.Error())
+		}
+		bits[i] = b
+	}
+
+	// 曜日の7は日曜日として扱う
+	if bits[4]&(1<<7) != 0 {
+		bits[4] = bits[4]&^(1<<7) | 1
+	}
+
+	return &cronSchedule{
+		spec:          spec,
+		minute:        bits[0],
+		hour:          bits[1],
+		dom:           bits[2],
+		month:         bits[3],
+		dow:           bits[4],
+		domRestricted: !strings.HasPrefix(fields[2], "*"),
+		dowRestricted: !strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+// '*', '*/n', 'a', 'a-b', 'a-b/n'をカンマ区切りで並べた項目を解析する
+func parseCronField(field string, min int, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if index := strings.Index(part, "/"); index >= 0 {
+			s, err := strconv.Atoi(part[index+1:])
+			if err != nil || s <= 0 {
+				return 0, errors.New("間隔は正の整数で指定してください")
+			}
+			step = s
+			part = part[:index]
+		}
+
+		start, end := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			index := strings.Index(part, "-")
+			a, err1 := strconv.Atoi(part[:index])
+			b, err2 := strconv.Atoi(part[index+1:])
+			if err1 != nil || err2 != nil || a > b {
+				return 0, fmt.Errorf("範囲'%s'が不正です", part)
+			}
+			start, end = a, b
+		default:
+			a, err := strconv.Atoi(part)
+			if err != nil {
+				return 0, fmt.Errorf("値'%s'が不正です", part)
+			}
+			start, end = a, a
+			if step > 1 {
+				// 'a/n'はaから最大値までn刻み
+				end = max
+			}
+		}
+		if start < min || end > max {
+			return 0, fmt.Errorf("%dから%dの範囲で指定してください", min, max)
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (c *cronSchedule) String() string {
+	return c.spec
+}
+
+// 日付が日・曜日の指定に一致するかどうか
+// 日と曜日の両方を指定した場合は、どちらかに一致すれば実行する(一般的なcronと同じ)
+func (c *cronSchedule) matchDay(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domRestricted && c.dowRestricted {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
+
+func (c *cronSchedule) Next(t time.Time) time.Time {
+	// 秒以下を切り捨てた次の分から探す
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	// 存在しない日付(2月30日など)のみの指定で無限に探さないように上限を設ける
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,219 @@
+package ontime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"reviewmakerback/logger"
+	"reviewmakerback/metrics"
+)
+
+// 定期処理
+type Job struct {
+	Name       string                          // 定期処理の名前(一意)
+	Schedule   Schedule                        // 実行日時の決め方
+	Timeout    time.Duration                   // 一回の実行の制限時間(0なら無制限)
+	RunOnStart bool                            // 起動直後に一度実行するかどうか
+	Run        func(ctx context.Context) error // 処理の本体(制限時間を過ぎるとctxがキャンセルされる)
+}
+
+// 複数のインスタンスのうち定期処理を実行するものを決める仕組み
+type Elector interface {
+	Acquire(ctx context.Context) (bool, error) // 実行を担当しているかどうか(担当していなければ担当を試みる)
+	Release()                                  // 担当を降りる
+}
+
+// 定期処理を登録した順に並行して実行する
+type Scheduler struct {
+	elector Elector
+	jobs    []Job
+
+	mutex    sync.Mutex
+	statuses map[string]*JobStatus
+	isLeader bool
+}
+
+// 定期処理の実行を管理する
+// electorがnilなら常にこのインスタンスで実行する
+func NewScheduler(elector Elector) *Scheduler {
+	return &Scheduler{
+		elector:  elector,
+		statuses: map[string]*JobStatus{},
+	}
+}
+
+// 定期処理を登録する(Startの前に呼ぶこと)
+func (s *Scheduler) Add(job Job) error {
+	if job.Name == "" || job.Schedule == nil || job.Run == nil {
+		return errors.New("定期処理の名前・スケジュール・処理は省略できません")
+	}
+	for _, j := range s.jobs {
+		if j.Name == job.Name {
+			return fmt.Errorf("定期処理'%s'は登録済みです", job.Name)
+		}
+	}
+	s.jobs = append(s.jobs, job)
+	s.statuses[job.Name] = &JobStatus{Name: job.Name, Schedule: job.Schedule.String(), Timeout: job.Timeout}
+	return nil
+}
+
+// 定期処理を開始する
+// 返り値の関数はctxのキャンセル後に実行中の処理が終わるまで待ち、担当を降りる
+func (s *Scheduler) Start(ctx context.Context) func() {
+	var wg sync.WaitGroup
+	for _, job := range s.jobs {
+		wg.Add(1)
+		go func(job Job) {
+			defer wg.Done()
+			s.loop(ctx, job)
+		}(job)
+	}
+	return func() {
+		wg.Wait()
+		if s.elector != nil {
+			s.elector.Release()
+		}
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context, job Job) {
+	next := time.Now()
+	if !job.RunOnStart {
+		next = job.Schedule.Next(next)
+	}
+
+	for {
+		if next.IsZero() {
+			// 次の実行日時が存在しない
+			logger.Warn(ctx, "job has no next run", logger.Fields{"job": job.Name})
+			return
+		}
+		s.update(job.Name, func(status *JobStatus) { status.NextRun = next })
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			// キャンセルされた場合
+			timer.Stop()
+			return
+		case <-timer.C:
+			// 実行日時に達した場合
+			s.runOnce(ctx, job)
+		}
+		next = job.Schedule.Next(time.Now())
+	}
+}
+
+// 実行を担当している場合のみ定期処理を一回実行して結果を記録する
+func (s *Scheduler) runOnce(ctx context.Context, job Job) {
+	if !s.acquire(ctx) {
+		s.update(job.Name, func(status *JobStatus) { status.Skipped = true })
+		metrics.JobRuns.WithLabelValues(job.Name, "skipped").Inc()
+		return
+	}
+
+	start := time.Now()
+	s.update(job.Name, func(status *JobStatus) {
+		status.LastRun = start
+		status.Running = true
+		status.Skipped = false
+	})
+
+	err := runJob(ctx, job)
+	duration := time.Since(start)
+
+	s.update(job.Name, func(status *JobStatus) {
+		status.Running = false
+		status.Duration = duration
+		status.LastError = ""
+		if err != nil {
+			status.LastError = err.Error()
+		}
+	})
+
+	result := "succeeded"
+	if err != nil {
+		result = "failed"
+		logger.Error(ctx, "job failed", logger.Fields{"job": job.Name, "error": err.Error()})
+	}
+	metrics.JobRuns.WithLabelValues(job.Name, result).Inc()
+	metrics.JobDuration.WithLabelValues(job.Name).Observe(duration.Seconds())
+}
+
+// 制限時間を設定して定期処理を実行する
+// パニックが発生しても他の定期処理を止めないよう、エラーとして返す
+func runJob(ctx context.Context, job Job) (err error) {
+	if job.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, job.Timeout)
+		defer cancel()
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error(ctx, "job panicked", logger.Fields{"job": job.Name, "panic": fmt.Sprint(r), "stack": string(debug.Stack())})
+			err = fmt.Errorf("パニックが発生しました: %v", r)
+		}
+	}()
+
+	err = job.Run(ctx)
+	if err == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("制限時間(%s)を過ぎたため中断しました", job.Timeout)
+	}
+	return err
+}
+
+// 実行を担当しているかどうかを確認し、担当が変わった場合は記録する
+func (s *Scheduler) acquire(ctx context.Context) bool {
+	leader := true
+	if s.elector != nil {
+		var err error
+		leader, err = s.elector.Acquire(ctx)
+		if err != nil {
+			logger.Warn(ctx, "job leader election failed", logger.Fields{"error": err.Error()})
+		}
+	}
+
+	s.mutex.Lock()
+	changed := s.isLeader != leader
+	s.isLeader = leader
+	s.mutex.Unlock()
+
+	if changed {
+		logger.Info(ctx, "job leader changed", logger.Fields{"isLeader": leader})
+	}
+	return leader
+}
+
+func (s *Scheduler) update(name string, f func(status *JobStatus)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	f(s.statuses[name])
+}
+
+// このインスタンスが定期処理の実行を担当しているかどうか(最後に確認した時点)
+func (s *Scheduler) IsLeader() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.isLeader
+}
+
+// 定期処理の実行状況を名前順に返す
+func (s *Scheduler) Statuses(now time.Time) []JobStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	statuses := make([]JobStatus, 0, len(s.statuses))
+	for _, status := range s.statuses {
+		st := *status
+		st.Stale = st.isStale(now)
+		statuses = append(statuses, st)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
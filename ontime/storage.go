@@ -0,0 +1,75 @@
+package ontime
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"reviewmakerback/config"
+	db "reviewmakerback/db"
+	"reviewmakerback/logger"
+	"reviewmakerback/metrics"
+	"reviewmakerback/storage"
+)
+
+// ログに出力する不整合の例の最大数
+const storageExampleMax = 20
+
+// 保存先のファイルとデータベースの参照を突き合わせ、不整合を記録する
+// 設定で削除を有効にしている場合は参照されていないファイルを削除する
+// 画像の保存・削除時に増減しているユーザーごとの容量は、残ったファイルから再計算した値で置き換える
+// (走査済みのフォルダに走査中に保存された画像は二重に数える場合があるが、次の確認で補正される)
+func CheckStorage(ctx context.Context, root string, conf config.FileGcConfig, now time.Time) error {
+	// 走査中に保存・削除された画像の容量は、走査の結果に加える
+	if err := db.StartStorageRecount(""); err != nil {
+		db.WriteErrorLog("none", "none", "chst-004", "ユーザーごとの容量の再計算を開始できませんでした", err.Error())
+		return err
+	}
+	report, err := storage.Scan(ctx, root, storage.Options{
+		Delete: conf.Delete,
+		MinAge: time.Duration(conf.MinAgeHours) * time.Hour,
+		Now:    now,
+	})
+	if err != nil {
+		db.WriteErrorLog("none", "none", "chst-001", "保存済みのファイルを確認できませんでした", err.Error())
+		return err
+	}
+
+	metrics.StorageProblems.WithLabelValues("orphan").Set(float64(len(report.Orphans) - len(report.Deleted)))
+	metrics.StorageProblems.WithLabelValues("dangling").Set(float64(len(report.Dangling)))
+
+	if len(report.Orphans) > 0 || len(report.Dangling) > 0 {
+		logger.Warn(ctx, "storage check found problems", logger.Fields{
+			"orphans":  limitExamples(report.Orphans),
+			"dangling": limitDangling(report.Dangling),
+		})
+	}
+	for _, file := range report.Failed {
+		db.WriteErrorLog("none", "none", "chst-002", "参照されていないファイルを削除できませんでした", file)
+	}
+	if err := db.SetStorageUsages(report.Usage, true); err != nil {
+		db.WriteErrorLog("none", "none", "chst-003", "ユーザーごとの容量を更新できませんでした", err.Error())
+		return err
+	}
+	db.WriteOperationLog("none", "none", "chst", fmt.Sprintf("files=%d references=%d orphans=%d dangling=%d deleted=%d users=%d",
+		report.Files, report.References, len(report.Orphans), len(report.Dangling), len(report.Deleted), len(report.Usage)))
+	return nil
+}
+
+func limitExamples(files []string) []string {
+	if len(files) > storageExampleMax {
+		return files[:storageExampleMax]
+	}
+	return files
+}
+
+func limitDangling(refs []storage.Reference) []string {
+	examples := []string{}
+	for i, ref := range refs {
+		if i >= storageExampleMax {
+			break
+		}
+		examples = append(examples, fmt.Sprintf("%s %s %s", ref.Kind, ref.OwnerId, ref.Path))
+	}
+	return examples
+}
@@ -0,0 +1,182 @@
+package ontime
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	common "reviewmakerback/common"
+	db "reviewmakerback/db"
+	"reviewmakerback/queue"
+)
+
+// 一度の周回でジョブキューに移す配信の最大数
+const webhookBatchSize = 100
+
+// Webhook送信のタイムアウト
+const webhookTimeout = 10 * time.Second
+
+// 再送間隔の上限
+const webhookBackoffMax = 6 * time.Hour
+
+var webhookClient = NewWebhookClient()
+
+// Webhookの送信に使用するクライアントを作成する
+// 利用者が指定したURLに送信するため、内部向けのアドレスへの接続とリダイレクトを拒否する
+func NewWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+		// 名前解決後のアドレスを接続の直前に確認する
+		Control: common.PublicOnlyControl,
+	}
+	return &http.Client{
+		Timeout: webhookTimeout,
+		Transport: &http.Transport{
+			// プロキシを経由すると接続先のアドレスを確認できないため使用しない
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: webhookTimeout,
+		},
+		// リダイレクト先へは送信せず、3xxのステータスを失敗として扱う
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// ジョブキュー導入前に登録した未送信の配信をジョブキューに移す
+// 制限時間を過ぎた場合は残りを次の周回で処理する
+func DeliverWebhooks(ctx context.Context) error {
+	for ctx.Err() == nil {
+		cnt, err := db.EnqueuePendingWebhookDeliveries(webhookBatchSize)
+		if err != nil {
+			db.WriteErrorLog("none", "none", "whdl-001", "Webhookの配信をジョブキューに登録できません", err.Error())
+			return err
+		}
+		if cnt > 0 {
+			queue.Notify()
+		}
+		if cnt < webhookBatchSize {
+			return nil
+		}
+	}
+	return ctx.Err()
+}
+
+// ジョブキューのWebhookの配信を登録する
+func RegisterWebhookJob(q *queue.Queue) {
+	q.Register(db.QueueKindWebhook, DeliverWebhookJob, queue.Options{
+		Timeout: webhookTimeout * 2,
+		Backoff: WebhookBackoff,
+	})
+}
+
+// 配信を一回送信し、結果を配信ログに記録する
+// 失敗した場合はジョブキューが再送し、最後の試行で失敗した場合は配信を失敗にする
+func DeliverWebhookJob(ctx context.Context, job queue.Job) (queue.Result, error) {
+	var payload db.WebhookJobPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return queue.Result{}, queue.Permanent(err)
+	}
+	delivery, err := db.GetWebhookDelivery(payload.DeliveryId)
+	if err != nil {
+		// 送信先と共に削除された場合は再送しない
+		return queue.Result{}, queue.Permanent(err)
+	}
+	if delivery.Status == db.WebhookSucceeded {
+		return queue.Result{}, nil
+	}
+
+	webhook, tx := db.GetWebhook(delivery.WebhookId, "*")
+	var cnt int64
+	tx.Count(&cnt)
+
+	delivery.Attempts = job.Attempts
+	var sendErr error
+	if cnt != 1 || !webhook.IsActive {
+		// 送信先が削除または無効化されている場合は送信しない
+		sendErr = queue.Permanent(errors.New("Webhookが存在しないか無効です"))
+	} else {
+		delivery.StatusCode, sendErr = SendWebhook(webhookClient, webhook.Url, webhook.Secret, delivery)
+		if errors.Is(sendErr, common.ErrNonPublicAddress) {
+			// 送信先を変更しない限り成功しないので再送しない
+			sendErr = queue.Permanent(sendErr)
+		}
+	}
+
+	switch {
+	case sendErr == nil:
+		delivery.Status = db.WebhookSucceeded
+		delivery.LastError = ""
+	case queue.IsPermanent(sendErr) || job.Attempts >= job.MaxAttempts:
+		delivery.Status = db.WebhookFailed
+		delivery.LastError = sendErr.Error()
+	default:
+		delivery.Status = db.WebhookPending
+		delivery.NextAttemptAt = time.Now().Add(WebhookBackoff(job.Attempts))
+		delivery.LastError = sendErr.Error()
+	}
+
+	err = db.UpdateWebhookDelivery(delivery)
+	if err != nil {
+		db.WriteErrorLog(job.UserId, "none", "whdl-002", "Webhookの配信結果を記録できません", fmt.Sprintf("delivery=%d %s", delivery.Id, err.Error()))
+	}
+	return queue.Result{}, sendErr
+}
+
+// Webhookを送信し、受け取ったHTTPステータスを返す
+// 本文には 'タイムスタンプ.本文' をシークレットで署名したものをヘッダに付与する
+func SendWebhook(client *http.Client, url string, secret string, delivery db.WebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, withoutUrl(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Kdtier-Event", delivery.Event)
+	req.Header.Set("X-Kdtier-Delivery", strconv.FormatUint(uint64(delivery.Id), 10))
+	req.Header.Set("X-Kdtier-Timestamp", timestamp)
+	req.Header.Set("X-Kdtier-Signature", "sha256="+common.GetHmacSHA256(secret, timestamp+"."+delivery.Payload))
+
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, withoutUrl(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return res.StatusCode, errors.New("送信先から異常なステータスが返却されました: " + res.Status)
+	}
+	return res.StatusCode, nil
+}
+
+// 送信先のURLにはトークンが含まれる場合があるため、エラーからURLを取り除く
+// 配信ログとエラーログにはこのエラーを記録する
+func withoutUrl(err error) error {
+	if inner := errors.Unwrap(err); inner != nil {
+		return fmt.Errorf("送信に失敗しました: %w", inner)
+	}
+	return err
+}
+
+// 試行回数に応じた再送までの待ち時間を返す(30秒から倍々に増やす)
+func WebhookBackoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	d := 30 * time.Second
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= webhookBackoffMax {
+			return webhookBackoffMax
+		}
+	}
+	return d
+}
@@ -0,0 +1,154 @@
+package queue
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ジョブの状態(データベースの保存先と同じ表記)
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusDead      = "dead"
+)
+
+// 実行中のジョブが他のワーカーに取得されていた場合のエラー
+var ErrLost = errors.New("ジョブが他のワーカーに取得されています")
+
+// プロセス内に保持する保存先のジョブ
+type MemoryJob struct {
+	Job
+	Status      string
+	RunAt       time.Time
+	LockedBy    string
+	LockedUntil time.Time
+	LastError   string
+	Result      Result
+}
+
+// プロセス内に保持する保存先
+// 再起動するとジョブが失われるため、動作確認用
+type MemoryStore struct {
+	mu     sync.Mutex
+	jobs   map[uint64]*MemoryJob
+	lastId uint64
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{jobs: map[uint64]*MemoryJob{}}
+}
+
+// ジョブを登録してIDを返す
+func (s *MemoryStore) Enqueue(kind string, userId string, payload string, maxAttempts int) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastId++
+	s.jobs[s.lastId] = &MemoryJob{
+		Job:    Job{Id: s.lastId, Kind: kind, UserId: userId, Payload: payload, MaxAttempts: maxAttempts},
+		Status: StatusQueued,
+		RunAt:  time.Now(),
+	}
+	return s.lastId
+}
+
+// ジョブの現在の状態を返す
+func (s *MemoryStore) Get(id uint64) (MemoryJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return MemoryJob{}, false
+	}
+	return *job, true
+}
+
+func (s *MemoryStore) Claim(workerId string, kinds []string, lease time.Duration) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var due []*MemoryJob
+	for _, job := range s.jobs {
+		if !containsKind(kinds, job.Kind) {
+			continue
+		}
+		if (job.Status == StatusQueued && !job.RunAt.After(now)) || (job.Status == StatusRunning && job.LockedUntil.Before(now)) {
+			due = append(due, job)
+		}
+	}
+	if len(due) == 0 {
+		return nil, nil
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if due[i].RunAt.Equal(due[j].RunAt) {
+			return due[i].Id < due[j].Id
+		}
+		return due[i].RunAt.Before(due[j].RunAt)
+	})
+
+	job := due[0]
+	job.Status = StatusRunning
+	job.LockedBy = workerId
+	job.LockedUntil = now.Add(lease)
+	job.Attempts++
+	claimed := job.Job
+	return &claimed, nil
+}
+
+// 取得したワーカーが保持しているジョブを更新する
+func (s *MemoryStore) update(id uint64, workerId string, f func(job *MemoryJob)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok || job.Status != StatusRunning || job.LockedBy != workerId {
+		return ErrLost
+	}
+	job.LockedBy = ""
+	f(job)
+	return nil
+}
+
+func (s *MemoryStore) Complete(job Job, workerId string, result Result) error {
+	return s.update(job.Id, workerId, func(j *MemoryJob) {
+		j.Status = StatusSucceeded
+		j.Result = result
+		j.LastError = ""
+	})
+}
+
+func (s *MemoryStore) Retry(job Job, workerId string, lastError string, runAt time.Time) error {
+	return s.update(job.Id, workerId, func(j *MemoryJob) {
+		j.Status = StatusQueued
+		j.LastError = lastError
+		j.RunAt = runAt
+	})
+}
+
+func (s *MemoryStore) Kill(job Job, workerId string, lastError string) error {
+	return s.update(job.Id, workerId, func(j *MemoryJob) {
+		j.Status = StatusDead
+		j.LastError = lastError
+	})
+}
+
+func (s *MemoryStore) Release(job Job, workerId string) error {
+	return s.update(job.Id, workerId, func(j *MemoryJob) {
+		j.Status = StatusQueued
+		j.Attempts--
+	})
+}
+
+func containsKind(kinds []string, kind string) bool {
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
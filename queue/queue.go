@@ -0,0 +1,318 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	"reviewmakerback/logger"
+	"reviewmakerback/metrics"
+)
+
+// 制限時間を指定しなかった場合の一回の実行の制限時間
+const defaultTimeout = 5 * time.Minute
+
+// 実行中のジョブを他のワーカーが取得できるようになるまでの猶予(制限時間に加える時間)
+const leaseMargin = time.Minute
+
+// 取得に失敗した場合に次の取得まで待つ時間
+const claimErrorWait = 5 * time.Second
+
+// 取得したジョブ
+type Job struct {
+	Id          uint64
+	Kind        string // ジョブの種類
+	UserId      string // ジョブを登録したユーザーの固有ID
+	Payload     string // ジョブの入力(JSON)
+	Attempts    int    // 今回を含めた試行回数
+	MaxAttempts int    // 試行回数の上限
+}
+
+// ジョブの実行結果
+type Result struct {
+	Data string // 実行結果(JSON)
+	File string // 実行結果として作成したファイル
+}
+
+// ジョブの保存先
+// 取得したワーカー以外が更新しないよう、更新時はワーカーのIDを照合する
+type Store interface {
+	Claim(workerId string, kinds []string, lease time.Duration) (*Job, error) // 実行時間に達したジョブを一つ取得する(無ければnil)
+	Complete(job Job, workerId string, result Result) error                   // 成功を記録する
+	Retry(job Job, workerId string, lastError string, runAt time.Time) error  // 失敗を記録してrunAtに再試行する
+	Kill(job Job, workerId string, lastError string) error                    // 再試行しない失敗(dead)にする
+	Release(job Job, workerId string) error                                   // 試行回数に数えずに実行待ちに戻す
+}
+
+// ジョブの処理
+// ctxは制限時間を過ぎるか、キューが停止するとキャンセルされる
+type Handler func(ctx context.Context, job Job) (Result, error)
+
+// ジョブの種類ごとの設定
+type Options struct {
+	Timeout time.Duration                    // 一回の実行の制限時間(0なら5分)
+	Backoff func(attempts int) time.Duration // 試行回数に応じた再試行までの待ち時間(nilなら10秒から倍々に増やす)
+
+	// deadにした後に呼ばれる処理(ジョブの入力として残したファイルの削除等に使用する)
+	OnDead func(ctx context.Context, job Job, lastError string)
+}
+
+// 再試行しても成功しない失敗
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// 再試行せずにdeadにする失敗として返す
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// 再試行しない失敗かどうか
+func IsPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
+
+// 試行回数に応じた再試行までの待ち時間(10秒から倍々に増やし、1時間で頭打ち)
+func DefaultBackoff(attempts int) time.Duration {
+	d := 10 * time.Second
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= time.Hour {
+			return time.Hour
+		}
+	}
+	return d
+}
+
+type handlerEntry struct {
+	handler Handler
+	options Options
+}
+
+// ジョブキューのワーカー
+type Queue struct {
+	store        Store
+	workers      int
+	pollInterval time.Duration
+	workerId     string
+
+	mutex    sync.Mutex
+	handlers map[string]handlerEntry
+	wake     chan struct{}
+}
+
+// storeのジョブをworkers個のワーカーで並行して実行するキューを作成する
+// 他のインスタンスが登録したジョブはpollIntervalごとに確認する
+func New(store Store, workers int, pollInterval time.Duration) *Queue {
+	if workers < 1 {
+		workers = 1
+	}
+	host, _ := os.Hostname()
+	return &Queue{
+		store:        store,
+		workers:      workers,
+		pollInterval: pollInterval,
+		workerId:     fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano()),
+		handlers:     map[string]handlerEntry{},
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// ジョブの種類ごとの処理を登録する(Startの前に呼ぶこと)
+func (q *Queue) Register(kind string, handler Handler, options Options) {
+	if options.Timeout <= 0 {
+		options.Timeout = defaultTimeout
+	}
+	if options.Backoff == nil {
+		options.Backoff = DefaultBackoff
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.handlers[kind] = handlerEntry{handler: handler, options: options}
+}
+
+// 待機中のワーカーを起こしてジョブを取得させる
+func (q *Queue) Notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// ワーカーを開始する
+// 返り値の関数はctxのキャンセル後に実行中のジョブが終わるまで待つ
+func (q *Queue) Start(ctx context.Context) func() {
+	q.mutex.Lock()
+	kinds := make([]string, 0, len(q.handlers))
+	for kind := range q.handlers {
+		kinds = append(kinds, kind)
+	}
+	q.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for i := 0; i < q.workers; i++ {
+		wg.Add(1)
+		go func(workerId string) {
+			defer wg.Done()
+			q.loop(ctx, workerId, kinds)
+		}(q.workerId + "-" + strconv.Itoa(i))
+	}
+	return wg.Wait
+}
+
+func (q *Queue) loop(ctx context.Context, workerId string, kinds []string) {
+	for ctx.Err() == nil {
+		job, err := q.store.Claim(workerId, kinds, q.lease(kinds))
+		if err != nil {
+			logger.Warn(ctx, "queue claim failed", logger.Fields{"worker": workerId, "error": err.Error()})
+			q.wait(ctx, claimErrorWait)
+			continue
+		}
+		if job == nil {
+			q.wait(ctx, q.pollInterval)
+			continue
+		}
+		q.run(ctx, workerId, *job)
+		// 他のワーカーにも残りのジョブを取得させる
+		q.Notify()
+	}
+}
+
+// 起こされるか、dが経過するか、キャンセルされるまで待つ
+func (q *Queue) wait(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-q.wake:
+	case <-timer.C:
+	}
+}
+
+// 取得するジョブのうち最も長い制限時間に猶予を加えた時間
+// 実行中のワーカーが停止した場合は、この時間が過ぎると他のワーカーが取得する
+func (q *Queue) lease(kinds []string) time.Duration {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	lease := time.Duration(0)
+	for _, kind := range kinds {
+		if t := q.handlers[kind].options.Timeout; t > lease {
+			lease = t
+		}
+	}
+	return lease + leaseMargin
+}
+
+// ジョブを一回実行して結果を記録する
+func (q *Queue) run(ctx context.Context, workerId string, job Job) {
+	q.mutex.Lock()
+	entry := q.handlers[job.Kind]
+	q.mutex.Unlock()
+
+	fields := logger.Fields{"job": job.Id, "kind": job.Kind, "attempts": job.Attempts}
+
+	if job.Attempts > job.MaxAttempts {
+		// 実行中にワーカーが停止し、取得し直した場合
+		q.kill(ctx, workerId, entry, job, "試行回数の上限を超えました", fields)
+		return
+	}
+
+	start := time.Now()
+	result, err := runHandler(ctx, entry, job)
+	metrics.QueueDuration.WithLabelValues(job.Kind).Observe(time.Since(start).Seconds())
+
+	switch {
+	case err == nil:
+		q.finish(ctx, job, "succeeded", q.store.Complete(job, workerId, result), fields)
+	case ctx.Err() != nil:
+		// 停止により中断した場合は次に起動したときに実行し直す
+		q.finish(ctx, job, "released", q.store.Release(job, workerId), fields)
+	case IsPermanent(err) || job.Attempts >= job.MaxAttempts:
+		fields["error"] = err.Error()
+		logger.Error(ctx, "queue job dead", fields)
+		q.kill(ctx, workerId, entry, job, err.Error(), fields)
+	default:
+		fields["error"] = err.Error()
+		logger.Warn(ctx, "queue job failed", fields)
+		q.finish(ctx, job, "retried", q.store.Retry(job, workerId, err.Error(), time.Now().Add(entry.options.Backoff(job.Attempts))), fields)
+	}
+}
+
+// ジョブをdeadにし、記録できた場合はOnDeadを呼ぶ
+func (q *Queue) kill(ctx context.Context, workerId string, entry handlerEntry, job Job, lastError string, fields logger.Fields) {
+	err := q.store.Kill(job, workerId, lastError)
+	q.finish(ctx, job, "dead", err, fields)
+	if err == nil && entry.options.OnDead != nil {
+		entry.options.OnDead(ctx, job, lastError)
+	}
+}
+
+// 実行結果の記録に失敗した場合はログに残す
+func (q *Queue) finish(ctx context.Context, job Job, result string, err error, fields logger.Fields) {
+	metrics.QueueJobs.WithLabelValues(job.Kind, result).Inc()
+	if err != nil {
+		fields["result"] = result
+		fields["error"] = err.Error()
+		logger.Error(ctx, "queue job update failed", fields)
+	}
+}
+
+// 制限時間を設定してジョブを実行する
+// パニックが発生しても他のジョブを止めないよう、エラーとして返す
+func runHandler(ctx context.Context, entry handlerEntry, job Job) (result Result, err error) {
+	if entry.handler == nil {
+		return result, Permanent(fmt.Errorf("ジョブの種類'%s'の処理が登録されていません", job.Kind))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, entry.options.Timeout)
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error(ctx, "queue job panicked", logger.Fields{"job": job.Id, "kind": job.Kind, "panic": fmt.Sprint(r), "stack": string(debug.Stack())})
+			err = fmt.Errorf("パニックが発生しました: %v", r)
+		}
+	}()
+
+	result, err = entry.handler(ctx, job)
+	if err == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("制限時間(%s)を過ぎたため中断しました", entry.options.Timeout)
+	}
+	return result, err
+}
+
+var (
+	currentMutex sync.Mutex
+	current      *Queue
+)
+
+// 実行中のキューを設定する(Notifyの通知先)
+func SetCurrent(q *Queue) {
+	currentMutex.Lock()
+	defer currentMutex.Unlock()
+	current = q
+}
+
+// 実行中のキューのワーカーを起こす(キューが開始していなければ何もしない)
+// ジョブを登録した直後に呼び、ポーリングを待たずに実行させる
+func Notify() {
+	currentMutex.Lock()
+	q := current
+	currentMutex.Unlock()
+
+	if q != nil {
+		q.Notify()
+	}
+}
@@ -0,0 +1,298 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// トークンバケットの設定
+type Limit struct {
+	Rate  float64 `json:"rate"`  // 1秒あたりに補充するトークン数
+	Burst int     `json:"burst"` // バケットの容量(連続して実行できる回数)
+}
+
+// 制限を行わない設定かどうか
+func (l Limit) IsZero() bool {
+	return l.Rate <= 0 || l.Burst <= 0
+}
+
+// ルートのまとまりごとの制限
+type Rule struct {
+	Name    string   `json:"name"`    // バケットを区別するための名前
+	Methods []string `json:"methods"` // 対象のHTTPメソッド(空なら全て)
+	Paths   []string `json:"paths"`   // 対象のパスの前方一致(空なら全て)
+	PerUser Limit    `json:"perUser"` // ユーザーIDごとの制限(ログインしている場合のみ)
+	PerIp   Limit    `json:"perIp"`   // IPアドレスごとの制限
+}
+
+// リクエストが対象かどうか
+func (r Rule) Match(method string, path string) bool {
+	if len(r.Methods) > 0 {
+		matched := false
+		for _, m := range r.Methods {
+			if strings.EqualFold(m, method) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(r.Paths) == 0 {
+		return true
+	}
+	for _, p := range r.Paths {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// 制限の設定
+// ルールは先頭から順に評価し、最初に一致したもののみ適用する
+type Config struct {
+	Store string `json:"store"` // 保存先(memory, postgres)
+	Rules []Rule `json:"rules"`
+}
+
+// 保存先
+const (
+	StoreMemory   = "memory"
+	StorePostgres = "postgres"
+)
+
+// 設定ファイルが無い場合の制限
+var DefaultConfig = Config{
+	Store: StoreMemory,
+	Rules: []Rule{
+		// ログイン・セッション操作
+		{Name: "auth", Paths: []string{"/auth/"}, PerIp: Limit{Rate: 0.5, Burst: 20}},
+		// 画像の取得
+		{Name: "file", Methods: []string{"GET"}, Paths: []string{"/userfile/"}, PerIp: Limit{Rate: 20, Burst: 200}},
+		// 作成・更新・削除
+		{Name: "write", Methods: []string{"POST", "PUT", "PATCH", "DELETE"}, PerUser: Limit{Rate: 0.5, Burst: 20}, PerIp: Limit{Rate: 1, Burst: 40}},
+		// 参照
+		{Name: "read", PerIp: Limit{Rate: 10, Burst: 100}},
+	},
+}
+
+// JSON形式の設定ファイルを読み込む
+//
+//	{"store": "memory", "rules": [
+//	  {"name": "auth", "paths": ["/auth/"], "perIp": {"rate": 0.5, "burst": 20}},
+//	  {"name": "write", "methods": ["POST", "PATCH", "DELETE"], "perUser": {"rate": 0.5, "burst": 20}, "perIp": {"rate": 1, "burst": 40}}
+//	]}
+func LoadConfig(path string) (Config, error) {
+	var config Config
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	err = json.Unmarshal(b, &config)
+	if err != nil {
+		return config, err
+	}
+	return config, config.Validate()
+}
+
+// 設定が使用可能かチェックする
+func (c Config) Validate() error {
+	if c.Store != StoreMemory && c.Store != StorePostgres {
+		return fmt.Errorf("保存先'%s'は使用できません", c.Store)
+	}
+	names := map[string]bool{}
+	for _, r := range c.Rules {
+		if r.Name == "" {
+			return errors.New("ルールの名前がありません")
+		}
+		if names[r.Name] {
+			return fmt.Errorf("ルール'%s'が重複しています", r.Name)
+		}
+		names[r.Name] = true
+		if r.PerUser.IsZero() && r.PerIp.IsZero() {
+			return fmt.Errorf("ルール'%s'に制限がありません", r.Name)
+		}
+	}
+	return nil
+}
+
+// 最初に一致したルールを返す
+func (c Config) Match(method string, path string) (Rule, bool) {
+	for _, r := range c.Rules {
+		if r.Match(method, path) {
+			return r, true
+		}
+	}
+	return Rule{}, false
+}
+
+// トークンを取り出した結果
+type Result struct {
+	Allowed    bool          // 実行を許可するかどうか
+	Remaining  int           // 残りのトークン数
+	RetryAfter time.Duration // 次にトークンが補充されるまでの時間(許可されなかった場合)
+	Reset      time.Duration // バケットが満杯になるまでの時間
+}
+
+// バケットの保存先
+type Store interface {
+	Take(key string, limit Limit, now time.Time) (Result, error)
+	// Takeで取り出したトークンを戻す(他のバケットで許可されなかった場合に使用する)
+	Refund(key string, limit Limit, now time.Time) error
+}
+
+// 前回の残量と経過時間から補充後のトークン数を計算する
+func Refill(tokens float64, last time.Time, now time.Time, limit Limit) float64 {
+	elapsed := now.Sub(last).Seconds()
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return math.Min(float64(limit.Burst), tokens+elapsed*limit.Rate)
+}
+
+// 補充後のトークン数から結果を作成する
+func MakeResult(tokens float64, allowed bool, limit Limit) Result {
+	result := Result{
+		Allowed:   allowed,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     secondsToDuration((float64(limit.Burst) - tokens) / limit.Rate),
+	}
+	if !allowed {
+		result.RetryAfter = secondsToDuration((1 - tokens) / limit.Rate)
+	}
+	return result
+}
+
+func secondsToDuration(s float64) time.Duration {
+	if s <= 0 {
+		return 0
+	}
+	return time.Duration(s * float64(time.Second))
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// プロセス内に保持する保存先
+// 複数台で動かす場合は台数分だけ制限が緩くなる
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// 使われなくなったバケットを整理する間隔
+const memorySweepSpan = time.Minute
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}}
+}
+
+func (s *MemoryStore) Take(key string, limit Limit, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) >= memorySweepSpan {
+		s.sweep(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		s.buckets[key] = b
+	}
+
+	b.tokens = Refill(b.tokens, b.last, now, limit)
+	b.last = now
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	return MakeResult(b.tokens, allowed, limit), nil
+}
+
+func (s *MemoryStore) Refund(key string, limit Limit, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if b, ok := s.buckets[key]; ok {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+1)
+	}
+	return nil
+}
+
+// 一定時間使われていないバケットを削除する
+// 削除されたバケットは次回満杯の状態で作り直される
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		if now.Sub(b.last) > memorySweepSpan*10 {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = now
+}
+
+// 制限の設定と保存先
+type Limiter struct {
+	Config Config
+	Store  Store
+}
+
+// ルールに従ってユーザーIDとIPアドレスのバケットからトークンを取り出す
+// どちらかが許可されなければ許可せず、取り出したトークンを戻す
+// (制限されたユーザーが同じIPアドレスの他のユーザーのトークンを減らさないようにする)
+// userIdが空文字列の場合はユーザーIDの制限を行わない
+func (l *Limiter) Take(rule Rule, userId string, ipAddress string, now time.Time) (Result, Limit, error) {
+	result := Result{Allowed: true, Remaining: math.MaxInt32}
+	var applied Limit
+
+	type target struct {
+		key   string
+		limit Limit
+	}
+	targets := []target{}
+	if userId != "" && !rule.PerUser.IsZero() {
+		targets = append(targets, target{rule.Name + ":user:" + userId, rule.PerUser})
+	}
+	if !rule.PerIp.IsZero() {
+		targets = append(targets, target{rule.Name + ":ip:" + ipAddress, rule.PerIp})
+	}
+
+	// 先頭からn個のバケットに取り出したトークンを戻す
+	refund := func(n int) error {
+		for _, t := range targets[:n] {
+			if err := l.Store.Refund(t.key, t.limit, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for i, t := range targets {
+		r, err := l.Store.Take(t.key, t.limit, now)
+		if err != nil {
+			refund(i)
+			return result, applied, err
+		}
+		if !r.Allowed {
+			// 許可されなかった時点で止め、それまでに取り出したトークンを戻す
+			return r, t.limit, refund(i)
+		}
+		// 最も残りの少ない結果を返す
+		if r.Remaining < result.Remaining {
+			result = r
+			applied = t.limit
+		}
+	}
+	return result, applied, nil
+}
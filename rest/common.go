@@ -16,12 +16,22 @@ const notificationsLimit = 100
 func getNotifications(c echo.Context) error {
 
 	// セッションの存在チェック
-	session, err := db.CheckSession(c, true, false)
+	session, err := db.CheckSession(c, true, true)
 	if err != nil {
-		return c.JSON(403, commonError.noSession)
+		return c.JSON(403, sessionError(err))
 	}
 
-	dbNotifications, tx := db.GetNotifications(session.UserId, notificationsLimit)
+	// 続きを取得する場合は、最後に受け取った通知IDを指定する
+	var cursor uint
+	if c.QueryParam("cursor") != "" {
+		nid, err := strconv.ParseUint(c.QueryParam("cursor"), 10, 32)
+		if err != nil {
+			return c.JSON(400, MakeError("gnts-002", "指定されたIDが不正です"))
+		}
+		cursor = uint(nid)
+	}
+
+	dbNotifications, tx := db.GetNotifications(c.Request().Context(), session.UserId, cursor, notificationsLimit)
 	if tx.Error != nil {
 		return c.JSON(400, MakeError("gnts-001", "通知情報が取得できません"))
 	}
@@ -44,12 +54,12 @@ func getNotifications(c echo.Context) error {
 func getNotificationsCount(c echo.Context) error {
 
 	// セッションの存在チェック
-	session, err := db.CheckSession(c, true, false)
+	session, err := db.CheckSession(c, true, true)
 	if err != nil {
-		return c.JSON(403, commonError.noSession)
+		return c.JSON(403, sessionError(err))
 	}
 
-	cnt, tx := db.GetNotificationsCount(session.UserId, notificationsLimit)
+	cnt, tx := db.GetNotificationsCount(c.Request().Context(), session.UserId, notificationsLimit)
 	if tx.Error != nil {
 		return c.JSON(400, MakeError("gntc-001", "通知情報の数が取得できません"))
 	}
@@ -61,9 +71,9 @@ func getNotificationsCount(c echo.Context) error {
 
 func updateNotificationRead(c echo.Context) error {
 	// セッションの存在チェック
-	session, err := db.CheckSession(c, true, false)
+	session, err := db.CheckSession(c, true, true)
 	if err != nil {
-		return c.JSON(403, commonError.noSession)
+		return c.JSON(403, sessionError(err))
 	}
 
 	// Bodyの読み取り
@@ -88,3 +98,43 @@ func updateNotificationRead(c echo.Context) error {
 	}
 	return c.NoContent(204)
 }
+
+// 指定した通知ID以下の通知をすべて既読にする
+func updateNotificationReadAll(c echo.Context) error {
+	// セッションの存在チェック
+	session, err := db.CheckSession(c, true, true)
+	if err != nil {
+		return c.JSON(403, sessionError(err))
+	}
+
+	nid, err := strconv.Atoi(c.Param("nid"))
+	if err != nil || nid < 0 {
+		return c.JSON(400, MakeError("unta-001", "指定されたIDが不正です"))
+	}
+
+	_, err = db.UpdateNotificationReadAll(session.UserId, uint(nid))
+	if err != nil {
+		return c.JSON(400, MakeError("unta-002", "通知既読状態の更新に失敗しました"))
+	}
+	return c.NoContent(204)
+}
+
+// 通知を自分の一覧から非表示にする
+func deleteNotification(c echo.Context) error {
+	// セッションの存在チェック
+	session, err := db.CheckSession(c, true, true)
+	if err != nil {
+		return c.JSON(403, sessionError(err))
+	}
+
+	nid, err := strconv.Atoi(c.Param("nid"))
+	if err != nil || nid < 0 {
+		return c.JSON(400, MakeError("dntf-001", "指定されたIDが不正です"))
+	}
+
+	err = db.HideNotification(session.UserId, uint(nid))
+	if err != nil {
+		return c.JSON(400, MakeError("dntf-002", "通知の非表示に失敗しました"))
+	}
+	return c.NoContent(204)
+}
@@ -0,0 +1,78 @@
+package rest
+
+import (
+	"reviewmakerback/config"
+	"reviewmakerback/mail"
+)
+
+// ユーザーが投稿した画像の保存先
+var filePath string
+
+// 準備完了とみなす画像の保存先の空き容量(バイト)
+var minFreeBytes uint64
+
+// ユーザーごとに保存できる画像の合計サイズ(バイト)
+var storageQuotaBytes int64
+
+// 確認メール等の送信手段(SMTPが設定されていなければnil)
+var mailTransport mail.Transport
+
+// メールの送信元
+var mailFrom string
+
+// メールに記載するAPIのURL
+var serverUrl string
+
+// 設定からバリデーションの制限値と取得件数を読み込む
+// Routeより前に呼び出すこと
+func Configure(conf config.Config) {
+	filePath = conf.Server.FilePath
+	minFreeBytes = uint64(conf.Server.MinFreeMb) * 1024 * 1024
+	storageQuotaBytes = int64(conf.Limits.User.StorageQuotaMb) * 1024 * 1024
+	metricsToken = conf.Metrics.Token
+
+	mailTransport = nil
+	if transport, ok := mail.NewSmtpTransport(conf.Smtp); ok {
+		mailTransport = transport
+	}
+	mailFrom = conf.Smtp.From
+	serverUrl = conf.Server.Url
+
+	limits := conf.Limits
+	postPageSize = limits.PostPageSize
+	ReviewMaxInTier = limits.ReviewMaxInTier
+	latestPostMax = limits.LatestPostMax
+
+	userValidation = UserValidation{
+		nameLenMax:    limits.User.NameLenMax,
+		profileLenMax: limits.User.ProfileLenMax,
+		iconMaxBytes:  limits.User.IconMaxBytes,
+	}
+	tierValidation = TierValidation{
+		nameLenMax:      limits.Tier.NameLenMax,
+		paramsLenMax:    limits.Tier.ParamsLenMax,
+		paramNameLenMax: limits.Tier.ParamNameLenMax,
+		imgMaxBytes:     limits.Tier.ImgMaxBytes,
+		imgMaxEdge:      limits.Tier.ImgMaxEdge,
+		imgAspectRate:   limits.Tier.ImgAspectRate,
+	}
+	reviewValidation = ReviewValidation{
+		nameLenMax:       limits.Review.NameLenMax,
+		titleLenMax:      limits.Review.TitleLenMax,
+		sectionLenMax:    limits.Review.SectionLenMax,
+		factorInfoLenMax: limits.Review.FactorInfoLenMax,
+		iconMaxBytes:     limits.Review.IconMaxBytes,
+		iconMaxEdge:      limits.Review.IconMaxEdge,
+		iconAspectRate:   limits.Review.IconAspectRate,
+	}
+	sectionValidation = SectionValidation{
+		sectionTitleLen:   limits.Section.SectionTitleLen,
+		paragTextLenMax:   limits.Section.ParagTextLenMax,
+		paragsLenMax:      limits.Section.ParagsLenMax,
+		paragLinkLenMax:   limits.Section.ParagLinkLenMax,
+		paragImgMaxBytes:  limits.Section.ParagImgMaxBytes,
+		paragImgAspect:    limits.Section.ParagImgAspect,
+		paragImgMax:       limits.Section.ParagImgMax,
+		paragImageQuality: limits.Section.ParagImageQuality,
+	}
+}
@@ -1,5 +1,7 @@
 package rest
 
+import "encoding/json"
+
 type ErrorResponse struct {
 	/*
 		エラーコード
@@ -17,6 +19,8 @@ type ErrorResponse struct {
 	Code string `json:"code"`
 	// エラーメッセージ
 	Message string `json:"message"`
+	// リクエストID(RequestIdミドルウェアが追加する)
+	RequestId string `json:"requestId,omitempty"`
 }
 
 type UserCreatingData struct {
@@ -58,8 +62,14 @@ type SelfUserData struct {
 	TwitterId        string `json:"twitterId"`        // TwitterID(自分自身でのログイン時およびTwitter連携を許可した時のみ開示)
 	TwitterUserName  string `json:"twitterUserName"`  // Twitter@名(自分自身でのログイン時のみ開示)
 	GoogleEmail      string `json:"googleEmail"`      // Google Mailアドレス(自分自身でのログイン時のみ開示)
+	OidcEmail        string `json:"oidcEmail"`        // OIDC Mailアドレス(自分自身でのログイン時のみ開示)
+	Role             string `json:"role"`             // 権限(自分自身でのログイン時のみ開示)
+	SuspensionState  string `json:"suspensionState"`  // 利用停止状態(自分自身でのログイン時のみ開示)
+	SuspendedUntil   string `json:"suspendedUntil"`   // 一時的な利用停止の終了日時(自分自身でのログイン時のみ開示)
 	ReviewsCount     int64  `json:"reviewsCount"`     // 今までに投稿したレビュー数
 	TiersCount       int64  `json:"tiersCount"`       // 今までに投稿したTier数
+	StorageUsed      int64  `json:"storageUsed"`      // 保存済みの画像の合計バイト数(自分自身でのログイン時のみ開示)
+	StorageQuota     int64  `json:"storageQuota"`     // 保存できる画像の合計バイト数の上限(自分自身でのログイン時のみ開示)
 }
 
 type TierData struct {
@@ -75,6 +85,7 @@ type TierData struct {
 	ReviewFactorParams []ReviewParamData `json:"reviewFactorParams"`
 	PullingUp          int               `json:"pullingUp"`
 	PullingDown        int               `json:"pullingDown"`
+	IsHidden           bool              `json:"isHidden"`
 	CreatedAt          string            `json:"createdAt"`
 	UpdatedAt          string            `json:"updatedAt"`
 }
@@ -91,6 +102,7 @@ type ReviewData struct {
 	ReviewFactors []ReviewFactorData `json:"reviewFactors"`
 	PointType     string             `json:"pointType"`
 	Sections      []SectionData      `json:"sections"`
+	IsHidden      bool               `json:"isHidden"`
 	CreatedAt     string             `json:"createdAt"`
 	UpdatedAt     string             `json:"updatedAt"`
 }
@@ -189,3 +201,231 @@ type CountData struct {
 type NotificationReadData struct {
 	IsRead bool `json:"isRead"`
 }
+
+type WebhookEditingData struct {
+	Url    string   `json:"url"`    // 送信先のURL
+	Secret string   `json:"secret"` // 署名に用いるシークレット
+	Events []string `json:"events"` // 送信対象のイベント
+}
+
+type WebhookData struct {
+	WebhookId string   `json:"webhookId"`
+	Url       string   `json:"url"`
+	Events    []string `json:"events"`
+	IsActive  bool     `json:"isActive"`
+	CreatedAt string   `json:"createdAt"`
+	UpdatedAt string   `json:"updatedAt"`
+}
+
+type WebhookDeliveryData struct {
+	Id            uint   `json:"id"`
+	Event         string `json:"event"`
+	Payload       string `json:"payload"`
+	Status        string `json:"status"`
+	Attempts      int    `json:"attempts"`
+	StatusCode    int    `json:"statusCode"`
+	LastError     string `json:"lastError"`
+	NextAttemptAt string `json:"nextAttemptAt"`
+	CreatedAt     string `json:"createdAt"`
+	UpdatedAt     string `json:"updatedAt"`
+}
+
+type DigestSettingData struct {
+	Email        string `json:"email"`        // 送信先メールアドレス(空文字列ならGoogleのメールアドレス 変更した場合は確認メールのURLにアクセスするまで反映しない)
+	Frequency    string `json:"frequency"`    // 送信頻度(none, daily, weekly)
+	PendingEmail string `json:"pendingEmail"` // 確認待ちの送信先メールアドレス(取得時のみ)
+}
+
+type SessionInfoData struct {
+	Id           string `json:"id"`           // セッションの識別子(セッションIDそのものではない)
+	IsCurrent    bool   `json:"isCurrent"`    // リクエスト元のセッションかどうか
+	LoginService string `json:"loginService"` // ログインに使用したサービス
+	IpAddress    string `json:"ipAddress"`    // 直近のアクセス元IPアドレス
+	UserAgent    string `json:"userAgent"`    // 直近のアクセス元ユーザーエージェント
+	CreatedAt    string `json:"createdAt"`    // ログインした日時
+	LastSeenAt   string `json:"lastSeenAt"`   // 直近のアクセス日時
+	ExpiredTime  string `json:"expiredTime"`  // セッションの有効期限
+}
+
+type RefreshTokenData struct {
+	RefreshToken string `json:"refreshToken"` // リフレッシュトークン
+	ExpiredTime  string `json:"expiredTime"`  // リフレッシュトークンの有効期限(ログインの絶対的な有効期限)
+}
+
+type RefreshedSessionData struct {
+	SessionId          string `json:"sessionId"`          // 新しいセッションID
+	ExpiredTime        string `json:"expiredTime"`        // 新しいセッションの有効期限
+	RefreshToken       string `json:"refreshToken"`       // 新しいリフレッシュトークン
+	RefreshExpiredTime string `json:"refreshExpiredTime"` // リフレッシュトークンの有効期限
+}
+
+type HiddenData struct {
+	IsHidden bool `json:"isHidden"` // 非表示にするかどうか
+}
+
+type RoleData struct {
+	Role string `json:"role"` // 権限(user, moderator, admin)
+}
+
+type AdminUserData struct {
+	UserId          string `json:"userId"`          // ユーザーID
+	Name            string `json:"name"`            // 登録名
+	IconUrl         string `json:"iconUrl"`         // アイコンURL
+	Role            string `json:"role"`            // 権限
+	SuspensionState string `json:"suspensionState"` // 利用停止状態
+	SuspendedUntil  string `json:"suspendedUntil"`  // 一時的な利用停止の終了日時
+	CreatedAt       string `json:"createdAt"`       // 登録日時
+}
+
+type ReportEditingData struct {
+	TargetType string `json:"targetType"` // 通報対象の種類(tier, review, user)
+	TargetId   string `json:"targetId"`   // 通報対象のID
+	Reason     string `json:"reason"`     // 通報理由の分類
+	Comment    string `json:"comment"`    // 通報理由の詳細
+}
+
+type ReportData struct {
+	Id         uint   `json:"id"`         // 通報ID
+	ReporterId string `json:"reporterId"` // 通報したユーザーのID
+	Reason     string `json:"reason"`     // 通報理由の分類
+	Comment    string `json:"comment"`    // 通報理由の詳細
+	CreatedAt  string `json:"createdAt"`  // 通報日時
+}
+
+type ReportGroupData struct {
+	TargetType      string         `json:"targetType"`      // 通報対象の種類
+	TargetId        string         `json:"targetId"`        // 通報対象のID
+	TargetUserId    string         `json:"targetUserId"`    // 通報対象の作成ユーザーのID
+	ReportCount     int64          `json:"reportCount"`     // 未対応の通報数
+	Reasons         map[string]int `json:"reasons"`         // 通報理由ごとの件数
+	Reports         []ReportData   `json:"reports"`         // 未対応の通報(新しい順)
+	FirstReportedAt string         `json:"firstReportedAt"` // 最初の通報日時
+	LastReportedAt  string         `json:"lastReportedAt"`  // 最新の通報日時
+}
+
+type ReportResolvingData struct {
+	Action      string `json:"action"`      // 対応方法(dismiss, hide, suspend)
+	SuspendDays int    `json:"suspendDays"` // 利用停止日数(suspendのみ, 0なら無期限)
+	Note        string `json:"note"`        // 対応メモ
+}
+
+type SuspensionEditingData struct {
+	State  string `json:"state"`  // 利用停止状態(none, temporary, permanent)
+	Days   int    `json:"days"`   // 利用停止日数(temporaryのみ)
+	Reason string `json:"reason"` // 変更理由
+}
+
+type SuspensionLogData struct {
+	State      string `json:"state"`      // 変更後の利用停止状態
+	Until      string `json:"until"`      // 一時的な利用停止の終了日時
+	Reason     string `json:"reason"`     // 変更理由
+	OperatorId string `json:"operatorId"` // 変更したユーザーのID
+	CreatedAt  string `json:"createdAt"`  // 変更日時
+}
+
+type ProviderLinkCodeData struct {
+	Code        string `json:"code"`        // 連携サービス追加用のコード
+	ExpiredTime string `json:"expiredTime"` // コードの有効期限
+}
+
+type ProviderLinkingData struct {
+	Code  string `json:"code"`  // 連携サービス追加用のコード
+	Merge bool   `json:"merge"` // 追加するサービスが別のユーザーに登録されている場合、そのユーザーを統合する
+}
+
+type ProviderData struct {
+	UserId          string   `json:"userId"`          // ユーザーID
+	Services        []string `json:"services"`        // 連携されているサービス
+	TwitterUserName string   `json:"twitterUserName"` // Twitter@名
+	GoogleEmail     string   `json:"googleEmail"`     // Google Mailアドレス
+	OidcEmail       string   `json:"oidcEmail"`       // OIDC Mailアドレス
+	MergedUserId    string   `json:"mergedUserId"`    // 統合して削除したユーザーのID(統合した場合のみ)
+}
+
+type HealthData struct {
+	Status     string                     `json:"status"`               // ok, error
+	Components map[string]ComponentHealth `json:"components,omitempty"` // 構成要素ごとの状態
+}
+
+type ComponentHealth struct {
+	Status string `json:"status"`           // ok, error
+	Detail string `json:"detail,omitempty"` // 状態の詳細
+}
+
+type AdminOperationLogData struct {
+	Id           uint64 `json:"id"`           // ログID
+	UserId       string `json:"userId"`       // 操作したユーザーのID
+	TargetUserId string `json:"targetUserId"` // 権限による操作の対象となったユーザーのID
+	IpAddress    string `json:"ipAddress"`    // 操作元のIPアドレス
+	RequestId    string `json:"requestId"`    // 操作を行ったリクエストのID
+	Operation    string `json:"operation"`    // 操作コード
+	Content      string `json:"content"`      // 操作内容
+	CreatedAt    string `json:"createdAt"`    // 操作日時
+}
+
+type AdminErrorLogData struct {
+	Id           uint64 `json:"id"`           // ログID
+	UserId       string `json:"userId"`       // ユーザーのID
+	IpAddress    string `json:"ipAddress"`    // リクエスト元のIPアドレス
+	RequestId    string `json:"requestId"`    // エラーが発生したリクエストのID
+	ErrorId      string `json:"errorId"`      // エラーID
+	Operation    string `json:"operation"`    // 操作内容
+	Descriptions string `json:"descriptions"` // 詳細
+	CreatedAt    string `json:"createdAt"`    // 発生日時
+}
+
+type ActivityData struct {
+	Id           uint64 `json:"id"`           // ログID(続きを取得する際のcursor)
+	Operation    string `json:"operation"`    // 操作コード
+	IpAddress    string `json:"ipAddress"`    // 操作元のIPアドレス(権限による操作の場合は空)
+	IsPrivileged bool   `json:"isPrivileged"` // 管理者・モデレーターによる操作かどうか
+	CreatedAt    string `json:"createdAt"`    // 操作日時
+}
+
+type JobsData struct {
+	IsLeader bool            `json:"isLeader"` // このインスタンスが定期処理の実行を担当しているかどうか
+	Jobs     []JobStatusData `json:"jobs"`     // 定期処理の実行状況(名前順)
+}
+
+type JobStatusData struct {
+	Name       string `json:"name"`       // 定期処理の名前
+	Schedule   string `json:"schedule"`   // スケジュール
+	LastRun    string `json:"lastRun"`    // 最後に実行を開始した日時(未実行なら空)
+	DurationMs int64  `json:"durationMs"` // 最後の実行にかかった時間(ミリ秒)
+	LastError  string `json:"lastError"`  // 最後の実行で発生したエラー(成功した場合は空)
+	NextRun    string `json:"nextRun"`    // 次の実行予定日時
+	IsRunning  bool   `json:"isRunning"`  // 実行中かどうか
+	IsSkipped  bool   `json:"isSkipped"`  // 他のインスタンスが担当しているため最後の予定日時に実行しなかったかどうか
+	IsStale    bool   `json:"isStale"`    // 予定の実行日時を大きく過ぎても実行されていないかどうか
+}
+
+type QueueJobData struct {
+	Id          uint64          `json:"id"`               // ジョブID
+	Kind        string          `json:"kind"`             // ジョブの種類(image.resize, user.export, webhook.deliver)
+	Status      string          `json:"status"`           // 状態(queued, running, succeeded, dead)
+	Attempts    int             `json:"attempts"`         // 実行を試行した回数
+	MaxAttempts int             `json:"maxAttempts"`      // 試行回数の上限
+	LastError   string          `json:"lastError"`        // 直近の実行で発生したエラー
+	Result      json.RawMessage `json:"result,omitempty"` // 実行結果(成功した場合のみ)
+	CreatedAt   string          `json:"createdAt"`        // 登録日時
+	FinishedAt  string          `json:"finishedAt"`       // 成功またはdeadになった日時(未完了なら空)
+}
+
+type AdminQueueJobData struct {
+	QueueJobData
+	UserId  string `json:"userId"`  // ジョブを登録したユーザーのID
+	Payload string `json:"payload"` // ジョブの入力(JSON)
+	RunAt   string `json:"runAt"`   // 次に実行する日時
+}
+
+type ExportJobData struct {
+	JobId uint64 `json:"jobId"` // エクスポートのジョブID(/jobs/:jidで状態を確認する)
+}
+
+type AdminStorageUsageData struct {
+	UserId     string `json:"userId"`     // ユーザーID
+	Name       string `json:"name"`       // 登録名(削除済みのユーザーは空文字列)
+	UsedBytes  int64  `json:"usedBytes"`  // 保存済みの画像の合計バイト数
+	QuotaBytes int64  `json:"quotaBytes"` // 保存できる画像の合計バイト数の上限
+	UpdatedAt  string `json:"updatedAt"`  // 容量の更新日時
+}
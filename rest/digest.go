@@ -0,0 +1,214 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"github.com/labstack/echo"
+
+	"reviewmakerback/common"
+	db "reviewmakerback/db"
+	"reviewmakerback/mail"
+)
+
+// メールアドレスの最大文字数
+const digestEmailLenMax = 254
+
+// 通知ダイジェストの設定を取得する
+func getReqDigestSetting(c echo.Context) error {
+	uid := c.Param("uid")
+
+	// セッションの存在チェック
+	session, err := db.CheckSession(c, true, true)
+	if err != nil {
+		return c.JSON(403, sessionError(err))
+	}
+
+	// 参照ユーザーとセッションのユーザーチェック
+	if session.UserId != uid {
+		return c.JSON(403, commonError.userNotEqual)
+	}
+
+	var cnt int64
+	user, tx := db.GetUser(c.Request().Context(), uid, "digest_email, digest_frequency, digest_pending_email, digest_confirm_sent_at")
+	tx.Count(&cnt)
+	if cnt != 1 {
+		return c.JSON(404, MakeError("gdgs-001", "ユーザーが存在しません"))
+	}
+
+	data := DigestSettingData{
+		Email:     user.DigestEmail,
+		Frequency: user.DigestFrequency,
+	}
+	if user.DigestPendingEmail != "" && time.Since(user.DigestConfirmSentAt) < db.DigestConfirmExpire {
+		data.PendingEmail = user.DigestPendingEmail
+	}
+	return c.JSON(200, data)
+}
+
+// 通知ダイジェストの設定を更新する
+func updateReqDigestSetting(c echo.Context) error {
+	uid := c.Param("uid")
+
+	// セッションの存在チェック
+	session, err := db.CheckSession(c, true, true)
+	if err != nil {
+		return c.JSON(403, sessionError(err))
+	}
+
+	// 編集ユーザーとセッションのユーザーチェック
+	if session.UserId != uid {
+		return c.JSON(403, commonError.userNotEqual)
+	}
+
+	// Bodyの読み取り
+	b, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return c.JSON(400, commonError.unreadableBody)
+	}
+	var digestData DigestSettingData
+	err = json.Unmarshal(b, &digestData)
+	if err != nil {
+		return c.JSON(400, commonError.unreadableBody)
+	}
+
+	// バリデーションチェック
+	if !common.Contains(digestData.Frequency, db.DigestFrequencies) {
+		return c.JSON(400, MakeError("udgs-001", "送信頻度が異常です"))
+	}
+	f, er := validText("メールアドレス", "udgs-002", digestData.Email, false, -1, digestEmailLenMax, `^([^@\s<>"]+@[^@\s<>"]+\.[^@\s<>"]+)?$`, "正しい形式")
+	if !f {
+		return c.JSON(400, er)
+	}
+
+	var cnt int64
+	user, tx := db.GetUser(c.Request().Context(), uid, "user_id, name, google_email, digest_email, digest_token, digest_pending_email, digest_confirm_sent_at")
+	tx.Count(&cnt)
+	if cnt != 1 {
+		return c.JSON(404, MakeError("udgs-003", "ユーザーが存在しません"))
+	}
+
+	if digestData.Frequency != "none" && digestData.Email == "" && user.GoogleEmail == "" {
+		return c.JSON(400, MakeError("udgs-004", "送信先のメールアドレスを指定してください"))
+	}
+
+	// 配信停止用トークンは初回のみ発行する
+	token := user.DigestToken
+	if token == "" {
+		token, err = common.MakeRandomChars(64, uid)
+		if err != nil {
+			return c.JSON(400, MakeError("udgs-005", "通知ダイジェストの設定に失敗しました"))
+		}
+	}
+
+	requestIp := net.ParseIP(c.RealIP()).String()
+	now := time.Now()
+
+	// 確認済みの送信先から変更する場合は、確認メールのURLにアクセスするまで送信先を変えない
+	email := digestData.Email
+	confirming := email != "" && email != user.DigestEmail
+	if confirming {
+		email = user.DigestEmail
+		pending := user.DigestPendingEmail != "" && now.Sub(user.DigestConfirmSentAt) < db.DigestConfirmExpire
+		if !pending || user.DigestPendingEmail != digestData.Email {
+			if mailTransport == nil {
+				return c.JSON(400, MakeError("udgs-007", "メールを送信できないため、送信先のメールアドレスを変更できません"))
+			}
+			if now.Sub(user.DigestConfirmSentAt) < db.DigestConfirmResendSpan {
+				return c.JSON(429, MakeError("udgs-008", fmt.Sprintf("確認メールは%d分以上あけて送信してください", int(db.DigestConfirmResendSpan/time.Minute))))
+			}
+			er := sendDigestConfirmMail(c.Request().Context(), user, digestData.Email, requestIp, now)
+			if er != nil {
+				return c.JSON(400, er)
+			}
+		}
+	} else {
+		err = db.ClearPendingDigestEmail(uid)
+		if err != nil {
+			db.WriteErrorLogContext(c.Request().Context(), uid, requestIp, "udgs-006", "通知ダイジェストの設定に失敗しました", err.Error())
+			return c.JSON(400, MakeError("udgs-006", "通知ダイジェストの設定に失敗しました"))
+		}
+	}
+
+	err = db.UpdateDigestSetting(uid, email, digestData.Frequency, token)
+	if err != nil {
+		db.WriteErrorLogContext(c.Request().Context(), uid, requestIp, "udgs-006", "通知ダイジェストの設定に失敗しました", err.Error())
+		return c.JSON(400, MakeError("udgs-006", "通知ダイジェストの設定に失敗しました"))
+	}
+
+	db.WriteOperationLogContext(c.Request().Context(), uid, requestIp, "udgs", digestData.Frequency)
+	if confirming {
+		// 送信先の変更は確認待ち
+		return c.NoContent(202)
+	}
+	return c.NoContent(204)
+}
+
+// 確認待ちの送信先に、送信先を確定するためのURLを記載したメールを送信する
+func sendDigestConfirmMail(ctx context.Context, user db.User, email string, requestIp string, now time.Time) *ErrorResponse {
+	confirmToken, err := common.MakeRandomChars(64, user.UserId+email)
+	if err != nil {
+		return MakeError("udgs-009", "確認メールの送信に失敗しました")
+	}
+	msg, err := mail.BuildDigestConfirmMessage(mailFrom, email, mail.DigestConfirmData{
+		UserName:    user.Name,
+		ConfirmUrl:  fmt.Sprintf("%s/digest/confirm/%s", serverUrl, confirmToken),
+		ExpireHours: int(db.DigestConfirmExpire / time.Hour),
+	})
+	if err != nil {
+		return MakeError("udgs-009", "確認メールの送信に失敗しました")
+	}
+
+	// 送信前に記録し、送信に失敗した場合も続けて送信できないようにする
+	err = db.SetPendingDigestEmail(user.UserId, email, confirmToken, now)
+	if err != nil {
+		db.WriteErrorLogContext(ctx, user.UserId, requestIp, "udgs-006", "通知ダイジェストの設定に失敗しました", err.Error())
+		return MakeError("udgs-006", "通知ダイジェストの設定に失敗しました")
+	}
+	err = mailTransport.Send(mailFrom, []string{email}, msg)
+	if err != nil {
+		db.WriteErrorLogContext(ctx, user.UserId, requestIp, "udgs-009", "確認メールの送信に失敗しました", err.Error())
+		return MakeError("udgs-009", "確認メールの送信に失敗しました")
+	}
+	return nil
+}
+
+// メールに記載したリンクから通知ダイジェストの送信先を確定する
+func getReqConfirmDigest(c echo.Context) error {
+	token := c.Param("token")
+
+	if !common.TestRegexp(`^[a-zA-Z0-9]+$`, token) {
+		return c.String(400, "確認用のURLが正しくありません")
+	}
+
+	f, err := db.ConfirmDigestEmail(token, time.Now())
+	if err != nil {
+		db.WriteErrorLogContext(c.Request().Context(), "", net.ParseIP(c.RealIP()).String(), "gdgc-001", "通知ダイジェストの送信先の確認に失敗しました", err.Error())
+		return c.String(400, "確認に失敗しました しばらく時間を空けてもう一度実行してください")
+	} else if !f {
+		return c.String(404, "確認用のURLが正しくないか、有効期限が切れています")
+	}
+	return c.String(200, "お知らせダイジェストの送信先を確認しました")
+}
+
+// メールに記載したリンクから通知ダイジェストを停止する
+func getReqUnsubscribeDigest(c echo.Context) error {
+	token := c.Param("token")
+
+	if !common.TestRegexp(`^[a-zA-Z0-9]+$`, token) {
+		return c.String(400, "配信停止用のURLが正しくありません")
+	}
+
+	f, err := db.UnsubscribeDigest(token)
+	if err != nil {
+		db.WriteErrorLogContext(c.Request().Context(), "", net.ParseIP(c.RealIP()).String(), "gdgu-001", "通知ダイジェストの配信停止に失敗しました", err.Error())
+		return c.String(400, "配信停止に失敗しました しばらく時間を空けてもう一度実行してください")
+	} else if !f {
+		return c.String(404, "配信停止用のURLが正しくありません")
+	}
+	return c.String(200, "お知らせダイジェストの配信を停止しました")
+}
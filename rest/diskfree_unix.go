@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package rest
+
+import "syscall"
+
+// 指定したパスのファイルシステムで使用できる空き容量(バイト)
+func diskFreeBytes(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
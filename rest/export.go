@@ -0,0 +1,279 @@
+package rest
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+
+	"reviewmakerback/common"
+	db "reviewmakerback/db"
+	"reviewmakerback/queue"
+)
+
+// エクスポートの制限時間
+const exportJobTimeout = 10 * time.Minute
+
+// エクスポートジョブの入力
+type exportJobPayload struct {
+	UserId string `json:"userId"`
+}
+
+// エクスポートするプロフィール
+type exportProfile struct {
+	UserId    string `json:"userId"`
+	Name      string `json:"name"`
+	Profile   string `json:"profile"`
+	IconUrl   string `json:"iconUrl"`
+	CreatedAt string `json:"createdAt"`
+}
+
+// エクスポートするTier
+type exportTier struct {
+	TierId       string          `json:"tierId"`
+	Name         string          `json:"name"`
+	ImageUrl     string          `json:"imageUrl"`
+	Parags       json.RawMessage `json:"parags"`
+	PointType    string          `json:"pointType"`
+	FactorParams json.RawMessage `json:"factorParams"`
+	PullingUp    int             `json:"pullingUp"`
+	PullingDown  int             `json:"pullingDown"`
+	CreatedAt    string          `json:"createdAt"`
+	UpdatedAt    string          `json:"updatedAt"`
+}
+
+// エクスポートするレビュー
+type exportReview struct {
+	ReviewId      string          `json:"reviewId"`
+	TierId        string          `json:"tierId"`
+	Title         string          `json:"title"`
+	Name          string          `json:"name"`
+	IconUrl       string          `json:"iconUrl"`
+	ReviewFactors json.RawMessage `json:"reviewFactors"`
+	Sections      json.RawMessage `json:"sections"`
+	CreatedAt     string          `json:"createdAt"`
+	UpdatedAt     string          `json:"updatedAt"`
+}
+
+// 空文字列のJSONをnullにする
+func rawJson(s string) json.RawMessage {
+	if s == "" {
+		return json.RawMessage("null")
+	}
+	return json.RawMessage(s)
+}
+
+// ユーザーのデータのエクスポートを登録する
+// 完了したかどうかは/jobs/:jidで確認し、/user/:uid/export/:jidで受け取る
+func postReqUserExport(c echo.Context) error {
+	// セッションの存在チェック
+	session, err := db.CheckSession(c, true, true)
+	if err != nil {
+		return c.JSON(403, sessionError(err))
+	}
+
+	uid := c.Param("uid")
+	if session.UserId != uid {
+		return c.JSON(403, commonError.userNotEqual)
+	}
+	requestIp := net.ParseIP(c.RealIP()).String()
+
+	// 同時に実行できるエクスポートは一つまで
+	job, err := db.EnqueueSingleQueueJob(db.QueueKindExport, uid, exportJobPayload{UserId: uid}, db.QueueExportRetryMax)
+	if errors.Is(err, db.ErrQueueJobActive) {
+		return c.JSON(409, MakeError("pexp-002", "実行中のエクスポートがあります 完了してからもう一度実行してください"))
+	} else if err != nil {
+		db.WriteErrorLogContext(c.Request().Context(), uid, requestIp, "pexp-003", "エクスポートを登録できません", err.Error())
+		return c.JSON(400, MakeError("pexp-003", "エクスポートを登録できません"))
+	}
+	queue.Notify()
+
+	db.WriteOperationLogContext(c.Request().Context(), uid, requestIp, "pexp", strconv.FormatUint(job.Id, 10))
+	return c.JSON(202, ExportJobData{JobId: job.Id})
+}
+
+// エクスポートしたファイルを受け取る
+func getReqUserExport(c echo.Context) error {
+	// セッションの存在チェック
+	session, err := db.CheckSession(c, true, true)
+	if err != nil {
+		return c.JSON(403, sessionError(err))
+	}
+
+	uid := c.Param("uid")
+	if session.UserId != uid {
+		return c.JSON(403, commonError.userNotEqual)
+	}
+
+	jid, err := strconv.ParseUint(c.Param("jid"), 10, 64)
+	if err != nil {
+		return c.JSON(400, MakeError("gexp-001", "指定されたIDが不正です"))
+	}
+	job, err := db.GetQueueJob(jid)
+	if err != nil || job.Kind != db.QueueKindExport || job.UserId != uid {
+		return c.JSON(404, MakeError("gexp-002", "エクスポートが存在しません"))
+	}
+	if job.Status != db.QueueSucceeded {
+		return c.JSON(409, MakeError("gexp-003", "エクスポートが完了していません"))
+	}
+	if _, err := os.Stat(job.ResultFile); err != nil {
+		return c.JSON(404, MakeError("gexp-004", "エクスポートしたファイルは保存期間を過ぎたため削除されました"))
+	}
+	return c.Attachment(job.ResultFile, fmt.Sprintf("export-%d.zip", jid))
+}
+
+// ユーザーのプロフィール・Tier・レビューと投稿した画像をzipにまとめる
+func exportUserJob(ctx context.Context, job queue.Job) (queue.Result, error) {
+	var payload exportJobPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil || !common.TestRegexp(`^[a-zA-Z0-9]+$`, payload.UserId) {
+		return queue.Result{}, queue.Permanent(errors.New("ジョブの入力が不正です"))
+	}
+	uid := payload.UserId
+
+	user, tx := db.GetUser(ctx, uid, "*")
+	if tx.Error != nil {
+		return queue.Result{}, tx.Error
+	}
+	if tx.RowsAffected != 1 {
+		// エクスポート前にユーザーが削除された
+		return queue.Result{}, queue.Permanent(errors.New("ユーザーが存在しません"))
+	}
+	tiers, err := db.GetAllTiersInUser(uid)
+	if err != nil {
+		return queue.Result{}, err
+	}
+	reviews, err := db.GetAllReviewsInUser(uid)
+	if err != nil {
+		return queue.Result{}, err
+	}
+
+	dir := fmt.Sprintf("%s/.exports/%s", filePath, uid)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return queue.Result{}, err
+	}
+	path := fmt.Sprintf("%s/%d.zip", dir, job.Id)
+	tmp := path + ".tmp"
+
+	err = writeExportZip(ctx, tmp, user, tiers, reviews)
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return queue.Result{}, err
+	}
+
+	db.WriteOperationLog(uid, "none", "uexp", strconv.FormatUint(job.Id, 10))
+	b, _ := json.Marshal(map[string]interface{}{"tiers": len(tiers), "reviews": len(reviews)})
+	return queue.Result{Data: string(b), File: path}, nil
+}
+
+func writeExportZip(ctx context.Context, path string, user db.User, tiers []db.Tier, reviews []db.Review) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	w := zip.NewWriter(out)
+
+	profile := exportProfile{
+		UserId:    user.UserId,
+		Name:      user.Name,
+		Profile:   user.Profile,
+		IconUrl:   user.IconUrl,
+		CreatedAt: common.DateToString(user.CreatedAt),
+	}
+	tierList := make([]exportTier, len(tiers))
+	for i, tier := range tiers {
+		tierList[i] = exportTier{
+			TierId:       tier.TierId,
+			Name:         tier.Name,
+			ImageUrl:     tier.ImageUrl,
+			Parags:       rawJson(tier.Parags),
+			PointType:    tier.PointType,
+			FactorParams: rawJson(tier.FactorParams),
+			PullingUp:    tier.PullingUp,
+			PullingDown:  tier.PullingDown,
+			CreatedAt:    common.DateToString(tier.CreatedAt),
+			UpdatedAt:    common.DateToString(tier.UpdatedAt),
+		}
+	}
+	reviewList := make([]exportReview, len(reviews))
+	for i, review := range reviews {
+		reviewList[i] = exportReview{
+			ReviewId:      review.ReviewId,
+			TierId:        review.TierId,
+			Title:         review.Title,
+			Name:          review.Name,
+			IconUrl:       review.IconUrl,
+			ReviewFactors: rawJson(review.ReviewFactors),
+			Sections:      rawJson(review.Sections),
+			CreatedAt:     common.DateToString(review.CreatedAt),
+			UpdatedAt:     common.DateToString(review.UpdatedAt),
+		}
+	}
+
+	for _, entry := range []struct {
+		name string
+		data interface{}
+	}{{"profile.json", profile}, {"tiers.json", tierList}, {"reviews.json", reviewList}} {
+		f, err := w.Create(entry.name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(entry.data); err != nil {
+			return err
+		}
+	}
+
+	// 投稿した画像はデータベースに保存したパスのままfiles/以下に入れる
+	root := filePath + "/" + user.UserId
+	err = filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if info.IsDir() || filepath.Ext(p) != ".jpg" {
+			return nil
+		}
+		rel, err := filepath.Rel(filePath, p)
+		if err != nil {
+			return err
+		}
+		f, err := w.Create("files/" + filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+		in, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		_, err = io.Copy(f, in)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return out.Sync()
+}
@@ -0,0 +1,112 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+
+	db "reviewmakerback/db"
+	"reviewmakerback/ontime"
+)
+
+// 準備状態の確認でデータベースの応答を待つ時間
+const readyDbTimeout = 2 * time.Second
+
+// 死活監視・メトリクス収集のパス(レート制限やアクセスログの対象外)
+var probePaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+	"/metrics": true,
+}
+
+// 死活監視・メトリクス収集のパスかどうか
+func isProbePath(path string) bool {
+	return probePaths[path]
+}
+
+// プロセスが応答できるかどうかを返す(liveness)
+// 外部の依存先は確認しない
+func getReqHealthz(c echo.Context) error {
+	return c.JSON(200, HealthData{Status: "ok"})
+}
+
+// リクエストを処理できる状態かどうかを返す(readiness)
+// 一つでも問題がある構成要素があれば503を返す
+func getReqReadyz(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(c.Request().Context(), readyDbTimeout)
+	defer cancel()
+
+	components := map[string]ComponentHealth{}
+
+	dbErr := db.PingDb(ctx)
+	components["database"] = componentHealth(dbErr)
+	if dbErr == nil {
+		components["migration"] = componentHealth(db.MigrationStatus())
+	} else {
+		components["migration"] = componentHealth(errors.New("データベースに接続できないため確認できません"))
+	}
+	components["storage"] = componentHealth(checkStorage(filePath, minFreeBytes))
+	components["ontime"] = componentHealth(checkOntime(time.Now()))
+
+	data := HealthData{Status: "ok", Components: components}
+	for _, component := range components {
+		if component.Status != "ok" {
+			data.Status = "error"
+			return c.JSON(503, data)
+		}
+	}
+	return c.JSON(200, data)
+}
+
+func componentHealth(err error) ComponentHealth {
+	if err != nil {
+		return ComponentHealth{Status: "error", Detail: err.Error()}
+	}
+	return ComponentHealth{Status: "ok"}
+}
+
+// 画像の保存先に書き込めて、空き容量が十分あるかどうかを確認する
+func checkStorage(path string, minFree uint64) error {
+	if path == "" {
+		return errors.New("画像の保存先が設定されていません")
+	}
+	file, err := ioutil.TempFile(path, ".readyz-")
+	if err != nil {
+		return errors.New("画像の保存先に書き込めません")
+	}
+	file.Close()
+	os.Remove(file.Name())
+
+	free, err := diskFreeBytes(path)
+	if err != nil {
+		return errors.New("画像の保存先の空き容量が取得できません")
+	}
+	if free < minFree {
+		return fmt.Errorf("画像の保存先の空き容量が不足しています(残り%dMB)", free/1024/1024)
+	}
+	return nil
+}
+
+// 定期処理が起動しており、周期通りに周回しているかどうかを確認する
+func checkOntime(now time.Time) error {
+	statuses := ontime.Statuses(now)
+	if len(statuses) == 0 {
+		return errors.New("定期処理が起動していません")
+	}
+	var stale []string
+	for _, status := range statuses {
+		if status.Stale {
+			stale = append(stale, fmt.Sprintf("%s(実行予定%s)", status.Name, status.NextRun.Format(time.RFC3339)))
+		}
+	}
+	if len(stale) > 0 {
+		return fmt.Errorf("定期処理が停止しています: %s", strings.Join(stale, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package rest
+
+import (
+	"time"
+
+	"github.com/labstack/echo"
+
+	"reviewmakerback/common"
+	"reviewmakerback/ontime"
+)
+
+// 日時がゼロ値なら空文字列にする
+func optionalDateToString(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return common.DateToString(t)
+}
+
+// このインスタンスの定期処理の実行状況を取得する(管理者のみ)
+func getReqAdminJobs(c echo.Context) error {
+	statuses := ontime.Statuses(time.Now())
+
+	jobs := make([]JobStatusData, len(statuses))
+	for i, status := range statuses {
+		jobs[i] = JobStatusData{
+			Name:       status.Name,
+			Schedule:   status.Schedule,
+			LastRun:    optionalDateToString(status.LastRun),
+			DurationMs: status.Duration.Milliseconds(),
+			LastError:  status.LastError,
+			NextRun:    optionalDateToString(status.NextRun),
+			IsRunning:  status.Running,
+			IsSkipped:  status.Skipped,
+			IsStale:    status.Stale,
+		}
+	}
+	return c.JSON(200, JobsData{
+		IsLeader: ontime.IsLeader(),
+		Jobs:     jobs,
+	})
+}
@@ -0,0 +1,165 @@
+package rest
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+
+	"reviewmakerback/common"
+	db "reviewmakerback/db"
+)
+
+// 管理画面で一度に取得可能なログ数
+const adminLogsPageSize = 100
+
+// 一度に取得可能なアカウントの操作履歴数
+const activitiesLimit = 50
+
+// 検索条件のクエリパラメーターを読み取る
+// codeRegはcodeの形式(操作ログとエラーログで異なる)
+// 問題があればerrorCodeのエラーを返す
+func readLogFilter(c echo.Context, codeReg string, errorCode string) (db.LogFilter, int, *ErrorResponse) {
+	var filter db.LogFilter
+
+	page, err := strconv.Atoi(c.QueryParam("page"))
+	if err != nil || page < 1 {
+		return filter, 0, MakeError(errorCode, "ページ指定が異常です")
+	}
+
+	filter.UserId = c.QueryParam("user")
+	filter.Code = c.QueryParam("code")
+	if filter.Code != "" && !common.TestRegexp(codeReg, filter.Code) {
+		return filter, 0, MakeError(errorCode, "コードの指定が異常です")
+	}
+
+	if ip := c.QueryParam("ip"); ip != "" {
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return filter, 0, MakeError(errorCode, "IPアドレスの指定が異常です")
+		}
+		filter.IpAddress = parsed.String()
+	}
+
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		if v := c.QueryParam(p.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return filter, 0, MakeError(errorCode, "日時の指定が異常です")
+			}
+			*p.t = t
+		}
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return filter, 0, MakeError(errorCode, "日時の指定が異常です")
+	}
+	return filter, page, nil
+}
+
+// 操作ログを検索する(管理者のみ)
+func getReqAdminOperationLogs(c echo.Context) error {
+	filter, page, errRes := readLogFilter(c, `^[a-z0-9]{4}$`, "gaol-001")
+	if errRes != nil {
+		return c.JSON(400, errRes)
+	}
+
+	logs, err := db.GetOperationLogs(filter, page, adminLogsPageSize)
+	if err != nil {
+		return c.JSON(400, MakeError("gaol-002", "操作ログが取得できません"))
+	}
+
+	logDataList := make([]AdminOperationLogData, len(logs))
+	for i, log := range logs {
+		logDataList[i] = AdminOperationLogData{
+			Id:           log.Id,
+			UserId:       log.UserId,
+			TargetUserId: log.TargetUserId,
+			IpAddress:    log.IpAddress,
+			RequestId:    log.RequestId,
+			Operation:    log.Operation,
+			Content:      log.Content,
+			CreatedAt:    common.DateToString(log.CreatedAt),
+		}
+	}
+	return c.JSON(200, logDataList)
+}
+
+// エラーログを検索する(管理者のみ)
+func getReqAdminErrorLogs(c echo.Context) error {
+	filter, page, errRes := readLogFilter(c, `^[a-z0-9]{4}(-[0-9]{3})?$`, "gael-001")
+	if errRes != nil {
+		return c.JSON(400, errRes)
+	}
+
+	logs, err := db.GetErrorLogs(filter, page, adminLogsPageSize)
+	if err != nil {
+		return c.JSON(400, MakeError("gael-002", "エラーログが取得できません"))
+	}
+
+	logDataList := make([]AdminErrorLogData, len(logs))
+	for i, log := range logs {
+		logDataList[i] = AdminErrorLogData{
+			Id:           log.Id,
+			UserId:       log.UserId,
+			IpAddress:    log.IpAddress,
+			RequestId:    log.RequestId,
+			ErrorId:      log.ErrorId,
+			Operation:    log.Operation,
+			Descriptions: log.Descriptions,
+			CreatedAt:    common.DateToString(log.CreatedAt),
+		}
+	}
+	return c.JSON(200, logDataList)
+}
+
+// 自分のアカウントの最近の操作履歴を取得する
+func getReqUserActivities(c echo.Context) error {
+	uid := c.Param("uid")
+
+	// セッションの存在チェック
+	session, err := db.CheckSession(c, true, true)
+	if err != nil {
+		return c.JSON(403, sessionError(err))
+	}
+
+	// 参照ユーザーとセッションのユーザーチェック
+	if session.UserId != uid {
+		return c.JSON(403, commonError.userNotEqual)
+	}
+
+	// 続きを取得する場合は、最後に受け取ったログIDを指定する
+	var cursor uint64
+	if c.QueryParam("cursor") != "" {
+		cursor, err = strconv.ParseUint(c.QueryParam("cursor"), 10, 64)
+		if err != nil {
+			return c.JSON(400, MakeError("gact-001", "指定されたIDが不正です"))
+		}
+	}
+
+	logs, err := db.GetUserActivities(uid, cursor, activitiesLimit)
+	if err != nil {
+		return c.JSON(400, MakeError("gact-002", "操作履歴が取得できません"))
+	}
+
+	activities := make([]ActivityData, len(logs))
+	for i, log := range logs {
+		// 権限による操作では、操作したユーザーとそのIPアドレスを公開しない
+		isPrivileged := log.UserId != uid
+		ipAddress := log.IpAddress
+		if isPrivileged {
+			ipAddress = ""
+		}
+		activities[i] = ActivityData{
+			Id:           log.Id,
+			Operation:    log.Operation,
+			IpAddress:    ipAddress,
+			IsPrivileged: isPrivileged,
+			CreatedAt:    common.DateToString(log.CreatedAt),
+		}
+	}
+	return c.JSON(200, activities)
+}
@@ -0,0 +1,113 @@
+package rest
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"reviewmakerback/metrics"
+)
+
+// エラーコードを読み取るために保持するレスポンスの最大サイズ
+const errorBodyMax = 1024
+
+// メトリクスの取得に必要なトークン(空なら誰でも取得できる)
+var metricsToken string
+
+// 登録済みのルート(メソッドとパスの組)
+// 最初のリクエストの時点で登録されているルートを記録する
+var registeredRoutes sync.Map
+
+// メトリクスやトレースに記録するルート
+// 一致するルートがない場合(404・405)はc.Path()が途中まで一致したルートやリクエストのパスになるため"unmatched"にする
+func routeLabel(c echo.Context) string {
+	path := c.Path()
+	routes, ok := registeredRoutes.Load(c.Echo())
+	if !ok {
+		m := make(map[string]bool)
+		for _, r := range c.Echo().Routes() {
+			m[r.Method+" "+r.Path] = true
+		}
+		routes, _ = registeredRoutes.LoadOrStore(c.Echo(), m)
+	}
+	if path == "" || !routes.(map[string]bool)[c.Request().Method+" "+path] {
+		return "unmatched"
+	}
+	return path
+}
+
+// ルートとステータスコードごとにリクエスト数と処理時間、エラーコードを記録する
+func Metrics() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			writer := &errorBodyWriter{ResponseWriter: c.Response().Writer, response: c.Response()}
+			c.Response().Writer = writer
+
+			// ステータスコードを確定させるため、エラーはここで処理する
+			if err := next(c); err != nil {
+				c.Error(err)
+			}
+
+			route := routeLabel(c)
+			status := strconv.Itoa(c.Response().Status)
+			method := c.Request().Method
+			metrics.HttpRequests.WithLabelValues(method, route, status).Inc()
+			metrics.HttpDuration.WithLabelValues(method, route, status).Observe(time.Since(start).Seconds())
+
+			if c.Response().Status >= 400 {
+				var errorResponse ErrorResponse
+				if json.Unmarshal(writer.body.Bytes(), &errorResponse) == nil && errorResponse.Code != "" {
+					metrics.ErrorCodes.WithLabelValues(metrics.ErrorCodePrefix(errorResponse.Code)).Inc()
+				}
+			}
+			return nil
+		}
+	}
+}
+
+// エラーレスポンスの本文の先頭を保持するResponseWriter
+type errorBodyWriter struct {
+	http.ResponseWriter
+	response *echo.Response
+	body     bytes.Buffer
+}
+
+func (w *errorBodyWriter) Write(b []byte) (int, error) {
+	if w.response.Status >= 400 && w.body.Len() < errorBodyMax {
+		rest := errorBodyMax - w.body.Len()
+		if len(b) < rest {
+			rest = len(b)
+		}
+		w.body.Write(b[:rest])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *errorBodyWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+var metricsHandler = promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{})
+
+// Prometheus形式でメトリクスを返す
+// トークンが設定されている場合はBearerトークンが一致する場合のみ返す
+func getReqMetrics(c echo.Context) error {
+	if metricsToken != "" {
+		token := c.Request().Header.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(token), []byte("Bearer "+metricsToken)) != 1 {
+			return c.JSON(401, MakeError("gmet-001", "メトリクスを取得する権限がありません"))
+		}
+	}
+	metricsHandler.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
@@ -0,0 +1,122 @@
+package rest
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+
+	"github.com/labstack/echo"
+
+	"reviewmakerback/common"
+	db "reviewmakerback/db"
+	"reviewmakerback/oidc"
+)
+
+// 設定ファイルで有効にしたOIDCのプロバイダー(プロバイダー名ごと)
+var oidcProviders = map[string]*oidc.Provider{}
+
+// OIDCのプロバイダーを設定する
+func SetOidcProviders(providers map[string]*oidc.Provider) {
+	oidcProviders = providers
+}
+
+// OIDCの認証に必要な一時セッションと認証ページのURLを返す
+func getReqOidcTempSession(c echo.Context) error {
+	provider, ok := oidcProviders[c.Param("provider")]
+	if !ok {
+		return c.JSON(404, MakeError("gots-001", "指定されたプロバイダーは存在しません"))
+	}
+	requestIp := net.ParseIP(c.RealIP()).String()
+
+	// state, nonce, PKCEのコード検証用文字列を生成する
+	var values [3]string
+	for i := range values {
+		v, err := oidc.RandomString()
+		if err != nil {
+			return c.JSON(400, MakeError("gots-002", "一時セッションの作成に失敗しました"))
+		}
+		values[i] = v
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	url, err := provider.AuthCodeURL(c.Request().Context(), state, nonce, verifier)
+	if err != nil {
+		db.WriteErrorLogContext(c.Request().Context(), "", requestIp, "gots-003", "OIDCのディスカバリーに失敗しました", provider.Config.Name+" "+err.Error())
+		return c.JSON(400, MakeError("gots-003", "認証サーバーに接続できません"))
+	}
+
+	tempSession, err := db.CreateOidcTempSession(provider.Config.Name, state, nonce, verifier, requestIp)
+	if err != nil {
+		return c.JSON(400, MakeError("gots-004", "一時セッションの作成に失敗しました"))
+	}
+
+	return c.JSON(200, common.TempSessionData{
+		SessionId: tempSession.SessionID,
+		Url:       url,
+	})
+}
+
+// OIDCの認証コードを検証してセッションを作成する
+func postReqOidcSession(c echo.Context) error {
+	provider, ok := oidcProviders[c.Param("provider")]
+	if !ok {
+		return c.JSON(404, MakeError("pots-001", "指定されたプロバイダーは存在しません"))
+	}
+	requestIp := net.ParseIP(c.RealIP()).String()
+
+	// Bodyの読み取り
+	b, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return c.JSON(400, commonError.unreadableBody)
+	}
+	var clientSession common.ClientTempSession
+	err = json.Unmarshal(b, &clientSession)
+	if err != nil || clientSession.SessionId == "" || clientSession.AuthorizationCode == "" {
+		return c.JSON(400, commonError.unreadableBody)
+	}
+
+	tempSession, err := db.PopOidcTempSession(clientSession.SessionId, provider.Config.Name)
+	if err != nil {
+		return c.JSON(403, MakeError("pots-002", "一時セッションが無効です 再度ログインしてください"))
+	}
+	if subtle.ConstantTimeCompare([]byte(tempSession.State), []byte(clientSession.State)) != 1 {
+		return c.JSON(403, MakeError("pots-003", "stateが一致しません"))
+	}
+
+	claims, err := provider.Exchange(c.Request().Context(), clientSession.AuthorizationCode, tempSession.CodeVerifier, tempSession.Nonce)
+	if err != nil {
+		db.WriteErrorLogContext(c.Request().Context(), "", requestIp, "pots-004", "OIDCの認証に失敗しました", provider.Config.Name+" "+err.Error())
+		return c.JSON(403, MakeError("pots-004", "認証に失敗しました"))
+	}
+
+	// 確認されていないメールアドレスは保存しない
+	email := ""
+	if claims.EmailVerified {
+		email = claims.Email
+	}
+	name := claims.Name
+	if name == "" {
+		name = claims.PreferredUsername
+	}
+
+	// 固有IDはプロバイダーごとに一意なので、プロバイダー名と組み合わせる
+	session, user, err := db.CreateLoginSession(c, db.ServiceOidc, 2, provider.Config.Name+":"+claims.Subject, email)
+	if err == db.ErrUserSuspended {
+		return c.JSON(403, commonError.suspended)
+	} else if err != nil {
+		return c.JSON(400, MakeError("pots-005", "セッションの作成に失敗しました"))
+	}
+
+	db.WriteOperationLogContext(c.Request().Context(), session.UserId, requestIp, "pots", provider.Config.Name)
+
+	return c.JSON(201, common.SessionData{
+		SessionId:   session.SessionId,
+		UserId:      session.UserId,
+		ExpiredTime: common.DateToString(session.ExpiredTime),
+		IsNew:       session.IsNew,
+		IconUrl:     user.IconUrl,
+		OidcEmail:   email,
+		OidcName:    name,
+	})
+}
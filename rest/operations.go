@@ -2,18 +2,25 @@ package rest
 
 import (
 	"bytes"
+	"context"
 	b64 "encoding/base64"
 	"fmt"
 	"image"
 	"image/jpeg"
+	"io"
 	"net/http"
 	"os"
+	"time"
 
 	common "reviewmakerback/common"
 	"reviewmakerback/db"
+	"reviewmakerback/metrics"
+	"reviewmakerback/storage"
+	"reviewmakerback/tracing"
 
 	"github.com/labstack/echo"
 	"github.com/nfnt/resize"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const saveRetryCount = 3
@@ -42,7 +49,7 @@ func getUserFile(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, MakeError("gusf-004", "不正なファイルが指定されました"))
 	}
 
-	path := os.Getenv("BACK_AP_FILE_PATH") + "/" + userId + "/" + data + "/" + id + "/" + fname
+	path := filePath + "/" + userId + "/" + data + "/" + id + "/" + fname
 	// アクセスされたファイルを返す
 	return c.File(path)
 }
@@ -50,31 +57,52 @@ func getUserFile(c echo.Context) error {
 func deleteFile(errorCode string, delpath string) *ErrorResponse {
 	// ファイル削除
 	if delpath != "" {
-		fullpath := os.Getenv("BACK_AP_FILE_PATH") + "/" + delpath
+		fullpath := filePath + "/" + delpath
 		_, err := os.Stat(fullpath)
 		if err == nil {
 			// ファイルが存在した場合
+			size := fileSize(fullpath)
 			err = os.Remove(fullpath)
 			if err != nil {
 				// エラーコードはsavePicと重複
 				return MakeError(errorCode+"-01", "画像の削除に失敗しました")
 			}
+			addStorageUsage(context.Background(), pathOwner(delpath), -size)
+		}
+		// 変換待ちの画像があれば、変換せずに終わるよう削除する
+		source := pendingPicturePath(delpath)
+		if size := fileSize(source); os.Remove(source) == nil {
+			addStorageUsage(context.Background(), pathOwner(delpath), -size)
 		}
 	}
 	return nil
 }
 
-func deleteFolder(userId string, data string, id string, errorCode string, ipAddress string) {
-	err := os.RemoveAll((fmt.Sprintf("%s/%s/%s/%s", os.Getenv("BACK_AP_FILE_PATH"), userId, data, id)))
+func deleteFolder(ctx context.Context, userId string, data string, id string, errorCode string, ipAddress string) {
+	size, _ := storage.DirSize(fmt.Sprintf("%s/%s/%s/%s", filePath, userId, data, id))
+	err := os.RemoveAll((fmt.Sprintf("%s/%s/%s/%s", filePath, userId, data, id)))
+	if err == nil {
+		addStorageUsage(ctx, userId, -size)
+	}
 	if os.IsNotExist(err) {
-		db.WriteErrorLog(userId, ipAddress, errorCode, "フォルダが削除できませんでした", fmt.Sprintf("'%s/%s/%s/%s' ", os.Getenv("BACK_AP_FILE_PATH"), userId, data, id)+err.Error())
+		db.WriteErrorLogContext(ctx, userId, ipAddress, errorCode, "フォルダが削除できませんでした", fmt.Sprintf("'%s/%s/%s/%s' ", filePath, userId, data, id)+err.Error())
 	}
 }
 
 // 画像を上書き保存する
 // delpath 省略可能
 // aspectRate 負数を指定するとアスペクト比を設定しない
-func savePicture(userId string, data string, id string, fname string, delpath string, imageBase64 string, errorCode string, imgMaxEdge int, aspectRate float32, quality int) (string, *ErrorResponse) {
+func savePicture(ctx context.Context, userId string, data string, id string, fname string, delpath string, imageBase64 string, errorCode string, imgMaxEdge int, aspectRate float32, quality int) (string, *ErrorResponse) {
+	// 処理時間を結果ごとに記録する
+	start := time.Now()
+	result := "error"
+	ctx, span := tracing.Start(ctx, "savePicture", attribute.String("image.data", data), attribute.String("image.error_code", errorCode))
+	defer func() {
+		metrics.ImageDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+		span.SetAttributes(attribute.String("image.result", result))
+		span.End()
+	}()
+
 	fullpath := ""
 	// データベースに保存するパス
 	dbpath := ""
@@ -91,6 +119,7 @@ func savePicture(userId string, data string, id string, fname string, delpath st
 		if er != nil {
 			return fullpath, er
 		}
+		result = "deleted"
 	} else {
 		byteAry, err := b64.StdEncoding.DecodeString(imageBase64)
 		if err != nil {
@@ -99,7 +128,11 @@ func savePicture(userId string, data string, id string, fname string, delpath st
 
 		// バイト列をReaderに変換
 		r := bytes.NewReader(byteAry)
-		img, _, err := image.Decode(r)
+		_, decodeSpan := tracing.Start(ctx, "image.decode", attribute.Int("image.input_bytes", len(byteAry)))
+		img, format, err := image.Decode(r)
+		decodeSpan.SetAttributes(attribute.String("image.format", format))
+		tracing.RecordError(decodeSpan, err)
+		decodeSpan.End()
 		if err != nil {
 			return fullpath, MakeError(errorCode+"-003", "画像の登録に失敗しました")
 		}
@@ -114,28 +147,29 @@ func savePicture(userId string, data string, id string, fname string, delpath st
 			}
 		}
 
+		// 縮小後のサイズは受け取った画像より小さくなるため、受け取った画像のサイズで容量を確保する
+		reserved, er := reserveStorageQuota(ctx, userId, int64(len(byteAry)), imageSize(delpath))
+		if er != nil {
+			return fullpath, er
+		}
+		saved := false
+		defer func() {
+			if !saved {
+				addStorageUsage(ctx, userId, -reserved)
+			}
+		}()
+
+		_, resizeSpan := tracing.Start(ctx, "image.resize", attribute.Int("image.width", x), attribute.Int("image.height", y), attribute.Int("image.max_edge", imgMaxEdge))
 		resizedImg := resize.Thumbnail(uint(imgMaxEdge), uint(imgMaxEdge), img, resize.NearestNeighbor)
-		err = os.MkdirAll(fmt.Sprintf("%s/%s/%s/%s", os.Getenv("BACK_AP_FILE_PATH"), userId, data, id), os.ModePerm)
+		resizeSpan.End()
+		err = os.MkdirAll(fmt.Sprintf("%s/%s/%s/%s", filePath, userId, data, id), os.ModePerm)
 		if err != nil {
 			return fullpath, MakeError(errorCode+"-005", "画像の登録に失敗しました")
 		}
 
-	lo:
-		for i := 0; i < saveRetryCount; i++ {
-			code, err := common.MakeRandomChars(16, fmt.Sprintf("%s%s_%d", userId, id, i))
-			if err != nil {
-				return "", MakeError(errorCode+"-006", "画像の登録に失敗しました しばらく時間を空けてもう一度実行してください")
-			}
-			fullpath = fmt.Sprintf("%s/%s/%s/%s/%s%s.jpg", os.Getenv("BACK_AP_FILE_PATH"), userId, data, id, fname, code)
-			dbpath = fmt.Sprintf("%s/%s/%s/%s%s.jpg", userId, data, id, fname, code)
-
-			_, err = os.Stat(fullpath)
-			if os.IsNotExist(err) {
-				break lo
-			} else if i == saveRetryCount-1 {
-				// リトライ上限に到達
-				return "", MakeError(errorCode+"-007", "画像の登録に失敗しました しばらく時間を空けてもう一度実行してください")
-			}
+		fullpath, dbpath, er = makePicturePath(userId, data, id, fname, errorCode)
+		if er != nil {
+			return "", er
 		}
 
 		out, err := os.Create(fullpath)
@@ -151,22 +185,65 @@ func savePicture(userId string, data string, id string, fname string, delpath st
 		}
 
 		// ファイル削除
-		er := deleteFile(errorCode, delpath)
+		er = deleteFile(errorCode, delpath)
 		if er != nil {
 			out.Close()
 			return fullpath, er
 		}
 
-		err = jpeg.Encode(out, resizedImg, opts)
+		counter := &countingWriter{w: out}
+		_, encodeSpan := tracing.Start(ctx, "image.encode", attribute.Int("image.quality", quality))
+		err = jpeg.Encode(counter, resizedImg, opts)
 		out.Close()
+		encodeSpan.SetAttributes(attribute.Int64("image.output_bytes", counter.n))
+		tracing.RecordError(encodeSpan, err)
+		encodeSpan.End()
 
 		if err != nil {
 			return dbpath, MakeError(errorCode+"-009", "画像の登録に失敗しました")
 		}
+		saved = true
+		addStorageUsage(ctx, userId, counter.n-reserved)
+		metrics.ImageBytes.Observe(float64(counter.n))
+		result = "saved"
 	}
 	return dbpath, nil
 }
 
+// 既存のファイルと重複しない画像の保存先を決める
+// 保存先のパスと、データベースに保存するパスを返す
+func makePicturePath(userId string, data string, id string, fname string, errorCode string) (string, string, *ErrorResponse) {
+	for i := 0; i < saveRetryCount; i++ {
+		code, err := common.MakeRandomChars(16, fmt.Sprintf("%s%s_%d", userId, id, i))
+		if err != nil {
+			return "", "", MakeError(errorCode+"-006", "画像の登録に失敗しました しばらく時間を空けてもう一度実行してください")
+		}
+		fullpath := fmt.Sprintf("%s/%s/%s/%s/%s%s.jpg", filePath, userId, data, id, fname, code)
+		dbpath := fmt.Sprintf("%s/%s/%s/%s%s.jpg", userId, data, id, fname, code)
+
+		// 変換待ちの画像とも重複しないようにする
+		_, err = os.Stat(fullpath)
+		_, err2 := os.Stat(pendingPicturePath(dbpath))
+		if os.IsNotExist(err) && os.IsNotExist(err2) {
+			return fullpath, dbpath, nil
+		}
+	}
+	// リトライ上限に到達
+	return "", "", MakeError(errorCode+"-007", "画像の登録に失敗しました しばらく時間を空けてもう一度実行してください")
+}
+
+// 書き込んだバイト数を数えるWriter
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
 // セクション配列から画像のパスをマップ化したものを取得
 func sections2ImageList(sections []SectionData) map[string]bool {
 	m := make(map[string]bool)
@@ -194,7 +271,10 @@ func parags2DelImageMap(oldParags []ParagData) map[string]bool {
 // 編集データをセクション配列に変換
 // oldImageMapはもともと存在していたparagsのなかに存在するファイルのパスのマップで、対応する値は全てfalseにしておく
 // 返すmapは、もともと存在していたparagsのなかに存在するかつ削除せずに残しておくファイル
-func createParags(parags []ParagEditingData, oldImageMap map[string]bool, userId string, data string, id string, fname string) ([]ParagData, map[string]bool, *ErrorResponse) {
+func createParags(ctx context.Context, parags []ParagEditingData, oldImageMap map[string]bool, userId string, data string, id string, fname string) ([]ParagData, map[string]bool, *ErrorResponse) {
+	ctx, span := tracing.Start(ctx, "createParags", attribute.Int("parags.count", len(parags)))
+	defer span.End()
+
 	madeParags := make([]ParagData, len(parags))
 	var exists bool
 	for i, parag := range parags {
@@ -215,7 +295,8 @@ func createParags(parags []ParagEditingData, oldImageMap map[string]bool, userId
 				}
 			} else {
 				// クライアント側で変更あり
-				path, er := savePicture(userId, data, id, fname, "", parag.Body, "cpgs-002", sectionValidation.paragImgMax, sectionValidation.paragImgAspect, sectionValidation.paragImageQuality)
+				// 枚数が多くなるため、縮小・変換はジョブキューで行う
+				path, er := queuePicture(ctx, userId, data, id, fname, parag.Body, "cpgs-002", sectionValidation.paragImgMax, sectionValidation.paragImgAspect, sectionValidation.paragImageQuality)
 				if er != nil {
 					return madeParags, oldImageMap, er
 				}
@@ -239,12 +320,12 @@ func createParags(parags []ParagEditingData, oldImageMap map[string]bool, userId
 // 編集データをセクション配列に変換
 // oldImageMapはもともと存在していたparagsのなかに存在するファイルのパスのマップで、対応する値は全てfalseにしておく
 // 返すmapは、もともと存在していたparagsのなかに存在するかつ削除せずに残しておくファイル
-func createSections(sections []SectionEditingData, oldImageMap map[string]bool, userId string, data string, id string, fname string) ([]SectionData, map[string]bool, *ErrorResponse) {
+func createSections(ctx context.Context, sections []SectionEditingData, oldImageMap map[string]bool, userId string, data string, id string, fname string) ([]SectionData, map[string]bool, *ErrorResponse) {
 	madeSections := make([]SectionData, len(sections))
 	var parags []ParagData
 	var er *ErrorResponse = nil
 	for i, section := range sections {
-		parags, oldImageMap, er = createParags(section.Parags, oldImageMap, userId, data, id, fname)
+		parags, oldImageMap, er = createParags(ctx, section.Parags, oldImageMap, userId, data, id, fname)
 		if er != nil {
 			return madeSections, oldImageMap, er
 		}
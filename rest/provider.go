@@ -0,0 +1,201 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"time"
+
+	"github.com/labstack/echo"
+
+	"reviewmakerback/common"
+	db "reviewmakerback/db"
+)
+
+// 連携サービスの追加・統合・解除のエラーをレスポンスに変換する
+func providerError(errorCode string, err error) (int, *ErrorResponse) {
+	switch err {
+	case db.ErrUserSuspended:
+		return 403, &commonError.suspended
+	case db.ErrProviderLinkCodeInvalid:
+		return 400, MakeError(errorCode+"-01", err.Error())
+	case db.ErrProviderLinked, db.ErrProviderConflict:
+		return 409, MakeError(errorCode+"-02", err.Error())
+	case db.ErrProviderOtherUser:
+		return 409, MakeError(errorCode+"-03", "指定されたサービスは別のユーザーに登録されています 統合する場合はmergeを指定してください")
+	case db.ErrProviderLast:
+		return 400, MakeError(errorCode+"-04", err.Error())
+	}
+	return 400, MakeError(errorCode+"-05", err.Error())
+}
+
+func makeProviderData(user db.User) ProviderData {
+	return ProviderData{
+		UserId:          user.UserId,
+		Services:        user.LinkedServices(),
+		TwitterUserName: user.TwitterUserName,
+		GoogleEmail:     user.GoogleEmail,
+		OidcEmail:       user.OidcEmail,
+	}
+}
+
+// 連携サービス追加用のコードを発行する
+// 追加するサービスでログインした別のセッションからコードを使用する
+func postReqProviderLinkCode(c echo.Context) error {
+	uid := c.Param("uid")
+
+	// セッションの存在チェック
+	session, err := db.CheckSession(c, true, true)
+	if err != nil {
+		return c.JSON(403, sessionError(err))
+	}
+	if session.UserId != uid {
+		return c.JSON(403, commonError.userNotEqual)
+	}
+
+	code, expiredTime, err := db.IssueProviderLinkCode(session.UserId, time.Now())
+	if err != nil {
+		return c.JSON(400, MakeError("plkc-001", "連携コードの発行に失敗しました"))
+	}
+
+	requestIp := net.ParseIP(c.RealIP()).String()
+	db.WriteOperationLogContext(c.Request().Context(), session.UserId, requestIp, "plkc", "")
+
+	return c.JSON(201, ProviderLinkCodeData{
+		Code:        code,
+		ExpiredTime: common.DateToString(expiredTime),
+	})
+}
+
+// 追加するサービスでログインしたセッションを、コードを発行したユーザーに連携する
+// 追加するサービスが別のユーザーに登録されている場合は、mergeを指定するとそのユーザーのTierとレビューを移して統合する
+func postReqProvider(c echo.Context) error {
+	uid := c.Param("uid")
+	requestIp := net.ParseIP(c.RealIP()).String()
+
+	// セッションの存在チェック(ユーザー未登録のセッションも受け付ける)
+	session, err := db.CheckSession(c, false, false)
+	if err != nil {
+		return c.JSON(403, sessionError(err))
+	}
+
+	// Bodyの読み取り
+	b, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return c.JSON(400, commonError.unreadableBody)
+	}
+	var linkingData ProviderLinkingData
+	err = json.Unmarshal(b, &linkingData)
+	if err != nil {
+		return c.JSON(400, commonError.unreadableBody)
+	}
+	if linkingData.Code == "" {
+		return c.JSON(400, MakeError("plnk-001", "連携コードを指定してください"))
+	}
+
+	if session.UserId == uid {
+		return c.JSON(409, MakeError("plnk-002", "指定されたサービスは既に連携されています"))
+	}
+
+	if session.UserId == "" {
+		// 未登録のサービスを追加する
+		user, err := db.LinkProvider(uid, linkingData.Code, session)
+		if err != nil {
+			status, er := providerError("plnk-003", err)
+			return c.JSON(status, er)
+		}
+		db.WriteOperationLogContext(c.Request().Context(), uid, requestIp, "plnk", session.LoginService)
+		return c.JSON(200, makeProviderData(user))
+	}
+
+	if !linkingData.Merge {
+		status, er := providerError("plnk-003", db.ErrProviderOtherUser)
+		return c.JSON(status, er)
+	}
+
+	// 別のユーザーを統合する
+	srcId := session.UserId
+	user, err := db.MergeUsers(uid, linkingData.Code, session)
+	if err != nil {
+		status, er := providerError("plnk-004", err)
+		return c.JSON(status, er)
+	}
+
+	// 統合元の画像を統合先のフォルダに移し、残ったファイルは削除する
+	moveUserFolder(c.Request().Context(), srcId, uid, "tier", requestIp, "plnk-005")
+	moveUserFolder(c.Request().Context(), srcId, uid, "review", requestIp, "plnk-005")
+	deleteUserFolder(c.Request().Context(), srcId, uid, requestIp, "plnk-006")
+	recountStorageUsage(c.Request().Context(), uid, requestIp, "plnk-007")
+
+	db.WritePrivilegedOperationLogContext(c.Request().Context(), uid, srcId, requestIp, "mrgu", "")
+
+	data := makeProviderData(user)
+	data.MergedUserId = srcId
+	return c.JSON(200, data)
+}
+
+// サービスの連携を解除する
+// 最後の連携サービスとログイン中のサービスは解除できない
+func deleteReqProvider(c echo.Context) error {
+	uid := c.Param("uid")
+	service := c.Param("service")
+
+	// セッションの存在チェック
+	session, err := db.CheckSession(c, true, true)
+	if err != nil {
+		return c.JSON(403, sessionError(err))
+	}
+	if session.UserId != uid {
+		return c.JSON(403, commonError.userNotEqual)
+	}
+
+	if !common.Contains(service, db.Services) {
+		return c.JSON(400, MakeError("dprv-001", "サービスの指定が異常です"))
+	}
+	if session.LoginService == service {
+		return c.JSON(400, MakeError("dprv-002", "ログイン中のサービスは解除できません"))
+	}
+
+	user, err := db.UnlinkProvider(uid, service)
+	if err != nil {
+		status, er := providerError("dprv-003", err)
+		return c.JSON(status, er)
+	}
+
+	requestIp := net.ParseIP(c.RealIP()).String()
+	db.WriteOperationLogContext(c.Request().Context(), uid, requestIp, "dprv", service)
+
+	return c.JSON(200, makeProviderData(user))
+}
+
+// ユーザーのフォルダ内のデータを別のユーザーのフォルダに移す
+// エラーが起こっても中断せず記録のみ残す
+// 変換待ちの画像も統合先の変換待ちのフォルダに移す
+func moveUserFolder(ctx context.Context, srcId string, dstId string, data string, ipAddress string, errorCode string) {
+	moveFolderEntries(ctx, fmt.Sprintf("%s/%s/%s", filePath, srcId, data), fmt.Sprintf("%s/%s/%s", filePath, dstId, data), dstId, ipAddress, errorCode)
+	moveFolderEntries(ctx, pendingPicturePath(srcId+"/"+data), pendingPicturePath(dstId+"/"+data), dstId, ipAddress, errorCode)
+}
+
+// フォルダ内のファイル・フォルダを別のフォルダに移す
+func moveFolderEntries(ctx context.Context, srcDir string, dstDir string, dstId string, ipAddress string, errorCode string) {
+	entries, err := os.ReadDir(srcDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			db.WriteErrorLogContext(ctx, dstId, ipAddress, errorCode, "フォルダが読み込めませんでした", fmt.Sprintf("'%s' %s", srcDir, err.Error()))
+		}
+		return
+	}
+	if err = os.MkdirAll(dstDir, os.ModePerm); err != nil {
+		db.WriteErrorLogContext(ctx, dstId, ipAddress, errorCode, "フォルダが作成できませんでした", fmt.Sprintf("'%s' %s", dstDir, err.Error()))
+		return
+	}
+	for _, entry := range entries {
+		err = os.Rename(srcDir+"/"+entry.Name(), dstDir+"/"+entry.Name())
+		if err != nil {
+			db.WriteErrorLogContext(ctx, dstId, ipAddress, errorCode, "フォルダが移動できませんでした", fmt.Sprintf("'%s/%s' %s", srcDir, entry.Name(), err.Error()))
+		}
+	}
+}
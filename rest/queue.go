@@ -0,0 +1,379 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	b64 "encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/nfnt/resize"
+	"go.opentelemetry.io/otel/attribute"
+
+	"reviewmakerback/common"
+	db "reviewmakerback/db"
+	"reviewmakerback/metrics"
+	"reviewmakerback/queue"
+	"reviewmakerback/tracing"
+)
+
+// 管理画面で一度に取得可能なジョブ数
+const adminQueuePageSize = 100
+
+// 画像の変換の制限時間
+const imageJobTimeout = 2 * time.Minute
+
+// 画像の縮小・変換ジョブの入力
+type imageJobPayload struct {
+	Path    string `json:"path"`    // 変換後の画像のデータベースに保存したパス
+	MaxEdge int    `json:"maxEdge"` // 縮小後の長辺の最大値
+	Quality int    `json:"quality"` // JPEGの品質
+}
+
+// ジョブキューの処理を登録する
+// Configureより後に呼び出すこと
+func RegisterQueueJobs(q *queue.Queue) {
+	q.Register(db.QueueKindImage, resizePictureJob, queue.Options{Timeout: imageJobTimeout, OnDead: resizePictureJobDead})
+	q.Register(db.QueueKindExport, exportUserJob, queue.Options{Timeout: exportJobTimeout})
+}
+
+// 変換待ちの画像(受け取ったままのもの)の保存先
+func pendingPicturePath(dbpath string) string {
+	return filePath + "/.queue/images/" + dbpath
+}
+
+// リクエスト中に登録したジョブのID
+type pendingJobs struct {
+	mutex sync.Mutex
+	ids   []string
+}
+
+type pendingJobsKey struct{}
+
+// リクエスト中に登録したジョブのIDを記録できるようにする
+func withPendingJobs(c echo.Context) *pendingJobs {
+	pending := &pendingJobs{}
+	c.SetRequest(c.Request().WithContext(context.WithValue(c.Request().Context(), pendingJobsKey{}, pending)))
+	return pending
+}
+
+func addPendingJob(ctx context.Context, id uint64) {
+	pending, ok := ctx.Value(pendingJobsKey{}).(*pendingJobs)
+	if !ok {
+		return
+	}
+	pending.mutex.Lock()
+	defer pending.mutex.Unlock()
+	pending.ids = append(pending.ids, strconv.FormatUint(id, 10))
+}
+
+// 登録したジョブのIDをカンマ区切りでX-Pending-Jobsヘッダに設定する
+// クライアントは/jobs/:jidで完了を確認できる
+func (p *pendingJobs) setHeader(c echo.Context) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if len(p.ids) > 0 {
+		c.Response().Header().Set("X-Pending-Jobs", strings.Join(p.ids, ","))
+	}
+}
+
+// 画像を検証して変換待ちとして保存し、縮小・JPEGへの変換をジョブキューに登録する
+// 変換が終わるまでは返したパスの画像は存在しない
+// aspectRate 負数を指定するとアスペクト比を設定しない
+func queuePicture(ctx context.Context, userId string, data string, id string, fname string, imageBase64 string, errorCode string, imgMaxEdge int, aspectRate float32, quality int) (string, *ErrorResponse) {
+	// フールプルーフ
+	if userId == "" || data == "" || id == "" || fname == "" {
+		return "", MakeError(errorCode+"-001", "ファイルを保存するのに必要な情報が不足しています 管理者に連絡してください")
+	}
+	if imageBase64 == "" {
+		return "", nil
+	}
+
+	byteAry, err := b64.StdEncoding.DecodeString(imageBase64)
+	if err != nil {
+		return "", MakeError(errorCode+"-002", "画像の登録に失敗しました")
+	}
+
+	// 画像全体の読み込みは変換時に行い、ここでは形式とサイズのみ確認する
+	conf, _, err := image.DecodeConfig(bytes.NewReader(byteAry))
+	if err != nil || conf.Width == 0 || conf.Height == 0 {
+		return "", MakeError(errorCode+"-003", "画像の登録に失敗しました")
+	}
+
+	// (画像のアスペクト比 / 既定のアスペクト比) がプラスマイナスaspectRateAmpになってるか確認
+	if aspectRate > 0 {
+		if ((float32(conf.Width)/float32(conf.Height))/aspectRate)-(1.0-aspectRateAmp) > aspectRateAmp*2 {
+			return "", MakeError(errorCode+"-004", "画像のアスペクト比が異常です")
+		}
+	}
+
+	// 変換後のサイズは受け取った画像より小さくなるため、受け取った画像のサイズで容量を確保する
+	// 変換待ちの画像も容量に含め、変換後にサイズの差を反映する
+	reserved, er := reserveStorageQuota(ctx, userId, int64(len(byteAry)), 0)
+	if er != nil {
+		return "", er
+	}
+	saved := false
+	defer func() {
+		if !saved {
+			addStorageUsage(ctx, userId, -reserved)
+		}
+	}()
+
+	err = os.MkdirAll(fmt.Sprintf("%s/%s/%s/%s", filePath, userId, data, id), os.ModePerm)
+	if err != nil {
+		return "", MakeError(errorCode+"-005", "画像の登録に失敗しました")
+	}
+	_, dbpath, er := makePicturePath(userId, data, id, fname, errorCode)
+	if er != nil {
+		return "", er
+	}
+
+	source := pendingPicturePath(dbpath)
+	err = os.MkdirAll(filepath.Dir(source), os.ModePerm)
+	if err != nil {
+		return "", MakeError(errorCode+"-005", "画像の登録に失敗しました")
+	}
+	err = ioutil.WriteFile(source, byteAry, 0644)
+	if err != nil {
+		return "", MakeError(errorCode+"-008", "画像の登録に失敗しました")
+	}
+
+	job, err := db.EnqueueQueueJob(db.QueueKindImage, userId, imageJobPayload{Path: dbpath, MaxEdge: imgMaxEdge, Quality: quality}, db.QueueImageRetryMax)
+	if err != nil {
+		os.Remove(source)
+		return "", MakeError(errorCode+"-010", "画像の登録に失敗しました")
+	}
+	saved = true
+	addStorageUsage(ctx, userId, int64(len(byteAry))-reserved)
+	addPendingJob(ctx, job.Id)
+	queue.Notify()
+	return dbpath, nil
+}
+
+// 変換待ちの画像を縮小してJPEGで保存する
+// 変換待ちの画像や保存先のフォルダが削除されている場合(投稿の削除等)は何もしない
+func resizePictureJob(ctx context.Context, job queue.Job) (queue.Result, error) {
+	start := time.Now()
+	result := "error"
+	ctx, span := tracing.Start(ctx, "resizePictureJob", attribute.Int64("queue.job_id", int64(job.Id)))
+	defer func() {
+		metrics.ImageDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+		span.SetAttributes(attribute.String("image.result", result))
+		span.End()
+	}()
+
+	var payload imageJobPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil || payload.Path == "" {
+		return queue.Result{}, queue.Permanent(errors.New("ジョブの入力が不正です"))
+	}
+	source := pendingPicturePath(payload.Path)
+	fullpath := filePath + "/" + payload.Path
+
+	byteAry, err := ioutil.ReadFile(source)
+	if os.IsNotExist(err) {
+		result = "deleted"
+		return queue.Result{}, nil
+	} else if err != nil {
+		return queue.Result{}, err
+	}
+	userId := pathOwner(payload.Path)
+	if _, err := os.Stat(filepath.Dir(fullpath)); os.IsNotExist(err) {
+		if os.Remove(source) == nil {
+			addStorageUsage(ctx, userId, -int64(len(byteAry)))
+		}
+		result = "deleted"
+		return queue.Result{}, nil
+	}
+
+	_, decodeSpan := tracing.Start(ctx, "image.decode", attribute.Int("image.input_bytes", len(byteAry)))
+	img, format, err := image.Decode(bytes.NewReader(byteAry))
+	decodeSpan.SetAttributes(attribute.String("image.format", format))
+	tracing.RecordError(decodeSpan, err)
+	decodeSpan.End()
+	if err != nil {
+		if os.Remove(source) == nil {
+			addStorageUsage(ctx, userId, -int64(len(byteAry)))
+		}
+		return queue.Result{}, queue.Permanent(err)
+	}
+
+	_, resizeSpan := tracing.Start(ctx, "image.resize", attribute.Int("image.width", img.Bounds().Dx()), attribute.Int("image.height", img.Bounds().Dy()), attribute.Int("image.max_edge", payload.MaxEdge))
+	resizedImg := resize.Thumbnail(uint(payload.MaxEdge), uint(payload.MaxEdge), img, resize.NearestNeighbor)
+	resizeSpan.End()
+
+	// 書き込み途中のファイルを返さないよう、一時ファイルに書き込んでから置き換える
+	tmp := fullpath + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return queue.Result{}, err
+	}
+	counter := &countingWriter{w: out}
+	_, encodeSpan := tracing.Start(ctx, "image.encode", attribute.Int("image.quality", payload.Quality))
+	err = jpeg.Encode(counter, resizedImg, &jpeg.Options{Quality: payload.Quality})
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	encodeSpan.SetAttributes(attribute.Int64("image.output_bytes", counter.n))
+	tracing.RecordError(encodeSpan, err)
+	encodeSpan.End()
+	if err == nil {
+		err = os.Rename(tmp, fullpath)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return queue.Result{}, err
+	}
+
+	// 変換待ちの画像を削除済みの場合(変換中に投稿を編集した等)は、その時点で容量から差し引かれている
+	if os.Remove(source) == nil {
+		addStorageUsage(ctx, userId, counter.n-int64(len(byteAry)))
+	} else {
+		addStorageUsage(ctx, userId, counter.n)
+	}
+	metrics.ImageBytes.Observe(float64(counter.n))
+	result = "saved"
+
+	b, _ := json.Marshal(map[string]string{"path": payload.Path})
+	return queue.Result{Data: string(b)}, nil
+}
+
+// 画像の変換が失敗し続けてdeadになった場合、変換待ちの画像を削除して容量から差し引く
+// 投稿からの参照は残るため、パスをエラーログに記録する(保存先の確認でも存在しないファイルへの参照として報告される)
+func resizePictureJobDead(ctx context.Context, job queue.Job, lastError string) {
+	var payload imageJobPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil || payload.Path == "" {
+		return
+	}
+	userId := pathOwner(payload.Path)
+	source := pendingPicturePath(payload.Path)
+	size := fileSize(source)
+	if err := os.Remove(source); err != nil {
+		if !os.IsNotExist(err) {
+			db.WriteErrorLogContext(ctx, userId, "none", "rpjd-001", "変換待ちの画像を削除できませんでした", fmt.Sprintf("'%s' %s", source, err.Error()))
+		}
+		return
+	}
+	addStorageUsage(ctx, userId, -size)
+	db.WriteErrorLogContext(ctx, userId, "none", "rpjd-002", "画像を変換できなかったため変換待ちの画像を削除しました", fmt.Sprintf("'%s' %s", payload.Path, lastError))
+}
+
+func makeQueueJobData(job db.QueueJob) QueueJobData {
+	data := QueueJobData{
+		Id:          job.Id,
+		Kind:        job.Kind,
+		Status:      job.Status,
+		Attempts:    job.Attempts,
+		MaxAttempts: job.MaxAttempts,
+		LastError:   job.LastError,
+		CreatedAt:   common.DateToString(job.CreatedAt),
+		FinishedAt:  optionalDateToString(job.FinishedAt),
+	}
+	if job.Result != "" {
+		data.Result = json.RawMessage(job.Result)
+	}
+	return data
+}
+
+// ジョブの状態を取得する(登録したユーザーのみ)
+func getReqQueueJob(c echo.Context) error {
+	// セッションの存在チェック
+	session, err := db.CheckSession(c, true, true)
+	if err != nil {
+		return c.JSON(403, sessionError(err))
+	}
+
+	jid, err := strconv.ParseUint(c.Param("jid"), 10, 64)
+	if err != nil {
+		return c.JSON(400, MakeError("gjob-001", "指定されたIDが不正です"))
+	}
+
+	job, err := db.GetQueueJob(jid)
+	if err != nil {
+		return c.JSON(404, MakeError("gjob-002", "ジョブが存在しません"))
+	}
+	if job.UserId != session.UserId {
+		return c.JSON(403, commonError.userNotEqual)
+	}
+
+	return c.JSON(200, makeQueueJobData(job))
+}
+
+// ジョブを状態・種類で絞り込んで取得する(管理者のみ)
+func getReqAdminQueue(c echo.Context) error {
+	page, err := strconv.Atoi(c.QueryParam("page"))
+	if err != nil || page < 1 {
+		return c.JSON(400, MakeError("gadq-001", "ページ指定が異常です"))
+	}
+	status := c.QueryParam("status")
+	if status != "" && !common.Contains(status, []string{db.QueueQueued, db.QueueRunning, db.QueueSucceeded, db.QueueDead}) {
+		return c.JSON(400, MakeError("gadq-003", "状態の指定が異常です"))
+	}
+
+	jobs, err := db.GetQueueJobs(status, c.QueryParam("kind"), page, adminQueuePageSize)
+	if err != nil {
+		return c.JSON(400, MakeError("gadq-002", "ジョブが取得できません"))
+	}
+
+	jobDataList := make([]AdminQueueJobData, len(jobs))
+	for i, job := range jobs {
+		jobDataList[i] = AdminQueueJobData{
+			QueueJobData: makeQueueJobData(job),
+			UserId:       job.UserId,
+			Payload:      job.Payload,
+			RunAt:        common.DateToString(job.RunAt),
+		}
+	}
+	return c.JSON(200, jobDataList)
+}
+
+// deadになったジョブを再度実行待ちにする(管理者のみ)
+func retryReqAdminQueueJob(c echo.Context) error {
+	session := c.Get(contextSession).(db.Session)
+	requestIp := net.ParseIP(c.RealIP()).String()
+
+	jid, err := strconv.ParseUint(c.Param("jid"), 10, 64)
+	if err != nil {
+		return c.JSON(400, MakeError("radq-001", "指定されたIDが不正です"))
+	}
+
+	job, err := db.GetQueueJob(jid)
+	if err != nil {
+		return c.JSON(404, MakeError("radq-002", "ジョブが存在しません"))
+	}
+	if job.Status != db.QueueDead {
+		return c.JSON(400, MakeError("radq-003", "失敗したジョブのみ再実行できます"))
+	}
+	// deadになった際に変換待ちの画像は削除されているため、再実行しても画像は保存されない
+	if job.Kind == db.QueueKindImage {
+		var payload imageJobPayload
+		if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil || payload.Path == "" {
+			return c.JSON(400, MakeError("radq-005", "変換待ちの画像が存在しないため再実行できません"))
+		}
+		if _, err := os.Stat(pendingPicturePath(payload.Path)); err != nil {
+			return c.JSON(400, MakeError("radq-005", "変換待ちの画像が存在しないため再実行できません"))
+		}
+	}
+
+	err = db.RetryDeadQueueJob(jid)
+	if err != nil {
+		db.WriteErrorLogContext(c.Request().Context(), session.UserId, requestIp, "radq-004", "ジョブを再実行できません", err.Error())
+		return c.JSON(400, MakeError("radq-004", "ジョブを再実行できません"))
+	}
+	queue.Notify()
+
+	db.WritePrivilegedOperationLogContext(c.Request().Context(), session.UserId, job.UserId, requestIp, "radq", strconv.FormatUint(jid, 10))
+	return c.NoContent(204)
+}
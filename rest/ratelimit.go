@@ -0,0 +1,58 @@
+package rest
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+
+	db "reviewmakerback/db"
+	"reviewmakerback/ratelimit"
+)
+
+// 設定に従ってユーザーIDとIPアドレスごとにリクエスト数を制限するミドルウェア
+func RateLimit(limiter *ratelimit.Limiter) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if isProbePath(c.Request().URL.Path) {
+				return next(c)
+			}
+			rule, ok := limiter.Config.Match(c.Request().Method, c.Request().URL.Path)
+			if !ok {
+				return next(c)
+			}
+
+			userId := ""
+			if !rule.PerUser.IsZero() {
+				userId = db.PeekSessionUserId(c)
+			}
+			requestIp := net.ParseIP(c.RealIP()).String()
+
+			result, limit, err := limiter.Take(rule, userId, requestIp, time.Now())
+			if err != nil {
+				// 保存先に障害がある場合は制限せずに処理を続行する
+				db.WriteErrorLogContext(c.Request().Context(), userId, requestIp, "rlmt-001", "レート制限の確認に失敗しました", fmt.Sprintf("rule=%s %s", rule.Name, err.Error()))
+				return next(c)
+			}
+
+			header := c.Response().Header()
+			header.Set("X-RateLimit-Limit", strconv.Itoa(limit.Burst))
+			header.Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			header.Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+			if !result.Allowed {
+				header.Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+				return c.JSON(429, commonError.rateLimited)
+			}
+			return next(c)
+		}
+	}
+}
+
+// 秒数に切り上げる
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
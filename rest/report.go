@@ -0,0 +1,273 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+
+	"reviewmakerback/common"
+	db "reviewmakerback/db"
+)
+
+// 通報の対応方法
+const (
+	reportActionDismiss = "dismiss" // 問題なしとして却下する
+	reportActionHide    = "hide"    // 対象を非表示にする
+	reportActionSuspend = "suspend" // 作成ユーザーを利用停止にする
+)
+
+var reportActions = []string{reportActionDismiss, reportActionHide, reportActionSuspend}
+
+type ReportValidation struct {
+	// 通報理由の詳細の最大文字数
+	commentLenMax int
+	// 対応メモの最大文字数
+	noteLenMax int
+	// 利用停止日数の最大値
+	suspendDaysMax int
+	// 一度に取得可能な通報の対象数
+	groupsPageSize int
+}
+
+// 通報に関するバリデーション
+var reportValidation = ReportValidation{
+	commentLenMax:  1000,
+	noteLenMax:     400,
+	suspendDaysMax: 365,
+	groupsPageSize: 20,
+}
+
+// 投稿を非表示にしたことを作成ユーザーに通知する
+// 通知に失敗しても非表示の処理は完了しているので、記録のみ残して処理を続行する
+func notifyHidden(ctx context.Context, operatorId string, ipAddress string, targetType string, targetId string) {
+	var content string
+	var userId string
+	switch targetType {
+	case db.ReportTargetTier:
+		tier, _ := db.GetTier(ctx, targetId, "user_id, name")
+		userId = tier.UserId
+		content = fmt.Sprintf("投稿したTier「%s」はガイドラインに違反しているため非表示になりました", tier.Name)
+	case db.ReportTargetReview:
+		review, _ := db.GetReview(ctx, targetId, "user_id, name")
+		userId = review.UserId
+		content = fmt.Sprintf("投稿したレビュー「%s」はガイドラインに違反しているため非表示になりました", review.Name)
+	default:
+		return
+	}
+
+	err := db.CreateUserNotification(userId, content, "", true)
+	if err != nil {
+		db.WriteErrorLogContext(ctx, operatorId, ipAddress, "nthd-001", "非表示の通知に失敗しました", fmt.Sprintf("%s=%s %s", targetType, targetId, err.Error()))
+	}
+}
+
+// 通報のバリデーション
+func validReport(reportData ReportEditingData) (bool, *ErrorResponse) {
+	if !common.Contains(reportData.TargetType, db.ReportTargetTypes) {
+		return false, MakeError("vrpt-001", "通報対象の種類が異常です")
+	}
+	if !common.Contains(reportData.Reason, db.ReportReasons) {
+		return false, MakeError("vrpt-002", "通報理由を選択してください")
+	}
+	return validText("通報理由の詳細", "vrpt-003", reportData.Comment, false, -1, reportValidation.commentLenMax, "", "")
+}
+
+func postReqReport(c echo.Context) error {
+	// セッションの存在チェック
+	session, err := db.CheckSession(c, true, true)
+	if err != nil {
+		return c.JSON(403, sessionError(err))
+	}
+
+	requestIp := net.ParseIP(c.RealIP()).String()
+
+	// Bodyの読み取り
+	b, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return c.JSON(400, commonError.unreadableBody)
+	}
+	var reportData ReportEditingData
+	err = json.Unmarshal(b, &reportData)
+	if err != nil {
+		return c.JSON(400, commonError.unreadableBody)
+	}
+
+	f, er := validReport(reportData)
+	if !f {
+		return c.JSON(400, er)
+	}
+
+	targetUserId, err := db.GetReportTargetUserId(reportData.TargetType, reportData.TargetId)
+	if err != nil {
+		return c.JSON(404, MakeError("prpt-001", "通報対象が存在しません"))
+	}
+
+	if targetUserId == session.UserId {
+		return c.JSON(400, MakeError("prpt-002", "自分自身の投稿は通報できません"))
+	}
+
+	if db.ExistsOpenReport(session.UserId, reportData.TargetType, reportData.TargetId) {
+		return c.JSON(400, MakeError("prpt-003", "この対象は通報済みです"))
+	}
+
+	report, err := db.CreateReport(session.UserId, reportData.TargetType, reportData.TargetId, targetUserId, reportData.Reason, common.ConvertHtmlSafeString(reportData.Comment))
+	if err != nil {
+		db.WriteErrorLogContext(c.Request().Context(), session.UserId, requestIp, "prpt-004", "通報の登録に失敗しました", err.Error())
+		return c.JSON(400, MakeError("prpt-004", "通報の登録に失敗しました"))
+	}
+
+	db.WriteOperationLogContext(c.Request().Context(), session.UserId, requestIp, "prpt", fmt.Sprintf("%s=%s", report.TargetType, report.TargetId))
+	return c.String(201, strconv.FormatUint(uint64(report.Id), 10))
+}
+
+// 未対応の通報を対象ごとにまとめて取得する(モデレーター以上)
+func getReqReportGroups(c echo.Context) error {
+	page, err := strconv.Atoi(c.QueryParam("page"))
+	if err != nil || page < 1 {
+		return c.JSON(400, MakeError("grpg-001", "ページ指定が異常です"))
+	}
+
+	groups, err := db.GetOpenReportGroups(c.Request().Context(), page, reportValidation.groupsPageSize)
+	if err != nil {
+		return c.JSON(400, MakeError("grpg-002", "通報が取得できません"))
+	}
+
+	groupDataList := make([]ReportGroupData, len(groups))
+	for i, group := range groups {
+		reports, err := db.GetOpenReports(c.Request().Context(), group.TargetType, group.TargetId)
+		if err != nil {
+			return c.JSON(400, MakeError("grpg-003", "通報が取得できません"))
+		}
+
+		reasons := map[string]int{}
+		reportDataList := make([]ReportData, len(reports))
+		for j, report := range reports {
+			reasons[report.Reason]++
+			reportDataList[j] = ReportData{
+				Id:         report.Id,
+				ReporterId: report.ReporterId,
+				Reason:     report.Reason,
+				Comment:    report.Comment,
+				CreatedAt:  common.DateToString(report.CreatedAt),
+			}
+		}
+
+		groupDataList[i] = ReportGroupData{
+			TargetType:      group.TargetType,
+			TargetId:        group.TargetId,
+			TargetUserId:    group.TargetUserId,
+			ReportCount:     group.ReportCount,
+			Reasons:         reasons,
+			Reports:         reportDataList,
+			FirstReportedAt: common.DateToString(group.FirstReportedAt),
+			LastReportedAt:  common.DateToString(group.LastReportedAt),
+		}
+	}
+	return c.JSON(200, groupDataList)
+}
+
+// 対象に対する未対応の通報をまとめて対応する(モデレーター以上)
+func resolveReqReports(c echo.Context) error {
+	targetType := c.Param("type")
+	targetId := c.Param("id")
+	session := c.Get(contextSession).(db.Session)
+	role := c.Get(contextRole).(string)
+	requestIp := net.ParseIP(c.RealIP()).String()
+
+	// Bodyの読み取り
+	b, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return c.JSON(400, commonError.unreadableBody)
+	}
+	var resolvingData ReportResolvingData
+	err = json.Unmarshal(b, &resolvingData)
+	if err != nil {
+		return c.JSON(400, commonError.unreadableBody)
+	}
+
+	if !common.Contains(resolvingData.Action, reportActions) {
+		return c.JSON(400, MakeError("rrpt-001", "対応方法が異常です"))
+	}
+	f, er := validText("対応メモ", "rrpt-002", resolvingData.Note, false, -1, reportValidation.noteLenMax, "", "")
+	if !f {
+		return c.JSON(400, er)
+	}
+
+	reports, err := db.GetOpenReports(c.Request().Context(), targetType, targetId)
+	if err != nil || len(reports) == 0 {
+		return c.JSON(404, MakeError("rrpt-003", "未対応の通報がありません"))
+	}
+	targetUserId := reports[0].TargetUserId
+
+	status := db.ReportDismissed
+	switch resolvingData.Action {
+	case reportActionHide:
+		status = db.ReportHidden
+		switch targetType {
+		case db.ReportTargetTier:
+			err = db.UpdateTierHidden(targetId, true)
+		case db.ReportTargetReview:
+			err = db.UpdateReviewHidden(targetId, true)
+		default:
+			return c.JSON(400, MakeError("rrpt-004", "ユーザーのプロフィールは非表示にできません 利用停止を選択してください"))
+		}
+		if err != nil {
+			db.WriteErrorLogContext(c.Request().Context(), session.UserId, requestIp, "rrpt-005", "通報対象を非表示にできませんでした", fmt.Sprintf("%s=%s %s", targetType, targetId, err.Error()))
+			return c.JSON(400, MakeError("rrpt-005", "通報対象を非表示にできませんでした"))
+		}
+		notifyHidden(c.Request().Context(), session.UserId, requestIp, targetType, targetId)
+
+	case reportActionSuspend:
+		status = db.ReportSuspended
+		f, er := validInteger("利用停止日数", "rrpt-006", resolvingData.SuspendDays, 0, reportValidation.suspendDaysMax)
+		if !f {
+			return c.JSON(400, er)
+		}
+
+		// 無期限の利用停止は管理者のみ
+		until := time.Time{}
+		if resolvingData.SuspendDays > 0 {
+			until = time.Now().AddDate(0, 0, resolvingData.SuspendDays)
+		} else if !db.HasRole(role, db.RoleAdmin) {
+			return c.JSON(403, commonError.noPermission)
+		}
+
+		if targetUserId == session.UserId {
+			return c.JSON(400, MakeError("rrpt-007", "自分自身は利用停止にできません"))
+		}
+
+		// 自分と同じか強い権限のユーザーは利用停止にできない
+		targetRole, err := db.GetUserRole(targetUserId)
+		if err != nil {
+			return c.JSON(404, MakeError("rrpt-010", "利用停止にするユーザーが存在しません"))
+		}
+		if !db.OutranksRole(role, targetRole) {
+			return c.JSON(403, commonError.noPermission)
+		}
+
+		reason := fmt.Sprintf("通報対応(%s=%s)", targetType, targetId)
+		if resolvingData.Note != "" {
+			reason += " " + resolvingData.Note
+		}
+		err = db.SuspendUser(targetUserId, until, reason, session.UserId)
+		if err != nil {
+			db.WriteErrorLogContext(c.Request().Context(), session.UserId, requestIp, "rrpt-008", "ユーザーを利用停止にできませんでした", fmt.Sprintf("user=%s %s", targetUserId, err.Error()))
+			return c.JSON(400, MakeError("rrpt-008", "ユーザーを利用停止にできませんでした"))
+		}
+	}
+
+	cnt, err := db.ResolveReports(targetType, targetId, status, session.UserId)
+	if err != nil {
+		db.WriteErrorLogContext(c.Request().Context(), session.UserId, requestIp, "rrpt-009", "通報を対応済みにできませんでした", fmt.Sprintf("%s=%s %s", targetType, targetId, err.Error()))
+		return c.JSON(400, MakeError("rrpt-009", "通報を対応済みにできませんでした"))
+	}
+
+	db.WritePrivilegedOperationLogContext(c.Request().Context(), session.UserId, targetUserId, requestIp, "rrpt", fmt.Sprintf("%s=%s action=%s reports=%d note=%s", targetType, targetId, resolvingData.Action, cnt, resolvingData.Note))
+	return c.NoContent(204)
+}
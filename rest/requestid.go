@@ -0,0 +1,124 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/labstack/echo"
+
+	"reviewmakerback/logger"
+)
+
+// リクエストIDのヘッダー
+const requestIdHeader = "X-Request-ID"
+
+// クライアントから受け取るリクエストIDの形式
+var requestIdPattern = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,64}$`)
+
+// リクエストごとにIDを割り当て、アクセスログを出力する
+// クライアントが正しい形式のX-Request-IDを送った場合はそれを引き継ぐ
+// エラーレスポンスにはリクエストIDを含める
+func RequestId() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			req := c.Request()
+
+			requestId := req.Header.Get(requestIdHeader)
+			if !requestIdPattern.MatchString(requestId) {
+				requestId = logger.NewRequestId()
+			}
+			ctx := logger.WithRequestId(req.Context(), requestId)
+			c.SetRequest(req.WithContext(ctx))
+			c.Response().Header().Set(requestIdHeader, requestId)
+
+			writer := &errorResponseWriter{ResponseWriter: c.Response().Writer, requestId: requestId}
+			c.Response().Writer = writer
+
+			// ステータスコードを確定させるため、エラーはここで処理する
+			if err := next(c); err != nil {
+				c.Error(err)
+			}
+			writer.flushError()
+
+			if !isProbePath(req.URL.Path) {
+				status := c.Response().Status
+				level := logger.LevelInfo
+				if status >= 500 {
+					level = logger.LevelError
+				} else if status >= 400 {
+					level = logger.LevelWarn
+				}
+				logger.Default().Log(ctx, level, "access", logger.Fields{
+					"method":    req.Method,
+					"path":      req.URL.Path,
+					"route":     c.Path(),
+					"status":    status,
+					"latencyMs": float64(time.Since(start).Microseconds()) / 1000,
+					"bytes":     c.Response().Size,
+					"ip":        net.ParseIP(c.RealIP()).String(),
+				})
+			}
+			return nil
+		}
+	}
+}
+
+// エラーレスポンス(ErrorResponse)にリクエストIDを追加するResponseWriter
+// ステータスコードが400以上の場合は本文を保持し、flushErrorで書き出す
+type errorResponseWriter struct {
+	http.ResponseWriter
+	requestId string
+	status    int
+	body      bytes.Buffer
+}
+
+func (w *errorResponseWriter) WriteHeader(code int) {
+	if code >= 400 {
+		w.status = code
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *errorResponseWriter) Write(b []byte) (int, error) {
+	if w.status != 0 {
+		return w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *errorResponseWriter) Flush() {
+	if w.status != 0 {
+		return
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// 保持しているエラーレスポンスを書き出す
+func (w *errorResponseWriter) flushError() {
+	if w.status == 0 {
+		return
+	}
+	body := w.body.Bytes()
+
+	// エラーコードを含むJSONの場合のみリクエストIDを追加する
+	var fields map[string]interface{}
+	if json.Unmarshal(body, &fields) == nil {
+		if _, ok := fields["code"]; ok {
+			fields["requestId"] = w.requestId
+			if b, err := json.Marshal(fields); err == nil {
+				body = append(b, '\n')
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(body)
+	w.status = 0
+}
@@ -29,16 +29,8 @@ type ReviewValidation struct {
 	iconAspectRate float32
 }
 
-// レビューに関するバリデーション
-var reviewValidation = ReviewValidation{
-	nameLenMax:       50,
-	titleLenMax:      100,
-	sectionLenMax:    8,
-	factorInfoLenMax: 16,
-	iconMaxBytes:     10000,
-	iconMaxEdge:      256,
-	iconAspectRate:   1.0,
-}
+// レビューに関するバリデーション(Configureで設定する)
+var reviewValidation ReviewValidation
 
 // Tierのバリデーション
 func validReview(reviewData ReviewEditingData, factorParams []ReviewParamData, pointType string) (bool, *ErrorResponse) {
@@ -107,9 +99,9 @@ func validReview(reviewData ReviewEditingData, factorParams []ReviewParamData, p
 
 func postReqReview(c echo.Context) error {
 	// セッションの存在チェック
-	session, err := db.CheckSession(c, true, false)
+	session, err := db.CheckSession(c, true, true)
 	if err != nil {
-		return c.JSON(403, commonError.noSession)
+		return c.JSON(403, sessionError(err))
 	}
 
 	// 最小投稿頻度のチェック
@@ -131,7 +123,7 @@ func postReqReview(c echo.Context) error {
 	}
 
 	// Tier検索
-	tier, tx := db.GetTier(reviewData.TierId, "tier_id, factor_params, user_id")
+	tier, tx := db.GetTier(c.Request().Context(), reviewData.TierId, "tier_id, factor_params, user_id")
 	if tx.Error != nil {
 		return c.JSON(400, MakeError("prev-001", "レビューに対応するTierが存在しません"))
 	}
@@ -141,7 +133,7 @@ func postReqReview(c echo.Context) error {
 		return c.JSON(400, MakeError("prev-002", "レビューに対応するTierが存在しません"))
 	}
 
-	if db.GetReviewCountInTier(tier.TierId) > ReviewMaxInTier {
+	if db.GetReviewCountInTier(tier.TierId) > int64(ReviewMaxInTier) {
 		return c.JSON(400, MakeError("prev-003", fmt.Sprintf("登録できるレビューはTier一つにつき%d個までです", ReviewMaxInTier)))
 	}
 
@@ -180,14 +172,17 @@ func postReqReview(c echo.Context) error {
 	var er *ErrorResponse
 	if reviewData.IconIsChanged {
 		// 画像の保存
-		path, er = savePicture(session.UserId, "review", reviewId, "icon_", "", reviewData.IconBase64, "prev-007", reviewValidation.iconMaxEdge, reviewValidation.iconAspectRate, 92)
+		path, er = savePicture(c.Request().Context(), session.UserId, "review", reviewId, "icon_", "", reviewData.IconBase64, "prev-007", reviewValidation.iconMaxEdge, reviewValidation.iconAspectRate, 92)
 		if er != nil {
 			return c.JSON(400, er)
 		}
 	}
 
+	// 説明画像の変換ジョブのIDを記録する
+	pending := withPendingJobs(c)
+
 	// セクションを加工、Parag内の画像を保存
-	madeSections, imageMap, er := createSections(reviewData.Sections, sections2ImageList([]SectionData{}), session.UserId, "review", reviewId, "image_")
+	madeSections, imageMap, er := createSections(c.Request().Context(), reviewData.Sections, sections2ImageList([]SectionData{}), session.UserId, "review", reviewId, "image_")
 	if er != nil {
 		deleteSectionImg(madeSections)
 		return c.JSON(400, er)
@@ -210,11 +205,13 @@ func postReqReview(c echo.Context) error {
 	if err != nil {
 		// 新しく作成した途中の画像ファイルを削除
 		deleteSectionImg(madeSections)
-		db.WriteErrorLog(session.UserId, requestIp, "prev-009", "レビューの更新に失敗しました", err.Error())
+		db.WriteErrorLogContext(c.Request().Context(), session.UserId, requestIp, "prev-009", "レビューの更新に失敗しました", err.Error())
 		return c.JSON(400, MakeError("prev-009", "レビューの更新に失敗しました"))
 	}
 
-	db.WriteOperationLog(session.UserId, requestIp, "prev", reviewId)
+	db.WriteOperationLogContext(c.Request().Context(), session.UserId, requestIp, "prev", reviewId)
+	emitWebhook(c.Request().Context(), session.UserId, requestIp, "review.created", reviewData.TierId, reviewId)
+	pending.setHeader(c)
 	return c.String(201, reviewId)
 }
 
@@ -222,9 +219,9 @@ func updateReqReview(c echo.Context) error {
 	rid := c.Param("rid")
 
 	// セッションの存在チェック
-	session, err := db.CheckSession(c, true, false)
+	session, err := db.CheckSession(c, true, true)
 	if err != nil {
-		return c.JSON(403, commonError.noSession)
+		return c.JSON(403, sessionError(err))
 	}
 
 	requestIp := net.ParseIP(c.RealIP()).String()
@@ -241,7 +238,7 @@ func updateReqReview(c echo.Context) error {
 	}
 
 	// 元レビュー検索
-	orgReview, tx := db.GetReview(rid, "*")
+	orgReview, tx := db.GetReview(c.Request().Context(), rid, "*")
 	if tx.Error != nil {
 		return c.JSON(400, MakeError("urev-001", "レビューが存在しません"))
 	}
@@ -252,7 +249,7 @@ func updateReqReview(c echo.Context) error {
 	}
 
 	// Tier検索
-	tier, tx := db.GetTier(orgReview.TierId, "tier_id, user_id, factor_params")
+	tier, tx := db.GetTier(c.Request().Context(), orgReview.TierId, "tier_id, user_id, factor_params")
 	if tx.Error != nil {
 		return c.JSON(400, MakeError("urev-003", "レビューに対応するTierが存在しません"))
 	}
@@ -290,7 +287,7 @@ func updateReqReview(c echo.Context) error {
 	var er *ErrorResponse
 	if reviewData.IconIsChanged {
 		// 画像の保存
-		path, er = savePicture(session.UserId, "review", orgReview.ReviewId, "icon_", orgReview.IconUrl, reviewData.IconBase64, "urev-007", reviewValidation.iconMaxEdge, reviewValidation.iconAspectRate, 92)
+		path, er = savePicture(c.Request().Context(), session.UserId, "review", orgReview.ReviewId, "icon_", orgReview.IconUrl, reviewData.IconBase64, "urev-007", reviewValidation.iconMaxEdge, reviewValidation.iconAspectRate, 92)
 		if er != nil {
 			return c.JSON(400, er)
 		}
@@ -304,8 +301,11 @@ func updateReqReview(c echo.Context) error {
 		return c.JSON(400, MakeError("urev-008", "説明文等の登録に失敗しました"))
 	}
 
+	// 説明画像の変換ジョブのIDを記録する
+	pending := withPendingJobs(c)
+
 	// セクションを加工、Parag内の画像を保存
-	madeSections, imageMap, er := createSections(reviewData.Sections, sections2ImageList(orgSections), session.UserId, "review", orgReview.ReviewId, "image_")
+	madeSections, imageMap, er := createSections(c.Request().Context(), reviewData.Sections, sections2ImageList(orgSections), session.UserId, "review", orgReview.ReviewId, "image_")
 	if er != nil {
 		deleteSectionImg(madeSections)
 		return c.JSON(400, er)
@@ -326,7 +326,7 @@ func updateReqReview(c echo.Context) error {
 	if err != nil {
 		// 新しく作成した途中の画像ファイルを削除
 		deleteSectionImg(madeSections)
-		db.WriteErrorLog(session.UserId, requestIp, "urev-010", "Tierの作成に失敗しました", err.Error())
+		db.WriteErrorLogContext(c.Request().Context(), session.UserId, requestIp, "urev-010", "Tierの作成に失敗しました", err.Error())
 		return c.JSON(400, MakeError("urev-010", "Tierの作成に失敗しました"))
 	}
 
@@ -335,7 +335,9 @@ func updateReqReview(c echo.Context) error {
 		deleteFile("", orgReview.IconUrl)
 	}
 
-	db.WriteOperationLog(session.UserId, requestIp, "urev", orgReview.ReviewId)
+	db.WriteOperationLogContext(c.Request().Context(), session.UserId, requestIp, "urev", orgReview.ReviewId)
+	emitWebhook(c.Request().Context(), session.UserId, requestIp, "review.updated", orgReview.TierId, orgReview.ReviewId)
+	pending.setHeader(c)
 	return c.String(200, orgReview.ReviewId)
 }
 
@@ -374,6 +376,7 @@ func makeReviewData(rid string, user db.User, review db.Review, pointType string
 		ReviewFactors: factors,
 		PointType:     pointType,
 		Sections:      sections,
+		IsHidden:      review.IsHidden,
 		CreatedAt:     common.DateToString(review.CreatedAt),
 		UpdatedAt:     common.DateToString(review.UpdatedAt),
 	}, nil
@@ -384,19 +387,24 @@ func getReqReview(c echo.Context) error {
 
 	var cnt int64
 
-	review, tx := db.GetReview(rid, "*")
+	review, tx := db.GetReview(c.Request().Context(), rid, "*")
 	tx.Count(&cnt)
 	if cnt != 1 {
 		return c.JSON(404, MakeError("grev-001", "レビューが存在しません"))
 	}
 
-	user, tx := db.GetUser(review.UserId, "*")
+	// 非表示のレビューは作成者とモデレーターのみ参照できる
+	if review.IsHidden && !canViewHidden(c, review.UserId) {
+		return c.JSON(404, MakeError("grev-001", "レビューが存在しません"))
+	}
+
+	user, tx := db.GetUser(c.Request().Context(), review.UserId, "*")
 	tx.Count(&cnt)
-	if cnt != 1 {
+	if cnt != 1 || isSuspendedFor(c, user) {
 		return c.JSON(404, MakeError("grev-002", "ユーザーが存在しません"))
 	}
 
-	tier, tx := db.GetTier(review.TierId, "point_type, factor_params")
+	tier, tx := db.GetTier(c.Request().Context(), review.TierId, "point_type, factor_params")
 	tx.Count(&cnt)
 	if cnt != 1 {
 		return c.JSON(404, MakeError("grev-003", "レビューに紐づいたTier情報の取得に失敗しました"))
@@ -435,15 +443,16 @@ func getReqReviewPairs(c echo.Context) error {
 	}
 
 	var cnt int64
-	user, tx := db.GetUser(userId, "*")
+	user, tx := db.GetUser(c.Request().Context(), userId, "*")
 	tx.Count(&cnt)
-	if cnt != 1 {
+	if cnt != 1 || isSuspendedFor(c, user) {
 		return c.JSON(404, MakeError("grvs-004", "指定されたユーザーは存在しません"))
 	}
 
 	var er *ErrorResponse
 	// TierIdは指定せず、ユーザーに紐づくレビューを取得
-	reviews, err := db.GetReviews(userId, "", word, sortType, page, postPageSize, true)
+	// 非表示のレビューは作成者とモデレーターのみ参照できる
+	reviews, err := db.GetReviews(c.Request().Context(), userId, "", word, sortType, page, postPageSize, true, canViewHidden(c, user.UserId))
 	if err != nil {
 		return c.JSON(400, MakeError("grvs-005", "Tierが取得できません"))
 	}
@@ -456,7 +465,7 @@ func getReqReviewPairs(c echo.Context) error {
 
 	for i, review := range reviews {
 		// Tier取得
-		tier, _ := db.GetTier(review.TierId, "point_type, factor_params")
+		tier, _ := db.GetTier(c.Request().Context(), review.TierId, "point_type, factor_params")
 		if tier.PointType == "" {
 			pointType = "stars"
 		} else {
@@ -498,15 +507,15 @@ func deleteReviewReq(c echo.Context) error {
 	rid := c.Param("rid")
 
 	// セッションの存在チェック
-	session, err := db.CheckSession(c, true, false)
+	session, err := db.CheckSession(c, true, true)
 	if err != nil {
-		return c.JSON(403, commonError.noSession)
+		return c.JSON(403, sessionError(err))
 	}
 
 	requestIp := net.ParseIP(c.RealIP()).String()
 
 	var cnt int64
-	review, tx := db.GetReview(rid, "user_id")
+	review, tx := db.GetReview(c.Request().Context(), rid, "user_id, tier_id")
 	tx.Count(&cnt)
 
 	if cnt != 1 {
@@ -518,13 +527,14 @@ func deleteReviewReq(c echo.Context) error {
 	}
 
 	err = db.DeleteReview(rid)
-	deleteFolder(review.UserId, "review", rid, "drev-003", requestIp)
+	deleteFolder(c.Request().Context(), review.UserId, "review", rid, "drev-003", requestIp)
 
 	if err != nil {
-		db.WriteErrorLog(session.UserId, requestIp, "drev-002", "レビューの削除に失敗しました", err.Error())
+		db.WriteErrorLogContext(c.Request().Context(), session.UserId, requestIp, "drev-002", "レビューの削除に失敗しました", err.Error())
 		return c.JSON(400, MakeError("drev-002", "レビューの削除に失敗しました"))
 	}
 
-	db.WriteOperationLog(session.UserId, requestIp, "drev", rid)
+	db.WriteOperationLogContext(c.Request().Context(), session.UserId, requestIp, "drev", rid)
+	emitWebhook(c.Request().Context(), session.UserId, requestIp, "review.deleted", review.TierId, rid)
 	return c.NoContent(204)
 }
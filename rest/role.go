@@ -0,0 +1,240 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+
+	"github.com/labstack/echo"
+
+	"reviewmakerback/common"
+	db "reviewmakerback/db"
+)
+
+// 管理画面で一度に取得可能なユーザー数
+const adminUsersPageSize = 50
+
+// ミドルウェアで確認したセッションと権限を格納するキー
+const (
+	contextSession = "session"
+	contextRole    = "role"
+)
+
+// 指定した権限以上のユーザーのみ実行できるようにするミドルウェア
+// 確認したセッションはc.Get(contextSession)で参照できる
+func requireRole(role string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			// セッションの存在チェック
+			session, err := db.CheckSession(c, true, true)
+			if err != nil {
+				return c.JSON(403, sessionError(err))
+			}
+
+			userRole, err := db.GetUserRole(session.UserId)
+			if err != nil || !db.HasRole(userRole, role) {
+				return c.JSON(403, commonError.noPermission)
+			}
+
+			c.Set(contextSession, session)
+			c.Set(contextRole, userRole)
+			return next(c)
+		}
+	}
+}
+
+// 非表示にされた投稿を参照できるかどうか(作成者本人またはモデレーター以上)
+func canViewHidden(c echo.Context, ownerId string) bool {
+	session, err := db.CheckSession(c, true, true)
+	if err != nil {
+		return false
+	}
+	if session.UserId == ownerId {
+		return true
+	}
+	role, err := db.GetUserRole(session.UserId)
+	return err == nil && db.HasRole(role, db.RoleModerator)
+}
+
+// 利用停止中のユーザーの投稿・プロフィールを参照できないかどうか(作成者本人とモデレーター以上は参照できる)
+func isSuspendedFor(c echo.Context, user db.User) bool {
+	return user.IsSuspended() && !canViewHidden(c, user.UserId)
+}
+
+// 非表示フラグのBodyを読み取る
+func readHiddenData(c echo.Context) (HiddenData, error) {
+	var hiddenData HiddenData
+	b, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return hiddenData, err
+	}
+	err = json.Unmarshal(b, &hiddenData)
+	return hiddenData, err
+}
+
+// Tierの表示・非表示を切り替える(モデレーター以上)
+func updateReqTierHidden(c echo.Context) error {
+	tid := c.Param("tid")
+	session := c.Get(contextSession).(db.Session)
+	requestIp := net.ParseIP(c.RealIP()).String()
+
+	hiddenData, err := readHiddenData(c)
+	if err != nil {
+		return c.JSON(400, commonError.unreadableBody)
+	}
+
+	var cnt int64
+	tier, tx := db.GetTier(c.Request().Context(), tid, "tier_id, user_id")
+	tx.Count(&cnt)
+	if cnt != 1 {
+		return c.JSON(404, MakeError("mtir-001", "Tierが存在しません"))
+	}
+
+	err = db.UpdateTierHidden(tid, hiddenData.IsHidden)
+	if err != nil {
+		db.WriteErrorLogContext(c.Request().Context(), session.UserId, requestIp, "mtir-002", "Tierの表示状態を変更できませんでした", err.Error())
+		return c.JSON(400, MakeError("mtir-002", "Tierの表示状態を変更できませんでした"))
+	}
+
+	if hiddenData.IsHidden {
+		notifyHidden(c.Request().Context(), session.UserId, requestIp, db.ReportTargetTier, tid)
+	}
+
+	db.WritePrivilegedOperationLogContext(c.Request().Context(), session.UserId, tier.UserId, requestIp, "mtir", fmt.Sprintf("tier=%s hidden=%t", tid, hiddenData.IsHidden))
+	return c.NoContent(204)
+}
+
+// レビューの表示・非表示を切り替える(モデレーター以上)
+func updateReqReviewHidden(c echo.Context) error {
+	rid := c.Param("rid")
+	session := c.Get(contextSession).(db.Session)
+	requestIp := net.ParseIP(c.RealIP()).String()
+
+	hiddenData, err := readHiddenData(c)
+	if err != nil {
+		return c.JSON(400, commonError.unreadableBody)
+	}
+
+	var cnt int64
+	review, tx := db.GetReview(c.Request().Context(), rid, "review_id, user_id")
+	tx.Count(&cnt)
+	if cnt != 1 {
+		return c.JSON(404, MakeError("mrev-001", "レビューが存在しません"))
+	}
+
+	err = db.UpdateReviewHidden(rid, hiddenData.IsHidden)
+	if err != nil {
+		db.WriteErrorLogContext(c.Request().Context(), session.UserId, requestIp, "mrev-002", "レビューの表示状態を変更できませんでした", err.Error())
+		return c.JSON(400, MakeError("mrev-002", "レビューの表示状態を変更できませんでした"))
+	}
+
+	if hiddenData.IsHidden {
+		notifyHidden(c.Request().Context(), session.UserId, requestIp, db.ReportTargetReview, rid)
+	}
+
+	db.WritePrivilegedOperationLogContext(c.Request().Context(), session.UserId, review.UserId, requestIp, "mrev", fmt.Sprintf("review=%s hidden=%t", rid, hiddenData.IsHidden))
+	return c.NoContent(204)
+}
+
+// ユーザーの一覧を取得する(管理者のみ)
+func getReqAdminUsers(c echo.Context) error {
+	word := c.QueryParam("word")
+	role := c.QueryParam("role")
+
+	page, err := strconv.Atoi(c.QueryParam("page"))
+	if err != nil || page < 1 {
+		return c.JSON(400, MakeError("gaus-001", "ページ指定が異常です"))
+	}
+	if role != "" && !common.Contains(role, db.Roles) {
+		return c.JSON(400, MakeError("gaus-002", "権限の指定が異常です"))
+	}
+
+	users, err := db.GetUsers(word, role, page, adminUsersPageSize)
+	if err != nil {
+		return c.JSON(400, MakeError("gaus-003", "ユーザーが取得できません"))
+	}
+
+	userDataList := make([]AdminUserData, len(users))
+	for i, user := range users {
+		userDataList[i] = AdminUserData{
+			UserId:          user.UserId,
+			Name:            user.Name,
+			IconUrl:         user.IconUrl,
+			Role:            user.Role,
+			SuspensionState: user.SuspensionState,
+			SuspendedUntil:  suspendedUntilString(user),
+			CreatedAt:       common.DateToString(user.CreatedAt),
+		}
+	}
+	return c.JSON(200, userDataList)
+}
+
+// ユーザーの権限を変更する(管理者のみ)
+func updateReqAdminUserRole(c echo.Context) error {
+	uid := c.Param("uid")
+	session := c.Get(contextSession).(db.Session)
+	requestIp := net.ParseIP(c.RealIP()).String()
+
+	// Bodyの読み取り
+	b, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return c.JSON(400, commonError.unreadableBody)
+	}
+	var roleData RoleData
+	err = json.Unmarshal(b, &roleData)
+	if err != nil {
+		return c.JSON(400, commonError.unreadableBody)
+	}
+
+	if !common.Contains(roleData.Role, db.Roles) {
+		return c.JSON(400, MakeError("uaur-001", "権限の指定が異常です"))
+	}
+
+	// 管理者が一人もいなくなることを防ぐため、自分自身の権限は変更できない
+	if uid == session.UserId {
+		return c.JSON(400, MakeError("uaur-002", "自分自身の権限は変更できません"))
+	}
+
+	oldRole, err := db.GetUserRole(uid)
+	if err != nil {
+		return c.JSON(404, MakeError("uaur-003", "ユーザーが存在しません"))
+	}
+
+	err = db.UpdateUserRole(uid, roleData.Role)
+	if err != nil {
+		db.WriteErrorLogContext(c.Request().Context(), session.UserId, requestIp, "uaur-004", "権限を変更できませんでした", err.Error())
+		return c.JSON(400, MakeError("uaur-004", "権限を変更できませんでした"))
+	}
+
+	db.WritePrivilegedOperationLogContext(c.Request().Context(), session.UserId, uid, requestIp, "uaur", fmt.Sprintf("role=%s->%s", oldRole, roleData.Role))
+	return c.NoContent(204)
+}
+
+// ユーザーを削除する(管理者のみ)
+func deleteReqAdminUser(c echo.Context) error {
+	uid := c.Param("uid")
+	session := c.Get(contextSession).(db.Session)
+	requestIp := net.ParseIP(c.RealIP()).String()
+
+	// 自分自身の削除は通常の手順で行う
+	if uid == session.UserId {
+		return c.JSON(400, MakeError("daus-001", "自分自身は削除できません"))
+	}
+
+	if !db.ExistsUser(uid) {
+		return c.JSON(404, MakeError("daus-002", "ユーザーが存在しません"))
+	}
+
+	err := db.DeleteUserData(uid)
+	if err != nil {
+		db.WriteErrorLogContext(c.Request().Context(), session.UserId, requestIp, "daus-003", "ユーザーの削除に失敗しました", fmt.Sprintf("user=%s %s", uid, err.Error()))
+		return c.JSON(400, MakeError("daus-003", "ユーザーの削除に失敗しました"))
+	}
+
+	deleteUserFolder(c.Request().Context(), uid, session.UserId, requestIp, "daus-004")
+
+	db.WritePrivilegedOperationLogContext(c.Request().Context(), session.UserId, uid, requestIp, "daus", "")
+	return c.NoContent(204)
+}
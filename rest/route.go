@@ -1,23 +1,44 @@
 package rest
 
 import (
+	db "reviewmakerback/db"
 	session "reviewmakerback/session"
 
 	"github.com/labstack/echo"
 )
 
 func Route(e *echo.Echo) {
+	e.GET("/healthz", getReqHealthz)
+	e.GET("/readyz", getReqReadyz)
+	e.GET("/metrics", getReqMetrics)
+	e.GET("/auth/tempsession/oidc/:provider", getReqOidcTempSession)
+	e.POST("/auth/session/oidc/:provider", postReqOidcSession)
 	e.GET("/auth/tempsession/:service/:version", session.GetReqTempSession)
 	e.POST("/auth/session/:service/:version", session.PostReqSession)
 	e.PATCH("/auth/service/:service/:version", session.UpdateService)
 	e.DELETE("/auth/service/:service", session.DeleteService)
 	e.DELETE("/auth/session", session.DelReqSession)
 	e.GET("/auth/check-session", session.GetReqCheckSession)
+	e.POST("/auth/refresh-token", postReqRefreshToken)
+	e.POST("/auth/refresh", postReqRefresh)
+	e.GET("/auth/sessions", getReqSessions)
+	e.DELETE("/auth/sessions/others", deleteReqOtherSessions)
+	e.DELETE("/auth/sessions/:id", deleteReqSession)
 	e.POST("/user", postReqUser)
 	e.DELETE("/user/:uid/try", deleteUser1)
 	e.DELETE("/user/:uid/commit", deleteUser2)
 	e.GET("/user/:uid", getReqUserData)
 	e.PATCH("/user/:uid", updateReqUser)
+	e.POST("/user/:uid/link-code", postReqProviderLinkCode)
+	e.POST("/user/:uid/providers", postReqProvider)
+	e.DELETE("/user/:uid/providers/:service", deleteReqProvider)
+	e.GET("/user/:uid/activities", getReqUserActivities)
+	e.POST("/user/:uid/export", postReqUserExport)
+	e.GET("/user/:uid/export/:jid", getReqUserExport)
+	e.GET("/user/:uid/digest", getReqDigestSetting)
+	e.PATCH("/user/:uid/digest", updateReqDigestSetting)
+	e.GET("/digest/unsubscribe/:token", getReqUnsubscribeDigest)
+	e.GET("/digest/confirm/:token", getReqConfirmDigest)
 	e.GET("/userfile/:uid/:method/:id/:fname", getUserFile)
 	e.POST("/tier", postReqTier)
 	e.GET("/tier/:tid", getReqTier)
@@ -33,4 +54,27 @@ func Route(e *echo.Echo) {
 	e.GET("/common/notifications", getNotifications)
 	e.GET("/common/notifications-count", getNotificationsCount)
 	e.PATCH("/common/notification-read/:nid", updateNotificationRead)
+	e.PATCH("/common/notification-read-all/:nid", updateNotificationReadAll)
+	e.DELETE("/common/notification/:nid", deleteNotification)
+	e.POST("/webhook", postReqWebhook)
+	e.GET("/webhooks", getReqWebhooks)
+	e.DELETE("/webhook/:wid", deleteReqWebhook)
+	e.GET("/webhook/:wid/deliveries", getReqWebhookDeliveries)
+	e.GET("/jobs/:jid", getReqQueueJob)
+	e.POST("/report", postReqReport)
+	e.GET("/mod/reports", getReqReportGroups, requireRole(db.RoleModerator))
+	e.PATCH("/mod/reports/:type/:id", resolveReqReports, requireRole(db.RoleModerator))
+	e.PATCH("/mod/tier/:tid/hidden", updateReqTierHidden, requireRole(db.RoleModerator))
+	e.PATCH("/mod/review/:rid/hidden", updateReqReviewHidden, requireRole(db.RoleModerator))
+	e.GET("/admin/users", getReqAdminUsers, requireRole(db.RoleAdmin))
+	e.PATCH("/admin/user/:uid/role", updateReqAdminUserRole, requireRole(db.RoleAdmin))
+	e.DELETE("/admin/user/:uid", deleteReqAdminUser, requireRole(db.RoleAdmin))
+	e.PATCH("/admin/user/:uid/suspension", updateReqAdminUserSuspension, requireRole(db.RoleAdmin))
+	e.GET("/admin/user/:uid/suspensions", getReqAdminUserSuspensions, requireRole(db.RoleAdmin))
+	e.GET("/admin/logs/operations", getReqAdminOperationLogs, requireRole(db.RoleAdmin))
+	e.GET("/admin/logs/errors", getReqAdminErrorLogs, requireRole(db.RoleAdmin))
+	e.GET("/admin/jobs", getReqAdminJobs, requireRole(db.RoleAdmin))
+	e.GET("/admin/queue", getReqAdminQueue, requireRole(db.RoleAdmin))
+	e.PATCH("/admin/queue/:jid/retry", retryReqAdminQueueJob, requireRole(db.RoleAdmin))
+	e.GET("/admin/storage", getReqAdminStorage, requireRole(db.RoleAdmin))
 }
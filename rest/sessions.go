@@ -0,0 +1,151 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+
+	"github.com/labstack/echo"
+
+	"reviewmakerback/common"
+	db "reviewmakerback/db"
+)
+
+// ログイン中のセッション一覧を取得する
+func getReqSessions(c echo.Context) error {
+	// セッションの存在チェック
+	session, err := db.CheckSession(c, true, true)
+	if err != nil {
+		return c.JSON(403, sessionError(err))
+	}
+
+	sessions, err := db.GetSessionsInUser(session.UserId)
+	if err != nil {
+		return c.JSON(400, MakeError("gses-001", "セッションの一覧が取得できません"))
+	}
+
+	sessionDataList := make([]SessionInfoData, len(sessions))
+	for i, s := range sessions {
+		sessionDataList[i] = SessionInfoData{
+			Id:           db.PublicSessionId(s.SessionId),
+			IsCurrent:    s.SessionId == session.SessionId,
+			LoginService: s.LoginService,
+			IpAddress:    s.IpAddress,
+			UserAgent:    s.UserAgent,
+			CreatedAt:    common.DateToString(s.CreatedAt),
+			LastSeenAt:   common.DateToString(s.LastSeenAt),
+			ExpiredTime:  common.DateToString(s.ExpiredTime),
+		}
+	}
+	return c.JSON(200, sessionDataList)
+}
+
+// 指定したセッションをログアウトさせる
+func deleteReqSession(c echo.Context) error {
+	id := c.Param("id")
+
+	// セッションの存在チェック
+	session, err := db.CheckSession(c, true, true)
+	if err != nil {
+		return c.JSON(403, sessionError(err))
+	}
+
+	requestIp := net.ParseIP(c.RealIP()).String()
+
+	if !common.TestRegexp(`^[a-f0-9]+$`, id) {
+		return c.JSON(400, MakeError("dses-001", "指定されたセッションが不正です"))
+	}
+
+	f, err := db.DeleteSessionInUser(session.UserId, id)
+	if err != nil {
+		db.WriteErrorLogContext(c.Request().Context(), session.UserId, requestIp, "dses-002", "セッションの削除に失敗しました", err.Error())
+		return c.JSON(400, MakeError("dses-002", "セッションの削除に失敗しました"))
+	} else if !f {
+		return c.JSON(404, MakeError("dses-003", "指定されたセッションは存在しません"))
+	}
+
+	db.WriteOperationLogContext(c.Request().Context(), session.UserId, requestIp, "dses", id)
+	return c.NoContent(204)
+}
+
+// リクエスト元以外のセッションを全てログアウトさせる
+func deleteReqOtherSessions(c echo.Context) error {
+	// セッションの存在チェック
+	session, err := db.CheckSession(c, true, true)
+	if err != nil {
+		return c.JSON(403, sessionError(err))
+	}
+
+	requestIp := net.ParseIP(c.RealIP()).String()
+
+	cnt, err := db.DeleteOtherSessionsInUser(session.UserId, session.SessionId)
+	if err != nil {
+		db.WriteErrorLogContext(c.Request().Context(), session.UserId, requestIp, "dsso-001", "セッションの削除に失敗しました", err.Error())
+		return c.JSON(400, MakeError("dsso-001", "セッションの削除に失敗しました"))
+	}
+
+	db.WriteOperationLogContext(c.Request().Context(), session.UserId, requestIp, "dsso", fmt.Sprintf("%d件", cnt))
+	return c.NoContent(204)
+}
+
+// リクエスト元のセッションに対してリフレッシュトークンを発行する
+func postReqRefreshToken(c echo.Context) error {
+	// セッションの存在チェック
+	session, err := db.CheckSession(c, false, true)
+	if err != nil {
+		return c.JSON(403, sessionError(err))
+	}
+
+	requestIp := net.ParseIP(c.RealIP()).String()
+
+	token, limit, err := db.IssueRefreshToken(session)
+	if errors.Is(err, db.ErrRefreshTokenIssued) {
+		return c.JSON(409, MakeError("prft-002", "このセッションのリフレッシュトークンは発行済みです"))
+	} else if err != nil {
+		return c.JSON(400, MakeError("prft-001", "リフレッシュトークンの発行に失敗しました"))
+	}
+
+	db.WriteOperationLogContext(c.Request().Context(), session.UserId, requestIp, "prft", db.PublicSessionId(session.SessionId))
+	return c.JSON(201, RefreshTokenData{
+		RefreshToken: token,
+		ExpiredTime:  common.DateToString(limit),
+	})
+}
+
+// リフレッシュトークンを使用してセッションを更新する
+func postReqRefresh(c echo.Context) error {
+	requestIp := net.ParseIP(c.RealIP()).String()
+
+	// Bodyの読み取り
+	b, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return c.JSON(400, commonError.unreadableBody)
+	}
+	var tokenData RefreshTokenData
+	err = json.Unmarshal(b, &tokenData)
+	if err != nil || tokenData.RefreshToken == "" {
+		return c.JSON(400, commonError.unreadableBody)
+	}
+
+	session, token, limit, err := db.RotateRefreshToken(tokenData.RefreshToken)
+	var reused *db.RefreshTokenReusedError
+	if errors.As(err, &reused) {
+		db.WriteErrorLogContext(c.Request().Context(), reused.UserId, requestIp, "prfs-001", "使用済みのリフレッシュトークンが使用されたため、関連するセッションを全て無効にしました", "family="+reused.FamilyId)
+		if reused.RevokeErr != nil {
+			db.WriteErrorLogContext(c.Request().Context(), reused.UserId, requestIp, "prfs-003", "使用済みのリフレッシュトークンの系列を無効にできませんでした", fmt.Sprintf("family=%s %s", reused.FamilyId, reused.RevokeErr.Error()))
+		}
+		return c.JSON(403, MakeError("prfs-001", "セッションの更新に失敗しました 再度ログインしてください"))
+	} else if err != nil {
+		return c.JSON(403, MakeError("prfs-002", "セッションの更新に失敗しました 再度ログインしてください"))
+	}
+
+	db.WriteOperationLogContext(c.Request().Context(), session.UserId, requestIp, "prfs", db.PublicSessionId(session.SessionId))
+	return c.JSON(201, RefreshedSessionData{
+		SessionId:          session.SessionId,
+		ExpiredTime:        common.DateToString(session.ExpiredTime),
+		RefreshToken:       token,
+		RefreshExpiredTime: common.DateToString(limit),
+	})
+}
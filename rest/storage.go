@@ -0,0 +1,106 @@
+package rest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+
+	"reviewmakerback/common"
+	db "reviewmakerback/db"
+	"reviewmakerback/storage"
+)
+
+// 保存先からの相対パスの先頭(ファイルを保存したユーザーのID)
+func pathOwner(dbpath string) string {
+	return strings.SplitN(strings.TrimPrefix(dbpath, "/"), "/", 2)[0]
+}
+
+// ファイルのサイズ(存在しなければ0)
+func fileSize(fullpath string) int64 {
+	info, err := os.Stat(fullpath)
+	if err != nil || !info.Mode().IsRegular() {
+		return 0
+	}
+	return info.Size()
+}
+
+// データベースに保存したパスの画像(変換待ちのものを含む)のサイズ
+func imageSize(dbpath string) int64 {
+	if dbpath == "" {
+		return 0
+	}
+	return fileSize(filePath+"/"+dbpath) + fileSize(pendingPicturePath(dbpath))
+}
+
+// ユーザーの保存済みの画像の容量を増減する
+// 失敗しても画像の保存・削除は続け、保存先の確認時の再計算で補正する
+func addStorageUsage(ctx context.Context, userId string, delta int64) {
+	err := db.AddStorageUsage(userId, delta)
+	if err != nil {
+		db.WriteErrorLogContext(ctx, userId, "none", "stus-001", "保存済みの画像の容量を更新できませんでした", err.Error())
+	}
+}
+
+// addingバイトの画像を保存してもユーザーの容量の上限を超えない場合のみ、その分の容量を確保する
+// freeingには同時に削除する画像のバイト数を指定する(削除時にaddStorageUsageで減らす)
+// 確保したバイト数を返すので、保存後は実際のサイズとの差を、保存に失敗した場合は確保した分を戻すこと
+// 容量を更新できない場合は保存を妨げないよう確保せずに続ける(保存先の確認時の再計算で補正する)
+func reserveStorageQuota(ctx context.Context, userId string, adding int64, freeing int64) (int64, *ErrorResponse) {
+	ok, err := db.ReserveStorageUsage(userId, adding, freeing, storageQuotaBytes)
+	if err != nil {
+		db.WriteErrorLogContext(ctx, userId, "none", "stus-002", "保存済みの画像の容量を確保できませんでした", err.Error())
+		return 0, nil
+	}
+	if !ok {
+		return 0, MakeError(commonError.storageQuota.Code, commonError.storageQuota.Message)
+	}
+	return adding, nil
+}
+
+// フォルダを移動した後等に、ユーザーの容量を保存先のファイルから数え直す
+func recountStorageUsage(ctx context.Context, userId string, ipAddress string, errorCode string) {
+	err := db.StartStorageRecount(userId)
+	if err == nil {
+		var used int64
+		used, err = storage.UserUsage(filePath, userId)
+		if err == nil {
+			err = db.SetStorageUsage(userId, used)
+		}
+	}
+	if err != nil {
+		db.WriteErrorLogContext(ctx, userId, ipAddress, errorCode, "保存済みの画像の容量を更新できませんでした", err.Error())
+	}
+}
+
+// 保存済みの画像の容量が多いユーザーを取得する(管理者のみ)
+func getReqAdminStorage(c echo.Context) error {
+	limit := db.StorageRankingMax
+	if c.QueryParam("limit") != "" {
+		var err error
+		limit, err = strconv.Atoi(c.QueryParam("limit"))
+		if err != nil || limit < 1 || limit > db.StorageRankingMax {
+			return c.JSON(400, MakeError("gads-001", fmt.Sprintf("取得件数は1以上%d以下で指定してください", db.StorageRankingMax)))
+		}
+	}
+
+	ranking, err := db.GetStorageUsageRanking(limit)
+	if err != nil {
+		return c.JSON(400, MakeError("gads-002", "容量が取得できません"))
+	}
+
+	usageDataList := make([]AdminStorageUsageData, len(ranking))
+	for i, usage := range ranking {
+		usageDataList[i] = AdminStorageUsageData{
+			UserId:     usage.UserId,
+			Name:       usage.Name,
+			UsedBytes:  usage.Bytes,
+			QuotaBytes: storageQuotaBytes,
+			UpdatedAt:  common.DateToString(usage.UpdatedAt),
+		}
+	}
+	return c.JSON(200, usageDataList)
+}
@@ -0,0 +1,111 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"github.com/labstack/echo"
+
+	"reviewmakerback/common"
+	db "reviewmakerback/db"
+)
+
+type SuspensionValidation struct {
+	// 変更理由の最大文字数
+	reasonLenMax int
+	// 利用停止日数の最大値
+	daysMax int
+}
+
+// 利用停止に関するバリデーション
+var suspensionValidation = SuspensionValidation{
+	reasonLenMax: 400,
+	daysMax:      365,
+}
+
+// 一時的な利用停止の終了日時(一時的な利用停止でなければ空文字列)
+func suspendedUntilString(user db.User) string {
+	if user.SuspensionState != db.SuspensionTemporary {
+		return ""
+	}
+	return common.DateToString(user.SuspendedUntil)
+}
+
+// ユーザーの利用停止状態を変更する(管理者のみ)
+func updateReqAdminUserSuspension(c echo.Context) error {
+	uid := c.Param("uid")
+	session := c.Get(contextSession).(db.Session)
+	requestIp := net.ParseIP(c.RealIP()).String()
+
+	// Bodyの読み取り
+	b, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return c.JSON(400, commonError.unreadableBody)
+	}
+	var suspensionData SuspensionEditingData
+	err = json.Unmarshal(b, &suspensionData)
+	if err != nil {
+		return c.JSON(400, commonError.unreadableBody)
+	}
+
+	if !common.Contains(suspensionData.State, db.SuspensionStates) {
+		return c.JSON(400, MakeError("uaus-001", "利用停止状態の指定が異常です"))
+	}
+	until := time.Time{}
+	if suspensionData.State == db.SuspensionTemporary {
+		f, er := validInteger("利用停止日数", "uaus-002", suspensionData.Days, 1, suspensionValidation.daysMax)
+		if !f {
+			return c.JSON(400, er)
+		}
+		until = time.Now().AddDate(0, 0, suspensionData.Days)
+	}
+	f, er := validText("変更理由", "uaus-003", suspensionData.Reason, true, -1, suspensionValidation.reasonLenMax, "", "")
+	if !f {
+		return c.JSON(400, er)
+	}
+
+	if uid == session.UserId {
+		return c.JSON(400, MakeError("uaus-004", "自分自身の利用停止状態は変更できません"))
+	}
+	if !db.ExistsUser(uid) {
+		return c.JSON(404, MakeError("uaus-005", "ユーザーが存在しません"))
+	}
+
+	err = db.UpdateSuspension(uid, suspensionData.State, until, common.ConvertHtmlSafeString(suspensionData.Reason), session.UserId)
+	if err != nil {
+		db.WriteErrorLogContext(c.Request().Context(), session.UserId, requestIp, "uaus-006", "利用停止状態を変更できませんでした", fmt.Sprintf("user=%s %s", uid, err.Error()))
+		return c.JSON(400, MakeError("uaus-006", "利用停止状態を変更できませんでした"))
+	}
+
+	db.WritePrivilegedOperationLogContext(c.Request().Context(), session.UserId, uid, requestIp, "uaus", fmt.Sprintf("state=%s days=%d", suspensionData.State, suspensionData.Days))
+	return c.NoContent(204)
+}
+
+// ユーザーの利用停止状態の変更履歴を取得する(管理者のみ)
+func getReqAdminUserSuspensions(c echo.Context) error {
+	uid := c.Param("uid")
+
+	logs, err := db.GetSuspensionLogs(uid)
+	if err != nil {
+		return c.JSON(400, MakeError("gaul-001", "変更履歴が取得できません"))
+	}
+
+	logDataList := make([]SuspensionLogData, len(logs))
+	for i, log := range logs {
+		until := ""
+		if log.State == db.SuspensionTemporary {
+			until = common.DateToString(log.Until)
+		}
+		logDataList[i] = SuspensionLogData{
+			State:      log.State,
+			Until:      until,
+			Reason:     log.Reason,
+			OperatorId: log.OperatorId,
+			CreatedAt:  common.DateToString(log.CreatedAt),
+		}
+	}
+	return c.JSON(200, logDataList)
+}
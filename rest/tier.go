@@ -7,17 +7,19 @@ import (
 	"net"
 	common "reviewmakerback/common"
 	db "reviewmakerback/db"
+	"reviewmakerback/tracing"
 	"strconv"
 
 	"github.com/labstack/echo"
+	"go.opentelemetry.io/otel/attribute"
 	"gorm.io/gorm"
 )
 
-// 一度に取得可能なTier/レビュー数
-const postPageSize = 5
+// 一度に取得可能なTier/レビュー数(Configureで設定する)
+var postPageSize int
 
-// レビューの最大登録数
-const ReviewMaxInTier = 255
+// レビューの最大登録数(Configureで設定する)
+var ReviewMaxInTier int
 
 type TierValidation struct {
 	// tier名の最大文字数最大
@@ -34,15 +36,8 @@ type TierValidation struct {
 	imgAspectRate float32
 }
 
-// Tierに関するバリデーション
-var tierValidation = TierValidation{
-	nameLenMax:      100,
-	paramsLenMax:    16,
-	paramNameLenMax: 16,
-	imgMaxBytes:     10000,
-	imgMaxEdge:      1080,
-	imgAspectRate:   10.0 / 3.0,
-}
+// Tierに関するバリデーション(Configureで設定する)
+var tierValidation TierValidation
 
 // Tierのバリデーション
 func validTier(tierData TierEditingData) (bool, *ErrorResponse) {
@@ -137,9 +132,9 @@ func removeParamIndex(params []ReviewParamData) []ReviewParam {
 
 func postReqTier(c echo.Context) error {
 	// セッションの存在チェック
-	session, err := db.CheckSession(c, true, false)
+	session, err := db.CheckSession(c, true, true)
 	if err != nil {
-		return c.JSON(403, commonError.noSession)
+		return c.JSON(403, sessionError(err))
 	}
 
 	// 最小投稿頻度のチェック
@@ -182,14 +177,17 @@ func postReqTier(c echo.Context) error {
 	var er *ErrorResponse
 	if tierData.ImageIsChanged {
 		// 画像の保存
-		path, er = savePicture(session.UserId, "tier", tierId, "image_", "", tierData.ImageBase64, "ptir-003", tierValidation.imgMaxEdge, tierValidation.imgAspectRate, 80)
+		path, er = savePicture(c.Request().Context(), session.UserId, "tier", tierId, "image_", "", tierData.ImageBase64, "ptir-003", tierValidation.imgMaxEdge, tierValidation.imgAspectRate, 80)
 		if er != nil {
 			return c.JSON(400, er)
 		}
 	}
 
+	// 説明画像の変換ジョブのIDを記録する
+	pending := withPendingJobs(c)
+
 	// Paragsを加工、Parag内の画像を保存
-	madeParags, _, er := createParags(tierData.Parags, parags2DelImageMap([]ParagData{}), session.UserId, "tier", tierId, "image_")
+	madeParags, _, er := createParags(c.Request().Context(), tierData.Parags, parags2DelImageMap([]ParagData{}), session.UserId, "tier", tierId, "image_")
 	if er != nil {
 		deleteParagsImg(madeParags)
 		return c.JSON(400, er)
@@ -209,11 +207,13 @@ func postReqTier(c echo.Context) error {
 	if err != nil {
 		// 新しく作成した途中の画像ファイルを削除
 		deleteParagsImg(madeParags)
-		db.WriteErrorLog(session.UserId, requestIp, "ptir-005", "Tierの作成に失敗しました", err.Error())
+		db.WriteErrorLogContext(c.Request().Context(), session.UserId, requestIp, "ptir-005", "Tierの作成に失敗しました", err.Error())
 		return c.JSON(400, MakeError("ptir-005", "Tierの作成に失敗しました"))
 	}
 
-	db.WriteOperationLog(session.UserId, requestIp, "ptir", tierId)
+	db.WriteOperationLogContext(c.Request().Context(), session.UserId, requestIp, "ptir", tierId)
+	emitWebhook(c.Request().Context(), session.UserId, requestIp, "tier.created", tierId, "")
+	pending.setHeader(c)
 	return c.String(201, tierId)
 }
 
@@ -221,9 +221,9 @@ func updateReqTier(c echo.Context) error {
 	tid := c.Param("tid")
 
 	// セッションの存在チェック
-	session, err := db.CheckSession(c, true, false)
+	session, err := db.CheckSession(c, true, true)
 	if err != nil {
-		return c.JSON(403, commonError.noSession)
+		return c.JSON(403, sessionError(err))
 	}
 
 	requestIp := net.ParseIP(c.RealIP()).String()
@@ -241,7 +241,7 @@ func updateReqTier(c echo.Context) error {
 
 	// Tierのチェック
 	var cnt int64
-	orgTier, tx := db.GetTier(tid, "*")
+	orgTier, tx := db.GetTier(c.Request().Context(), tid, "*")
 	tx.Count(&cnt)
 	if cnt != 1 {
 		return c.JSON(400, MakeError("utir-001", "該当するTierがありません"))
@@ -277,7 +277,7 @@ func updateReqTier(c echo.Context) error {
 	path := ""
 	var er *ErrorResponse
 	if tierData.ImageIsChanged {
-		path, er = savePicture(session.UserId, "tier", tid, "icon_", "", tierData.ImageBase64, "utir-003", tierValidation.imgMaxEdge, tierValidation.imgAspectRate, 80)
+		path, er = savePicture(c.Request().Context(), session.UserId, "tier", tid, "icon_", "", tierData.ImageBase64, "utir-003", tierValidation.imgMaxEdge, tierValidation.imgAspectRate, 80)
 		if er != nil {
 			return c.JSON(400, er)
 		}
@@ -291,8 +291,11 @@ func updateReqTier(c echo.Context) error {
 		return c.JSON(400, MakeError("utir-004", "説明文等"))
 	}
 
+	// 説明画像の変換ジョブのIDを記録する
+	pending := withPendingJobs(c)
+
 	// Paragsを加工、Parag内の画像を保存
-	madeParags, imageMap, er := createParags(tierData.Parags, parags2DelImageMap(orgParags), session.UserId, "tier", orgTier.TierId, "image_")
+	madeParags, imageMap, er := createParags(c.Request().Context(), tierData.Parags, parags2DelImageMap(orgParags), session.UserId, "tier", orgTier.TierId, "image_")
 	if er != nil {
 		deleteParagsImg(madeParags)
 		return c.JSON(400, er)
@@ -345,47 +348,61 @@ func updateReqTier(c echo.Context) error {
 		diffParams = true
 	}
 
-	err = db.Db.Transaction(func(tx *gorm.DB) error {
+	// トランザクション内のクエリをリクエストのトレースに含める
+	ctx := c.Request().Context()
+	err = db.Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
 
 		// paramsの変更に合わせて、レビュー評点や情報を整理する
 		if diffParams {
-			// 旧データを取得
-			tx1 := tx.Select("review_id, review_factors, updated_at").Where("tier_id = ?", orgTier.TierId).Find(&reviews)
+			// レビューの評価要素の並び替えとJSONの再変換
+			err = func() error {
+				spanCtx, span := tracing.Start(ctx, "tier.updateReviewFactors", attribute.String("tier.id", orgTier.TierId))
+				defer span.End()
+				tx := tx.WithContext(spanCtx)
 
-			if tx1.Error != nil {
-				return tx1.Error
-			}
+				// 旧データを取得
+				tx1 := tx.Select("review_id, review_factors, updated_at").Where("tier_id = ?", orgTier.TierId).Find(&reviews)
 
-			for _, review := range reviews {
-				// 旧データをデシリアライズ
-				err = json.Unmarshal([]byte(review.ReviewFactors), &oldFactors)
-				if err != nil {
-					return err
+				if tx1.Error != nil {
+					return tx1.Error
 				}
-				// 新しい評価要素を入れる配列
-				newFactors = make([]ReviewFactorData, newParamsLen)
-				for i := range newFactors {
-					// 受け取ったデータから、旧配列のときにあった場所を読み取る
-					oldIndex = tierData.ReviewFactorParams[i].Index
-					if oldIndex < 0 {
-						// 負数であれば、新規追加されたものとして初期化する
-						newFactors[i] = ReviewFactorData{
-							Info:  "",
-							Point: 0,
+
+				for _, review := range reviews {
+					// 旧データをデシリアライズ
+					err = json.Unmarshal([]byte(review.ReviewFactors), &oldFactors)
+					if err != nil {
+						return err
+					}
+					// 新しい評価要素を入れる配列
+					newFactors = make([]ReviewFactorData, newParamsLen)
+					for i := range newFactors {
+						// 受け取ったデータから、旧配列のときにあった場所を読み取る
+						oldIndex = tierData.ReviewFactorParams[i].Index
+						if oldIndex < 0 {
+							// 負数であれば、新規追加されたものとして初期化する
+							newFactors[i] = ReviewFactorData{
+								Info:  "",
+								Point: 0,
+							}
+						} else if oldIndex < len(oldFactors) {
+							// 0以上であれば、旧配列の位置から新配列の位置に移動する
+							newFactors[i] = oldFactors[oldIndex]
 						}
-					} else if oldIndex < len(oldFactors) {
-						// 0以上であれば、旧配列の位置から新配列の位置に移動する
-						newFactors[i] = oldFactors[oldIndex]
+					}
+					newFactorsBin, err = json.Marshal(newFactors)
+					if err != nil {
+						return err
+					}
+					tx1 = tx.Model(&review).Update("review_factors", string(newFactorsBin))
+					if tx1.Error != nil {
+						return tx1.Error
 					}
 				}
-				newFactorsBin, err = json.Marshal(newFactors)
-				if err != nil {
-					return err
-				}
-				tx1 = tx.Model(&review).Update("review_factors", string(newFactorsBin))
-				if tx1.Error != nil {
-					return tx1.Error
-				}
+				span.SetAttributes(attribute.Int("reviews.count", len(reviews)))
+				return nil
+			}()
+			if err != nil {
+				return err
 			}
 		}
 
@@ -404,9 +421,9 @@ func updateReqTier(c echo.Context) error {
 		// 新しく保存した方の画像削除
 		er = deleteFile("utir-006", path)
 		if er != nil {
-			db.WriteErrorLog(session.UserId, requestIp, er.Code, er.Message, err.Error())
+			db.WriteErrorLogContext(c.Request().Context(), session.UserId, requestIp, er.Code, er.Message, err.Error())
 		}
-		db.WriteErrorLog(session.UserId, requestIp, "utir-007", "Tierの更新に失敗しました", err.Error())
+		db.WriteErrorLogContext(c.Request().Context(), session.UserId, requestIp, "utir-007", "Tierの更新に失敗しました", err.Error())
 		return c.JSON(400, MakeError("utir-007", "Tierに紐づくレビューの評価要素の登録に失敗しました"))
 	}
 
@@ -415,7 +432,9 @@ func updateReqTier(c echo.Context) error {
 		deleteFile("", orgTier.ImageUrl)
 	}
 
-	db.WriteOperationLog(session.UserId, requestIp, "utir", tid)
+	db.WriteOperationLogContext(c.Request().Context(), session.UserId, requestIp, "utir", tid)
+	emitWebhook(c.Request().Context(), session.UserId, requestIp, "tier.updated", tid, "")
+	pending.setHeader(c)
 	return c.String(200, tid)
 }
 
@@ -424,15 +443,20 @@ func getReqTier(c echo.Context) error {
 
 	var cnt int64
 
-	tier, tx := db.GetTier(tid, "*")
+	tier, tx := db.GetTier(c.Request().Context(), tid, "*")
 	tx.Count(&cnt)
 	if cnt != 1 {
 		return c.JSON(404, MakeError("gtir-001", "Tierが存在しません"))
 	}
 
-	user, tx := db.GetUser(tier.UserId, "*")
+	// 非表示のTierは作成者とモデレーターのみ参照できる
+	if tier.IsHidden && !canViewHidden(c, tier.UserId) {
+		return c.JSON(404, MakeError("gtir-001", "Tierが存在しません"))
+	}
+
+	user, tx := db.GetUser(c.Request().Context(), tier.UserId, "*")
 	tx.Count(&cnt)
-	if cnt != 1 {
+	if cnt != 1 || isSuspendedFor(c, user) {
 		return c.JSON(404, MakeError("gtir-002", "ユーザーが存在しません"))
 	}
 
@@ -441,7 +465,8 @@ func getReqTier(c echo.Context) error {
 		return c.JSON(400, er)
 	}
 
-	reviews, err := db.GetReviews(user.UserId, tid, "", "updatedAtDesc", 1, ReviewMaxInTier, false)
+	// 非表示のレビューは作成者とモデレーターのみ参照できる
+	reviews, err := db.GetReviews(c.Request().Context(), user.UserId, tid, "", "updatedAtDesc", 1, ReviewMaxInTier, false, canViewHidden(c, user.UserId))
 	if err != nil {
 		return c.JSON(404, MakeError("gtir-004", "Tierに紐づくレビューが取得できませんでした"))
 	}
@@ -500,6 +525,7 @@ func makeTierData(tid string, user db.User, tier db.Tier, code string) (TierData
 		ReviewFactorParams: params,
 		PullingUp:          tier.PullingUp,
 		PullingDown:        tier.PullingDown,
+		IsHidden:           tier.IsHidden,
 		CreatedAt:          common.DateToString(tier.CreatedAt),
 		UpdatedAt:          common.DateToString(tier.UpdatedAt),
 	}, nil
@@ -522,14 +548,15 @@ func getReqTiers(c echo.Context) error {
 	}
 
 	var cnt int64
-	user, tx := db.GetUser(userId, "*")
+	user, tx := db.GetUser(c.Request().Context(), userId, "*")
 	tx.Count(&cnt)
-	if cnt != 1 {
+	if cnt != 1 || isSuspendedFor(c, user) {
 		return c.JSON(404, MakeError("gtrs-004", "指定されたユーザーは存在しません"))
 	}
 
 	var er *ErrorResponse
-	tiers, err := db.GetTiers(userId, word, sortType, page, postPageSize)
+	// 非表示のTierは作成者とモデレーターのみ参照できる
+	tiers, err := db.GetTiers(c.Request().Context(), userId, word, sortType, page, postPageSize, canViewHidden(c, user.UserId))
 	if err != nil {
 		return c.JSON(400, MakeError("gtrs-005", "Tierが取得できません"))
 	}
@@ -548,14 +575,14 @@ func deleteReqTier(c echo.Context) error {
 	tid := c.Param("tid")
 
 	// セッションの存在チェック
-	session, err := db.CheckSession(c, true, false)
+	session, err := db.CheckSession(c, true, true)
 	if err != nil {
-		return c.JSON(403, commonError.noSession)
+		return c.JSON(403, sessionError(err))
 	}
 
 	requestIp := net.ParseIP(c.RealIP()).String()
 
-	tier, tx := db.GetTier(tid, "tier_id, user_id")
+	tier, tx := db.GetTier(c.Request().Context(), tid, "tier_id, user_id")
 
 	var cnt int64
 	tx.Count(&cnt)
@@ -584,15 +611,19 @@ func deleteReqTier(c echo.Context) error {
 	})
 
 	if err != nil {
-		db.WriteErrorLog(session.UserId, requestIp, "dtir-002", "Tierの削除に失敗しました", err.Error())
+		db.WriteErrorLogContext(c.Request().Context(), session.UserId, requestIp, "dtir-002", "Tierの削除に失敗しました", err.Error())
 		return c.JSON(400, MakeError("dtir-002", "Tierの削除に失敗しました"))
 	}
 
 	for _, review := range reviews {
-		deleteFolder(tier.UserId, "review", review.ReviewId, "dtir-003", requestIp)
+		deleteFolder(c.Request().Context(), tier.UserId, "review", review.ReviewId, "dtir-003", requestIp)
 	}
-	deleteFolder(tier.UserId, "tier", tier.TierId, "dtir-004", requestIp)
+	deleteFolder(c.Request().Context(), tier.UserId, "tier", tier.TierId, "dtir-004", requestIp)
 
-	db.WriteOperationLog(session.UserId, requestIp, "dtir", tid)
+	db.WriteOperationLogContext(c.Request().Context(), session.UserId, requestIp, "dtir", tid)
+	for _, review := range reviews {
+		emitWebhook(c.Request().Context(), session.UserId, requestIp, "review.deleted", tid, review.ReviewId)
+	}
+	emitWebhook(c.Request().Context(), session.UserId, requestIp, "tier.deleted", tid, "")
 	return c.NoContent(204)
 }
@@ -0,0 +1,53 @@
+package rest
+
+import (
+	"github.com/labstack/echo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"reviewmakerback/logger"
+	"reviewmakerback/tracing"
+)
+
+// リクエストごとにサーバーのスパンを開始する
+// クライアントからtraceparentヘッダーを受け取った場合はそのトレースを引き継ぐ
+func Tracing() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			if isProbePath(req.URL.Path) {
+				return next(c)
+			}
+
+			ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+			route := routeLabel(c)
+			ctx, span := tracing.Tracer().Start(ctx, req.Method+" "+route,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					semconv.HTTPMethodKey.String(req.Method),
+					semconv.HTTPRouteKey.String(route),
+					semconv.HTTPTargetKey.String(req.URL.Path),
+					semconv.HTTPClientIPKey.String(c.RealIP()),
+					attribute.String("http.request_id", logger.RequestId(ctx)),
+				))
+			defer span.End()
+			c.SetRequest(req.WithContext(ctx))
+
+			// ステータスコードを確定させるため、エラーはここで処理する
+			if err := next(c); err != nil {
+				c.Error(err)
+			}
+
+			status := c.Response().Status
+			span.SetAttributes(semconv.HTTPStatusCodeKey.Int(status))
+			if status >= 500 {
+				span.SetStatus(codes.Error, "")
+			}
+			return nil
+		}
+	}
+}
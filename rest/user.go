@@ -1,8 +1,8 @@
 package rest
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -11,20 +11,20 @@ import (
 	"time"
 
 	"github.com/labstack/echo"
-	"gorm.io/gorm"
 
 	"reviewmakerback/common"
 	db "reviewmakerback/db"
 )
 
-const latestPostMax = 100
+// 最新の投稿一覧の最大取得数(Configureで設定する)
+var latestPostMax int
 
 // ユーザー作成のためのPOSTリクエストの処理
 func postReqUser(c echo.Context) error {
 	// セッションの存在チェック
 	session, err := db.CheckSession(c, false, false)
 	if err != nil {
-		return c.JSON(403, commonError.noSession)
+		return c.JSON(403, sessionError(err))
 	}
 
 	// Bodyの読み取り
@@ -66,17 +66,29 @@ func postReqUser(c echo.Context) error {
 
 	// アイコンはとりあえず設定しない
 	requestIp := net.ParseIP(c.RealIP()).String()
-	user, err := db.CreateUser(
-		session.LoginService,
-		userData.Name,
-		userData.Profile,
-		"",
-		twitterId,
-		session.TwitterUserName,
-		googleId,
-		session.GoogleEmail,
-		requestIp,
-	)
+	var user db.User
+	if session.LoginService == db.ServiceOidc {
+		user, err = db.CreateOidcUser(
+			userData.Name,
+			userData.Profile,
+			"",
+			session.ServiceId,
+			session.OidcEmail,
+			requestIp,
+		)
+	} else {
+		user, err = db.CreateUser(
+			session.LoginService,
+			userData.Name,
+			userData.Profile,
+			"",
+			twitterId,
+			session.TwitterUserName,
+			googleId,
+			session.GoogleEmail,
+			requestIp,
+		)
+	}
 	if err != nil {
 		return c.JSON(400, MakeError("pusr-005", err.Error()))
 	}
@@ -89,7 +101,7 @@ func postReqUser(c echo.Context) error {
 	// 画像の保存
 	path := ""
 	if userData.IconBase64 != "" {
-		path, er = savePicture(user.UserId, "user", "user", "icon_", "", userData.IconBase64, "pusr-006", reviewValidation.iconMaxEdge, reviewValidation.iconAspectRate, 92)
+		path, er = savePicture(c.Request().Context(), user.UserId, "user", "user", "icon_", "", userData.IconBase64, "pusr-006", reviewValidation.iconMaxEdge, reviewValidation.iconAspectRate, 92)
 		if er != nil {
 			// トランザクションを使用できないので、保存に失敗したらユーザーを削除する
 			db.Db.Where("user_id = ?", user.UserId).Delete(&db.User{})
@@ -100,8 +112,9 @@ func postReqUser(c echo.Context) error {
 	// 後からアイコンを変更する
 	db.UpdateUser(user, userData.Name, userData.Profile, path, true, false, 7200)
 
-	db.WriteOperationLog(user.UserId, requestIp, "pusr", "")
+	db.WriteOperationLogContext(c.Request().Context(), user.UserId, requestIp, "pusr", "")
 
+	usedBytes, _ := db.GetStorageUsage(user.UserId)
 	return c.JSON(201, SelfUserData{
 		UserId:           user.UserId,
 		IsSelf:           true,
@@ -113,17 +126,20 @@ func postReqUser(c echo.Context) error {
 		TwitterId:        twitterId,
 		TwitterUserName:  session.TwitterUserName,
 		GoogleEmail:      session.GoogleEmail,
+		OidcEmail:        user.OidcEmail,
 		ReviewsCount:     0,
 		TiersCount:       0,
+		StorageUsed:      usedBytes,
+		StorageQuota:     storageQuotaBytes,
 	})
 }
 
 // ユーザーデータの更新のためのUPDATEリクエストの処理
 func updateReqUser(c echo.Context) error {
 	// セッションの存在チェック
-	session, err := db.CheckSession(c, true, false)
+	session, err := db.CheckSession(c, true, true)
 	if err != nil {
-		return c.JSON(403, commonError.noSession)
+		return c.JSON(403, sessionError(err))
 	}
 
 	uid := c.Param("uid")
@@ -165,7 +181,7 @@ func updateReqUser(c echo.Context) error {
 	}
 
 	var cnt int64
-	user, tx := db.GetUser(uid, "*")
+	user, tx := db.GetUser(c.Request().Context(), uid, "*")
 	if err != nil {
 		return c.JSON(400, MakeError("uusr-005", "ユーザーの更新に失敗しました"))
 	}
@@ -178,7 +194,7 @@ func updateReqUser(c echo.Context) error {
 	path := ""
 	if userData.IconIsChanged {
 		// 画像の保存
-		path, er = savePicture(user.UserId, "user", "user", "icon_", user.IconUrl, userData.IconBase64, "uusr-007", reviewValidation.iconMaxEdge, reviewValidation.iconAspectRate, 92)
+		path, er = savePicture(c.Request().Context(), user.UserId, "user", "user", "icon_", user.IconUrl, userData.IconBase64, "uusr-007", reviewValidation.iconMaxEdge, reviewValidation.iconAspectRate, 92)
 		if er != nil {
 			return c.JSON(400, er)
 		}
@@ -187,7 +203,7 @@ func updateReqUser(c echo.Context) error {
 	db.UpdateUser(user, userData.Name, userData.Profile, path, userData.IconIsChanged, userData.AllowTwitterLink, userData.KeepSession*60)
 
 	requestIp := net.ParseIP(c.RealIP()).String()
-	db.WriteOperationLog(user.UserId, requestIp, "uusr", "")
+	db.WriteOperationLogContext(c.Request().Context(), user.UserId, requestIp, "uusr", "")
 
 	return c.String(200, uid)
 }
@@ -195,7 +211,7 @@ func updateReqUser(c echo.Context) error {
 // ユーザーデータを取得するGETリクエストの処理
 func getReqUserData(c echo.Context) error {
 	// 送信元ユーザーと参照先ユーザーが同じかどうかチェック
-	session, err := db.CheckSession(c, true, false)
+	session, err := db.CheckSession(c, true, true)
 
 	existsSession := err == nil
 
@@ -203,10 +219,10 @@ func getReqUserData(c echo.Context) error {
 	var cnt int64
 
 	uid := c.Param("uid")
-	user, tx := db.GetUser(uid, "*")
+	user, tx := db.GetUser(c.Request().Context(), uid, "*")
 	tx.Count(&cnt)
 
-	if cnt != 1 {
+	if cnt != 1 || isSuspendedFor(c, user) {
 		return c.JSON(404, MakeError("gusr-001", "ユーザーが存在しません"))
 	}
 
@@ -220,13 +236,19 @@ func getReqUserData(c echo.Context) error {
 			TwitterId:        user.TwitterId,
 			TwitterUserName:  user.TwitterUserName,
 			GoogleEmail:      user.GoogleEmail,
+			OidcEmail:        user.OidcEmail,
+			Role:             user.Role,
+			SuspensionState:  user.SuspensionState,
+			SuspendedUntil:   suspendedUntilString(user),
 			Name:             user.Name,
 			Profile:          user.Profile,
 			AllowTwitterLink: user.AllowTwitterLink,
 			KeepSession:      user.KeepSession / 60,
-			ReviewsCount:     db.GetReviewCountInUser(user.UserId),
-			TiersCount:       db.GetTierCountInUser(user.UserId),
+			ReviewsCount:     db.GetReviewCountInUser(c.Request().Context(), user.UserId),
+			TiersCount:       db.GetTierCountInUser(c.Request().Context(), user.UserId),
+			StorageQuota:     storageQuotaBytes,
 		}
+		selfUserData.StorageUsed, _ = db.GetStorageUsage(user.UserId)
 
 		return c.JSON(200, selfUserData)
 	} else {
@@ -239,8 +261,8 @@ func getReqUserData(c echo.Context) error {
 			Name:             user.Name,
 			Profile:          user.Profile,
 			AllowTwitterLink: user.AllowTwitterLink,
-			ReviewsCount:     db.GetReviewCountInUser(user.UserId),
-			TiersCount:       db.GetTierCountInUser(user.UserId),
+			ReviewsCount:     db.GetReviewCountInUser(c.Request().Context(), user.UserId),
+			TiersCount:       db.GetTierCountInUser(c.Request().Context(), user.UserId),
 		}
 
 		// 送信元ユーザーと参照先ユーザーが異なる場合またはそもそもセッションが無い場合
@@ -266,15 +288,18 @@ func getReqLatestPostLists(c echo.Context) error {
 		}
 	}
 
-	if !db.ExistsUser(uid) {
+	var cnt int64
+	user, tx := db.GetUser(c.Request().Context(), uid, "user_id, suspension_state, suspended_until")
+	tx.Count(&cnt)
+	if cnt != 1 || isSuspendedFor(c, user) {
 		return c.JSON(404, MakeError("gpls-002", "ユーザーが存在しません"))
 	}
 
 	var tiers []db.Tier
-	db.Db.Select("tier_id, name").Where("user_id = ?", uid).Order("updated_at desc").Limit(length).Find(&tiers)
+	db.Db.Select("tier_id, name").Where("user_id = ? and is_hidden = ?", uid, false).Order("updated_at desc").Limit(length).Find(&tiers)
 
 	var reviews []db.Review
-	db.Db.Select("review_id, name").Where("user_id = ?", uid).Order("updated_at desc").Limit(length).Find(&reviews)
+	db.Db.Select("review_id, name").Where("user_id = ? and is_hidden = ?", uid, false).Order("updated_at desc").Limit(length).Find(&reviews)
 
 	postListData := PostListsData{
 		Tiers:   make([]PostListItem, len(tiers)),
@@ -302,9 +327,9 @@ func deleteUser1(c echo.Context) error {
 	uid := c.Param("uid")
 
 	// セッションの存在チェック
-	session, err := db.CheckSession(c, true, false)
+	session, err := db.CheckSession(c, true, true)
 	if err != nil {
-		return c.JSON(403, commonError.noSession)
+		return c.JSON(403, sessionError(err))
 	}
 
 	// 編集ユーザーとTier所有ユーザーチェック
@@ -321,7 +346,7 @@ func deleteUser1(c echo.Context) error {
 	}
 
 	requestIp := net.ParseIP(c.RealIP()).String()
-	db.WriteOperationLog(session.UserId, requestIp, "dus1", "")
+	db.WriteOperationLogContext(c.Request().Context(), session.UserId, requestIp, "dus1", "")
 
 	return c.String(202, delcode)
 }
@@ -333,9 +358,9 @@ func deleteUser2(c echo.Context) error {
 	requestIp := net.ParseIP(c.RealIP()).String()
 
 	// セッションの存在チェック
-	session, err := db.CheckSession(c, true, false)
+	session, err := db.CheckSession(c, true, true)
 	if err != nil {
-		return c.JSON(403, commonError.noSession)
+		return c.JSON(403, sessionError(err))
 	}
 
 	// 編集ユーザーとTier所有ユーザーチェック
@@ -349,62 +374,35 @@ func deleteUser2(c echo.Context) error {
 		return c.JSON(400, MakeError("dus2-002", "削除コードの期限が切れています"))
 	}
 
-	result := db.Db.Transaction(func(tx *gorm.DB) error {
-		var user db.User
-		var cnt int64
-		tdb := tx.Where("user_id = ?", session.UserId).Find(&user)
-		if tdb.Error != nil {
-			return tdb.Error
-		}
-		if tdb.Count(&cnt); cnt != 1 {
-			return errors.New("ユーザーが存在しません")
-		}
-
-		// Tier削除
-		tdb = tx.Where("user_id = ?", session.UserId).Delete(&db.Tier{})
-		if tdb.Error != nil {
-			return tdb.Error
-		}
-
-		// レビュー削除
-		tdb = tx.Where("user_id = ?", session.UserId).Delete(&db.Review{})
-		if tdb.Error != nil {
-			return tdb.Error
-		}
-
-		// 通知の既読状態削除
-		tdb = tx.Where("user_id = ?", session.UserId).Delete(&db.NotificationRead{})
-		if tdb.Error != nil {
-			return tdb.Error
-		}
-
-		// セッション削除
-		tdb = tx.Where("user_id = ?", session.UserId).Delete(&db.Session{})
-		if tdb.Error != nil {
-			return tdb.Error
-		}
-
-		// 一時セッションはUserIdを持っていないので何もしない
-
-		// ユーザー削除
-		tdb = tx.Where("user_id = ?", session.UserId).Delete(&db.User{})
-		if tdb.Error != nil {
-			return tdb.Error
-		}
-		return nil
-	})
+	result := db.DeleteUserData(session.UserId)
 
 	if result != nil {
-		db.WriteErrorLog(session.UserId, requestIp, "dus2-003", "ユーザーの削除に失敗しました", result.Error())
+		db.WriteErrorLogContext(c.Request().Context(), session.UserId, requestIp, "dus2-003", "ユーザーの削除に失敗しました", result.Error())
 		return c.JSON(400, MakeError("dus2-003", "ユーザーの削除に失敗しました"))
 	}
 
 	// 全ファイルを削除するが、エラーが起こっても中断せず記録のみ残す
-	err = os.RemoveAll((fmt.Sprintf("%s/%s", os.Getenv("BACK_AP_FILE_PATH"), session.UserId)))
-	if err != nil && !os.IsNotExist(err) {
-		db.WriteErrorLog(session.UserId, requestIp, "dus2-004", "フォルダが削除できませんでした", fmt.Sprintf("'%s/%s' %s", os.Getenv("BACK_AP_FILE_PATH"), session.UserId, err.Error()))
-	}
+	deleteUserFolder(c.Request().Context(), session.UserId, session.UserId, requestIp, "dus2-004")
 
-	db.WriteOperationLog(session.UserId, requestIp, "dus2", "")
+	db.WriteOperationLogContext(c.Request().Context(), session.UserId, requestIp, "dus2", "")
 	return c.NoContent(204)
 }
+
+// ユーザーの全ファイル(変換待ちの画像・エクスポートしたファイルを含む)を削除する
+// エラーが起こっても中断せず記録のみ残す
+func deleteUserFolder(ctx context.Context, userId string, operatorId string, ipAddress string, errorCode string) {
+	for _, dir := range []string{
+		fmt.Sprintf("%s/%s", filePath, userId),
+		fmt.Sprintf("%s/.queue/images/%s", filePath, userId),
+		fmt.Sprintf("%s/.exports/%s", filePath, userId),
+	} {
+		err := os.RemoveAll(dir)
+		if err != nil && !os.IsNotExist(err) {
+			db.WriteErrorLogContext(ctx, operatorId, ipAddress, errorCode, "フォルダが削除できませんでした", fmt.Sprintf("'%s' %s", dir, err.Error()))
+		}
+	}
+	err := db.DeleteStorageUsage(userId)
+	if err != nil {
+		db.WriteErrorLogContext(ctx, operatorId, ipAddress, errorCode, "保存済みの画像の容量の記録を削除できませんでした", err.Error())
+	}
+}
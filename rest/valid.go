@@ -1,6 +1,7 @@
 package rest
 
 import (
+	"errors"
 	"fmt"
 	"regexp"
 	"reviewmakerback/common"
@@ -17,11 +18,8 @@ type UserValidation struct {
 	iconMaxBytes int
 }
 
-var userValidation = UserValidation{
-	nameLenMax:    50,
-	profileLenMax: 400,
-	iconMaxBytes:  10000,
-}
+// ユーザーに関するバリデーション(Configureで設定する)
+var userValidation UserValidation
 
 type SectionValidation struct {
 	// セクションタイトルの最大文字数
@@ -42,16 +40,8 @@ type SectionValidation struct {
 	paragImageQuality int
 }
 
-var sectionValidation = SectionValidation{
-	sectionTitleLen:   100,
-	paragTextLenMax:   5000,
-	paragsLenMax:      64,
-	paragLinkLenMax:   400,
-	paragImgMaxBytes:  10000,
-	paragImgAspect:    -1,
-	paragImgMax:       1080,
-	paragImageQuality: 60,
-}
+// セクションに関するバリデーション(Configureで設定する)
+var sectionValidation SectionValidation
 
 // アスペクト比の振れ幅
 const aspectRateAmp = 0.1
@@ -61,6 +51,10 @@ type CommonError struct {
 	unreadableBody ErrorResponse
 	userNotEqual   ErrorResponse
 	tooFrequently  ErrorResponse
+	noPermission   ErrorResponse
+	suspended      ErrorResponse
+	rateLimited    ErrorResponse
+	storageQuota   ErrorResponse
 }
 
 var commonError = CommonError{
@@ -80,6 +74,30 @@ var commonError = CommonError{
 		Code:    "gen0-004-00",
 		Message: fmt.Sprintf("投稿は%d秒以上あけて実行してください", db.PostSpanMin),
 	},
+	noPermission: ErrorResponse{
+		Code:    "gen0-005-00",
+		Message: "この操作を行う権限がありません",
+	},
+	suspended: ErrorResponse{
+		Code:    "gen0-006-00",
+		Message: "利用停止中のため、この操作は行えません",
+	},
+	rateLimited: ErrorResponse{
+		Code:    "gen0-007-00",
+		Message: "リクエストが多すぎます しばらく時間を空けてもう一度実行してください",
+	},
+	storageQuota: ErrorResponse{
+		Code:    "gen0-008-00",
+		Message: "保存できる画像の容量の上限を超えています 不要な画像を削除してからもう一度実行してください",
+	},
+}
+
+// セッションの確認で発生したエラーに対応するレスポンスを返す
+func sessionError(err error) ErrorResponse {
+	if errors.Is(err, db.ErrUserSuspended) {
+		return commonError.suspended
+	}
+	return commonError.noSession
 }
 
 func MakeError(code string, message string) *ErrorResponse {
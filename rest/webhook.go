@@ -0,0 +1,231 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+
+	"reviewmakerback/common"
+	db "reviewmakerback/db"
+)
+
+// 一度に取得可能な配信ログ数
+const webhookDeliveriesPageSize = 50
+
+type WebhookValidation struct {
+	// URLの最大文字数
+	urlLenMax int
+	// シークレットの最小文字数
+	secretLenMin int
+	// シークレットの最大文字数
+	secretLenMax int
+	// ユーザー一人当たりのWebhook最大数
+	webhooksMax int64
+}
+
+// Webhookに関するバリデーション
+var webhookValidation = WebhookValidation{
+	urlLenMax:    400,
+	secretLenMin: 16,
+	secretLenMax: 128,
+	webhooksMax:  5,
+}
+
+// Webhookの配信キューを登録する
+// 登録に失敗しても元の操作は完了しているので、記録のみ残して処理を続行する
+func emitWebhook(ctx context.Context, userId string, ipAddress string, event string, tierId string, reviewId string) {
+	err := db.EnqueueWebhookEvent(userId, event, tierId, reviewId)
+	if err != nil {
+		db.WriteErrorLogContext(ctx, userId, ipAddress, "ewhk-001", "Webhookの配信キューの登録に失敗しました", fmt.Sprintf("event=%s tier=%s review=%s %s", event, tierId, reviewId, err.Error()))
+	}
+}
+
+func makeWebhookData(webhook db.Webhook) WebhookData {
+	return WebhookData{
+		WebhookId: webhook.WebhookId,
+		Url:       webhook.Url,
+		Events:    strings.Split(webhook.Events, ","),
+		IsActive:  webhook.IsActive,
+		CreatedAt: common.DateToString(webhook.CreatedAt),
+		UpdatedAt: common.DateToString(webhook.UpdatedAt),
+	}
+}
+
+// Webhookのバリデーション
+func validWebhook(webhookData WebhookEditingData) (bool, *ErrorResponse) {
+	f, er := validText("送信先URL", "vwhk-001", webhookData.Url, true, -1, webhookValidation.urlLenMax, `^((http)|(https))://[^<>"\s]+$`, "正しい形式")
+	if !f {
+		return f, er
+	}
+	// 内部向けのアドレスへの送信を防ぐ(名前解決が必要なホストは送信時に確認する)
+	u, err := url.Parse(webhookData.Url)
+	if err != nil || u.Hostname() == "" {
+		return false, MakeError("vwhk-001", "送信先URLは正しい形式で入力してください")
+	}
+	if common.IsInternalHost(u.Hostname()) {
+		return false, MakeError("vwhk-005", "内部向けのアドレスは送信先URLに指定できません")
+	}
+
+	f, er = validText("シークレット", "vwhk-002", webhookData.Secret, true, webhookValidation.secretLenMin, webhookValidation.secretLenMax, "", "")
+	if !f {
+		return f, er
+	}
+
+	if len(webhookData.Events) == 0 {
+		return false, MakeError("vwhk-003", "イベントを少なくとも一つ以上指定してください")
+	}
+	for _, event := range webhookData.Events {
+		if !common.Contains(event, db.WebhookEvents) {
+			return false, MakeError("vwhk-004", fmt.Sprintf("イベント'%s'は指定できません", event))
+		}
+	}
+	return true, nil
+}
+
+func postReqWebhook(c echo.Context) error {
+	// セッションの存在チェック
+	session, err := db.CheckSession(c, true, true)
+	if err != nil {
+		return c.JSON(403, sessionError(err))
+	}
+
+	requestIp := net.ParseIP(c.RealIP()).String()
+
+	// Bodyの読み取り
+	b, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return c.JSON(400, commonError.unreadableBody)
+	}
+	var webhookData WebhookEditingData
+	err = json.Unmarshal(b, &webhookData)
+	if err != nil {
+		return c.JSON(400, commonError.unreadableBody)
+	}
+
+	f, er := validWebhook(webhookData)
+	if !f {
+		return c.JSON(400, er)
+	}
+
+	if db.GetWebhookCountInUser(session.UserId) >= webhookValidation.webhooksMax {
+		return c.JSON(400, MakeError("pwhk-001", fmt.Sprintf("登録できるWebhookは%d個までです", webhookValidation.webhooksMax)))
+	}
+
+	webhook, err := db.CreateWebhook(session.UserId, webhookData.Url, webhookData.Secret, webhookData.Events)
+	if err != nil {
+		db.WriteErrorLogContext(c.Request().Context(), session.UserId, requestIp, "pwhk-002", "Webhookの登録に失敗しました", err.Error())
+		return c.JSON(400, MakeError("pwhk-002", "Webhookの登録に失敗しました"))
+	}
+
+	db.WriteOperationLogContext(c.Request().Context(), session.UserId, requestIp, "pwhk", webhook.WebhookId)
+	return c.JSON(201, makeWebhookData(webhook))
+}
+
+func getReqWebhooks(c echo.Context) error {
+	// セッションの存在チェック
+	session, err := db.CheckSession(c, true, true)
+	if err != nil {
+		return c.JSON(403, sessionError(err))
+	}
+
+	webhooks, err := db.GetWebhooks(session.UserId)
+	if err != nil {
+		return c.JSON(400, MakeError("gwhs-001", "Webhookが取得できません"))
+	}
+
+	webhookDataList := make([]WebhookData, len(webhooks))
+	for i, webhook := range webhooks {
+		webhookDataList[i] = makeWebhookData(webhook)
+	}
+	return c.JSON(200, webhookDataList)
+}
+
+func deleteReqWebhook(c echo.Context) error {
+	wid := c.Param("wid")
+
+	// セッションの存在チェック
+	session, err := db.CheckSession(c, true, true)
+	if err != nil {
+		return c.JSON(403, sessionError(err))
+	}
+
+	requestIp := net.ParseIP(c.RealIP()).String()
+
+	var cnt int64
+	webhook, tx := db.GetWebhook(wid, "webhook_id, user_id")
+	tx.Count(&cnt)
+	if cnt != 1 {
+		return c.JSON(404, MakeError("dwhk-001", "Webhookが存在しません"))
+	}
+
+	// 編集ユーザーとWebhook所有ユーザーチェック
+	if session.UserId != webhook.UserId {
+		return c.JSON(403, commonError.userNotEqual)
+	}
+
+	err = db.DeleteWebhook(wid)
+	if err != nil {
+		db.WriteErrorLogContext(c.Request().Context(), session.UserId, requestIp, "dwhk-002", "Webhookの削除に失敗しました", err.Error())
+		return c.JSON(400, MakeError("dwhk-002", "Webhookの削除に失敗しました"))
+	}
+
+	db.WriteOperationLogContext(c.Request().Context(), session.UserId, requestIp, "dwhk", wid)
+	return c.NoContent(204)
+}
+
+// Webhookの配信ログを取得する
+func getReqWebhookDeliveries(c echo.Context) error {
+	wid := c.Param("wid")
+
+	// セッションの存在チェック
+	session, err := db.CheckSession(c, true, true)
+	if err != nil {
+		return c.JSON(403, sessionError(err))
+	}
+
+	page, err := strconv.Atoi(c.QueryParam("page"))
+	if err != nil || page < 1 {
+		return c.JSON(400, MakeError("gwhd-001", "ページ指定が異常です"))
+	}
+
+	var cnt int64
+	webhook, tx := db.GetWebhook(wid, "webhook_id, user_id")
+	tx.Count(&cnt)
+	if cnt != 1 {
+		return c.JSON(404, MakeError("gwhd-002", "Webhookが存在しません"))
+	}
+
+	// 参照ユーザーとWebhook所有ユーザーチェック
+	if session.UserId != webhook.UserId {
+		return c.JSON(403, commonError.userNotEqual)
+	}
+
+	deliveries, err := db.GetWebhookDeliveries(wid, page, webhookDeliveriesPageSize)
+	if err != nil {
+		return c.JSON(400, MakeError("gwhd-003", "配信ログが取得できません"))
+	}
+
+	deliveryDataList := make([]WebhookDeliveryData, len(deliveries))
+	for i, delivery := range deliveries {
+		deliveryDataList[i] = WebhookDeliveryData{
+			Id:            delivery.Id,
+			Event:         delivery.Event,
+			Payload:       delivery.Payload,
+			Status:        delivery.Status,
+			Attempts:      delivery.Attempts,
+			StatusCode:    delivery.StatusCode,
+			LastError:     delivery.LastError,
+			NextAttemptAt: common.DateToString(delivery.NextAttemptAt),
+			CreatedAt:     common.DateToString(delivery.CreatedAt),
+			UpdatedAt:     common.DateToString(delivery.UpdatedAt),
+		}
+	}
+	return c.JSON(200, deliveryDataList)
+}
@@ -0,0 +1,375 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	db "reviewmakerback/db"
+)
+
+// データベースから一度に読み込む行数
+const batchSize = 500
+
+// 保存先の直下でこの文字から始まるフォルダ(変換待ちの画像・エクスポート等)は確認の対象外
+const internalPrefix = "."
+
+// 変換待ちの画像の保存先(保存先からの相対パス)
+const pendingImageDir = ".queue/images"
+
+// ファイルの参照元の種類
+const (
+	KindTierImage   = "tier.image"
+	KindTierParag   = "tier.parag"
+	KindReviewIcon  = "review.icon"
+	KindReviewParag = "review.parag"
+	KindUserIcon    = "user.icon"
+)
+
+// データベースからのファイルの参照
+type Reference struct {
+	Kind    string `json:"kind"`    // 参照元の種類
+	OwnerId string `json:"ownerId"` // 参照元のTierID・レビューID・ユーザーID
+	UserId  string `json:"userId"`  // 参照元を作成したユーザーのID
+	Path    string `json:"path"`    // 参照しているファイル(保存先からの相対パス)
+}
+
+// 確認の設定
+type Options struct {
+	Delete bool          // 参照されていないファイルを削除するかどうか(falseなら報告のみ)
+	MinAge time.Duration // 保存中のファイルを削除しないよう、更新からこの時間が経っていないファイルは対象外にする
+	Now    time.Time     // 経過時間の基準日時
+
+	// 実行待ち・実行中の画像の変換ジョブの出力先のパス
+	// 変換待ちの画像のうちジョブがないものは参照されていないファイルとして扱う(nilなら全てジョブがあるものとして扱う)
+	PendingJobs map[string]bool
+}
+
+// 確認の結果
+type Report struct {
+	Files      int         `json:"files"`      // 保存先にあるファイル数
+	References int         `json:"references"` // データベースからの参照数
+	Orphans    []string    `json:"orphans"`    // 参照されていないファイル
+	Dangling   []Reference `json:"dangling"`   // 存在しないファイルへの参照
+	Deleted    []string    `json:"deleted"`    // 削除したファイル
+	Failed     []string    `json:"failed"`     // 削除に失敗したファイル
+	Young      int         `json:"young"`      // 参照されていないが、更新から間もないため対象外にしたファイル数
+
+	// ユーザーごとの残ったファイル(変換待ちの画像を含む)の合計バイト数
+	Usage map[string]int64 `json:"-"`
+}
+
+type paragData struct {
+	Type string `json:"type"`
+	Body string `json:"body"`
+}
+
+type sectionData struct {
+	Parags []paragData `json:"parags"`
+}
+
+// 保存先のファイルを指す値かどうか(外部のURLや空文字列は対象外)
+func isLocalPath(p string) bool {
+	return p != "" && !strings.Contains(p, "://")
+}
+
+func appendParags(refs []Reference, kind string, ownerId string, userId string, parags []paragData) []Reference {
+	for _, parag := range parags {
+		if parag.Type == "imageLink" && isLocalPath(parag.Body) {
+			refs = append(refs, Reference{Kind: kind, OwnerId: ownerId, UserId: userId, Path: parag.Body})
+		}
+	}
+	return refs
+}
+
+// Tierのカバー画像と説明文の画像への参照
+func TierReferences(tier db.Tier) []Reference {
+	var refs []Reference
+	if isLocalPath(tier.ImageUrl) {
+		refs = append(refs, Reference{Kind: KindTierImage, OwnerId: tier.TierId, UserId: tier.UserId, Path: tier.ImageUrl})
+	}
+	var parags []paragData
+	if json.Unmarshal([]byte(tier.Parags), &parags) == nil {
+		refs = appendParags(refs, KindTierParag, tier.TierId, tier.UserId, parags)
+	}
+	return refs
+}
+
+// レビューのアイコンと説明セクションの画像への参照
+func ReviewReferences(review db.Review) []Reference {
+	var refs []Reference
+	if isLocalPath(review.IconUrl) {
+		refs = append(refs, Reference{Kind: KindReviewIcon, OwnerId: review.ReviewId, UserId: review.UserId, Path: review.IconUrl})
+	}
+	var sections []sectionData
+	if json.Unmarshal([]byte(review.Sections), &sections) == nil {
+		for _, section := range sections {
+			refs = appendParags(refs, KindReviewParag, review.ReviewId, review.UserId, section.Parags)
+		}
+	}
+	return refs
+}
+
+// ユーザーのアイコンへの参照(連携サービスのアイコンのURLは対象外)
+func UserReferences(user db.User) []Reference {
+	if !isLocalPath(user.IconUrl) {
+		return nil
+	}
+	return []Reference{{Kind: KindUserIcon, OwnerId: user.UserId, UserId: user.UserId, Path: user.IconUrl}}
+}
+
+// データベースの全Tier・レビュー・ユーザーからファイルへの参照を集める
+func CollectReferences(ctx context.Context) ([]Reference, error) {
+	var refs []Reference
+	err := db.EachTierFiles(batchSize, func(tiers []db.Tier) error {
+		for _, tier := range tiers {
+			refs = append(refs, TierReferences(tier)...)
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = db.EachReviewFiles(batchSize, func(reviews []db.Review) error {
+		for _, review := range reviews {
+			refs = append(refs, ReviewReferences(review)...)
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = db.EachUserFiles(batchSize, func(users []db.User) error {
+		for _, user := range users {
+			refs = append(refs, UserReferences(user)...)
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+// 参照のパスを保存先からの相対パスに正規化する(保存先の外を指す場合は空文字列)
+func cleanPath(p string) string {
+	p = path.Clean(strings.TrimPrefix(p, "/"))
+	if p == "." || p == ".." || strings.HasPrefix(p, "../") || strings.HasPrefix(p, internalPrefix) {
+		return ""
+	}
+	return p
+}
+
+// 保存先rootのファイルと参照を突き合わせる
+// 変換待ちの画像がある参照は存在するものとして扱う
+func Check(ctx context.Context, root string, refs []Reference, opts Options) (Report, error) {
+	report := Report{References: len(refs), Orphans: []string{}, Dangling: []Reference{}, Deleted: []string{}, Failed: []string{}, Usage: map[string]int64{}}
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+
+	referenced := make(map[string]bool, len(refs))
+	for _, ref := range refs {
+		if p := cleanPath(ref.Path); p != "" {
+			referenced[p] = true
+		}
+	}
+
+	// 保存先のファイルを走査して参照されていないものを探す
+	found := map[string]bool{}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == root {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if p != root && strings.HasPrefix(d.Name(), internalPrefix) && !strings.Contains(rel, "/") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		report.Files++
+		found[rel] = true
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if referenced[rel] {
+			addUsage(report.Usage, rel, info.Size())
+			return nil
+		}
+
+		if opts.Now.Sub(info.ModTime()) < opts.MinAge {
+			report.Young++
+			addUsage(report.Usage, rel, info.Size())
+			return nil
+		}
+		report.Orphans = append(report.Orphans, rel)
+		if opts.Delete {
+			if err := os.Remove(p); err != nil {
+				report.Failed = append(report.Failed, rel)
+			} else {
+				report.Deleted = append(report.Deleted, rel)
+				return nil
+			}
+		}
+		addUsage(report.Usage, rel, info.Size())
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	// 変換待ちの画像も容量に含める
+	// ジョブがない(deadになった・削除された)ものは変換されないため、参照されていないファイルとして扱う
+	pending := filepath.Join(root, filepath.FromSlash(pendingImageDir))
+	live := func(rel string) bool {
+		return opts.PendingJobs == nil || opts.PendingJobs[rel]
+	}
+	err = walkFiles(ctx, pending, func(rel string, info fs.FileInfo) {
+		if live(rel) {
+			addUsage(report.Usage, rel, info.Size())
+			return
+		}
+		if opts.Now.Sub(info.ModTime()) < opts.MinAge {
+			// ジョブの登録前に保存した直後のもの
+			report.Young++
+			addUsage(report.Usage, rel, info.Size())
+			return
+		}
+		orphan := pendingImageDir + "/" + rel
+		report.Orphans = append(report.Orphans, orphan)
+		if opts.Delete {
+			if err := os.Remove(filepath.Join(pending, filepath.FromSlash(rel))); err != nil {
+				report.Failed = append(report.Failed, orphan)
+			} else {
+				report.Deleted = append(report.Deleted, orphan)
+				return
+			}
+		}
+		addUsage(report.Usage, rel, info.Size())
+	})
+	if err != nil {
+		return report, err
+	}
+
+	// 存在しないファイルへの参照を探す
+	for _, ref := range refs {
+		p := cleanPath(ref.Path)
+		if p != "" && found[p] {
+			continue
+		}
+		if p != "" && live(p) {
+			if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(pendingImageDir+"/"+p))); err == nil {
+				continue
+			}
+		}
+		report.Dangling = append(report.Dangling, ref)
+	}
+
+	sort.Strings(report.Orphans)
+	sort.Slice(report.Dangling, func(i, j int) bool { return report.Dangling[i].Path < report.Dangling[j].Path })
+	return report, nil
+}
+
+// 保存先からの相対パスの先頭(ファイルを保存したユーザーのID)にsizeを加える
+// ユーザーのフォルダ外のファイルは対象外
+func addUsage(usage map[string]int64, rel string, size int64) {
+	i := strings.Index(rel, "/")
+	if i <= 0 {
+		return
+	}
+	usage[rel[:i]] += size
+}
+
+// dir以下の通常のファイルを走査し、dirからの相対パスとファイルの情報をfnに渡す
+// dirが存在しなければ何もしない
+func walkFiles(ctx context.Context, dir string, fn func(rel string, info fs.FileInfo)) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		fn(filepath.ToSlash(rel), info)
+		return nil
+	})
+}
+
+// dir以下のファイルの合計バイト数
+func DirSize(dir string) (int64, error) {
+	var total int64
+	err := walkFiles(context.Background(), dir, func(rel string, info fs.FileInfo) {
+		total += info.Size()
+	})
+	return total, err
+}
+
+// 保存先rootにあるユーザーのファイル(変換待ちの画像を含む)の合計バイト数
+func UserUsage(root string, userId string) (int64, error) {
+	if userId == "" || strings.ContainsAny(userId, "/\\") || strings.HasPrefix(userId, internalPrefix) {
+		return 0, nil
+	}
+	total, err := DirSize(filepath.Join(root, userId))
+	if err != nil {
+		return 0, err
+	}
+	pending, err := DirSize(filepath.Join(root, filepath.FromSlash(pendingImageDir), userId))
+	if err != nil {
+		return 0, err
+	}
+	return total + pending, nil
+}
+
+// データベースの参照を集めて保存先rootのファイルと突き合わせる
+func Scan(ctx context.Context, root string, opts Options) (Report, error) {
+	// ジョブを先に取得し、走査中に登録されたジョブの変換待ちの画像は保存直後のものとして対象外にする
+	jobs, err := db.GetPendingImageJobPaths()
+	if err != nil {
+		return Report{}, err
+	}
+	opts.PendingJobs = make(map[string]bool, len(jobs))
+	for _, p := range jobs {
+		opts.PendingJobs[cleanPath(p)] = true
+	}
+	refs, err := CollectReferences(ctx)
+	if err != nil {
+		return Report{}, err
+	}
+	return Check(ctx, root, refs, opts)
+}
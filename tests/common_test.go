@@ -3,6 +3,7 @@ package tests
 import (
 	"fmt"
 	"reviewmakerback/common"
+	"strings"
 	"testing"
 )
 
@@ -113,3 +114,18 @@ func TestCommonRegexp(t *testing.T) {
 		t.Error("miss")
 	}
 }
+
+func TestCommonMaskSecrets(t *testing.T) {
+	token := "ya29.a0AfH6SMBx3kQ_lY9vZ2nE-8rTqW1uXoPcJdLmN4sGhK7"
+	masked := common.MaskSecrets("token=" + token + " user=abcdEFGH12345678")
+	if strings.Contains(masked, "a0AfH6SMBx3kQ_lY9vZ2nE") {
+		t.Error("miss")
+	}
+	// 16文字のIDは伏せない
+	if !strings.Contains(masked, "abcdEFGH12345678") {
+		t.Error("miss")
+	}
+	if common.MaskSecrets("短い説明 abc") != "短い説明 abc" {
+		t.Error("miss")
+	}
+}
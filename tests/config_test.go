@@ -0,0 +1,219 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"reviewmakerback/config"
+	"strings"
+	"testing"
+)
+
+// 必須項目を全て含む環境変数
+var requiredEnv = map[string]string{
+	"BACK_DB_HOST":         "localhost",
+	"BACK_DB_PORT":         "5432",
+	"BACK_DB_NAME":         "db",
+	"BACK_DB_USER":         "user",
+	"BACK_DB_PASSWORD":     "password",
+	"BACK_DB_TIMEZONE":     "Asia/Tokyo",
+	"BACK_AP_PORT":         "8080",
+	"BACK_AP_FILE_PATH":    "/tmp/files",
+	"BACK_AP_POST_SPAN":    "10",
+	"BACK_TW_CLIENT_ID":    "id",
+	"BACK_TW_CLIENT_SEC":   "sec",
+	"BACK_TW_REDIRECT_URI": "http://localhost",
+	"BACK_TW1_APIKEY":      "key",
+	"BACK_TW1_APISECRET":   "secret",
+	"BACK_TW1_ACCESSTOKEN": "token",
+	"BACK_TW1_ACCESSSEC":   "sec",
+	"BACK_GG_CONFJSON":     "{}",
+	"BACK_ENC_KEYS":        "k1:MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=",
+}
+
+func lookupMap(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+}
+
+func writeConfigFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigEnvOnly(t *testing.T) {
+	conf, err := config.Load("", lookupMap(requiredEnv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Database.Port != 5432 || conf.Server.FilePath != "/tmp/files" || conf.Limits.PostSpan != 10 {
+		t.Errorf("miss %+v", conf)
+	}
+	// 既定値
+	if conf.Limits.PostPageSize != 5 || conf.Limits.Tier.ImgMaxEdge != 1080 || conf.Smtp.Port != 587 || conf.Server.ShutdownTimeout != 30 {
+		t.Errorf("miss default %+v", conf.Limits)
+	}
+	// IDは従来と同じ16文字の16進数
+	if id, err := conf.Ids.Generator().Generate(); err != nil || len(id) != 16 || strings.Trim(id, "0123456789abcdef") != "" {
+		t.Errorf("miss default id %s %v", id, err)
+	}
+	if conf.Ids.SessionGenerator().Length != 64 {
+		t.Errorf("miss default session %+v", conf.Ids)
+	}
+}
+
+func TestConfigReportsAllProblems(t *testing.T) {
+	env := map[string]string{"BACK_DB_PORT": "port", "BACK_AP_PORT": "70000", "BACK_AP_SHUTDOWN_TIMEOUT": "0", "BACK_LOG_LEVEL": "trace"}
+	_, err := config.Load("", lookupMap(env))
+	verr, ok := err.(*config.ValidationError)
+	if !ok {
+		t.Fatalf("miss %v", err)
+	}
+	// 必須項目の不足と形式の誤りを一度に報告する
+	for _, want := range []string{"BACK_DB_PORT", "BACK_DB_HOST", "BACK_ENC_KEYS", "BACK_TW1_APIKEY", "server.port", "server.shutdownTimeout", "log.level"} {
+		if !strings.Contains(verr.Error(), want) {
+			t.Errorf("miss %s", want)
+		}
+	}
+	if len(verr.Problems) < 17 {
+		t.Errorf("miss count %d", len(verr.Problems))
+	}
+}
+
+func TestConfigYamlWithEnvOverride(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", `
+database:
+  host: filehost
+  port: 5433
+limits:
+  postPageSize: 20
+  tier:
+    nameLenMax: 30
+admin:
+  users: [u1, u2]
+`)
+	env := map[string]string{}
+	for k, v := range requiredEnv {
+		if k != "BACK_DB_HOST" && k != "BACK_DB_PORT" {
+			env[k] = v
+		}
+	}
+	env["BACK_ADMIN_USERS"] = "u3, u4"
+
+	conf, err := config.Load(path, lookupMap(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Database.Host != "filehost" || conf.Database.Port != 5433 {
+		t.Errorf("miss file %+v", conf.Database)
+	}
+	if conf.Limits.PostPageSize != 20 || conf.Limits.Tier.NameLenMax != 30 || conf.Limits.Tier.ParamsLenMax != 16 {
+		t.Errorf("miss limits %+v", conf.Limits)
+	}
+	// 環境変数が優先される
+	if len(conf.Admin.Users) != 2 || conf.Admin.Users[0] != "u3" || conf.Admin.Users[1] != "u4" {
+		t.Errorf("miss env %v", conf.Admin.Users)
+	}
+}
+
+func TestConfigToml(t *testing.T) {
+	path := writeConfigFile(t, "config.toml", `
+[server]
+url = "https://api.example.com"
+
+[limits.review]
+sectionLenMax = 4
+`)
+	conf, err := config.Load(path, lookupMap(requiredEnv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Server.Url != "https://api.example.com" || conf.Limits.Review.SectionLenMax != 4 {
+		t.Errorf("miss %+v", conf)
+	}
+}
+
+func TestConfigUnknownAndInvalid(t *testing.T) {
+	// 未知の項目
+	path := writeConfigFile(t, "config.toml", "[server]\nprot = 8080\n")
+	if _, err := config.Load(path, lookupMap(requiredEnv)); err == nil || !strings.Contains(err.Error(), "server.prot") {
+		t.Errorf("miss unknown toml %v", err)
+	}
+	path = writeConfigFile(t, "config.yml", "server:\n  prot: 8080\n")
+	if _, err := config.Load(path, lookupMap(requiredEnv)); err == nil {
+		t.Error("miss unknown yaml")
+	}
+
+	// 制限値は正の値
+	path = writeConfigFile(t, "config.yaml", "limits:\n  postPageSize: 0\n  section:\n    paragImgAspect: 0\n")
+	_, err := config.Load(path, lookupMap(requiredEnv))
+	if err == nil || !strings.Contains(err.Error(), "limits.postPageSize") || !strings.Contains(err.Error(), "limits.section.paragImgAspect") {
+		t.Errorf("miss limits %v", err)
+	}
+
+	// 拡張子
+	path = writeConfigFile(t, "config.json", "{}")
+	if _, err = config.Load(path, lookupMap(requiredEnv)); err == nil {
+		t.Error("miss extension")
+	}
+}
+
+func TestConfigInvalidValues(t *testing.T) {
+	cases := []struct {
+		name    string
+		env     map[string]string
+		problem string
+	}{
+		{"trace exporter", map[string]string{"BACK_TRACE_EXPORTER": "jaeger"}, "tracing.exporter"},
+		{"trace ratio", map[string]string{"BACK_TRACE_SAMPLE_RATIO": "1.5"}, "tracing.sampleRatio"},
+		{"trace headers", map[string]string{"BACK_TRACE_HEADERS": "Authorization"}, "tracing.headers"},
+		{"retention days", map[string]string{"BACK_RETENTION_OPERATION_DAYS": "-1"}, "retention.operationDays"},
+		{"retention span", map[string]string{"BACK_RETENTION_SPAN": "0"}, "retention.span"},
+		{"job schedules", map[string]string{"BACK_JOBS_SCHEDULES": "@daily"}, "jobs.schedules"},
+		{"queue workers", map[string]string{"BACK_QUEUE_WORKERS": "0"}, "queue.workers"},
+		{"queue poll", map[string]string{"BACK_QUEUE_POLL_INTERVAL": "-1"}, "queue.pollInterval"},
+		{"file gc age", map[string]string{"BACK_FILEGC_MIN_AGE_HOURS": "0"}, "fileGc.minAgeHours"},
+		{"id alphabet", map[string]string{"BACK_ID_ALPHABET": "aab"}, "'ids'"},
+		{"id sortable", map[string]string{"BACK_ID_SORTABLE": "true", "BACK_ID_LENGTH": "8"}, "'ids'"},
+		{"session length", map[string]string{"BACK_ID_SESSION_LENGTH": "0"}, "ids.sessionLength"},
+	}
+	for _, c := range cases {
+		env := map[string]string{}
+		for k, v := range requiredEnv {
+			env[k] = v
+		}
+		for k, v := range c.env {
+			env[k] = v
+		}
+		_, err := config.Load("", lookupMap(env))
+		if err == nil || !strings.Contains(err.Error(), c.problem) {
+			t.Errorf("miss %s: %v", c.name, err)
+		}
+	}
+}
+
+func TestConfigListValues(t *testing.T) {
+	env := map[string]string{}
+	for k, v := range requiredEnv {
+		env[k] = v
+	}
+	env["BACK_TRACE_HEADERS"] = "Authorization=Bearer x, X-Scope=a=b"
+	// cron形式はカンマを含むため環境変数ではセミコロンで区切る
+	env["BACK_JOBS_SCHEDULES"] = "arrangeLogs=0,30 3 * * *; sendDigests=@every 30m"
+	conf, err := config.Load("", lookupMap(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := conf.Tracing.HeaderMap()
+	if len(headers) != 2 || headers["Authorization"] != "Bearer x" || headers["X-Scope"] != "a=b" {
+		t.Errorf("miss headers %v", headers)
+	}
+	schedules := conf.Jobs.ScheduleMap()
+	if len(schedules) != 2 || schedules["arrangeLogs"] != "0,30 3 * * *" || schedules["sendDigests"] != "@every 30m" {
+		t.Errorf("miss schedules %v", schedules)
+	}
+}
@@ -0,0 +1,133 @@
+package tests
+
+import (
+	"context"
+	"reflect"
+	"reviewmakerback/db"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/gorm/schema"
+)
+
+func newTestEncryptor(t *testing.T, current string) *db.Encryptor {
+	keys, _, err := db.ParseEncryptionKeys("k1:MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=,k2:ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	e, err := db.NewEncryptor(keys, current, "passwordpassword")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return e
+}
+
+func TestEncryptorRoundTrip(t *testing.T) {
+	e := newTestEncryptor(t, "k1")
+
+	c1, err := e.Encrypt("plaintext")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	c2, _ := e.Encrypt("plaintext")
+	if c1 == c2 {
+		t.Error("miss nonce")
+	}
+	if !strings.HasPrefix(c1, "v2:k1:") {
+		t.Errorf("miss '%s'", c1)
+	}
+
+	p, err := e.Decrypt(c1)
+	if err != nil || p != "plaintext" {
+		t.Error("miss")
+	}
+}
+
+func TestEncryptorTampered(t *testing.T) {
+	e := newTestEncryptor(t, "k1")
+	c, _ := e.Encrypt("plaintext")
+
+	// 鍵IDの書き換え
+	if _, err := e.Decrypt(strings.Replace(c, "v2:k1:", "v2:k2:", 1)); err == nil {
+		t.Error("miss key id")
+	}
+
+	// 暗号文の書き換え(末尾はbase64のパディングを含むため中央を書き換える)
+	b := []byte(c)
+	i := len("v2:k1:") + (len(b)-len("v2:k1:"))/2
+	if b[i] == 'A' {
+		b[i] = 'B'
+	} else {
+		b[i] = 'A'
+	}
+	if _, err := e.Decrypt(string(b)); err == nil {
+		t.Error("miss body")
+	}
+}
+
+func TestEncryptorLegacyAndRotate(t *testing.T) {
+	// 旧形式で"plaintext"を鍵"passwordpassword"で暗号化したもの
+	const legacy = `{"b":"VDIszBuv6yRJ","l":9}`
+
+	e1 := newTestEncryptor(t, "k1")
+	p, err := e1.Decrypt(legacy)
+	if err != nil || p != "plaintext" {
+		t.Error("miss legacy")
+	}
+
+	c1, _ := e1.Encrypt("plaintext")
+	e2 := newTestEncryptor(t, "k2")
+	if e2.IsCurrent(c1) || e2.IsCurrent(legacy) {
+		t.Error("miss current")
+	}
+
+	c2, err := e2.Rotate(c1)
+	if err != nil || !strings.HasPrefix(c2, "v2:k2:") {
+		t.Error("miss rotate")
+	}
+	if p, _ = e2.Decrypt(c2); p != "plaintext" {
+		t.Error("miss rotated")
+	}
+	if p, _ = e2.Decrypt(c1); p != "plaintext" {
+		t.Error("miss old key")
+	}
+}
+
+func TestEncryptedSerializer(t *testing.T) {
+	db.Encryption = newTestEncryptor(t, "k1")
+	defer func() { db.Encryption = nil }()
+
+	s, err := schema.Parse(&db.Session{}, &sync.Map{}, schema.NamingStrategy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	field := s.LookUpField("TwitterToken")
+	ctx := context.Background()
+
+	session := db.Session{TwitterToken: "token-value"}
+	value, err := field.Serializer.Value(ctx, field, reflect.ValueOf(&session), session.TwitterToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := value.(string)
+	if !db.IsEncrypted(stored) || strings.Contains(stored, "token-value") {
+		t.Errorf("not encrypted: %s", stored)
+	}
+
+	var loaded db.Session
+	if err = field.Serializer.Scan(ctx, field, reflect.ValueOf(&loaded), stored); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.TwitterToken != "token-value" {
+		t.Errorf("miss: %s", loaded.TwitterToken)
+	}
+
+	// 暗号化前の平文はそのまま読み込む
+	if err = field.Serializer.Scan(ctx, field, reflect.ValueOf(&loaded), []byte("plain-value")); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.TwitterToken != "plain-value" {
+		t.Errorf("miss: %s", loaded.TwitterToken)
+	}
+}
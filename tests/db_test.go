@@ -1,25 +1,16 @@
 package tests
 
 import (
+	"context"
 	"fmt"
 	"reviewmakerback/db"
 	"testing"
 )
 
-func TestEncrypting(t *testing.T) {
-	const plaintext = "plaintext"
+func TestDecrypting(t *testing.T) {
 	const password = "passwordpassword"
-	fmt.Println("================ EN ================")
-	fmt.Printf("plaintext = '%s'\n", plaintext)
-	fmt.Printf("password = '%s'\n", password)
-	etd, err := db.EncryptText(plaintext, password)
-	fmt.Printf("etd.Base64Text = '%s'\n", etd.Base64Text)
-	fmt.Printf("etd.Length = %d\n", etd.Length)
-	if err != nil {
-		t.Error(err.Error())
-		return
-	}
-	fmt.Println("================ DE ================")
+	// 旧形式で"plaintext"を暗号化したもの
+	etd := db.EncryptedTextData{Base64Text: "VDIszBuv6yRJ", Length: 9}
 	result, err := db.DecryptText(etd, password)
 	if err != nil {
 		t.Error(err.Error())
@@ -27,32 +18,32 @@ func TestEncrypting(t *testing.T) {
 	}
 	fmt.Printf("result = '%s'\n", result)
 
-	if result != plaintext {
+	if result != "plaintext" {
 		t.Error("miss")
 	}
 }
-func TestEncryptingNone(t *testing.T) {
-	const plaintext = ""
+func TestDecryptingNone(t *testing.T) {
 	const password = "passwordpassword"
-	fmt.Println("================ EN ================")
-	fmt.Printf("plaintext = '%s'\n", plaintext)
-	fmt.Printf("password = '%s'\n", password)
-	etd, err := db.EncryptText(plaintext, password)
-	fmt.Printf("etd.Base64Text = '%s'\n", etd.Base64Text)
-	fmt.Printf("etd.Length = %d\n", etd.Length)
-	if err != nil {
-		t.Error(err.Error())
-		return
-	}
-	fmt.Println("================ DE ================")
-	result, err := db.DecryptText(etd, password)
+	result, err := db.DecryptText(db.EncryptedTextData{}, password)
 	if err != nil {
 		t.Error(err.Error())
 		return
 	}
-	fmt.Printf("result = '%s'\n", result)
 
-	if result != plaintext {
+	if result != "" {
 		t.Error("miss")
 	}
 }
+
+func TestArrangeSessionContext(t *testing.T) {
+	requireDb(t)
+	if err := db.ArrangeSession(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// 定期処理の制限時間を過ぎた場合は中断する
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := db.ArrangeSession(ctx); err == nil {
+		t.Error("miss canceled")
+	}
+}
@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"reviewmakerback/config"
+	"reviewmakerback/db"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var testDbOnce sync.Once
+
+// テスト用のデータベースの接続と設定
+var testDb *gorm.DB
+var testDbConf config.Config
+
+// データベースを使うテストは、BACK_TEST_DB=1とBACK_DB_*でPostgreSQLを指定した場合のみ実行する
+// 指定した値以外はrequiredEnvの値を使う
+// データベースに接続していない前提のテストに影響しないよう、テストの終了時に接続を外す
+func requireDb(t *testing.T) config.Config {
+	t.Helper()
+	if os.Getenv("BACK_TEST_DB") != "1" {
+		t.Skip("BACK_TEST_DB=1 が指定されていないため、データベースを使うテストは実行しません")
+	}
+	testDbOnce.Do(func() {
+		conf, err := config.Load("", func(name string) (string, bool) {
+			if v, ok := os.LookupEnv(name); ok {
+				return v, true
+			}
+			v, ok := requiredEnv[name]
+			return v, ok
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		testDbConf = conf
+		testDb = db.InitDb(conf)
+	})
+	if testDb == nil {
+		t.Fatal("データベースに接続できません")
+	}
+	db.Db = testDb
+	t.Cleanup(func() { db.Db = nil })
+	return testDbConf
+}
+
+// テストごとに重複しないID
+func testDbId(prefix string) string {
+	return fmt.Sprintf("%s%d", prefix, time.Now().UnixNano())
+}
+
+// テスト用のユーザーを作成し、テストの終了時に削除する
+func createTestUser(t *testing.T, prefix string, role string) db.User {
+	t.Helper()
+	user := db.User{UserId: testDbId(prefix), Name: prefix, Role: role}
+	if err := db.Db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Db.Where("user_id = ?", user.UserId).Delete(&db.User{})
+		db.Db.Where("user_id = ?", user.UserId).Delete(&db.Session{})
+		db.Db.Where("user_id = ?", user.UserId).Delete(&db.SuspensionLog{})
+	})
+	return user
+}
+
+// テスト用のユーザーのセッションを作成し、Authorizationヘッダの値を返す
+func createTestSession(t *testing.T, user db.User) string {
+	t.Helper()
+	session := db.Session{
+		SessionId:    testDbId("sess"),
+		UserId:       user.UserId,
+		ExpiredTime:  time.Now().Add(time.Hour),
+		LoginService: "google",
+		LoginVersion: 2,
+	}
+	if err := db.Db.Create(&session).Error; err != nil {
+		t.Fatal(err)
+	}
+	return "Bearer " + session.SessionId
+}
@@ -0,0 +1,52 @@
+package tests
+
+import (
+	"reviewmakerback/db"
+	"testing"
+	"time"
+)
+
+func TestDigestConfirmEmail(t *testing.T) {
+	requireDb(t)
+	userId := createTestUser(t, "dgst", db.RoleUser).UserId
+	if err := db.UpdateDigestSetting(userId, "old@example.com", "daily", "unsubscribe"+userId); err != nil {
+		t.Fatal(err)
+	}
+
+	digestEmail := func() (string, string) {
+		var user db.User
+		if err := db.Db.Where("user_id = ?", userId).First(&user).Error; err != nil {
+			t.Fatal(err)
+		}
+		return user.DigestEmail, user.DigestPendingEmail
+	}
+
+	now := time.Now()
+	if err := db.SetPendingDigestEmail(userId, "new@example.com", "token"+userId, now); err != nil {
+		t.Fatal(err)
+	}
+	// 確認するまで送信先は変わらない
+	if email, pending := digestEmail(); email != "old@example.com" || pending != "new@example.com" {
+		t.Errorf("miss pending %s %s", email, pending)
+	}
+
+	// 異なるトークンや有効期限切れでは確定しない
+	if f, err := db.ConfirmDigestEmail("other"+userId, now); f || err != nil {
+		t.Errorf("miss other token %v %v", f, err)
+	}
+	if f, err := db.ConfirmDigestEmail("token"+userId, now.Add(db.DigestConfirmExpire+time.Minute)); f || err != nil {
+		t.Errorf("miss expired %v %v", f, err)
+	}
+
+	if f, err := db.ConfirmDigestEmail("token"+userId, now.Add(time.Minute)); !f || err != nil {
+		t.Errorf("miss confirm %v %v", f, err)
+	}
+	if email, pending := digestEmail(); email != "new@example.com" || pending != "" {
+		t.Errorf("miss confirmed %s %s", email, pending)
+	}
+
+	// 一度確定したトークンは使えない
+	if f, _ := db.ConfirmDigestEmail("token"+userId, now.Add(time.Minute)); f {
+		t.Error("miss reuse")
+	}
+}
@@ -0,0 +1,79 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reviewmakerback/config"
+	"reviewmakerback/ontime"
+	"reviewmakerback/rest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+func newHealthServer(t *testing.T) *echo.Echo {
+	conf := config.Default()
+	conf.Server.FilePath = t.TempDir()
+	conf.Server.MinFreeMb = 0
+	rest.Configure(conf)
+
+	e := echo.New()
+	rest.Route(e)
+	return e
+}
+
+func TestHealthz(t *testing.T) {
+	e := newHealthServer(t)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != 200 {
+		t.Errorf("miss %d", rec.Code)
+	}
+}
+
+func TestReadyzReportsComponents(t *testing.T) {
+	e := newHealthServer(t)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+
+	// データベースに接続しておらず、定期処理も起動していない
+	if rec.Code != 503 {
+		t.Errorf("miss %d", rec.Code)
+	}
+	var data rest.HealthData
+	if err := json.Unmarshal(rec.Body.Bytes(), &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Status != "error" {
+		t.Errorf("miss status %s", data.Status)
+	}
+	want := map[string]string{"database": "error", "migration": "error", "storage": "ok", "ontime": "error"}
+	for name, status := range want {
+		if data.Components[name].Status != status {
+			t.Errorf("miss %s %+v", name, data.Components[name])
+		}
+	}
+}
+
+func TestReadyzStorageNotWritable(t *testing.T) {
+	conf := config.Default()
+	conf.Server.FilePath = t.TempDir() + "/missing"
+	rest.Configure(conf)
+	e := echo.New()
+	rest.Route(e)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	var data rest.HealthData
+	json.Unmarshal(rec.Body.Bytes(), &data)
+	if data.Components["storage"].Status != "error" || data.Components["storage"].Detail == "" {
+		t.Errorf("miss %+v", data.Components["storage"])
+	}
+}
+
+func TestOntimeStatusesNotStarted(t *testing.T) {
+	if statuses := ontime.Statuses(time.Now()); len(statuses) != 0 {
+		t.Errorf("miss %+v", statuses)
+	}
+}
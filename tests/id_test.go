@@ -0,0 +1,86 @@
+package tests
+
+import (
+	"reviewmakerback/common"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestIdGeneratorCompatible(t *testing.T) {
+	// 従来と同じ16文字の16進数
+	id, err := common.DefaultIdGenerator.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !common.TestRegexp(`^[0-9a-f]{16}$`, id) {
+		t.Errorf("miss: %s", id)
+	}
+	if common.DefaultIdGenerator.EntropyBits() != 64 {
+		t.Error("miss")
+	}
+
+	session, err := common.MakeSession("seed")
+	if err != nil || !common.TestRegexp(`^[0-9a-f]{64}$`, session) {
+		t.Errorf("miss: %s", session)
+	}
+}
+
+func TestIdGeneratorUnique(t *testing.T) {
+	ids := map[string]bool{}
+	for i := 0; i < 10000; i++ {
+		// 同じseedでも異なるIDになる
+		id, err := common.MakeRandomChars(16, "seed")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ids[id] {
+			t.Fatalf("duplicated: %s", id)
+		}
+		ids[id] = true
+	}
+}
+
+func TestIdGeneratorAlphabet(t *testing.T) {
+	g := common.IdGenerator{Alphabet: "abc", Length: 300}
+	id, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !common.TestRegexp(`^[abc]{300}$`, id) {
+		t.Errorf("miss: %s", id)
+	}
+
+	if (common.IdGenerator{Alphabet: "aa", Length: 8}).Validate() == nil {
+		t.Error("miss")
+	}
+	if (common.IdGenerator{Alphabet: common.AlphabetHex, Length: 0}).Validate() == nil {
+		t.Error("miss")
+	}
+	if (common.IdGenerator{Alphabet: "ba", Length: 64, Sortable: true}).Validate() == nil {
+		t.Error("miss")
+	}
+}
+
+func TestIdGeneratorSortable(t *testing.T) {
+	g := common.IdGenerator{Alphabet: common.AlphabetBase32, Length: 26, Sortable: true}
+	if g.EntropyBits() != 80 {
+		t.Errorf("miss: %f", g.EntropyBits())
+	}
+	if (common.IdGenerator{Alphabet: common.AlphabetBase32, Length: 10, Sortable: true}).Validate() == nil {
+		t.Error("miss")
+	}
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ids := make([]string, 100)
+	for i := range ids {
+		id, err := g.GenerateAt(base.Add(time.Duration(i) * time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = id
+	}
+	if !sort.StringsAreSorted(ids) {
+		t.Error("not sorted")
+	}
+}
@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"reviewmakerback/logger"
+	"strings"
+	"testing"
+	"time"
+)
+
+func decodeLogLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("miss json %s", line)
+		}
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+func TestLoggerJson(t *testing.T) {
+	var buf bytes.Buffer
+	l := logger.New(&buf, logger.LevelInfo)
+	l.SetNow(func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) })
+
+	ctx := logger.WithRequestId(context.Background(), "req-1")
+	l.Debug(ctx, "debug", nil)
+	l.Info(ctx, "情報", logger.Fields{"count": 3})
+	l.Error(context.Background(), "error", logger.Fields{"error": errors.New("失敗")})
+
+	lines := decodeLogLines(t, &buf)
+	if len(lines) != 2 {
+		t.Fatalf("miss level filter %d", len(lines))
+	}
+	if lines[0]["msg"] != "情報" || lines[0]["level"] != "info" || lines[0]["requestId"] != "req-1" ||
+		lines[0]["count"] != float64(3) || lines[0]["time"] != "2024-01-02T03:04:05Z" {
+		t.Errorf("miss %v", lines[0])
+	}
+	if lines[1]["level"] != "error" || lines[1]["error"] != "失敗" {
+		t.Errorf("miss %v", lines[1])
+	}
+	if _, ok := lines[1]["requestId"]; ok {
+		t.Errorf("miss requestId %v", lines[1])
+	}
+}
+
+func TestLoggerWriter(t *testing.T) {
+	var buf bytes.Buffer
+	l := logger.New(&buf, logger.LevelDebug)
+	std := log.New(l.Writer(logger.LevelWarn), "", 0)
+	std.Print("line1\nline2")
+
+	lines := decodeLogLines(t, &buf)
+	if len(lines) != 2 || lines[0]["msg"] != "line1" || lines[1]["level"] != "warn" {
+		t.Errorf("miss %v", lines)
+	}
+}
+
+func TestLoggerParseLevel(t *testing.T) {
+	if level, err := logger.ParseLevel("WARN"); err != nil || level != logger.LevelWarn {
+		t.Errorf("miss %v %v", level, err)
+	}
+	if _, err := logger.ParseLevel("trace"); err == nil {
+		t.Error("miss unknown")
+	}
+}
+
+func TestNewRequestId(t *testing.T) {
+	a, b := logger.NewRequestId(), logger.NewRequestId()
+	if len(a) != 32 || a == b {
+		t.Errorf("miss %s %s", a, b)
+	}
+}
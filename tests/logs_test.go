@@ -0,0 +1,81 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"reviewmakerback/db"
+	"testing"
+	"time"
+)
+
+func TestLogArchiveWriter(t *testing.T) {
+	var buf bytes.Buffer
+	archive := db.NewLogArchiveWriter(&buf)
+	createdAt := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	rows := []map[string]interface{}{
+		{"id": int64(1), "user_id": "u1", "operation": "pusr", "created_at": createdAt},
+		{"id": int64(2), "user_id": "u2", "operation": "dses", "created_at": createdAt},
+	}
+	for _, row := range rows {
+		if err := archive.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanner := bufio.NewScanner(gz)
+	var lines []map[string]interface{}
+	for scanner.Scan() {
+		var line map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("miss json %s", scanner.Text())
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("miss lines %d", len(lines))
+	}
+	if lines[1]["id"] != float64(2) || lines[1]["operation"] != "dses" || lines[0]["created_at"] != "2022-01-02T03:04:05Z" {
+		t.Errorf("miss %v", lines)
+	}
+}
+
+func TestLogPrimaryKeyMigration(t *testing.T) {
+	conf := requireDb(t)
+	hasPrimaryKey := func(table string) bool {
+		var cnt int64
+		if err := db.Db.Raw("select count(*) from pg_index where indrelid = ?::regclass and indisprimary", table).Scan(&cnt).Error; err != nil {
+			t.Fatal(err)
+		}
+		return cnt > 0
+	}
+
+	// 主キーの列のみ追加された既存のテーブルを再現する
+	if err := db.Db.Exec("alter table error_logs drop constraint if exists error_logs_pkey").Error; err != nil {
+		t.Fatal(err)
+	}
+	if hasPrimaryKey("error_logs") {
+		t.Fatal("miss drop")
+	}
+
+	migrated := db.InitDb(conf)
+	t.Cleanup(func() {
+		if sqlDb, err := migrated.DB(); err == nil {
+			sqlDb.Close()
+		}
+	})
+	if err := db.MigrationStatus(); err != nil {
+		t.Fatal(err)
+	}
+	if !hasPrimaryKey("error_logs") || !hasPrimaryKey("operation_logs") {
+		t.Error("miss primary key")
+	}
+}
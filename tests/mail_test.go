@@ -0,0 +1,148 @@
+package tests
+
+import (
+	"bufio"
+	"mime/quotedprintable"
+	"net"
+	"reviewmakerback/mail"
+	"strings"
+	"testing"
+)
+
+// テスト用のSMTPサーバー
+// 受信したメールの本文を一通だけchに送る
+func startSmtpStub(t *testing.T) (string, string, chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	ch := make(chan string, 1)
+
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		w := bufio.NewWriter(conn)
+		reply := func(s string) {
+			w.WriteString(s + "\r\n")
+			w.Flush()
+		}
+
+		reply("220 localhost ESMTP stub")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "MAIL FROM"), strings.HasPrefix(cmd, "RCPT TO"):
+				reply("250 OK")
+			case cmd == "DATA":
+				reply("354 End data with <CR><LF>.<CR><LF>")
+				var data strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil || l == ".\r\n" {
+						break
+					}
+					data.WriteString(l)
+				}
+				ch <- data.String()
+				reply("250 OK")
+			case cmd == "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("502 Not implemented")
+			}
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	return host, port, ch
+}
+
+func TestSendDigestMail(t *testing.T) {
+	host, port, ch := startSmtpStub(t)
+	transport := mail.SmtpTransport{Host: host, Port: port}
+
+	msg, err := mail.BuildDigestMessage("from@example.com", "to@example.com", mail.DigestData{
+		UserName:  "テストユーザー",
+		Frequency: "daily",
+		Items: []mail.DigestItem{
+			{Content: "メンテナンスのお知らせ", Url: "https://example.com/info", IsImportant: true, CreatedAt: "2023-01-01T00:00:00Z"},
+			{Content: "<script>", CreatedAt: "2023-01-01T00:00:00Z"},
+		},
+		ImportantCount: 1,
+		UnsubscribeUrl: "https://api.example.com/digest/unsubscribe/abc",
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	err = transport.Send("from@example.com", []string{"to@example.com"}, msg)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	received := <-ch
+	if !strings.Contains(received, "List-Unsubscribe: <https://api.example.com/digest/unsubscribe/abc>") {
+		t.Error("miss unsubscribe header")
+	}
+	if !strings.Contains(received, "multipart/alternative") {
+		t.Error("miss multipart")
+	}
+
+	var decoded strings.Builder
+	qr := quotedprintable.NewReader(strings.NewReader(received))
+	buf := make([]byte, 1024)
+	for {
+		n, err := qr.Read(buf)
+		decoded.Write(buf[:n])
+		if err != nil {
+			break
+		}
+	}
+	body := decoded.String()
+	if !strings.Contains(body, "【重要】メンテナンスのお知らせ") {
+		t.Error("miss text body")
+	}
+	if !strings.Contains(body, "&lt;script&gt;") {
+		t.Error("miss html escape")
+	}
+}
+
+func TestBuildDigestConfirmMessage(t *testing.T) {
+	msg, err := mail.BuildDigestConfirmMessage("from@example.com", "to@example.com", mail.DigestConfirmData{
+		UserName:    "テストユーザー",
+		ConfirmUrl:  "https://api.example.com/digest/confirm/abc",
+		ExpireHours: 24,
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var decoded strings.Builder
+	qr := quotedprintable.NewReader(strings.NewReader(string(msg)))
+	buf := make([]byte, 1024)
+	for {
+		n, err := qr.Read(buf)
+		decoded.Write(buf[:n])
+		if err != nil {
+			break
+		}
+	}
+	if !strings.Contains(decoded.String(), "https://api.example.com/digest/confirm/abc") || !strings.Contains(decoded.String(), "24時間以内") {
+		t.Errorf("miss body %s", decoded.String())
+	}
+	if !strings.Contains(string(msg), "To: to@example.com") {
+		t.Error("miss to")
+	}
+}
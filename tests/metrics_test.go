@@ -0,0 +1,105 @@
+package tests
+
+import (
+	"net/http/httptest"
+	"reviewmakerback/config"
+	"reviewmakerback/metrics"
+	"reviewmakerback/rest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+)
+
+func TestErrorCodePrefix(t *testing.T) {
+	cases := map[string]string{
+		"gots-001":    "gots",
+		"ptir-009-02": "ptir",
+		"rlmt":        "rlmt",
+		"":            "none",
+	}
+	for code, want := range cases {
+		if got := metrics.ErrorCodePrefix(code); got != want {
+			t.Errorf("miss %s: %s", code, got)
+		}
+	}
+}
+
+func newMetricsServer(token string) *echo.Echo {
+	conf := config.Default()
+	conf.Metrics.Token = token
+	rest.Configure(conf)
+
+	e := echo.New()
+	e.Use(rest.Metrics())
+	rest.Route(e)
+	e.GET("/test/metrics/:id", func(c echo.Context) error {
+		if c.Param("id") == "ng" {
+			return c.JSON(400, rest.MakeError("tmet-001", "エラー"))
+		}
+		return c.JSON(200, "ok")
+	})
+	return e
+}
+
+func scrape(t *testing.T, e *echo.Echo, token string) string {
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != 200 {
+		t.Fatalf("miss scrape %d", rec.Code)
+	}
+	return rec.Body.String()
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	e := newMetricsServer("")
+	for _, id := range []string{"ok", "ng", "ng"} {
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/test/metrics/"+id, nil))
+	}
+
+	body := scrape(t, e, "")
+	for _, want := range []string{
+		`reviewmaker_http_requests_total{method="GET",route="/test/metrics/:id",status="200"} 1`,
+		`reviewmaker_http_requests_total{method="GET",route="/test/metrics/:id",status="400"} 2`,
+		`reviewmaker_http_error_codes_total{prefix="tmet"} 2`,
+		`reviewmaker_http_request_duration_seconds_count{method="GET",route="/test/metrics/:id",status="400"} 2`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("miss %s", want)
+		}
+	}
+}
+
+func TestMetricsUnmatchedRoute(t *testing.T) {
+	e := newMetricsServer("")
+	// 一致するルートがない場合(404)とメソッドが一致しない場合(405)
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/test/unknown/path", nil))
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PUT", "/test/metrics/1", nil))
+
+	body := scrape(t, e, "")
+	for _, want := range []string{
+		`reviewmaker_http_requests_total{method="GET",route="unmatched",status="404"}`,
+		`reviewmaker_http_requests_total{method="PUT",route="unmatched",status="405"}`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("miss %s", want)
+		}
+	}
+	if strings.Contains(body, "/test/unknown") || strings.Contains(body, `method="PUT",route="/test/metrics/:id"`) {
+		t.Error("miss raw path")
+	}
+}
+
+func TestMetricsToken(t *testing.T) {
+	e := newMetricsServer("secret")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != 401 {
+		t.Errorf("miss %d", rec.Code)
+	}
+	scrape(t, e, "secret")
+}
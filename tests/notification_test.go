@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"context"
+	"reviewmakerback/db"
+	"testing"
+)
+
+// 自分宛の通知をn件作成し、古い順のIDを返す
+func createTestNotifications(t *testing.T, userId string, n int) []uint {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := db.CreateUserNotification(userId, "test", "", false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var notifications []db.Notification
+	if err := db.Db.Where("user_id = ?", userId).Order("id").Find(&notifications).Error; err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]uint, len(notifications))
+	for i, notification := range notifications {
+		ids[i] = notification.Id
+	}
+	t.Cleanup(func() {
+		db.Db.Where("user_id = ?", userId).Delete(&db.NotificationRead{})
+		db.Db.Where("user_id = ?", userId).Delete(&db.Notification{})
+	})
+	return ids
+}
+
+// 一覧に含まれる指定した通知の既読状態
+func notificationStates(t *testing.T, userId string, ids []uint) map[uint]bool {
+	t.Helper()
+	notifications, tx := db.GetNotifications(context.Background(), userId, 0, 1000)
+	if tx.Error != nil {
+		t.Fatal(tx.Error)
+	}
+	states := map[uint]bool{}
+	for _, notification := range notifications {
+		for _, id := range ids {
+			if notification.Id == id {
+				states[id] = notification.IsRead
+			}
+		}
+	}
+	return states
+}
+
+func TestNotificationReadAllBoundary(t *testing.T) {
+	requireDb(t)
+	userId := testDbId("ntfa")
+	ids := createTestNotifications(t, userId, 3)
+
+	// 2件目までを既読にする
+	if _, err := db.UpdateNotificationReadAll(userId, ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	states := notificationStates(t, userId, ids)
+	if len(states) != 3 || !states[ids[0]] || !states[ids[1]] || states[ids[2]] {
+		t.Errorf("miss states %v", states)
+	}
+
+	// 既読にした後に届いた通知も未読のまま
+	ids = createTestNotifications(t, userId, 4)
+	states = notificationStates(t, userId, ids)
+	if states[ids[2]] || states[ids[3]] {
+		t.Errorf("miss newer %v", states)
+	}
+
+	// 他のユーザーの既読状態は変わらない
+	otherId := testDbId("ntfo")
+	states = notificationStates(t, otherId, ids)
+	if len(states) != 0 {
+		t.Errorf("miss other %v", states)
+	}
+}
+
+func TestNotificationHidden(t *testing.T) {
+	requireDb(t)
+	userId := testDbId("ntfh")
+	ids := createTestNotifications(t, userId, 2)
+
+	before, tx := db.GetNotificationsCount(context.Background(), userId, 1000)
+	if tx.Error != nil {
+		t.Fatal(tx.Error)
+	}
+	if err := db.HideNotification(userId, ids[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	states := notificationStates(t, userId, ids)
+	if _, ok := states[ids[1]]; ok || len(states) != 1 {
+		t.Errorf("miss list %v", states)
+	}
+	after, tx := db.GetNotificationsCount(context.Background(), userId, 1000)
+	if tx.Error != nil || after != before-1 {
+		t.Errorf("miss count %d -> %d %v", before, after, tx.Error)
+	}
+
+	// 非表示にした通知は件数の枠に含めない(最新の通知を非表示にしても、次の未読の通知を数える)
+	if cnt, tx := db.GetNotificationsCount(context.Background(), userId, 1); tx.Error != nil || cnt != 1 {
+		t.Errorf("miss limited count %d %v", cnt, tx.Error)
+	}
+
+	// 他のユーザー宛の通知は非表示にできない
+	if err := db.HideNotification(testDbId("ntfo"), ids[0]); err == nil {
+		t.Error("miss other user")
+	}
+}
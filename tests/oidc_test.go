@@ -0,0 +1,289 @@
+package tests
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reviewmakerback/oidc"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// テスト用のOIDC発行者
+type mockIssuer struct {
+	server *httptest.Server
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PrivateKey
+	challenge string
+	nonce     string
+	subject   string
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	m := &mockIssuer{keys: map[string]*rsa.PrivateKey{}, subject: "user1"}
+	m.addKey(t, "k1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/auth",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		keys := []map[string]string{}
+		for kid, key := range m.keys {
+			keys = append(keys, map[string]string{
+				"kty": "RSA",
+				"kid": kid,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		m.mu.Lock()
+		challenge, nonce := m.challenge, m.nonce
+		m.mu.Unlock()
+		// PKCEの検証
+		if r.PostForm.Get("code") != "code1" || oidc.CodeChallenge(r.PostForm.Get("code_verifier")) != challenge {
+			w.WriteHeader(400)
+			w.Write([]byte(`{"error":"invalid_grant","refresh_token":"leaked-token"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     m.sign(t, "RS256", "k1", m.claims(nonce)),
+		})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *mockIssuer) addKey(t *testing.T, kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.mu.Lock()
+	m.keys[kid] = key
+	m.mu.Unlock()
+}
+
+func (m *mockIssuer) claims(nonce string) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":            m.server.URL,
+		"sub":            m.subject,
+		"aud":            "client",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          nonce,
+		"email":          "user1@example.com",
+		"email_verified": true,
+	}
+}
+
+func (m *mockIssuer) sign(t *testing.T, alg string, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	if alg == "none" {
+		return signed + "."
+	}
+
+	m.mu.Lock()
+	key := m.keys[kid]
+	m.mu.Unlock()
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (m *mockIssuer) provider() *oidc.Provider {
+	return oidc.NewProvider(oidc.ProviderConfig{
+		Name:        "mock",
+		Issuer:      m.server.URL,
+		ClientId:    "client",
+		RedirectUrl: "http://localhost/auth/oidc/mock",
+	}, m.server.Client())
+}
+
+func TestOidcCodeChallenge(t *testing.T) {
+	// RFC 7636 Appendix B
+	if c := oidc.CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"); c != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("miss %s", c)
+	}
+}
+
+func TestOidcLogin(t *testing.T) {
+	m := newMockIssuer(t)
+	p := m.provider()
+	ctx := context.Background()
+
+	state, _ := oidc.RandomString()
+	nonce, _ := oidc.RandomString()
+	verifier, _ := oidc.RandomString()
+	rawUrl, err := p.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(rawUrl)
+	q := u.Query()
+	if !strings.HasPrefix(rawUrl, m.server.URL+"/auth?") || q.Get("state") != state || q.Get("nonce") != nonce ||
+		q.Get("code_challenge_method") != "S256" || q.Get("client_id") != "client" || !strings.Contains(q.Get("scope"), "openid") {
+		t.Fatalf("miss url %s", rawUrl)
+	}
+	m.challenge = q.Get("code_challenge")
+	m.nonce = q.Get("nonce")
+
+	claims, err := p.Exchange(ctx, "code1", verifier, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "user1" || claims.Email != "user1@example.com" || !claims.EmailVerified {
+		t.Errorf("miss %+v", claims)
+	}
+
+	// コード検証用文字列が異なる(エラーに応答の本文を含めない)
+	if _, err = p.Exchange(ctx, "code1", "other", nonce); err == nil || strings.Contains(err.Error(), "leaked-token") {
+		t.Errorf("miss verifier %v", err)
+	}
+	// nonceが異なる
+	if _, err = p.Exchange(ctx, "code1", verifier, "other"); err == nil {
+		t.Error("miss nonce")
+	}
+}
+
+func TestOidcVerify(t *testing.T) {
+	m := newMockIssuer(t)
+	p := m.provider()
+	ctx := context.Background()
+	now := time.Now()
+
+	if _, err := p.Verify(ctx, m.sign(t, "RS256", "k1", m.claims("n1")), "n1", now); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := map[string]func(c map[string]interface{}){
+		"aud":    func(c map[string]interface{}) { c["aud"] = "other" },
+		"azp":    func(c map[string]interface{}) { c["aud"] = []string{"client", "other"} },
+		"iss":    func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" },
+		"exp":    func(c map[string]interface{}) { c["exp"] = now.Add(-time.Hour).Unix() },
+		"iat":    func(c map[string]interface{}) { c["iat"] = now.Add(time.Hour).Unix() },
+		"nonce":  func(c map[string]interface{}) { c["nonce"] = "n2" },
+		"sub":    func(c map[string]interface{}) { delete(c, "sub") },
+		"nonexp": func(c map[string]interface{}) { delete(c, "exp") },
+	}
+	for name, modify := range invalid {
+		claims := m.claims("n1")
+		modify(claims)
+		if _, err := p.Verify(ctx, m.sign(t, "RS256", "k1", claims), "n1", now); err == nil {
+			t.Errorf("miss %s", name)
+		}
+	}
+
+	// 複数の対象者を含む場合はazpが一致すればよい
+	claims := m.claims("n1")
+	claims["aud"] = []string{"client", "other"}
+	claims["azp"] = "client"
+	if _, err := p.Verify(ctx, m.sign(t, "RS256", "k1", claims), "n1", now); err != nil {
+		t.Errorf("miss azp: %s", err.Error())
+	}
+
+	// 署名の改ざん
+	token := m.sign(t, "RS256", "k1", m.claims("n1"))
+	parts := strings.Split(token, ".")
+	payload, _ := json.Marshal(m.claims("n1"))
+	parts[1] = base64.RawURLEncoding.EncodeToString([]byte(strings.Replace(string(payload), "user1", "admin", 1)))
+	if _, err := p.Verify(ctx, strings.Join(parts, "."), "n1", now); err == nil {
+		t.Error("miss tampered")
+	}
+
+	// 署名なし
+	if _, err := p.Verify(ctx, m.sign(t, "none", "k1", m.claims("n1")), "n1", now); err == nil {
+		t.Error("miss alg none")
+	}
+}
+
+func TestOidcKeyRotation(t *testing.T) {
+	m := newMockIssuer(t)
+	p := m.provider()
+	ctx := context.Background()
+
+	if _, err := p.Verify(ctx, m.sign(t, "RS256", "k1", m.claims("n1")), "n1", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 取得済みの鍵セットにない鍵は、再取得の間隔を空けるまで受け付けない
+	m.addKey(t, "k2")
+	token := m.sign(t, "RS256", "k2", m.claims("n1"))
+	if _, err := p.Verify(ctx, token, "n1", time.Now()); err == nil {
+		t.Error("miss refresh span")
+	}
+
+	span := oidc.JwksRefreshSpan
+	oidc.JwksRefreshSpan = 0
+	defer func() { oidc.JwksRefreshSpan = span }()
+	if _, err := p.Verify(ctx, token, "n1", time.Now()); err != nil {
+		t.Errorf("miss rotation: %s", err.Error())
+	}
+}
+
+func TestOidcDiscoveryIssuer(t *testing.T) {
+	m := newMockIssuer(t)
+	p := oidc.NewProvider(oidc.ProviderConfig{
+		Name:        "mock",
+		Issuer:      m.server.URL + "/",
+		ClientId:    "client",
+		RedirectUrl: "http://localhost/auth/oidc/mock",
+	}, m.server.Client())
+
+	// ディスカバリーで取得した発行者が設定と一致しない
+	if _, err := p.AuthCodeURL(context.Background(), "s", "n", "v"); err == nil {
+		t.Error("miss issuer")
+	}
+}
+
+func TestOidcConfigValidate(t *testing.T) {
+	valid := oidc.ProviderConfig{Name: "keycloak", Issuer: "https://sso.example.com", ClientId: "c", RedirectUrl: "https://example.com/cb"}
+	if err := (oidc.Config{Providers: []oidc.ProviderConfig{valid}}).Validate(); err != nil {
+		t.Error(err)
+	}
+	if (oidc.Config{Providers: []oidc.ProviderConfig{valid, valid}}).Validate() == nil {
+		t.Error("miss duplicate")
+	}
+	invalidName := valid
+	invalidName.Name = "key/cloak"
+	if (oidc.Config{Providers: []oidc.ProviderConfig{invalidName}}).Validate() == nil {
+		t.Error("miss name")
+	}
+	noIssuer := valid
+	noIssuer.Issuer = ""
+	if (oidc.Config{Providers: []oidc.ProviderConfig{noIssuer}}).Validate() == nil {
+		t.Error("miss issuer")
+	}
+}
@@ -0,0 +1,92 @@
+package tests
+
+import (
+	"reflect"
+	"reviewmakerback/db"
+	"testing"
+)
+
+func TestUserLinkedServices(t *testing.T) {
+	user := db.User{TwitterId: "t1"}
+	if !reflect.DeepEqual(user.LinkedServices(), []string{"twitter"}) {
+		t.Errorf("miss %v", user.LinkedServices())
+	}
+
+	user.SetService(db.ServiceGoogle, "g1", "a@example.com")
+	if !reflect.DeepEqual(user.LinkedServices(), []string{"twitter", "google"}) || user.GoogleEmail != "a@example.com" {
+		t.Errorf("miss %v", user.LinkedServices())
+	}
+
+	user.SetService(db.ServiceTwitter, "", "")
+	if !reflect.DeepEqual(user.LinkedServices(), []string{"google"}) {
+		t.Errorf("miss %v", user.LinkedServices())
+	}
+}
+
+func TestCheckUnlinkService(t *testing.T) {
+	user := db.User{TwitterId: "t1", GoogleId: "g1"}
+	if db.CheckUnlinkService(user, db.ServiceTwitter) != nil {
+		t.Error("miss two services")
+	}
+
+	// 最後の連携サービスは解除できない
+	user.GoogleId = ""
+	if db.CheckUnlinkService(user, db.ServiceTwitter) != db.ErrProviderLast {
+		t.Error("miss last service")
+	}
+	if db.CheckUnlinkService(user, db.ServiceGoogle) == nil {
+		t.Error("miss not linked")
+	}
+}
+
+func TestMergeServices(t *testing.T) {
+	dst := db.User{UserId: "u1", TwitterId: "t1", TwitterUserName: "name"}
+	src := db.User{UserId: "u2", GoogleId: "g1", GoogleEmail: "a@example.com"}
+
+	merged, err := db.MergeServices(dst, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.UserId != "u1" || merged.TwitterId != "t1" || merged.GoogleId != "g1" || merged.GoogleEmail != "a@example.com" {
+		t.Errorf("miss %+v", merged)
+	}
+
+	// 同じサービスの別のアカウントが連携されている場合は統合できない
+	src.TwitterId = "t2"
+	if _, err = db.MergeServices(dst, src); err != db.ErrProviderConflict {
+		t.Error("miss conflict")
+	}
+}
+
+func TestReplacePathOwner(t *testing.T) {
+	if p := db.ReplacePathOwner("u2/tier/t1/image_a.jpg", "u2", "u1"); p != "u1/tier/t1/image_a.jpg" {
+		t.Errorf("miss %s", p)
+	}
+	if p := db.ReplacePathOwner("u22/tier/t1/image_a.jpg", "u2", "u1"); p != "u22/tier/t1/image_a.jpg" {
+		t.Errorf("miss prefix %s", p)
+	}
+	if p := db.ReplacePathOwner("", "u2", "u1"); p != "" {
+		t.Errorf("miss empty %s", p)
+	}
+}
+
+func TestReplaceParagsOwner(t *testing.T) {
+	parags := `[{"type":"text","body":"u2/tier/t1/a.jpg"},{"type":"imageLink","body":"u2/tier/t1/b.jpg"},{"type":"imageLink","body":"https://example.com/u2/c.jpg"}]`
+	want := `[{"type":"text","body":"u2/tier/t1/a.jpg"},{"type":"imageLink","body":"u1/tier/t1/b.jpg"},{"type":"imageLink","body":"https://example.com/u2/c.jpg"}]`
+	if p := db.ReplaceParagsOwner(parags, "u2", "u1"); p != want {
+		t.Errorf("miss %s", p)
+	}
+	// 読み込めない・置き換えるものがない場合はそのまま
+	if p := db.ReplaceParagsOwner("broken", "u2", "u1"); p != "broken" {
+		t.Errorf("miss broken %s", p)
+	}
+	if p := db.ReplaceParagsOwner(want, "u2", "u1"); p != want {
+		t.Errorf("miss unchanged %s", p)
+	}
+
+	sections := `[{"title":"s1","parags":[{"type":"imageLink","body":"u2/review/r1/a.jpg"}]},{"title":"s2","parags":[]}]`
+	want = `[{"title":"s1","parags":[{"type":"imageLink","body":"u1/review/r1/a.jpg"}]},{"title":"s2","parags":[]}]`
+	if p := db.ReplaceSectionsOwner(sections, "u2", "u1"); p != want {
+		t.Errorf("miss sections %s", p)
+	}
+}
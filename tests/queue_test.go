@@ -0,0 +1,387 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reviewmakerback/db"
+	"reviewmakerback/queue"
+	"reviewmakerback/rest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// 再試行を待たずに行う
+func noBackoff(attempts int) time.Duration {
+	return 0
+}
+
+// ワーカーを開始し、テストの終了時に停止する
+func startQueue(t *testing.T, q *queue.Queue) context.CancelFunc {
+	ctx, cancel := context.WithCancel(context.Background())
+	wait := q.Start(ctx)
+	t.Cleanup(func() {
+		cancel()
+		wait()
+	})
+	return cancel
+}
+
+// ジョブが指定した状態になるまで待つ
+func waitJobStatus(t *testing.T, store *queue.MemoryStore, id uint64, status string) queue.MemoryJob {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, _ := store.Get(id)
+		if job.Status == status {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	job, _ := store.Get(id)
+	t.Fatalf("miss status %s %+v", status, job)
+	return job
+}
+
+func TestQueueSucceeded(t *testing.T) {
+	store := queue.NewMemoryStore()
+	q := queue.New(store, 2, 10*time.Millisecond)
+	q.Register("echo", func(ctx context.Context, job queue.Job) (queue.Result, error) {
+		return queue.Result{Data: job.Payload, File: "out.zip"}, nil
+	}, queue.Options{})
+	startQueue(t, q)
+
+	id := store.Enqueue("echo", "user1", `{"a":1}`, 3)
+	q.Notify()
+	job := waitJobStatus(t, store, id, queue.StatusSucceeded)
+	if job.Result.Data != `{"a":1}` || job.Result.File != "out.zip" || job.Attempts != 1 {
+		t.Errorf("miss %+v", job)
+	}
+}
+
+func TestQueueRetry(t *testing.T) {
+	store := queue.NewMemoryStore()
+	q := queue.New(store, 1, 10*time.Millisecond)
+	var calls int32
+	q.Register("flaky", func(ctx context.Context, job queue.Job) (queue.Result, error) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			return queue.Result{}, errors.New("temporary")
+		}
+		return queue.Result{}, nil
+	}, queue.Options{Backoff: noBackoff})
+	startQueue(t, q)
+
+	id := store.Enqueue("flaky", "", "{}", 5)
+	job := waitJobStatus(t, store, id, queue.StatusSucceeded)
+	if job.Attempts != 3 || job.LastError != "" {
+		t.Errorf("miss %+v", job)
+	}
+}
+
+func TestQueueDead(t *testing.T) {
+	store := queue.NewMemoryStore()
+	q := queue.New(store, 1, 10*time.Millisecond)
+	var calls int32
+	q.Register("fail", func(ctx context.Context, job queue.Job) (queue.Result, error) {
+		atomic.AddInt32(&calls, 1)
+		return queue.Result{}, errors.New("always")
+	}, queue.Options{Backoff: noBackoff})
+	q.Register("permanent", func(ctx context.Context, job queue.Job) (queue.Result, error) {
+		atomic.AddInt32(&calls, 1)
+		return queue.Result{}, queue.Permanent(errors.New("broken input"))
+	}, queue.Options{Backoff: noBackoff})
+	startQueue(t, q)
+
+	// 試行回数の上限に達するとdeadになる
+	id := store.Enqueue("fail", "", "{}", 3)
+	job := waitJobStatus(t, store, id, queue.StatusDead)
+	if job.Attempts != 3 || job.LastError != "always" || atomic.LoadInt32(&calls) != 3 {
+		t.Errorf("miss %+v %d", job, calls)
+	}
+
+	// 再試行しない失敗は一回でdeadになる
+	id = store.Enqueue("permanent", "", "{}", 3)
+	job = waitJobStatus(t, store, id, queue.StatusDead)
+	if job.Attempts != 1 || job.LastError != "broken input" || atomic.LoadInt32(&calls) != 4 {
+		t.Errorf("miss %+v %d", job, calls)
+	}
+
+	// 処理が登録されていない種類は取得しない
+	id = store.Enqueue("unknown", "", "{}", 3)
+	time.Sleep(50 * time.Millisecond)
+	if job, _ = store.Get(id); job.Status != queue.StatusQueued {
+		t.Errorf("miss unknown %+v", job)
+	}
+}
+
+func TestQueueOnDead(t *testing.T) {
+	store := queue.NewMemoryStore()
+	q := queue.New(store, 1, 10*time.Millisecond)
+	dead := make(chan string, 10)
+	onDead := func(ctx context.Context, job queue.Job, lastError string) {
+		dead <- job.Payload + " " + lastError
+	}
+	var calls int32
+	q.Register("flaky", func(ctx context.Context, job queue.Job) (queue.Result, error) {
+		if job.Payload == "recover" && atomic.AddInt32(&calls, 1) > 1 {
+			return queue.Result{}, nil
+		}
+		return queue.Result{}, errors.New("always")
+	}, queue.Options{Backoff: noBackoff, OnDead: onDead})
+	q.Register("permanent", func(ctx context.Context, job queue.Job) (queue.Result, error) {
+		return queue.Result{}, queue.Permanent(errors.New("broken input"))
+	}, queue.Options{OnDead: onDead})
+	startQueue(t, q)
+
+	receive := func() string {
+		select {
+		case d := <-dead:
+			return d
+		case <-time.After(5 * time.Second):
+			t.Fatal("miss on dead")
+			return ""
+		}
+	}
+
+	// 再試行の後に成功した場合は呼ばれない
+	id := store.Enqueue("flaky", "", "recover", 3)
+	waitJobStatus(t, store, id, queue.StatusSucceeded)
+
+	// 試行回数の上限に達した場合と再試行しない失敗の場合に一回ずつ呼ばれる
+	store.Enqueue("flaky", "", "exhausted", 2)
+	if d := receive(); d != "exhausted always" {
+		t.Errorf("miss exhausted %s", d)
+	}
+	store.Enqueue("permanent", "", "input", 2)
+	if d := receive(); d != "input broken input" {
+		t.Errorf("miss permanent %s", d)
+	}
+	select {
+	case d := <-dead:
+		t.Errorf("miss extra %s", d)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestImageJobDeadRemovesSource(t *testing.T) {
+	conf := requireDb(t)
+	conf.Server.FilePath = t.TempDir()
+	rest.Configure(conf)
+	user := createTestUser(t, "imgd", db.RoleUser)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	path := user.UserId + "/tier/t1/image.jpg"
+	source := filepath.Join(conf.Server.FilePath, ".queue/images", path)
+	if err := os.MkdirAll(filepath.Dir(source), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(source, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// 変換後の画像を書き込めないようにする
+	if err := os.MkdirAll(filepath.Join(conf.Server.FilePath, path+".tmp"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetStorageUsage(user.UserId, int64(buf.Len())); err != nil {
+		t.Fatal(err)
+	}
+
+	store := queue.NewMemoryStore()
+	q := queue.New(store, 1, 10*time.Millisecond)
+	rest.RegisterQueueJobs(q)
+	startQueue(t, q)
+	payload, _ := json.Marshal(map[string]interface{}{"path": path, "maxEdge": 4, "quality": 80})
+	id := store.Enqueue(db.QueueKindImage, user.UserId, string(payload), 1)
+	waitJobStatus(t, store, id, queue.StatusDead)
+
+	// 変換待ちの画像を削除し、容量から差し引く
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := os.Stat(source)
+		used, _ := db.GetStorageUsage(user.UserId)
+		if os.IsNotExist(err) && used == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("miss source removed %v %d", err, used)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestQueuePanicAndTimeout(t *testing.T) {
+	store := queue.NewMemoryStore()
+	q := queue.New(store, 1, 10*time.Millisecond)
+	q.Register("panic", func(ctx context.Context, job queue.Job) (queue.Result, error) {
+		panic("boom")
+	}, queue.Options{Backoff: noBackoff})
+	q.Register("slow", func(ctx context.Context, job queue.Job) (queue.Result, error) {
+		<-ctx.Done()
+		return queue.Result{}, nil
+	}, queue.Options{Timeout: 20 * time.Millisecond, Backoff: noBackoff})
+	startQueue(t, q)
+
+	id := store.Enqueue("panic", "", "{}", 1)
+	job := waitJobStatus(t, store, id, queue.StatusDead)
+	if job.LastError != "パニックが発生しました: boom" {
+		t.Errorf("miss %+v", job)
+	}
+
+	// 制限時間を過ぎた場合は成功として扱わない
+	id = store.Enqueue("slow", "", "{}", 1)
+	job = waitJobStatus(t, store, id, queue.StatusDead)
+	if job.LastError == "" {
+		t.Errorf("miss %+v", job)
+	}
+}
+
+func TestQueueRelease(t *testing.T) {
+	store := queue.NewMemoryStore()
+	q := queue.New(store, 1, 10*time.Millisecond)
+	started := make(chan struct{})
+	q.Register("long", func(ctx context.Context, job queue.Job) (queue.Result, error) {
+		close(started)
+		<-ctx.Done()
+		return queue.Result{}, ctx.Err()
+	}, queue.Options{Backoff: noBackoff})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wait := q.Start(ctx)
+	id := store.Enqueue("long", "", "{}", 1)
+	<-started
+	cancel()
+	wait()
+
+	// 停止で中断したジョブは試行回数に数えずに実行待ちに戻す
+	job, _ := store.Get(id)
+	if job.Status != queue.StatusQueued || job.Attempts != 0 {
+		t.Errorf("miss %+v", job)
+	}
+}
+
+func TestQueueExpiredLease(t *testing.T) {
+	store := queue.NewMemoryStore()
+	id := store.Enqueue("kind", "", "{}", 3)
+
+	// 応答しなくなったワーカーが取得したジョブは、期限を過ぎると他のワーカーが取得する
+	first, _ := store.Claim("worker1", []string{"kind"}, -time.Second)
+	second, _ := store.Claim("worker2", []string{"kind"}, time.Minute)
+	if first == nil || second == nil || second.Id != id || second.Attempts != 2 {
+		t.Fatalf("miss %+v %+v", first, second)
+	}
+	if err := store.Complete(*first, "worker1", queue.Result{}); !errors.Is(err, queue.ErrLost) {
+		t.Errorf("miss lost %v", err)
+	}
+	if job, _ := store.Claim("worker3", []string{"kind"}, time.Minute); job != nil {
+		t.Errorf("miss duplicate %+v", job)
+	}
+}
+
+func TestQueueDefaultBackoff(t *testing.T) {
+	if queue.DefaultBackoff(1) != 10*time.Second || queue.DefaultBackoff(3) != 40*time.Second || queue.DefaultBackoff(100) != time.Hour {
+		t.Error("miss")
+	}
+	if !queue.IsPermanent(queue.Permanent(errors.New("x"))) || queue.IsPermanent(errors.New("x")) || queue.Permanent(nil) != nil {
+		t.Error("miss permanent")
+	}
+}
+
+func TestRetryImageJobWithoutSource(t *testing.T) {
+	conf := requireDb(t)
+	conf.Server.FilePath = t.TempDir()
+	rest.Configure(conf)
+	e := echo.New()
+	rest.Route(e)
+
+	admin := createTestUser(t, "rqad", db.RoleAdmin)
+	t.Cleanup(func() { db.Db.Where("user_id = ?", admin.UserId).Delete(&db.OperationLog{}) })
+	auth := createTestSession(t, admin)
+	user := createTestUser(t, "rqim", db.RoleUser)
+
+	path := user.UserId + "/tier/t1/image.jpg"
+	job, err := db.EnqueueQueueJob(db.QueueKindImage, user.UserId, map[string]interface{}{"path": path, "maxEdge": 4, "quality": 80}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Db.Delete(&db.QueueJob{}, job.Id) })
+	if err := db.Db.Model(&db.QueueJob{}).Where("id = ?", job.Id).Update("status", db.QueueDead).Error; err != nil {
+		t.Fatal(err)
+	}
+	retry := func() int {
+		req := httptest.NewRequest("PATCH", fmt.Sprintf("/admin/queue/%d/retry", job.Id), nil)
+		req.Header.Set("Authorization", auth)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// 変換待ちの画像が削除済みの場合は再実行しない
+	if code := retry(); code != 400 {
+		t.Errorf("miss no source %d", code)
+	}
+	if job, _ := db.GetQueueJob(job.Id); job.Status != db.QueueDead {
+		t.Errorf("miss status %s", job.Status)
+	}
+
+	source := filepath.Join(conf.Server.FilePath, ".queue/images", path)
+	if err := os.MkdirAll(filepath.Dir(source), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(source, []byte("image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code := retry(); code != 204 {
+		t.Errorf("miss retry %d", code)
+	}
+	if job, _ := db.GetQueueJob(job.Id); job.Status != db.QueueQueued {
+		t.Errorf("miss status %s", job.Status)
+	}
+}
+
+func TestEnqueueSingleQueueJob(t *testing.T) {
+	requireDb(t)
+	user := createTestUser(t, "qsgl", db.RoleUser)
+	t.Cleanup(func() {
+		db.Db.Where("user_id = ? and kind = ?", user.UserId, db.QueueKindExport).Delete(&db.QueueJob{})
+	})
+
+	// 同時に登録しても一つのみ
+	var wg sync.WaitGroup
+	var enqueued, active int32
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := db.EnqueueSingleQueueJob(db.QueueKindExport, user.UserId, map[string]string{"userId": user.UserId}, 1)
+			if err == nil {
+				atomic.AddInt32(&enqueued, 1)
+			} else if errors.Is(err, db.ErrQueueJobActive) {
+				atomic.AddInt32(&active, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if enqueued != 1 || active != 4 {
+		t.Errorf("miss concurrent %d %d", enqueued, active)
+	}
+
+	// 完了すれば再度登録できる
+	db.Db.Model(&db.QueueJob{}).Where("user_id = ? and kind = ?", user.UserId, db.QueueKindExport).Update("status", db.QueueSucceeded)
+	if _, err := db.EnqueueSingleQueueJob(db.QueueKindExport, user.UserId, map[string]string{"userId": user.UserId}, 1); err != nil {
+		t.Errorf("miss after finished %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reviewmakerback/ratelimit"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreBucket(t *testing.T) {
+	store := ratelimit.NewMemoryStore()
+	limit := ratelimit.Limit{Rate: 1, Burst: 3}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		r, _ := store.Take("k", limit, now)
+		if !r.Allowed || r.Remaining != 2-i {
+			t.Errorf("miss %d: %+v", i, r)
+		}
+	}
+
+	r, _ := store.Take("k", limit, now)
+	if r.Allowed || r.RetryAfter != time.Second {
+		t.Errorf("miss: %+v", r)
+	}
+
+	// 別のキーは影響を受けない
+	if r, _ := store.Take("other", limit, now); !r.Allowed {
+		t.Error("miss other")
+	}
+
+	// 補充
+	r, _ = store.Take("k", limit, now.Add(1500*time.Millisecond))
+	if !r.Allowed || r.Remaining != 0 {
+		t.Errorf("miss refill: %+v", r)
+	}
+}
+
+func TestRateLimitConfigMatch(t *testing.T) {
+	config := ratelimit.DefaultConfig
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"POST /auth/refresh":        "auth",
+		"GET /userfile/a/b/c/d.jpg": "file",
+		"PATCH /tier/abc":           "write",
+		"GET /tier/abc":             "read",
+	}
+	for req, name := range cases {
+		parts := strings.SplitN(req, " ", 2)
+		rule, ok := config.Match(parts[0], parts[1])
+		if !ok || rule.Name != name {
+			t.Errorf("%s: %s", req, rule.Name)
+		}
+	}
+}
+
+func TestLimiterUserAndIp(t *testing.T) {
+	limiter := ratelimit.Limiter{Store: ratelimit.NewMemoryStore()}
+	rule := ratelimit.Rule{
+		Name:    "write",
+		PerUser: ratelimit.Limit{Rate: 1, Burst: 2},
+		PerIp:   ratelimit.Limit{Rate: 1, Burst: 10},
+	}
+	now := time.Now()
+
+	limiter.Take(rule, "user1", "127.0.0.1", now)
+	limiter.Take(rule, "user1", "127.0.0.2", now)
+
+	// IPアドレスを変えてもユーザーIDの制限がかかる
+	r, limit, err := limiter.Take(rule, "user1", "127.0.0.3", now)
+	if err != nil || r.Allowed || limit.Burst != 2 {
+		t.Errorf("miss: %+v %+v", r, limit)
+	}
+
+	// 未ログインはIPアドレスの制限のみ
+	r, limit, _ = limiter.Take(rule, "", "127.0.0.3", now)
+	if !r.Allowed || limit.Burst != 10 {
+		t.Errorf("miss: %+v %+v", r, limit)
+	}
+}
+
+func TestLimiterDeniedDoesNotConsume(t *testing.T) {
+	store := ratelimit.NewMemoryStore()
+	limiter := ratelimit.Limiter{Store: store}
+	rule := ratelimit.Rule{
+		Name:    "write",
+		PerUser: ratelimit.Limit{Rate: 1, Burst: 1},
+		PerIp:   ratelimit.Limit{Rate: 1, Burst: 3},
+	}
+	now := time.Now()
+
+	// 制限されたユーザーが繰り返しても同じIPアドレスのトークンは減らない
+	limiter.Take(rule, "user1", "127.0.0.1", now)
+	for i := 0; i < 5; i++ {
+		if r, _, _ := limiter.Take(rule, "user1", "127.0.0.1", now); r.Allowed {
+			t.Errorf("miss user1 %d", i)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if r, _, _ := limiter.Take(rule, fmt.Sprintf("user%d", i+2), "127.0.0.1", now); !r.Allowed {
+			t.Errorf("miss other user %d: %+v", i, r)
+		}
+	}
+
+	// IPアドレスで制限された場合はユーザーのトークンを戻す
+	if r, _, _ := limiter.Take(rule, "user9", "127.0.0.1", now); r.Allowed {
+		t.Errorf("miss ip: %+v", r)
+	}
+	if r, _ := store.Take("write:user:user9", rule.PerUser, now); !r.Allowed {
+		t.Errorf("miss refund: %+v", r)
+	}
+}
+
+func TestRateLimitLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ratelimit.json")
+
+	os.WriteFile(path, []byte(`{"store":"postgres","rules":[{"name":"all","perIp":{"rate":2,"burst":5}}]}`), 0600)
+	config, err := ratelimit.LoadConfig(path)
+	if err != nil || config.Store != ratelimit.StorePostgres || config.Rules[0].PerIp.Burst != 5 {
+		t.Errorf("miss: %+v %v", config, err)
+	}
+
+	os.WriteFile(path, []byte(`{"store":"redis","rules":[]}`), 0600)
+	if _, err := ratelimit.LoadConfig(path); err == nil {
+		t.Error("miss store")
+	}
+
+	os.WriteFile(path, []byte(`{"store":"memory","rules":[{"name":"a"}]}`), 0600)
+	if _, err := ratelimit.LoadConfig(path); err == nil {
+		t.Error("miss empty limit")
+	}
+}
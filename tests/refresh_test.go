@@ -0,0 +1,167 @@
+package tests
+
+import (
+	"errors"
+	"reviewmakerback/db"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// テスト用のユーザーとセッションを作成する(リフレッシュトークンはテストの終了時に削除する)
+func createRefreshTestSession(t *testing.T, prefix string) (db.User, db.Session) {
+	t.Helper()
+	user := createTestUser(t, prefix, db.RoleUser)
+	t.Cleanup(func() { db.Db.Where("user_id = ?", user.UserId).Delete(&db.RefreshToken{}) })
+	sessionId := strings.TrimPrefix(createTestSession(t, user), "Bearer ")
+	var session db.Session
+	if err := db.Db.Where("session_id = ?", sessionId).Find(&session).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user, session
+}
+
+func sessionExists(t *testing.T, sessionId string) bool {
+	t.Helper()
+	var cnt int64
+	if err := db.Db.Model(&db.Session{}).Where("session_id = ?", sessionId).Count(&cnt).Error; err != nil {
+		t.Fatal(err)
+	}
+	return cnt == 1
+}
+
+func TestRefreshTokenIssueOnce(t *testing.T) {
+	requireDb(t)
+	_, session := createRefreshTestSession(t, "rfis")
+
+	if _, _, err := db.IssueRefreshToken(session); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.IssueRefreshToken(session); !errors.Is(err, db.ErrRefreshTokenIssued) {
+		t.Errorf("miss twice %v", err)
+	}
+
+	// 同時に発行しても一つのみ
+	_, session = createRefreshTestSession(t, "rfic")
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	issued, rejected := 0, 0
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := db.IssueRefreshToken(session)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err == nil {
+				issued++
+			} else if errors.Is(err, db.ErrRefreshTokenIssued) {
+				rejected++
+			}
+		}()
+	}
+	wg.Wait()
+	if issued != 1 || rejected != 4 {
+		t.Errorf("miss concurrent %d %d", issued, rejected)
+	}
+}
+
+func TestRefreshTokenRotate(t *testing.T) {
+	requireDb(t)
+	user, session := createRefreshTestSession(t, "rfrt")
+	token, _, err := db.IssueRefreshToken(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newSession, newToken, _, err := db.RotateRefreshToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newSession.SessionId == session.SessionId || newSession.UserId != user.UserId || newToken == token {
+		t.Errorf("miss new session %+v", newSession)
+	}
+	if sessionExists(t, session.SessionId) || !sessionExists(t, newSession.SessionId) {
+		t.Error("miss replace session")
+	}
+
+	// 新しいトークンで続けて更新できる
+	if _, _, _, err := db.RotateRefreshToken(newToken); err != nil {
+		t.Errorf("miss next %v", err)
+	}
+}
+
+func TestRefreshTokenReuse(t *testing.T) {
+	requireDb(t)
+	user, session := createRefreshTestSession(t, "rfru")
+	token0, _, err := db.IssueRefreshToken(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session1, token1, _, err := db.RotateRefreshToken(token0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session2, token2, _, err := db.RotateRefreshToken(token1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 使用済みのトークンを再度使うと系列のセッションとトークンを全て無効にする
+	_, _, _, err = db.RotateRefreshToken(token0)
+	var reused *db.RefreshTokenReusedError
+	if !errors.As(err, &reused) || !errors.Is(err, db.ErrRefreshTokenReused) {
+		t.Fatalf("miss reused %v", err)
+	}
+	if reused.UserId != user.UserId || reused.FamilyId == "" || reused.RevokeErr != nil {
+		t.Errorf("miss reused detail %+v", reused)
+	}
+	if sessionExists(t, session1.SessionId) || sessionExists(t, session2.SessionId) {
+		t.Error("miss revoke sessions")
+	}
+	if _, _, _, err := db.RotateRefreshToken(token2); err == nil {
+		t.Error("miss revoke tokens")
+	}
+}
+
+func TestRefreshTokenLimits(t *testing.T) {
+	requireDb(t)
+
+	// 操作がない場合の保持時間(KeepSession)を超えて延長しない
+	user, session := createRefreshTestSession(t, "rfki")
+	if err := db.Db.Model(&db.User{}).Where("user_id = ?", user.UserId).Update("keep_session", 60).Error; err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := db.IssueRefreshToken(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	newSession, _, _, err := db.RotateRefreshToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newSession.ExpiredTime.After(time.Now().Add(60 * time.Second)) || newSession.ExpiredTime.Before(start.Add(59*time.Second)) {
+		t.Errorf("miss keep session %v", newSession.ExpiredTime)
+	}
+
+	// 絶対的な有効期限を超えて延長しない
+	_, session = createRefreshTestSession(t, "rfab")
+	limit := time.Now().Add(30 * time.Second).Truncate(time.Second)
+	session.LimitTime = limit
+	if err := db.Db.Model(&db.Session{}).Where("session_id = ?", session.SessionId).Update("limit_time", limit).Error; err != nil {
+		t.Fatal(err)
+	}
+	token, issuedLimit, err := db.IssueRefreshToken(session)
+	if err != nil || !issuedLimit.Equal(limit) {
+		t.Fatalf("miss issue limit %v %v", issuedLimit, err)
+	}
+	newSession, _, refreshLimit, err := db.RotateRefreshToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newSession.ExpiredTime.After(limit) || !newSession.LimitTime.Equal(limit) || !refreshLimit.Equal(limit) {
+		t.Errorf("miss absolute limit %v %v %v", newSession.ExpiredTime, newSession.LimitTime, refreshLimit)
+	}
+}
@@ -0,0 +1,133 @@
+package tests
+
+import (
+	"context"
+	"net/http/httptest"
+	"reviewmakerback/db"
+	"reviewmakerback/rest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+)
+
+// ユーザーに対する通報をn件作成する
+func createTestReports(t *testing.T, target db.User, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		reporter := createTestUser(t, "rptr", db.RoleUser)
+		if _, err := db.CreateReport(reporter.UserId, db.ReportTargetUser, target.UserId, target.UserId, "spam", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		db.Db.Where("target_type = ? and target_id = ?", db.ReportTargetUser, target.UserId).Delete(&db.Report{})
+	})
+}
+
+// 未対応の通報のまとめのうち、指定したユーザーに対するもの
+func findReportGroups(t *testing.T, targetIds ...string) []db.ReportGroup {
+	t.Helper()
+	groups, err := db.GetOpenReportGroups(context.Background(), 1, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []db.ReportGroup
+	for _, group := range groups {
+		for _, targetId := range targetIds {
+			if group.TargetType == db.ReportTargetUser && group.TargetId == targetId {
+				found = append(found, group)
+			}
+		}
+	}
+	return found
+}
+
+func TestReportGroupsAndResolve(t *testing.T) {
+	requireDb(t)
+	many := createTestUser(t, "rptm", db.RoleUser)
+	few := createTestUser(t, "rptf", db.RoleUser)
+	moderator := createTestUser(t, "rptd", db.RoleModerator)
+	createTestReports(t, many, 3)
+	createTestReports(t, few, 1)
+
+	// 対象ごとにまとめ、通報数の多い順に並べる
+	groups := findReportGroups(t, many.UserId, few.UserId)
+	if len(groups) != 2 || groups[0].TargetId != many.UserId || groups[0].ReportCount != 3 || groups[1].ReportCount != 1 {
+		t.Fatalf("miss groups %+v", groups)
+	}
+	if groups[0].TargetUserId != many.UserId || groups[0].FirstReportedAt.After(groups[0].LastReportedAt) {
+		t.Errorf("miss group %+v", groups[0])
+	}
+
+	cnt, err := db.ResolveReports(db.ReportTargetUser, many.UserId, db.ReportDismissed, moderator.UserId)
+	if err != nil || cnt != 3 {
+		t.Errorf("miss resolve %d %v", cnt, err)
+	}
+	if reports, _ := db.GetOpenReports(context.Background(), db.ReportTargetUser, many.UserId); len(reports) != 0 {
+		t.Errorf("miss open %+v", reports)
+	}
+	if groups = findReportGroups(t, many.UserId, few.UserId); len(groups) != 1 || groups[0].TargetId != few.UserId {
+		t.Errorf("miss groups after resolve %+v", groups)
+	}
+
+	// 対応済みの通報は再度対応しない
+	if cnt, err = db.ResolveReports(db.ReportTargetUser, many.UserId, db.ReportHidden, moderator.UserId); err != nil || cnt != 0 {
+		t.Errorf("miss resolve again %d %v", cnt, err)
+	}
+	var report db.Report
+	db.Db.Where("target_type = ? and target_id = ?", db.ReportTargetUser, many.UserId).First(&report)
+	if report.Status != db.ReportDismissed || report.ResolvedBy != moderator.UserId || report.ResolvedAt.IsZero() {
+		t.Errorf("miss resolved %+v", report)
+	}
+}
+
+func TestResolveReportSuspendRole(t *testing.T) {
+	conf := requireDb(t)
+	conf.Server.FilePath = t.TempDir()
+	rest.Configure(conf)
+	e := echo.New()
+	rest.Route(e)
+
+	moderator := createTestUser(t, "rsmd", db.RoleModerator)
+	auth := createTestSession(t, moderator)
+
+	suspend := func(target db.User) int {
+		req := httptest.NewRequest("PATCH", "/mod/reports/user/"+target.UserId, strings.NewReader(`{"action":"suspend","suspendDays":1}`))
+		req.Header.Set("Authorization", auth)
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	suspended := func(target db.User) bool {
+		var user db.User
+		db.Db.Where("user_id = ?", target.UserId).First(&user)
+		return user.SuspensionState != db.SuspensionNone
+	}
+
+	// 同じか強い権限のユーザーは利用停止にできず、通報も未対応のまま
+	for _, role := range []string{db.RoleModerator, db.RoleAdmin} {
+		target := createTestUser(t, "rstg", role)
+		createTestReports(t, target, 1)
+		if code := suspend(target); code != 403 {
+			t.Errorf("miss %s %d", role, code)
+		}
+		if suspended(target) {
+			t.Errorf("miss suspended %s", role)
+		}
+		if reports, _ := db.GetOpenReports(context.Background(), db.ReportTargetUser, target.UserId); len(reports) != 1 {
+			t.Errorf("miss open %s", role)
+		}
+	}
+
+	// 弱い権限のユーザーは利用停止にできる
+	target := createTestUser(t, "rstu", db.RoleUser)
+	createTestReports(t, target, 1)
+	if code := suspend(target); code != 204 {
+		t.Errorf("miss user %d", code)
+	}
+	if !suspended(target) {
+		t.Error("miss suspend user")
+	}
+}
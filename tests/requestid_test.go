@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"reviewmakerback/logger"
+	"reviewmakerback/rest"
+	"testing"
+
+	"github.com/labstack/echo"
+)
+
+func newRequestIdServer(t *testing.T) (*echo.Echo, *bytes.Buffer) {
+	var buf bytes.Buffer
+	original := logger.Default()
+	logger.SetDefault(logger.New(&buf, logger.LevelInfo))
+	t.Cleanup(func() { logger.SetDefault(original) })
+
+	e := echo.New()
+	e.Use(rest.RequestId())
+	e.GET("/test/requestid/:id", func(c echo.Context) error {
+		// ハンドラー内のログにもリクエストIDが含まれる
+		logger.Info(c.Request().Context(), "handler", nil)
+		if c.Param("id") == "ng" {
+			return c.JSON(400, rest.MakeError("treq-001", "エラー"))
+		}
+		return c.JSON(200, map[string]string{"result": "ok"})
+	})
+	e.GET("/healthz", func(c echo.Context) error { return c.NoContent(200) })
+	return e, &buf
+}
+
+func TestRequestIdGenerated(t *testing.T) {
+	e, buf := newRequestIdServer(t)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/test/requestid/ng", nil))
+
+	requestId := rec.Header().Get("X-Request-ID")
+	if len(requestId) != 32 {
+		t.Fatalf("miss header %s", requestId)
+	}
+	if rec.Code != 400 {
+		t.Errorf("miss status %d", rec.Code)
+	}
+	var body rest.ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Code != "treq-001" || body.RequestId != requestId {
+		t.Errorf("miss body %+v", body)
+	}
+
+	lines := decodeLogLines(t, buf)
+	if len(lines) != 2 {
+		t.Fatalf("miss lines %v", lines)
+	}
+	for _, line := range lines {
+		if line["requestId"] != requestId {
+			t.Errorf("miss requestId %v", line)
+		}
+	}
+	if lines[1]["msg"] != "access" || lines[1]["status"] != float64(400) || lines[1]["level"] != "warn" ||
+		lines[1]["route"] != "/test/requestid/:id" {
+		t.Errorf("miss access %v", lines[1])
+	}
+}
+
+func TestRequestIdPropagated(t *testing.T) {
+	e, _ := newRequestIdServer(t)
+	req := httptest.NewRequest("GET", "/test/requestid/ok", nil)
+	req.Header.Set("X-Request-ID", "client-id.1")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Header().Get("X-Request-ID") != "client-id.1" {
+		t.Errorf("miss %s", rec.Header().Get("X-Request-ID"))
+	}
+	// 正常なレスポンスは変更しない
+	if rec.Code != 200 || rec.Body.String() != "{\"result\":\"ok\"}\n" {
+		t.Errorf("miss body %s", rec.Body.String())
+	}
+
+	// 不正な形式は引き継がない
+	req = httptest.NewRequest("GET", "/test/requestid/ok", nil)
+	req.Header.Set("X-Request-ID", "bad id\n")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if id := rec.Header().Get("X-Request-ID"); id == "bad id\n" || len(id) != 32 {
+		t.Errorf("miss invalid %s", id)
+	}
+}
+
+func TestRequestIdSkipsProbeAccessLog(t *testing.T) {
+	e, buf := newRequestIdServer(t)
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthz", nil))
+	if buf.Len() != 0 {
+		t.Errorf("miss %s", buf.String())
+	}
+
+	// 存在しないルートのエラーもリクエストIDを含む
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/missing", nil))
+	if rec.Code != 404 || rec.Header().Get("X-Request-ID") == "" {
+		t.Errorf("miss %d", rec.Code)
+	}
+}
@@ -0,0 +1,84 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"reviewmakerback/db"
+	"testing"
+)
+
+func TestHasRole(t *testing.T) {
+	if !db.HasRole(db.RoleAdmin, db.RoleModerator) {
+		t.Error("miss")
+	}
+	if !db.HasRole(db.RoleModerator, db.RoleModerator) {
+		t.Error("miss")
+	}
+	if db.HasRole(db.RoleUser, db.RoleModerator) {
+		t.Error("miss")
+	}
+	if db.HasRole(db.RoleModerator, db.RoleAdmin) {
+		t.Error("miss")
+	}
+	if db.HasRole("unknown", db.RoleUser) {
+		t.Error("miss")
+	}
+}
+
+func TestOutranksRole(t *testing.T) {
+	cases := []struct {
+		role   string
+		target string
+		want   bool
+	}{
+		{db.RoleAdmin, db.RoleModerator, true},
+		{db.RoleAdmin, db.RoleUser, true},
+		{db.RoleModerator, db.RoleUser, true},
+		// 同じ権限や強い権限のユーザーは操作できない
+		{db.RoleAdmin, db.RoleAdmin, false},
+		{db.RoleModerator, db.RoleModerator, false},
+		{db.RoleModerator, db.RoleAdmin, false},
+		{db.RoleUser, db.RoleUser, false},
+		{"unknown", db.RoleUser, false},
+	}
+	for _, c := range cases {
+		if db.OutranksRole(c.role, c.target) != c.want {
+			t.Errorf("miss %s -> %s", c.role, c.target)
+		}
+	}
+}
+
+func TestListHiddenPosts(t *testing.T) {
+	requireDb(t)
+	user := createTestUser(t, "lhid", db.RoleUser)
+	t.Cleanup(func() {
+		db.Db.Where("user_id = ?", user.UserId).Delete(&db.Tier{})
+		db.Db.Where("user_id = ?", user.UserId).Delete(&db.Review{})
+	})
+	tierId := testDbId("lhtr")
+	for i, hidden := range []bool{false, true} {
+		tier := db.Tier{TierId: fmt.Sprintf("%s%d", tierId, i), UserId: user.UserId, IsHidden: hidden}
+		if err := db.Db.Create(&tier).Error; err != nil {
+			t.Fatal(err)
+		}
+		review := db.Review{ReviewId: fmt.Sprintf("%sr%d", tierId, i), UserId: user.UserId, TierId: tier.TierId, IsHidden: hidden}
+		if err := db.Db.Create(&review).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+
+	// 作成者とモデレーター以外には非表示の投稿を含めない
+	if tiers, _ := db.GetTiers(ctx, user.UserId, "", "", 1, 10, false); len(tiers) != 1 || tiers[0].IsHidden {
+		t.Errorf("miss tiers %d", len(tiers))
+	}
+	if reviews, _ := db.GetReviews(ctx, user.UserId, "", "", "", 1, 10, false, false); len(reviews) != 1 || reviews[0].IsHidden {
+		t.Errorf("miss reviews %d", len(reviews))
+	}
+	if tiers, _ := db.GetTiers(ctx, user.UserId, "", "", 1, 10, true); len(tiers) != 2 {
+		t.Errorf("miss hidden tiers %d", len(tiers))
+	}
+	if reviews, _ := db.GetReviews(ctx, user.UserId, "", "", "", 1, 10, false, true); len(reviews) != 2 {
+		t.Errorf("miss hidden reviews %d", len(reviews))
+	}
+}
@@ -0,0 +1,292 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"reviewmakerback/db"
+	"reviewmakerback/ontime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseScheduleCron(t *testing.T) {
+	base := time.Date(2022, 1, 31, 23, 59, 30, 0, time.UTC) // 月曜日
+	cases := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"30 3 * * *", time.Date(2022, 2, 1, 3, 30, 0, 0, time.UTC)},
+		{"0 9 * * 1-5", time.Date(2022, 2, 1, 9, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2022, 2, 6, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 12 1,15 * *", time.Date(2022, 2, 1, 12, 0, 0, 0, time.UTC)},
+		// 日と曜日の両方を指定した場合はどちらかに一致すれば実行する
+		{"0 0 10 * 3", time.Date(2022, 2, 2, 0, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		schedule, err := ontime.ParseSchedule(c.spec)
+		if err != nil {
+			t.Errorf("miss %s %v", c.spec, err)
+			continue
+		}
+		if got := schedule.Next(base); !got.Equal(c.want) {
+			t.Errorf("miss %s %s", c.spec, got)
+		}
+		if schedule.String() != c.spec {
+			t.Errorf("miss string %s", schedule.String())
+		}
+	}
+
+	// 存在しない日付
+	schedule, _ := ontime.ParseSchedule("0 0 31 2 *")
+	if !schedule.Next(base).IsZero() {
+		t.Error("miss impossible")
+	}
+}
+
+func TestParseScheduleEvery(t *testing.T) {
+	schedule, err := ontime.ParseSchedule("@every 90s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	if !schedule.Next(base).Equal(base.Add(90*time.Second)) || schedule.String() != "@every 1m30s" {
+		t.Errorf("miss %s", schedule)
+	}
+}
+
+func TestParseScheduleInvalid(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "a * * * *", "@every -1s", "@every x", "@yearly"} {
+		if _, err := ontime.ParseSchedule(spec); err == nil {
+			t.Errorf("miss %q", spec)
+		}
+	}
+}
+
+// 実行の担当を切り替えられるElector
+type testElector struct {
+	mutex    sync.Mutex
+	leader   bool
+	released bool
+}
+
+func (e *testElector) Acquire(ctx context.Context) (bool, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.leader, nil
+}
+
+func (e *testElector) Release() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.released = true
+}
+
+func findStatus(t *testing.T, s *ontime.Scheduler, name string) ontime.JobStatus {
+	for _, status := range s.Statuses(time.Now()) {
+		if status.Name == name {
+			return status
+		}
+	}
+	t.Fatalf("miss status %s", name)
+	return ontime.JobStatus{}
+}
+
+// 条件を満たすまで待つ
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSchedulerRunsJobs(t *testing.T) {
+	s := ontime.NewScheduler(nil)
+	var mutex sync.Mutex
+	runs := 0
+	err := s.Add(ontime.Job{
+		Name:       "count",
+		Schedule:   ontime.Every(10 * time.Millisecond),
+		RunOnStart: true,
+		Run: func(ctx context.Context) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			runs++
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add(ontime.Job{Name: "count", Schedule: ontime.Every(time.Second), Run: func(ctx context.Context) error { return nil }}); err == nil {
+		t.Error("miss duplicate")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wait := s.Start(ctx)
+	waitFor(t, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return runs >= 3
+	})
+	cancel()
+	wait()
+
+	status := findStatus(t, s, "count")
+	if status.LastRun.IsZero() || status.LastError != "" || status.Schedule != "@every 10ms" || !s.IsLeader() {
+		t.Errorf("miss %+v", status)
+	}
+}
+
+func TestSchedulerRecoversPanicAndTimeout(t *testing.T) {
+	s := ontime.NewScheduler(nil)
+	s.Add(ontime.Job{
+		Name:       "panic",
+		Schedule:   ontime.Every(time.Hour),
+		RunOnStart: true,
+		Run:        func(ctx context.Context) error { panic("boom") },
+	})
+	s.Add(ontime.Job{
+		Name:       "timeout",
+		Schedule:   ontime.Every(time.Hour),
+		Timeout:    10 * time.Millisecond,
+		RunOnStart: true,
+		Run: func(ctx context.Context) error {
+			<-ctx.Done()
+			return nil
+		},
+	})
+	s.Add(ontime.Job{
+		Name:       "error",
+		Schedule:   ontime.Every(time.Hour),
+		RunOnStart: true,
+		Run:        func(ctx context.Context) error { return errors.New("failed") },
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wait := s.Start(ctx)
+	waitFor(t, func() bool {
+		for _, status := range s.Statuses(time.Now()) {
+			if status.LastRun.IsZero() || status.Running {
+				return false
+			}
+		}
+		return true
+	})
+	cancel()
+	wait()
+
+	for name, want := range map[string]bool{"panic": true, "timeout": true, "error": true} {
+		status := findStatus(t, s, name)
+		if (status.LastError != "") != want {
+			t.Errorf("miss %s %+v", name, status)
+		}
+		if status.NextRun.Before(status.LastRun.Add(59 * time.Minute)) {
+			t.Errorf("miss next %s %+v", name, status)
+		}
+	}
+}
+
+func TestSchedulerSkipsWhenNotLeader(t *testing.T) {
+	elector := &testElector{}
+	s := ontime.NewScheduler(elector)
+	var mutex sync.Mutex
+	runs := 0
+	s.Add(ontime.Job{
+		Name:       "leader",
+		Schedule:   ontime.Every(10 * time.Millisecond),
+		RunOnStart: true,
+		Run: func(ctx context.Context) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			runs++
+			return nil
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wait := s.Start(ctx)
+	waitFor(t, func() bool { return findStatus(t, s, "leader").Skipped })
+	mutex.Lock()
+	if runs != 0 || s.IsLeader() {
+		t.Errorf("miss runs %d", runs)
+	}
+	mutex.Unlock()
+
+	// 担当になると実行する
+	elector.mutex.Lock()
+	elector.leader = true
+	elector.mutex.Unlock()
+	waitFor(t, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return runs > 0
+	})
+	if findStatus(t, s, "leader").Stale {
+		t.Error("miss stale")
+	}
+
+	cancel()
+	wait()
+	if !elector.released {
+		t.Error("miss release")
+	}
+}
+
+func TestJobStatusStale(t *testing.T) {
+	s := ontime.NewScheduler(nil)
+	s.Add(ontime.Job{Name: "later", Schedule: ontime.Every(time.Hour), Run: func(ctx context.Context) error { return nil }})
+	ctx, cancel := context.WithCancel(context.Background())
+	wait := s.Start(ctx)
+	defer func() {
+		cancel()
+		wait()
+	}()
+
+	waitFor(t, func() bool { return !findStatus(t, s, "later").NextRun.IsZero() })
+	if statuses := s.Statuses(time.Now()); statuses[0].Stale {
+		t.Error("miss fresh")
+	}
+	if statuses := s.Statuses(time.Now().Add(2 * time.Hour)); !statuses[0].Stale {
+		t.Error("miss stale")
+	}
+}
+
+func TestAdvisoryLockElection(t *testing.T) {
+	requireDb(t)
+	key := time.Now().UnixNano()
+	first := db.NewAdvisoryLock(key)
+	second := db.NewAdvisoryLock(key)
+	defer first.Release()
+	defer second.Release()
+	ctx := context.Background()
+
+	if ok, err := first.Acquire(ctx); err != nil || !ok {
+		t.Fatalf("miss first %v", err)
+	}
+	// 保持している間は何度確認しても担当のまま
+	if ok, _ := first.Acquire(ctx); !ok {
+		t.Error("miss keep")
+	}
+	// 他のインスタンスは担当になれない
+	if ok, err := second.Acquire(ctx); err != nil || ok {
+		t.Errorf("miss second %v", err)
+	}
+
+	// 解放すると他のインスタンスが担当になる
+	first.Release()
+	if ok, err := second.Acquire(ctx); err != nil || !ok {
+		t.Errorf("miss takeover %v", err)
+	}
+	if ok, _ := first.Acquire(ctx); ok {
+		t.Error("miss released")
+	}
+}
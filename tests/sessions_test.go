@@ -0,0 +1,153 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reviewmakerback/db"
+	"reviewmakerback/rest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+)
+
+// セッション管理のエンドポイントのテスト用のサーバー
+func newSessionsTestServer(t *testing.T) *echo.Echo {
+	t.Helper()
+	conf := requireDb(t)
+	conf.Server.FilePath = t.TempDir()
+	rest.Configure(conf)
+	e := echo.New()
+	rest.Route(e)
+	return e
+}
+
+func serveSessionsRequest(e *echo.Echo, method string, path string, auth string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("User-Agent", "sessions-test/1.0")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func sessionIdOf(auth string) string {
+	return strings.TrimPrefix(auth, "Bearer ")
+}
+
+func operationLogCount(t *testing.T, userId string, operation string) int64 {
+	t.Helper()
+	var cnt int64
+	if err := db.Db.Model(&db.OperationLog{}).Where("user_id = ? and operation = ?", userId, operation).Count(&cnt).Error; err != nil {
+		t.Fatal(err)
+	}
+	return cnt
+}
+
+func TestGetSessions(t *testing.T) {
+	e := newSessionsTestServer(t)
+	user := createTestUser(t, "gses", db.RoleUser)
+	auth := createTestSession(t, user)
+	other := createTestSession(t, user)
+
+	rec := serveSessionsRequest(e, "GET", "/auth/sessions", auth)
+	if rec.Code != 200 {
+		t.Fatalf("miss code %d %s", rec.Code, rec.Body.String())
+	}
+	var sessions []rest.SessionInfoData
+	if err := json.Unmarshal(rec.Body.Bytes(), &sessions); err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("miss count %d", len(sessions))
+	}
+	for _, s := range sessions {
+		switch s.Id {
+		case db.PublicSessionId(sessionIdOf(auth)):
+			// アクセス元の情報を記録する
+			if !s.IsCurrent || s.UserAgent != "sessions-test/1.0" || s.LastSeenAt == "" {
+				t.Errorf("miss current %+v", s)
+			}
+		case db.PublicSessionId(sessionIdOf(other)):
+			if s.IsCurrent {
+				t.Errorf("miss other %+v", s)
+			}
+		default:
+			t.Errorf("miss id %+v", s)
+		}
+		// セッションIDそのものは返さない
+		if s.Id == sessionIdOf(auth) || s.Id == sessionIdOf(other) {
+			t.Error("miss raw session id")
+		}
+	}
+
+	var session db.Session
+	db.Db.Where("session_id = ?", sessionIdOf(auth)).First(&session)
+	if session.UserAgent != "sessions-test/1.0" || session.LastSeenAt.IsZero() {
+		t.Errorf("miss touch %+v", session)
+	}
+}
+
+func TestDeleteSession(t *testing.T) {
+	e := newSessionsTestServer(t)
+	user := createTestUser(t, "dses", db.RoleUser)
+	t.Cleanup(func() { db.Db.Where("user_id = ?", user.UserId).Delete(&db.OperationLog{}) })
+	auth := createTestSession(t, user)
+	target := createTestSession(t, user)
+
+	other := createTestUser(t, "dsot", db.RoleUser)
+	otherAuth := createTestSession(t, other)
+
+	// 他のユーザーのセッションは公開IDを指定しても削除できない
+	rec := serveSessionsRequest(e, "DELETE", "/auth/sessions/"+db.PublicSessionId(sessionIdOf(otherAuth)), auth)
+	if rec.Code != 404 || !sessionExists(t, sessionIdOf(otherAuth)) {
+		t.Errorf("miss other user %d", rec.Code)
+	}
+
+	if rec := serveSessionsRequest(e, "DELETE", "/auth/sessions/not-hex", auth); rec.Code != 400 {
+		t.Errorf("miss invalid %d", rec.Code)
+	}
+
+	rec = serveSessionsRequest(e, "DELETE", "/auth/sessions/"+db.PublicSessionId(sessionIdOf(target)), auth)
+	if rec.Code != 204 {
+		t.Fatalf("miss code %d %s", rec.Code, rec.Body.String())
+	}
+	if sessionExists(t, sessionIdOf(target)) || !sessionExists(t, sessionIdOf(auth)) {
+		t.Error("miss delete")
+	}
+	if operationLogCount(t, user.UserId, "dses") != 1 {
+		t.Error("miss operation log")
+	}
+}
+
+func TestDeleteOtherSessions(t *testing.T) {
+	e := newSessionsTestServer(t)
+	user := createTestUser(t, "dsso", db.RoleUser)
+	t.Cleanup(func() { db.Db.Where("user_id = ?", user.UserId).Delete(&db.OperationLog{}) })
+	auth := createTestSession(t, user)
+	others := []string{createTestSession(t, user), createTestSession(t, user)}
+
+	another := createTestUser(t, "dsan", db.RoleUser)
+	anotherAuth := createTestSession(t, another)
+
+	rec := serveSessionsRequest(e, "DELETE", "/auth/sessions/others", auth)
+	if rec.Code != 204 {
+		t.Fatalf("miss code %d %s", rec.Code, rec.Body.String())
+	}
+	// リクエスト元のセッションは残す
+	if !sessionExists(t, sessionIdOf(auth)) {
+		t.Error("miss current")
+	}
+	for _, other := range others {
+		if sessionExists(t, sessionIdOf(other)) {
+			t.Error("miss others")
+		}
+	}
+	// 他のユーザーのセッションには影響しない
+	if !sessionExists(t, sessionIdOf(anotherAuth)) {
+		t.Error("miss another user")
+	}
+	if operationLogCount(t, user.UserId, "dsso") != 1 {
+		t.Error("miss operation log")
+	}
+}
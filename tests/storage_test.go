@@ -0,0 +1,273 @@
+package tests
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reviewmakerback/db"
+	"reviewmakerback/storage"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStorageReferences(t *testing.T) {
+	tier := db.Tier{
+		TierId:   "t1",
+		UserId:   "u1",
+		ImageUrl: "u1/tier/t1/image_a.jpg",
+		Parags:   `[{"type":"text","body":"x"},{"type":"imageLink","body":"u1/tier/t1/image_b.jpg"},{"type":"imageLink","body":""}]`,
+	}
+	refs := storage.TierReferences(tier)
+	if len(refs) != 2 || refs[0].Kind != storage.KindTierImage || refs[1].Kind != storage.KindTierParag || refs[1].Path != "u1/tier/t1/image_b.jpg" {
+		t.Errorf("miss tier %+v", refs)
+	}
+
+	review := db.Review{
+		ReviewId: "r1",
+		UserId:   "u1",
+		IconUrl:  "",
+		Sections: `[{"title":"a","parags":[{"type":"imageLink","body":"u1/review/r1/image_c.jpg"}]},{"title":"b","parags":[]}]`,
+	}
+	refs = storage.ReviewReferences(review)
+	if len(refs) != 1 || refs[0].Kind != storage.KindReviewParag || refs[0].OwnerId != "r1" {
+		t.Errorf("miss review %+v", refs)
+	}
+
+	// 連携サービスのアイコンのURLは対象外
+	if refs = storage.UserReferences(db.User{UserId: "u1", IconUrl: "https://pbs.twimg.com/a.jpg"}); len(refs) != 0 {
+		t.Errorf("miss user %+v", refs)
+	}
+	if refs = storage.UserReferences(db.User{UserId: "u1", IconUrl: "u1/user/user/icon_a.jpg"}); len(refs) != 1 {
+		t.Errorf("miss user %+v", refs)
+	}
+}
+
+// 保存先にファイルを作成し、更新日時をmodTimeにする
+func writeStorageFile(t *testing.T, root string, rel string, modTime time.Time) {
+	t.Helper()
+	p := filepath.Join(root, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(p, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorageCheck(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+
+	writeStorageFile(t, root, "u1/tier/t1/image_a.jpg", old)               // 参照あり
+	writeStorageFile(t, root, "u1/tier/t1/image_b.jpg", old)               // 参照なし
+	writeStorageFile(t, root, "u1/tier/t1/image_c.jpg", now)               // 参照なしだが保存直後
+	writeStorageFile(t, root, "u1/review/r1/icon_d.jpg", old)              // 参照なし
+	writeStorageFile(t, root, ".exports/u1/1.zip", old)                    // 対象外
+	writeStorageFile(t, root, ".queue/images/u1/tier/t1/image_e.jpg", old) // 変換待ち
+
+	refs := []storage.Reference{
+		{Kind: storage.KindTierImage, OwnerId: "t1", Path: "u1/tier/t1/image_a.jpg"},
+		{Kind: storage.KindTierParag, OwnerId: "t1", Path: "u1/tier/t1/image_e.jpg"},
+		{Kind: storage.KindTierParag, OwnerId: "t1", Path: "u1/tier/t1/missing.jpg"},
+		{Kind: storage.KindUserIcon, OwnerId: "u1", Path: "../outside.jpg"},
+	}
+
+	// 報告のみ
+	report, err := storage.Check(context.Background(), root, refs, storage.Options{MinAge: 24 * time.Hour, Now: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Files != 4 || report.References != 4 || report.Young != 1 {
+		t.Errorf("miss counts %+v", report)
+	}
+	if len(report.Orphans) != 2 || report.Orphans[0] != "u1/review/r1/icon_d.jpg" || report.Orphans[1] != "u1/tier/t1/image_b.jpg" || len(report.Deleted) != 0 {
+		t.Errorf("miss orphans %+v", report)
+	}
+	if len(report.Dangling) != 2 || report.Dangling[0].Path != "../outside.jpg" || report.Dangling[1].Path != "u1/tier/t1/missing.jpg" {
+		t.Errorf("miss dangling %+v", report.Dangling)
+	}
+	if _, err := os.Stat(filepath.Join(root, "u1/tier/t1/image_b.jpg")); err != nil {
+		t.Error("miss dry-run")
+	}
+	if len(report.Usage) != 1 || report.Usage["u1"] != 5 {
+		t.Errorf("miss usage %+v", report.Usage)
+	}
+
+	// 削除
+	report, err = storage.Check(context.Background(), root, refs, storage.Options{Delete: true, MinAge: 24 * time.Hour, Now: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Deleted) != 2 || len(report.Failed) != 0 {
+		t.Errorf("miss deleted %+v", report)
+	}
+	// 削除したファイルは容量に含めない
+	if report.Usage["u1"] != 3 {
+		t.Errorf("miss usage %+v", report.Usage)
+	}
+	for _, rel := range []string{"u1/tier/t1/image_b.jpg", "u1/review/r1/icon_d.jpg"} {
+		if _, err := os.Stat(filepath.Join(root, rel)); !os.IsNotExist(err) {
+			t.Errorf("miss delete %s", rel)
+		}
+	}
+	for _, rel := range []string{"u1/tier/t1/image_a.jpg", "u1/tier/t1/image_c.jpg", ".exports/u1/1.zip"} {
+		if _, err := os.Stat(filepath.Join(root, rel)); err != nil {
+			t.Errorf("miss keep %s", rel)
+		}
+	}
+
+	// 保存先が存在しない場合は全ての参照が存在しない
+	report, err = storage.Check(context.Background(), filepath.Join(root, "none"), refs[:1], storage.Options{Now: now})
+	if err != nil || report.Files != 0 || len(report.Dangling) != 1 {
+		t.Errorf("miss empty %+v %v", report, err)
+	}
+}
+
+func TestStorageCheckPendingJobs(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+
+	writeStorageFile(t, root, ".queue/images/u1/tier/t1/image_a.jpg", old) // 変換中
+	writeStorageFile(t, root, ".queue/images/u1/tier/t1/image_b.jpg", old) // ジョブがdeadになった
+	writeStorageFile(t, root, ".queue/images/u1/tier/t1/image_c.jpg", now) // ジョブの登録前
+	refs := []storage.Reference{
+		{Kind: storage.KindTierParag, OwnerId: "t1", Path: "u1/tier/t1/image_a.jpg"},
+		{Kind: storage.KindTierParag, OwnerId: "t1", Path: "u1/tier/t1/image_b.jpg"},
+	}
+	opts := storage.Options{MinAge: 24 * time.Hour, Now: now, PendingJobs: map[string]bool{"u1/tier/t1/image_a.jpg": true}}
+
+	report, err := storage.Check(context.Background(), root, refs, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Orphans) != 1 || report.Orphans[0] != ".queue/images/u1/tier/t1/image_b.jpg" || report.Young != 1 {
+		t.Errorf("miss orphans %+v", report)
+	}
+	// 変換されない画像への参照は存在しないファイルへの参照になる
+	if len(report.Dangling) != 1 || report.Dangling[0].Path != "u1/tier/t1/image_b.jpg" {
+		t.Errorf("miss dangling %+v", report.Dangling)
+	}
+	if report.Usage["u1"] != 3 {
+		t.Errorf("miss usage %+v", report.Usage)
+	}
+
+	opts.Delete = true
+	report, err = storage.Check(context.Background(), root, refs, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Deleted) != 1 || report.Usage["u1"] != 2 {
+		t.Errorf("miss deleted %+v", report)
+	}
+	if _, err := os.Stat(filepath.Join(root, ".queue/images/u1/tier/t1/image_b.jpg")); !os.IsNotExist(err) {
+		t.Error("miss delete")
+	}
+	for _, rel := range []string{"u1/tier/t1/image_a.jpg", "u1/tier/t1/image_c.jpg"} {
+		if _, err := os.Stat(filepath.Join(root, ".queue/images", rel)); err != nil {
+			t.Errorf("miss keep %s", rel)
+		}
+	}
+}
+
+func TestStorageUserUsage(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now()
+	writeStorageFile(t, root, "u1/tier/t1/image_a.jpg", now)
+	writeStorageFile(t, root, "u1/review/r1/icon_b.jpg", now)
+	writeStorageFile(t, root, "u2/user/user/icon_c.jpg", now)
+	writeStorageFile(t, root, ".queue/images/u1/tier/t1/image_d.jpg", now)
+
+	used, err := storage.UserUsage(root, "u1")
+	if err != nil || used != 3 {
+		t.Errorf("miss u1 %d %v", used, err)
+	}
+	used, err = storage.UserUsage(root, "none")
+	if err != nil || used != 0 {
+		t.Errorf("miss none %d %v", used, err)
+	}
+	// 保存先の外や内部のフォルダは数えない
+	for _, userId := range []string{"", "../u1", ".queue"} {
+		if used, _ = storage.UserUsage(root, userId); used != 0 {
+			t.Errorf("miss %q %d", userId, used)
+		}
+	}
+
+	size, err := storage.DirSize(filepath.Join(root, "u1", "tier"))
+	if err != nil || size != 1 {
+		t.Errorf("miss dir %d %v", size, err)
+	}
+}
+
+func TestStorageUsageReserve(t *testing.T) {
+	requireDb(t)
+	userId := createTestUser(t, "stqr", db.RoleUser).UserId
+	t.Cleanup(func() { db.DeleteStorageUsage(userId) })
+
+	if ok, err := db.ReserveStorageUsage(userId, 60, 0, 100); err != nil || !ok {
+		t.Fatalf("miss reserve %v", err)
+	}
+	if ok, _ := db.ReserveStorageUsage(userId, 50, 0, 100); ok {
+		t.Error("miss over quota")
+	}
+	// 同時に削除する分は上限の確認から除く
+	if ok, _ := db.ReserveStorageUsage(userId, 50, 20, 100); !ok {
+		t.Error("miss freeing")
+	}
+	db.AddStorageUsage(userId, -20)
+	if used, _ := db.GetStorageUsage(userId); used != 90 {
+		t.Errorf("miss used %d", used)
+	}
+
+	// 同時に確保しても上限を超えない
+	db.StartStorageRecount(userId)
+	db.SetStorageUsage(userId, 0)
+	var wg sync.WaitGroup
+	var reserved int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _ := db.ReserveStorageUsage(userId, 20, 0, 100); ok {
+				atomic.AddInt32(&reserved, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if used, _ := db.GetStorageUsage(userId); reserved != 5 || used != 100 {
+		t.Errorf("miss concurrent %d %d", reserved, used)
+	}
+}
+
+func TestStorageUsageRecount(t *testing.T) {
+	requireDb(t)
+	userId := createTestUser(t, "stqc", db.RoleUser).UserId
+	t.Cleanup(func() { db.DeleteStorageUsage(userId) })
+
+	db.AddStorageUsage(userId, 500)
+	if err := db.StartStorageRecount(userId); err != nil {
+		t.Fatal(err)
+	}
+	// 再計算中に保存・削除した分は再計算の結果に加える
+	db.AddStorageUsage(userId, 30)
+	db.AddStorageUsage(userId, -10)
+	if err := db.SetStorageUsage(userId, 100); err != nil {
+		t.Fatal(err)
+	}
+	if used, _ := db.GetStorageUsage(userId); used != 120 {
+		t.Errorf("miss recount %d", used)
+	}
+
+	// 加えた分は次の再計算には含めない
+	db.StartStorageRecount(userId)
+	db.SetStorageUsage(userId, 100)
+	if used, _ := db.GetStorageUsage(userId); used != 100 {
+		t.Errorf("miss second recount %d", used)
+	}
+}
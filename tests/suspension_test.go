@@ -0,0 +1,27 @@
+package tests
+
+import (
+	"reviewmakerback/db"
+	"testing"
+	"time"
+)
+
+func TestUserIsSuspendedAt(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if (db.User{SuspensionState: db.SuspensionNone}).IsSuspendedAt(now) {
+		t.Error("miss none")
+	}
+	if !(db.User{SuspensionState: db.SuspensionPermanent}).IsSuspendedAt(now) {
+		t.Error("miss permanent")
+	}
+
+	temporary := db.User{SuspensionState: db.SuspensionTemporary, SuspendedUntil: now.Add(time.Hour)}
+	if !temporary.IsSuspendedAt(now) {
+		t.Error("miss temporary")
+	}
+	// 期限を過ぎたら自動的に解除される
+	if temporary.IsSuspendedAt(now.Add(2 * time.Hour)) {
+		t.Error("miss expired")
+	}
+}
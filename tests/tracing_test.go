@@ -0,0 +1,256 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reviewmakerback/common"
+	"reviewmakerback/db"
+	"reviewmakerback/rest"
+	"reviewmakerback/tracing"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"golang.org/x/oauth2"
+	"google.golang.org/protobuf/proto"
+)
+
+// テスト中のみスパンを記録するトレーサーを設定する
+func useSpanRecorder(t *testing.T) *tracetest.SpanRecorder {
+	if _, err := tracing.Setup(tracing.Config{Exporter: tracing.ExporterNone}); err != nil {
+		t.Fatal(err)
+	}
+	recorder := tracetest.NewSpanRecorder()
+	original := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(original) })
+	return recorder
+}
+
+func TestTracingSetupExporter(t *testing.T) {
+	if _, err := tracing.Setup(tracing.Config{Exporter: "jaeger"}); err == nil {
+		t.Error("miss unknown exporter")
+	}
+	if _, err := tracing.Setup(tracing.Config{Exporter: tracing.ExporterOtlp}); err == nil {
+		t.Error("miss endpoint")
+	}
+}
+
+func TestOtlpExporter(t *testing.T) {
+	var received []*collectortrace.ExportTraceServiceRequest
+	var header http.Header
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		path = r.URL.Path
+		b, _ := ioutil.ReadAll(r.Body)
+		var req collectortrace.ExportTraceServiceRequest
+		if err := proto.Unmarshal(b, &req); err != nil {
+			t.Errorf("miss body %v", err)
+		}
+		received = append(received, &req)
+	}))
+	defer server.Close()
+
+	exporter, err := tracing.NewOtlpExporter(context.Background(), server.URL+"/otlp/v1/traces", map[string]string{"Authorization": "Bearer x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	tracer := provider.Tracer("test")
+
+	ctx, parent := tracer.Start(context.Background(), "parent")
+	_, child := tracer.Start(ctx, "child", trace.WithAttributes(attribute.Int("n", 3)))
+	child.End()
+	parent.End()
+	provider.Shutdown(context.Background())
+
+	if path != "/otlp/v1/traces" || header.Get("Content-Type") != "application/x-protobuf" || header.Get("Authorization") != "Bearer x" {
+		t.Errorf("miss request %s %v", path, header)
+	}
+	// 同期エクスポーターはスパンごとに送信する
+	found := false
+	for _, req := range received {
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, span := range ss.Spans {
+					if span.Name != "child" {
+						continue
+					}
+					found = true
+					if ss.Scope.GetName() != "test" ||
+						trace.SpanID(*(*[8]byte)(span.ParentSpanId)) != parent.SpanContext().SpanID() ||
+						trace.TraceID(*(*[16]byte)(span.TraceId)) != parent.SpanContext().TraceID() ||
+						len(span.Attributes) != 1 || span.Attributes[0].Value.GetIntValue() != 3 {
+						t.Errorf("miss span %v", span)
+					}
+				}
+			}
+		}
+	}
+	if !found {
+		t.Error("miss child")
+	}
+}
+
+func TestOtlpExporterEndpoint(t *testing.T) {
+	for _, endpoint := range []string{"localhost:4318", "ftp://localhost:4318/v1/traces", "http://"} {
+		if _, err := tracing.NewOtlpExporter(context.Background(), endpoint, nil); err == nil {
+			t.Errorf("miss %s", endpoint)
+		}
+	}
+}
+
+func TestWriterExporter(t *testing.T) {
+	var buf bytes.Buffer
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(tracing.NewWriterExporter(&buf)))
+	_, span := provider.Tracer("test").Start(context.Background(), "local")
+	span.End()
+	provider.Shutdown(context.Background())
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || decoded["name"] != "local" {
+		t.Errorf("miss %s", buf.String())
+	}
+}
+
+func TestTracingTransport(t *testing.T) {
+	recorder := useSpanRecorder(t)
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(404)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: tracing.Transport(nil)}
+	res, err := client.Get(server.URL + "/token?code=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("miss spans %d", len(spans))
+	}
+	span := spans[0]
+	if !strings.Contains(traceparent, span.SpanContext().TraceID().String()) {
+		t.Errorf("miss traceparent %s", traceparent)
+	}
+	for _, attr := range span.Attributes() {
+		if attr.Key == "http.url" && strings.Contains(attr.Value.AsString(), "secret") {
+			t.Errorf("miss query %s", attr.Value.AsString())
+		}
+	}
+	if span.SpanKind() != trace.SpanKindClient || span.Status().Code.String() != "Error" {
+		t.Errorf("miss %v %v", span.SpanKind(), span.Status())
+	}
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	recorder := useSpanRecorder(t)
+	e := echo.New()
+	e.Use(rest.RequestId())
+	e.Use(rest.Tracing())
+	e.GET("/test/tracing/:id", func(c echo.Context) error {
+		_, span := tracing.Start(c.Request().Context(), "inner")
+		span.End()
+		return c.NoContent(500)
+	})
+
+	traceId := "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest("GET", "/test/tracing/1", nil)
+	req.Header.Set("traceparent", "00-"+traceId+"-00f067aa0ba902b7-01")
+	e.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("miss spans %d", len(spans))
+	}
+	inner, server := spans[0], spans[1]
+	if server.Name() != "GET /test/tracing/:id" || server.SpanKind() != trace.SpanKindServer {
+		t.Errorf("miss server %s", server.Name())
+	}
+	if server.SpanContext().TraceID().String() != traceId || server.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("miss parent %v", server.Parent())
+	}
+	if inner.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Error("miss inner parent")
+	}
+	if server.Status().Code.String() != "Error" {
+		t.Errorf("miss status %v", server.Status())
+	}
+
+	// 一致するルートがない場合はリクエストのパスをスパン名にしない
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/test/unknown/path", nil))
+	spans = recorder.Ended()
+	if len(spans) != 3 || spans[2].Name() != "GET unmatched" {
+		t.Fatalf("miss unmatched %d", len(spans))
+	}
+
+	// 死活監視は記録しない
+	e.GET("/healthz", func(c echo.Context) error { return c.NoContent(200) })
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthz", nil))
+	if len(recorder.Ended()) != 3 {
+		t.Error("miss probe")
+	}
+}
+
+func TestQueryTracing(t *testing.T) {
+	requireDb(t)
+	user := createTestUser(t, "trcq", db.RoleUser)
+	recorder := useSpanRecorder(t)
+
+	ctx, span := tracing.Start(context.Background(), "request")
+	if _, tx := db.GetUser(ctx, user.UserId, "user_id"); tx.Error != nil {
+		t.Fatal(tx.Error)
+	}
+	span.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 2 || spans[0].Name() != "gorm.query" || spans[0].Parent().SpanID() != span.SpanContext().SpanID() {
+		t.Fatalf("miss spans %d", len(spans))
+	}
+
+	// 親スパンのないクエリは記録しない
+	db.GetUser(context.Background(), user.UserId, "user_id")
+	if len(recorder.Ended()) != 2 {
+		t.Error("miss no parent")
+	}
+}
+
+func TestOAuthContextClient(t *testing.T) {
+	recorder := useSpanRecorder(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"token","token_type":"bearer"}`))
+	}))
+	defer server.Close()
+
+	common.SetOAuthClient(tracing.NewClient(time.Second))
+	t.Cleanup(func() { common.SetOAuthClient(nil) })
+
+	config := oauth2.Config{ClientID: "id", Endpoint: oauth2.Endpoint{TokenURL: server.URL + "/token"}}
+	if _, err := config.Exchange(common.OAuthContext(context.Background()), "code"); err != nil {
+		t.Fatal(err)
+	}
+	// 既定のクライアントは変更しない
+	if http.DefaultClient.Transport != nil {
+		t.Error("miss default client")
+	}
+	if spans := recorder.Ended(); len(spans) != 1 || spans[0].SpanKind() != trace.SpanKindClient {
+		t.Errorf("miss spans %d", len(spans))
+	}
+}
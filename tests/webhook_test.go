@@ -0,0 +1,175 @@
+package tests
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reviewmakerback/common"
+	"reviewmakerback/db"
+	"reviewmakerback/ontime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSendWebhook(t *testing.T) {
+	const secret = "secretsecretsecret"
+	const payload = `{"event":"tier.created","tierId":"abc"}`
+
+	received := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		timestamp := r.Header.Get("X-Kdtier-Timestamp")
+		if string(body) != payload {
+			t.Errorf("miss body '%s'", string(body))
+		}
+		if r.Header.Get("X-Kdtier-Event") != "tier.created" {
+			t.Error("miss event")
+		}
+		if r.Header.Get("X-Kdtier-Signature") != "sha256="+common.GetHmacSHA256(secret, timestamp+"."+string(body)) {
+			t.Error("miss signature")
+		}
+		received = true
+		w.WriteHeader(204)
+	}))
+	defer server.Close()
+
+	code, err := ontime.SendWebhook(server.Client(), server.URL, secret, db.WebhookDelivery{
+		Id:      1,
+		Event:   "tier.created",
+		Payload: payload,
+	})
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if code != 204 || !received {
+		t.Error("miss")
+	}
+}
+
+func TestSendWebhookError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	}))
+	defer server.Close()
+
+	code, err := ontime.SendWebhook(server.Client(), server.URL, "secret", db.WebhookDelivery{Payload: "{}"})
+	if err == nil || code != 500 {
+		t.Error("miss")
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	if ontime.WebhookBackoff(1) != 30*time.Second {
+		t.Error("miss")
+	}
+	if ontime.WebhookBackoff(3) != 120*time.Second {
+		t.Error("miss")
+	}
+	if ontime.WebhookBackoff(100) != 6*time.Hour {
+		t.Error("miss")
+	}
+}
+
+func TestWebhookPublicAddress(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "0.0.0.0", "100.64.0.1", "::1", "fe80::1", "fd00::1", "::ffff:127.0.0.1"} {
+		if common.IsPublicIP(net.ParseIP(addr)) {
+			t.Errorf("miss private %s", addr)
+		}
+	}
+	for _, addr := range []string{"8.8.8.8", "203.0.113.1", "2001:4860:4860::8888"} {
+		if !common.IsPublicIP(net.ParseIP(addr)) {
+			t.Errorf("miss public %s", addr)
+		}
+	}
+	for host, internal := range map[string]bool{"localhost": true, "api.localhost": true, "[::1]": true, "169.254.169.254": true, "example.com": false, "8.8.8.8": false} {
+		if common.IsInternalHost(host) != internal {
+			t.Errorf("miss host %s", host)
+		}
+	}
+}
+
+func TestWebhookClientRefusesInternal(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("miss sent to loopback")
+	}))
+	defer server.Close()
+
+	_, err := ontime.SendWebhook(ontime.NewWebhookClient(), server.URL, "secret", db.WebhookDelivery{Payload: "{}"})
+	if !errors.Is(err, common.ErrNonPublicAddress) {
+		t.Errorf("miss %v", err)
+	}
+}
+
+func TestWebhookClientRefusesRedirect(t *testing.T) {
+	redirected := false
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redirected = true
+	}))
+	defer target.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusTemporaryRedirect)
+	}))
+	defer server.Close()
+
+	// ループバックへの接続を許可したクライアントでリダイレクトの扱いのみ確認する
+	client := ontime.NewWebhookClient()
+	client.Transport = http.DefaultTransport
+	code, err := ontime.SendWebhook(client, server.URL, "secret", db.WebhookDelivery{Payload: "{}"})
+	if err == nil || code != http.StatusTemporaryRedirect || redirected {
+		t.Errorf("miss %d %v", code, err)
+	}
+}
+
+func TestSendWebhookErrorHidesUrl(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+
+	client := ontime.NewWebhookClient()
+	client.Transport = http.DefaultTransport
+	_, err := ontime.SendWebhook(client, server.URL+"/hooks/leaked-token", "secret", db.WebhookDelivery{Payload: "{}"})
+	if err == nil || strings.Contains(err.Error(), "leaked-token") {
+		t.Errorf("miss %v", err)
+	}
+}
+
+func TestWebhookSecretEncrypted(t *testing.T) {
+	requireDb(t)
+	user := createTestUser(t, "whsc", db.RoleUser)
+	t.Cleanup(func() { db.Db.Where("user_id = ?", user.UserId).Delete(&db.Webhook{}) })
+
+	webhook, err := db.CreateWebhook(user.UserId, "https://example.com/hook", "webhook-secret", []string{"tier.created"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	storedSecret := func(wid string) string {
+		var stored string
+		db.Db.Table("webhooks").Select("secret").Where("webhook_id = ?", wid).Scan(&stored)
+		return stored
+	}
+	if stored := storedSecret(webhook.WebhookId); !db.IsEncrypted(stored) || strings.Contains(stored, "webhook-secret") {
+		t.Errorf("not encrypted: %s", stored)
+	}
+	if loaded, _ := db.GetWebhook(webhook.WebhookId, "*"); loaded.Secret != "webhook-secret" {
+		t.Errorf("miss: %s", loaded.Secret)
+	}
+
+	// 暗号化前に保存された平文は再暗号化の処理で暗号化する
+	plain := db.Webhook{WebhookId: testDbId("whpl"), UserId: user.UserId, Url: "https://example.com/hook", Events: "tier.created"}
+	if err := db.Db.Table("webhooks").Create(map[string]interface{}{
+		"webhook_id": plain.WebhookId, "user_id": plain.UserId, "url": plain.Url, "secret": "plain-secret", "events": plain.Events, "is_active": true,
+	}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.RotateEncryptedColumns(); err != nil {
+		t.Fatal(err)
+	}
+	if stored := storedSecret(plain.WebhookId); !db.IsEncrypted(stored) {
+		t.Errorf("not rotated: %s", stored)
+	}
+	if loaded, _ := db.GetWebhook(plain.WebhookId, "*"); loaded.Secret != "plain-secret" {
+		t.Errorf("miss: %s", loaded.Secret)
+	}
+}
@@ -0,0 +1,41 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// OTLPの送信のタイムアウト
+const otlpTimeout = 10 * time.Second
+
+// OTLP/HTTP(protobuf)でスパンを送信するエクスポーター
+// endpoint 送信先のURL(パスを省略した場合は/v1/traces)
+// 送信には専用のHTTPクライアントを使用するため、送信自体はトレースしない
+func NewOtlpExporter(ctx context.Context, endpoint string, headers map[string]string) (sdktrace.SpanExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("OTLPの送信先'%s'が不正です", endpoint)
+	}
+
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(u.Host),
+		otlptracehttp.WithHeaders(headers),
+		otlptracehttp.WithTimeout(otlpTimeout),
+	}
+	switch u.Scheme {
+	case "http":
+		opts = append(opts, otlptracehttp.WithInsecure())
+	case "https":
+	default:
+		return nil, fmt.Errorf("OTLPの送信先'%s'はhttpかhttpsで指定してください", endpoint)
+	}
+	if u.Path != "" && u.Path != "/" {
+		opts = append(opts, otlptracehttp.WithURLPath(u.Path))
+	}
+	return otlptracehttp.New(ctx, opts...)
+}
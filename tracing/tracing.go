@@ -0,0 +1,146 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 計装の名前
+const instrumentationName = "reviewmakerback"
+
+// スパンの出力先
+const (
+	ExporterNone   = "none"   // トレースを記録しない
+	ExporterOtlp   = "otlp"   // OTLP/HTTP(protobuf)でコレクターに送信する
+	ExporterStdout = "stdout" // 標準出力に1行ずつJSONで出力する(ローカルでのデバッグ用)
+)
+
+// トレースの設定
+type Config struct {
+	Exporter    string            // スパンの出力先
+	Endpoint    string            // OTLPの送信先URL(例: http://localhost:4318/v1/traces)
+	Headers     map[string]string // OTLPの送信時に追加するヘッダー(認証等)
+	SampleRatio float64           // 記録するトレースの割合(0から1 親スパンがある場合は親に従う)
+	ServiceName string            // サービス名
+}
+
+// トレースを開始する
+// 返り値の関数は未送信のスパンを送信してから終了する
+func Setup(conf Config) (func(context.Context) error, error) {
+	// 出力しない場合もW3C Trace Contextは次のサービスへ引き継ぐ
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	switch conf.Exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOtlp:
+		if conf.Endpoint == "" {
+			return nil, fmt.Errorf("OTLPの送信先が指定されていません")
+		}
+		var err error
+		exporter, err = NewOtlpExporter(context.Background(), conf.Endpoint, conf.Headers)
+		if err != nil {
+			return nil, err
+		}
+	case ExporterStdout:
+		exporter = NewWriterExporter(os.Stdout)
+	default:
+		return nil, fmt.Errorf("トレースの出力先'%s'は存在しません", conf.Exporter)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(conf.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(conf.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// 計装に使用するトレーサー
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// スパンを開始する
+// 呼び出し側で必ずEndを呼ぶこと
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// エラーをスパンに記録する(nilなら何もしない)
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// 外部へのHTTPリクエストをスパンとして記録するTransport
+// トレースの情報はtraceparentヘッダーで送信先に引き継ぐ
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{base: base}
+}
+
+// 外部へのHTTPリクエストをスパンとして記録するHTTPクライアント
+// http.DefaultClientは変更せず、トレースする通信にのみこのクライアントを使用する
+func NewClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: Transport(nil)}
+}
+
+type transport struct {
+	base http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// クエリにはトークン等が含まれる場合があるため記録しない
+	url := *req.URL
+	url.RawQuery = ""
+	url.User = nil
+
+	ctx, span := Tracer().Start(req.Context(), "HTTP "+req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPMethodKey.String(req.Method),
+			semconv.HTTPURLKey.String(url.String()),
+			semconv.NetPeerNameKey.String(req.URL.Hostname()),
+		))
+	defer span.End()
+
+	req = req.Clone(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		RecordError(span, err)
+		return res, err
+	}
+	span.SetAttributes(semconv.HTTPStatusCodeKey.Int(res.StatusCode))
+	if res.StatusCode >= 400 {
+		span.SetStatus(codes.Error, res.Status)
+	}
+	return res, nil
+}
+
+// スパンを1行ずつJSONで出力するエクスポーター
+func NewWriterExporter(w io.Writer) sdktrace.SpanExporter {
+	return &writerExporter{w: w}
+}
@@ -0,0 +1,212 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+type writerExporter struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+func (e *writerExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	encoder := json.NewEncoder(e.w)
+	for _, span := range spans {
+		if err := encoder.Encode(encodeSpan(span)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *writerExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// OTLPのJSON形式
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceId           string         `json:"traceId"`
+	SpanId            string         `json:"spanId"`
+	ParentSpanId      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"` // 0: 未設定, 1: 正常, 2: エラー
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+// リソースと計装ごとにスパンをまとめる
+func encodeSpans(spans []sdktrace.ReadOnlySpan) otlpTraces {
+	type scopeKey struct {
+		resource attribute.Distinct
+		scope    instrumentation.Scope
+	}
+	resources := map[attribute.Distinct]*resource.Resource{}
+	var resourceOrder []attribute.Distinct
+	scopes := map[scopeKey][]otlpSpan{}
+	var scopeOrder []scopeKey
+
+	for _, span := range spans {
+		res := span.Resource()
+		resKey := res.Equivalent()
+		if _, ok := resources[resKey]; !ok {
+			resources[resKey] = res
+			resourceOrder = append(resourceOrder, resKey)
+		}
+		key := scopeKey{resource: resKey, scope: span.InstrumentationScope()}
+		if _, ok := scopes[key]; !ok {
+			scopeOrder = append(scopeOrder, key)
+		}
+		scopes[key] = append(scopes[key], encodeSpan(span))
+	}
+
+	traces := otlpTraces{ResourceSpans: []otlpResourceSpans{}}
+	for _, resKey := range resourceOrder {
+		resourceSpans := otlpResourceSpans{Resource: otlpResource{Attributes: encodeAttributes(resources[resKey].Attributes())}}
+		for _, key := range scopeOrder {
+			if key.resource != resKey {
+				continue
+			}
+			resourceSpans.ScopeSpans = append(resourceSpans.ScopeSpans, otlpScopeSpans{
+				Scope: otlpScope{Name: key.scope.Name, Version: key.scope.Version},
+				Spans: scopes[key],
+			})
+		}
+		traces.ResourceSpans = append(traces.ResourceSpans, resourceSpans)
+	}
+	return traces
+}
+
+func encodeSpan(span sdktrace.ReadOnlySpan) otlpSpan {
+	s := otlpSpan{
+		TraceId:           span.SpanContext().TraceID().String(),
+		SpanId:            span.SpanContext().SpanID().String(),
+		Name:              span.Name(),
+		Kind:              int(span.SpanKind()),
+		StartTimeUnixNano: unixNano(span.StartTime()),
+		EndTimeUnixNano:   unixNano(span.EndTime()),
+		Attributes:        encodeAttributes(span.Attributes()),
+	}
+	if span.Parent().HasSpanID() {
+		s.ParentSpanId = span.Parent().SpanID().String()
+	}
+	for _, event := range span.Events() {
+		s.Events = append(s.Events, otlpEvent{
+			TimeUnixNano: unixNano(event.Time),
+			Name:         event.Name,
+			Attributes:   encodeAttributes(event.Attributes),
+		})
+	}
+	switch span.Status().Code {
+	case codes.Ok:
+		s.Status.Code = 1
+	case codes.Error:
+		s.Status = otlpStatus{Code: 2, Message: span.Status().Description}
+	}
+	return s
+}
+
+func encodeAttributes(attrs []attribute.KeyValue) []otlpKeyValue {
+	kvs := make([]otlpKeyValue, 0, len(attrs))
+	for _, attr := range attrs {
+		kvs = append(kvs, otlpKeyValue{Key: string(attr.Key), Value: encodeValue(attr.Value)})
+	}
+	return kvs
+}
+
+// 64bit整数はJSONでは文字列で表す
+func encodeValue(v attribute.Value) map[string]interface{} {
+	switch v.Type() {
+	case attribute.BOOL:
+		return map[string]interface{}{"boolValue": v.AsBool()}
+	case attribute.INT64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(v.AsInt64(), 10)}
+	case attribute.FLOAT64:
+		return map[string]interface{}{"doubleValue": v.AsFloat64()}
+	case attribute.STRING:
+		return map[string]interface{}{"stringValue": v.AsString()}
+	}
+
+	// 配列
+	var values []map[string]interface{}
+	switch v.Type() {
+	case attribute.BOOLSLICE:
+		for _, b := range v.AsBoolSlice() {
+			values = append(values, encodeValue(attribute.BoolValue(b)))
+		}
+	case attribute.INT64SLICE:
+		for _, n := range v.AsInt64Slice() {
+			values = append(values, encodeValue(attribute.Int64Value(n)))
+		}
+	case attribute.FLOAT64SLICE:
+		for _, f := range v.AsFloat64Slice() {
+			values = append(values, encodeValue(attribute.Float64Value(f)))
+		}
+	case attribute.STRINGSLICE:
+		for _, s := range v.AsStringSlice() {
+			values = append(values, encodeValue(attribute.StringValue(s)))
+		}
+	default:
+		return map[string]interface{}{"stringValue": v.Emit()}
+	}
+	return map[string]interface{}{"arrayValue": map[string]interface{}{"values": values}}
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}